@@ -0,0 +1,24 @@
+package audit
+
+import (
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+)
+
+// A denied attempt to call the API or to perform an operation
+type Denial struct {
+	Id          int                   `json:"id"`
+	Principal   string                `json:"principal,omitempty"`
+	Credentials *auth.Credentials     `json:"credentials,omitempty"`
+	Listener    string                `json:"listener,omitempty"`
+	Route       string                `json:"route"`
+	SecretId    string                `json:"secretId,omitempty"`
+	InstanceId  string                `json:"instanceId,omitempty"`
+	Operation   secrets.OperationName `json:"operation,omitempty"`
+	Permission  auth.Permissions      `json:"permission,omitempty"`
+	Roles       auth.ClaimedRoles     `json:"roles,omitempty"`
+	Reason      string                `json:"reason"`
+	DeniedAt    time.Time             `json:"deniedAt"`
+}
@@ -0,0 +1,20 @@
+package auth
+
+import "time"
+
+// A time-boxed elevation of a principal to a role, for break-glass access
+type Elevation struct {
+	Id        string     `json:"id"`
+	Principal string     `json:"principal"`
+	Role      RoleName   `json:"role"`
+	Reason    string     `json:"reason"`
+	StartedAt time.Time  `json:"startedAt"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+	RevokedBy string     `json:"revokedBy,omitempty"`
+}
+
+// Active checks if the elevation is neither expired nor revoked at the given time
+func (e *Elevation) Active(at time.Time) bool {
+	return e.RevokedAt == nil && at.Before(e.ExpiresAt)
+}
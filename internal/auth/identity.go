@@ -12,8 +12,21 @@ import (
 )
 
 type Identity struct {
-	Principal string
-	Roles     ClaimedRoles
+	Principal   string
+	Roles       ClaimedRoles
+	Credentials *Credentials
+	Process     *Process
+	// The active elevation through which the last of the roles is claimed, if any
+	Elevation *Elevation
+	// The name of the listener through which the caller connected, if it is named
+	Listener string
+}
+
+// The credentials of the process at the other end of a socket connection
+type Credentials struct {
+	Uid uint32 `json:"uid"`
+	Gid uint32 `json:"gid"`
+	Pid int32  `json:"pid"`
 }
 
 type Claims struct {
@@ -3,11 +3,14 @@
 package auth
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
 	"maps"
 	"net"
 	"net/http"
 	"os/user"
+	"path"
 	"slices"
 	"strconv"
 
@@ -15,9 +18,16 @@ import (
 )
 
 // PlatformClaims are the platform-specific claims that are obtained from unix socket peercreds
+// and from the peer process
 type PlatformClaims struct {
 	Users  map[Entity]ClaimedRoles `json:"users,omitempty"`
 	Groups map[Entity]ClaimedRoles `json:"groups,omitempty"`
+	// Roles claimed by processes with executables matching the given path patterns
+	Executables map[string]ClaimedRoles `json:"executables,omitempty"`
+	// Roles claimed by processes belonging to systemd units matching the given patterns
+	Units map[string]ClaimedRoles `json:"units,omitempty"`
+	// Roles claimed by processes in cgroups matching the given path patterns
+	Cgroups map[string]ClaimedRoles `json:"cgroups,omitempty"`
 }
 
 // getClaimedRolesFromMap returns the union of ClaimedRoles for all entities in m
@@ -32,18 +42,56 @@ func addClaimedRoles(authorisedRoles map[RoleName]struct{}, claims map[Entity]Cl
 	}
 }
 
+// addPatternClaimedRoles adds the ClaimedRoles for all patterns in claims which match value.
+func addPatternClaimedRoles(authorisedRoles map[RoleName]struct{}, claims map[string]ClaimedRoles, value string) {
+	if value == "" {
+		return
+	}
+	for pattern, roles := range claims {
+		if matched, _ := path.Match(pattern, value); matched {
+			for _, role := range roles {
+				authorisedRoles[role] = struct{}{}
+			}
+		}
+	}
+}
+
+// The function to read the process at the other end of a socket connection
+var readPeerProcess = readProcess
+
 // Claim the identity of the caller from the socket connection.
+// If the identity cannot be claimed, the partial identity returned with the error holds the
+// credentials of the caller if they could be obtained.
+//
+// The calling process is only known if it can be pinned by a pidfd and read from /proc, which is
+// not possible on kernels before 5.3, for callers in another pid namespace, or where /proc is
+// mounted with hidepid. The caller is then identified by the uid and gid of its credentials and the
+// groups of its user, and claims upon its process do not apply.
 func (c *PlatformClaims) ClaimIdentity(request *http.Request, connection net.Conn) (*Identity, error) {
-	user, groups, err := c.authenticate(connection)
+	credentials, pidfd, err := peerCredentials(connection)
 	if err != nil {
 		return nil, err
 	}
-	principal, roles := c.authorise(user, groups)
-	return &Identity{Principal: principal, Roles: roles}, nil
+
+	var process *Process
+	if pidfd >= 0 {
+		defer unix.Close(pidfd)
+		process, err = readPeerProcess(pidfd, credentials.Pid)
+		if err != nil {
+			slog.Debug("identifying peer by its credentials", "pid", credentials.Pid, "error", err)
+			process = nil
+		}
+	}
+	user, groups, err := c.authenticate(credentials, process)
+	if err != nil {
+		return &Identity{Credentials: credentials, Process: process}, err
+	}
+	principal, roles := c.authorise(user, groups, process)
+	return &Identity{Principal: principal, Roles: roles, Credentials: credentials, Process: process}, nil
 }
 
-// Authorise resolves the principal and roles for the authenticated user and groups from the claims config.
-func (c *PlatformClaims) authorise(user *user.User, groups []*user.Group) (string, ClaimedRoles) {
+// Authorise resolves the principal and roles for the authenticated user, groups and process from the claims config.
+func (c *PlatformClaims) authorise(user *user.User, groups []*user.Group, process *Process) (string, ClaimedRoles) {
 	authorisedRoles := make(map[RoleName]struct{})
 	if c.Users != nil {
 		addClaimedRoles(authorisedRoles, c.Users, user.Uid, user.Username)
@@ -53,6 +101,11 @@ func (c *PlatformClaims) authorise(user *user.User, groups []*user.Group) (strin
 			addClaimedRoles(authorisedRoles, c.Groups, group.Gid, group.Name)
 		}
 	}
+	if process != nil {
+		addPatternClaimedRoles(authorisedRoles, c.Executables, process.Exe)
+		addPatternClaimedRoles(authorisedRoles, c.Units, process.Unit)
+		addPatternClaimedRoles(authorisedRoles, c.Cgroups, process.Cgroup)
+	}
 
 	principal := fmt.Sprintf("linux:%s/%s", user.Username, user.Uid)
 	roles := slices.Collect(maps.Keys(authorisedRoles))
@@ -60,18 +113,20 @@ func (c *PlatformClaims) authorise(user *user.User, groups []*user.Group) (strin
 	return principal, roles
 }
 
-// Authenticate establishes the caller's user and groups from the socket peer credentials.
-func (c *PlatformClaims) authenticate(connection net.Conn) (*user.User, []*user.Group, error) {
+// peerCredentials gets the credentials of the caller from the socket connection, and a pidfd
+// referring to the calling process which must be closed by the caller, or -1 if there is none.
+func peerCredentials(connection net.Conn) (*Credentials, int, error) {
 	var cred *unix.Ucred
+	pidfd := -1
 
 	// Get Raw socket connection
 	uc, ok := connection.(*net.UnixConn)
 	if !ok {
-		return nil, nil, fmt.Errorf("unexpected socket type")
+		return nil, 0, fmt.Errorf("unexpected socket type")
 	}
 	raw, err := uc.SyscallConn()
 	if err != nil {
-		return nil, nil, fmt.Errorf("error opening raw connection: %w", err)
+		return nil, 0, fmt.Errorf("error opening raw connection: %w", err)
 	}
 
 	// Get socket credentials
@@ -80,13 +135,33 @@ func (c *PlatformClaims) authenticate(connection net.Conn) (*user.User, []*user.
 			unix.SOL_SOCKET,
 			unix.SO_PEERCRED,
 		)
+		if err != nil {
+			return
+		}
+		var pidfdErr error
+		pidfd, pidfdErr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_PEERPIDFD)
+		if errors.Is(pidfdErr, unix.ENOPROTOOPT) && cred.Pid != 0 {
+			// Kernels before 6.5 cannot give the pidfd of the peer, so open it from the pid. This
+			// cannot detect the pid being reused between the connection and the pidfd being opened.
+			pidfd, pidfdErr = unix.PidfdOpen(int(cred.Pid), 0)
+		}
+		if pidfdErr != nil {
+			slog.Debug("failed to get pidfd of peer", "pid", cred.Pid, "error", pidfdErr)
+			pidfd = -1
+		}
 	})
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get socket credentials: %w", err)
+		return nil, 0, fmt.Errorf("failed to get socket credentials: %w", err)
 	} else if controlErr != nil {
-		return nil, nil, fmt.Errorf("failed to control socket: %w", controlErr)
+		return nil, 0, fmt.Errorf("failed to control socket: %w", controlErr)
 	}
 
+	return &Credentials{Uid: cred.Uid, Gid: cred.Gid, Pid: cred.Pid}, pidfd, nil
+}
+
+// Authenticate establishes the caller's user and groups from the socket peer credentials and the
+// real supplementary groups of the process, or the groups of the user if the process is not known.
+func (c *PlatformClaims) authenticate(cred *Credentials, process *Process) (*user.User, []*user.Group, error) {
 	// Lookup authenticated user
 	authenticatedUser, err := user.LookupId(strconv.FormatUint(uint64(cred.Uid), 10))
 	if err != nil {
@@ -99,14 +174,21 @@ func (c *PlatformClaims) authenticate(connection net.Conn) (*user.User, []*user.
 	}
 
 	authenticatedGroups := []*user.Group{authenticatedGroup}
-	gids, err := authenticatedUser.GroupIds()
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to find user groups: %w", err)
+	var gids []string
+	if process != nil {
+		gids = process.Groups
+	} else {
+		// without the process, fall back to the groups of the user according to NSS
+		gids, err = authenticatedUser.GroupIds()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to find user groups: %w", err)
+		}
 	}
 	for _, gid := range gids {
 		group, err := user.LookupGroupId(gid)
 		if err != nil {
-			return nil, nil, fmt.Errorf("failed to lookup user group: %w", err)
+			// groups unknown to NSS can still be claimed by ID
+			group = &user.Group{Gid: gid}
 		}
 		authenticatedGroups = append(authenticatedGroups, group)
 	}
@@ -6,7 +6,9 @@ import (
 	"encoding/json"
 	"net"
 	"net/http"
+	"os"
 	"os/user"
+	"path/filepath"
 	"testing"
 )
 
@@ -116,6 +118,7 @@ func TestAuthorise(t *testing.T) {
 		claims        PlatformClaims
 		user          *user.User
 		groups        []*user.Group
+		process       *Process
 		wantPrincipal string
 		wantRoles     ClaimedRoles
 	}{
@@ -202,6 +205,35 @@ func TestAuthorise(t *testing.T) {
 			wantPrincipal: "linux:alice/1000",
 			wantRoles:     ClaimedRoles{"admin", "reader", "writer"},
 		},
+		{
+			name: "process matched by executable, unit and cgroup",
+			claims: PlatformClaims{
+				Executables: map[string]ClaimedRoles{"/nix/store/*/bin/nginx": {"web"}},
+				Units:       map[string]ClaimedRoles{"nginx.service": {"certs"}},
+				Cgroups:     map[string]ClaimedRoles{"/system.slice/*": {"system"}},
+			},
+			user:   alice,
+			groups: nil,
+			process: &Process{
+				Exe:    "/nix/store/abc-nginx/bin/nginx",
+				Cgroup: "/system.slice/nginx.service",
+				Unit:   "nginx.service",
+			},
+			wantPrincipal: "linux:alice/1000",
+			wantRoles:     ClaimedRoles{"web", "certs", "system"},
+		},
+		{
+			name: "process not matched by other unit or unreadable executable",
+			claims: PlatformClaims{
+				Executables: map[string]ClaimedRoles{"*": {"any-exe"}},
+				Units:       map[string]ClaimedRoles{"nginx.service": {"certs"}},
+			},
+			user:          alice,
+			groups:        nil,
+			process:       &Process{Cgroup: "/system.slice/evil.service", Unit: "evil.service"},
+			wantPrincipal: "linux:alice/1000",
+			wantRoles:     nil,
+		},
 		{
 			name: "no matching entity yields empty roles",
 			claims: PlatformClaims{
@@ -216,7 +248,7 @@ func TestAuthorise(t *testing.T) {
 	}
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			principal, roles := tc.claims.authorise(tc.user, tc.groups)
+			principal, roles := tc.claims.authorise(tc.user, tc.groups, tc.process)
 			if principal != tc.wantPrincipal {
 				t.Errorf("principal: got %q, want %q", principal, tc.wantPrincipal)
 			}
@@ -240,3 +272,112 @@ func TestClaimIdentity_nonUnixConn_returnsError(t *testing.T) {
 		t.Errorf("expected 'unexpected socket type', got %v", err)
 	}
 }
+
+func TestClaimIdentity_unixConn_claimsProcess(t *testing.T) {
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: filepath.Join(t.TempDir(), "socket"), Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	client, err := net.DialUnix("unix", nil, listener.Addr().(*net.UnixAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := listener.AcceptUnix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &PlatformClaims{Executables: map[string]ClaimedRoles{exe: {"tester"}}}
+	identity, err := c.ClaimIdentity(&http.Request{}, server)
+	if err != nil {
+		t.Fatalf("ClaimIdentity: %v", err)
+	}
+	if identity.Credentials == nil || identity.Credentials.Pid != int32(os.Getpid()) {
+		t.Errorf("Credentials = %+v, want pid %d", identity.Credentials, os.Getpid())
+	}
+	if !slicesEqual(identity.Roles, ClaimedRoles{"tester"}) {
+		t.Errorf("Roles = %v, want [tester]", identity.Roles)
+	}
+}
+
+func TestClaimIdentity_unixConn_withoutProcess(t *testing.T) {
+	readPeerProcess = func(pidfd int, pid int32) (*Process, error) {
+		return nil, os.ErrNotExist
+	}
+	defer func() { readPeerProcess = readProcess }()
+
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: filepath.Join(t.TempDir(), "socket"), Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	client, err := net.DialUnix("unix", nil, listener.Addr().(*net.UnixAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := listener.AcceptUnix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	current, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &PlatformClaims{
+		Users:       map[Entity]ClaimedRoles{{Id: current.Uid}: {"user"}},
+		Executables: map[string]ClaimedRoles{exe: {"tester"}},
+	}
+	identity, err := c.ClaimIdentity(&http.Request{}, server)
+	if err != nil {
+		t.Fatalf("ClaimIdentity: %v", err)
+	}
+	if identity.Process != nil {
+		t.Errorf("Process = %+v, want none", identity.Process)
+	}
+	if identity.Credentials == nil || identity.Credentials.Uid != uint32(os.Getuid()) {
+		t.Errorf("Credentials = %+v, want uid %d", identity.Credentials, os.Getuid())
+	}
+	if !slicesEqual(identity.Roles, ClaimedRoles{"user"}) {
+		t.Errorf("Roles = %v, want [user]", identity.Roles)
+	}
+}
+
+func TestAuthenticate_withoutProcess(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gids, err := current.GroupIds()
+	if err != nil {
+		t.Skipf("groups of current user unknown: %v", err)
+	}
+	credentials := &Credentials{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}
+
+	_, groups, err := (&PlatformClaims{}).authenticate(credentials, nil)
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	got := make(map[string]bool)
+	for _, group := range groups {
+		got[group.Gid] = true
+	}
+	for _, gid := range gids {
+		if !got[gid] {
+			t.Errorf("groups = %v, want to include %s of the user", got, gid)
+		}
+	}
+}
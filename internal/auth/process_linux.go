@@ -0,0 +1,113 @@
+//go:build linux
+
+package auth
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// The process at the other end of a socket connection
+type Process struct {
+	// The path of the executable, if it could be read
+	Exe string `json:"exe,omitempty"`
+	// The unified (v2) cgroup of the process
+	Cgroup string `json:"cgroup,omitempty"`
+	// The systemd unit which the process belongs to, derived from its cgroup
+	Unit string `json:"unit,omitempty"`
+	// The real supplementary group IDs of the process
+	Groups []string `json:"groups,omitempty"`
+}
+
+// Suffixes of the names of systemd units which may own processes
+var unitSuffixes = []string{".service", ".scope"}
+
+// readProcess reads information about the process referred to by the pidfd from /proc.
+// The pidfd is used to check that the process is still alive once its information has been
+// read, so that the information cannot belong to another process which has reused its pid.
+func readProcess(pidfd int, pid int32) (*Process, error) {
+	procDir := fmt.Sprintf("/proc/%d", pid)
+	process := &Process{}
+
+	// reading the executable needs ptrace access to the process, so may not be permitted
+	process.Exe, _ = os.Readlink(path.Join(procDir, "exe"))
+
+	cgroupFile, err := os.Open(path.Join(procDir, "cgroup"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read process cgroup: %w", err)
+	}
+	defer cgroupFile.Close()
+	process.Cgroup, err = parseCgroup(cgroupFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read process cgroup: %w", err)
+	}
+	process.Unit = unitOfCgroup(process.Cgroup)
+
+	statusFile, err := os.Open(path.Join(procDir, "status"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read process status: %w", err)
+	}
+	defer statusFile.Close()
+	process.Groups, err = parseStatusGroups(statusFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read process status: %w", err)
+	}
+
+	err = unix.PidfdSendSignal(pidfd, 0, nil, 0)
+	if errors.Is(err, unix.ESRCH) {
+		return nil, fmt.Errorf("peer process %d exited during authentication", pid)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to check peer process: %w", err)
+	}
+
+	return process, nil
+}
+
+// parseCgroup finds the unified (v2) cgroup in the contents of /proc/<pid>/cgroup
+func parseCgroup(r io.Reader) (string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if cgroup, ok := strings.CutPrefix(scanner.Text(), "0::"); ok {
+			return cgroup, nil
+		}
+	}
+	return "", scanner.Err()
+}
+
+// unitOfCgroup finds the systemd unit in a cgroup path which the system manager placed the process in, e.g.
+// "nginx.service" for "/system.slice/nginx.service". Units nested beneath it, such as those of a user manager in
+// "user@1000.service", are named by whoever controls that part of the hierarchy, so no unit is found for them.
+func unitOfCgroup(cgroup string) string {
+	for _, component := range strings.Split(cgroup, "/") {
+		for _, suffix := range unitSuffixes {
+			if strings.HasSuffix(component, suffix) {
+				if strings.HasPrefix(component, "user@") {
+					return ""
+				}
+				return component
+			}
+		}
+	}
+	return ""
+}
+
+// parseStatusGroups finds the supplementary group IDs in the contents of /proc/<pid>/status
+func parseStatusGroups(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if groups, ok := strings.CutPrefix(scanner.Text(), "Groups:"); ok {
+			return strings.Fields(groups), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("no groups found in process status")
+}
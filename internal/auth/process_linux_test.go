@@ -0,0 +1,100 @@
+//go:build linux
+
+package auth
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestParseCgroup(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		want     string
+	}{
+		{"unified", "0::/system.slice/nginx.service\n", "/system.slice/nginx.service"},
+		{"hybrid", "12:cpu:/foo\n0::/user.slice/user-1000.slice/session-2.scope\n", "/user.slice/user-1000.slice/session-2.scope"},
+		{"legacy only", "12:cpu:/foo\n", ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseCgroup(strings.NewReader(tc.contents))
+			if err != nil {
+				t.Fatalf("parseCgroup: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("parseCgroup = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestUnitOfCgroup(t *testing.T) {
+	tests := []struct {
+		cgroup string
+		want   string
+	}{
+		{"/system.slice/nginx.service", "nginx.service"},
+		{"/user.slice/user-1000.slice/user@1000.service/app.slice/nginx.service", ""},
+		{"/user.slice/user-1000.slice/user@1000.service/init.scope", ""},
+		{"/user.slice/user-1000.slice/session-2.scope", "session-2.scope"},
+		{"/system.slice/nginx.service/worker", "nginx.service"},
+		{"/system.slice/nginx.service/delegated/foo.service", "nginx.service"},
+		{"/", ""},
+		{"", ""},
+	}
+	for _, tc := range tests {
+		if got := unitOfCgroup(tc.cgroup); got != tc.want {
+			t.Errorf("unitOfCgroup(%q) = %q, want %q", tc.cgroup, got, tc.want)
+		}
+	}
+}
+
+func TestParseStatusGroups(t *testing.T) {
+	status := "Name:\tnginx\nUid:\t60\t60\t60\t60\nGid:\t60\t60\t60\t60\nGroups:\t60 998 \nNgid:\t0\n"
+	got, err := parseStatusGroups(strings.NewReader(status))
+	if err != nil {
+		t.Fatalf("parseStatusGroups: %v", err)
+	}
+	if want := []string{"60", "998"}; !slices.Equal(got, want) {
+		t.Errorf("parseStatusGroups = %v, want %v", got, want)
+	}
+
+	_, err = parseStatusGroups(strings.NewReader("Name:\tnginx\n"))
+	if err == nil {
+		t.Error("parseStatusGroups without groups: want error")
+	}
+}
+
+func TestReadProcess_self(t *testing.T) {
+	pid := os.Getpid()
+	pidfd, err := unix.PidfdOpen(pid, 0)
+	if err != nil {
+		t.Skipf("pidfd not supported: %v", err)
+	}
+	defer unix.Close(pidfd)
+
+	process, err := readProcess(pidfd, int32(pid))
+	if err != nil {
+		t.Fatalf("readProcess: %v", err)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if process.Exe != exe {
+		t.Errorf("Exe = %q, want %q", process.Exe, exe)
+	}
+	groups, err := os.Getgroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(process.Groups) != len(groups) {
+		t.Errorf("Groups = %v, want %v", process.Groups, groups)
+	}
+}
@@ -2,9 +2,11 @@ package auth
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"slices"
 
 	"github.com/eliasvasylenko/secret-agent/internal/marshal"
 )
@@ -19,10 +21,20 @@ type RoleName string
 type Role struct {
 	Name        RoleName    `json:"name"`
 	Permissions Permissions `json:"permissions"`
+	// Roles whose permissions and denials are inherited
+	Inherits []RoleName `json:"inherits,omitempty"`
+	// Permissions which are denied, even if granted by this or any other claimed role
+	Deny Permissions `json:"deny,omitempty"`
+}
+
+// The permissions granted and denied by a set of roles
+type EffectivePermissions struct {
+	Granted Permissions `json:"granted"`
+	Denied  Permissions `json:"denied,omitempty"`
 }
 
 // A set of permissions
-type Permissions map[Subject]Action
+type Permissions map[Subject]Actions
 
 // Subjects which can be acted upon
 type Subject string
@@ -34,6 +46,12 @@ const (
 	Secrets Subject = "secrets"
 	// Instances subject
 	Instances Subject = "instances"
+	// Audit subject, for the audit trail of denied attempts
+	Audit Subject = "audit"
+	// Elevations subject, for break-glass elevations of principals to roles
+	Elevations Subject = "elevations"
+	// Status subject, for the status of the agent and the configuration it loaded
+	Status Subject = "status"
 )
 
 // Actions which can be performed upon subjects
@@ -48,8 +66,40 @@ const (
 	Read Action = "read"
 	// Write action
 	Write Action = "write"
+	// Approve action, for deciding upon operations which require approval
+	Approve Action = "approve"
+	// Force action, for overriding the safety checks of operations
+	Force Action = "force"
+	// Elevate action, for requesting and revoking break-glass elevations
+	Elevate Action = "elevate"
 )
 
+// One or more actions
+type Actions []Action
+
+func (a *Actions) UnmarshalJSON(p []byte) error {
+	var action Action
+	err1 := json.Unmarshal(p, &action)
+	if err1 == nil {
+		*a = Actions{action}
+		return nil
+	}
+	var temp []Action
+	err2 := json.Unmarshal(p, &temp)
+	if err2 != nil {
+		return errors.Join(err1, err2)
+	}
+	*a = temp
+	return nil
+}
+
+func (a Actions) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return marshal.JSON(a[0])
+	}
+	return marshal.JSON([]Action(a))
+}
+
 func Load(rolesFileName string) (Roles, error) {
 	rolesFile, err := os.Open(rolesFileName)
 	if err != nil {
@@ -70,36 +120,136 @@ func Load(rolesFileName string) (Roles, error) {
 	return roles, nil
 }
 
+// The JSON representation of a role, which is named by its key
+type roleJSON struct {
+	Permissions Permissions `json:"permissions"`
+	Inherits    []RoleName  `json:"inherits,omitempty"`
+	Deny        Permissions `json:"deny,omitempty"`
+}
+
 func (r *Roles) UnmarshalJSON(p []byte) error {
-	rolePermissions := make(map[RoleName]struct {
-		Permissions `json:"permissions"`
-	}, 0)
-	if err := json.Unmarshal(p, &rolePermissions); err != nil {
+	roles := make(map[RoleName]roleJSON, 0)
+	if err := json.Unmarshal(p, &roles); err != nil {
 		return err
 	}
 	*r = Roles{}
-	for name, permissions := range rolePermissions {
+	for name, role := range roles {
 		if name == "" {
 			return fmt.Errorf("Failed to parse role name")
 		}
 		(*r)[name] = Role{
 			Name:        name,
-			Permissions: permissions.Permissions,
+			Permissions: role.Permissions,
+			Inherits:    role.Inherits,
+			Deny:        role.Deny,
 		}
 	}
-	return nil
+	return r.validate()
 }
 
 func (r Roles) MarshalJSON() ([]byte, error) {
-	rolePermissions := make(map[RoleName]struct {
-		Permissions `json:"permissions"`
-	}, 0)
+	roles := make(map[RoleName]roleJSON, 0)
 	for _, role := range r {
-		rolePermissions[role.Name] = struct {
-			Permissions "json:\"permissions\""
-		}{role.Permissions}
+		roles[role.Name] = roleJSON{
+			Permissions: role.Permissions,
+			Inherits:    role.Inherits,
+			Deny:        role.Deny,
+		}
+	}
+	return marshal.JSON(roles)
+}
+
+// validate checks that roles only inherit from roles which exist, without cycles.
+func (r Roles) validate() error {
+	visited := make(map[RoleName]bool)
+	var visit func(name RoleName, path []RoleName) error
+	visit = func(name RoleName, path []RoleName) error {
+		if slices.Contains(path, name) {
+			return fmt.Errorf("role %s inherits from itself through %v", name, path)
+		}
+		if visited[name] {
+			return nil
+		}
+		role, ok := r[name]
+		if !ok {
+			return fmt.Errorf("role %s inherits from unknown role %s", path[len(path)-1], name)
+		}
+		for _, inherited := range role.Inherits {
+			if err := visit(inherited, append(path, name)); err != nil {
+				return err
+			}
+		}
+		visited[name] = true
+		return nil
+	}
+	for name := range r {
+		if err := visit(name, nil); err != nil {
+			return err
+		}
 	}
-	return marshal.JSON(rolePermissions)
+	return nil
+}
+
+// Effective merges the permissions granted and denied by the given claims, and by the roles they inherit from.
+func (r Roles) Effective(claims ClaimedRoles) EffectivePermissions {
+	effective := EffectivePermissions{Granted: Permissions{}, Denied: Permissions{}}
+	visited := make(map[RoleName]bool)
+	var visit func(name RoleName)
+	visit = func(name RoleName) {
+		if visited[name] {
+			return
+		}
+		visited[name] = true
+		role := r[name]
+		effective.Granted.merge(role.Permissions)
+		effective.Denied.merge(role.Deny)
+		for _, inherited := range role.Inherits {
+			visit(inherited)
+		}
+	}
+	for _, name := range claims {
+		visit(name)
+	}
+	return effective
+}
+
+// merge adds the given permissions to these permissions
+func (p Permissions) merge(permissions Permissions) {
+	for subject, actions := range permissions {
+		for _, action := range actions {
+			if !slices.Contains(p[subject], action) {
+				p[subject] = append(p[subject], action)
+			}
+		}
+	}
+}
+
+// Includes checks if the permissions include the given action on the given subject, directly or through All and Any.
+func (p Permissions) Includes(subject Subject, action Action) bool {
+	for _, s := range []Subject{subject, All} {
+		actions := p[s]
+		if slices.Contains(actions, action) || slices.Contains(actions, Any) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckPermission checks if the given action on the given subject is granted and not denied.
+func (e EffectivePermissions) CheckPermission(subject Subject, action Action) bool {
+	return e.Granted.Includes(subject, action) && !e.Denied.Includes(subject, action)
+}
+
+// CheckPermissions checks if all of the given permissions are granted and not denied.
+func (e EffectivePermissions) CheckPermissions(permissions Permissions) bool {
+	for subject, actions := range permissions {
+		for _, action := range actions {
+			if !e.CheckPermission(subject, action) {
+				return false
+			}
+		}
+	}
+	return true
 }
 
 // AssertPermission checks if the given claims have the given permissions.
@@ -112,26 +262,12 @@ func (r Roles) AssertPermission(claims ClaimedRoles, permissions Permissions) er
 	return nil
 }
 
-// CheckPermission checks if the given claims have the given permissions.
+// CheckPermission checks if the given claims have the given permissions, merged across all claimed roles.
 func (r Roles) CheckPermission(claims ClaimedRoles, permissions Permissions) bool {
-	for _, roleName := range claims {
-		role := r[roleName]
-		allPermitted := true
-		for subject, action := range permissions {
-			if !role.CheckPermission(subject, action) && !role.CheckPermission(All, action) {
-				allPermitted = false
-				break
-			}
-		}
-		if allPermitted {
-			return true
-		}
-	}
-	return false
+	return r.Effective(claims).CheckPermissions(permissions)
 }
 
-// CheckPermission checks if the given role has the given permission.
+// CheckPermission checks if the given role has the given permission, disregarding the roles it inherits from.
 func (r Role) CheckPermission(subject Subject, action Action) bool {
-	permittedAction, ok := r.Permissions[subject]
-	return ok && (permittedAction == action || permittedAction == Any)
+	return r.Permissions.Includes(subject, action) && !r.Deny.Includes(subject, action)
 }
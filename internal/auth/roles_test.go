@@ -11,8 +11,9 @@ func TestRoleCheckPermission(t *testing.T) {
 	role := Role{
 		Name: "reader",
 		Permissions: Permissions{
-			Secrets: Read,
-			All:     List,
+			Secrets:   {Read},
+			Instances: {Read, Approve},
+			All:       {List},
 		},
 	}
 	tests := []struct {
@@ -23,7 +24,9 @@ func TestRoleCheckPermission(t *testing.T) {
 		{Secrets, Read, true},
 		{Secrets, Write, false},
 		{All, List, true},
-		{Instances, Read, false},
+		{Instances, Read, true},
+		{Instances, Approve, true},
+		{Instances, Write, false},
 	}
 	for _, tc := range tests {
 		t.Run(string(tc.subject)+"_"+string(tc.action), func(t *testing.T) {
@@ -39,11 +42,15 @@ func TestRolesCheckPermission(t *testing.T) {
 	roles := Roles{
 		"reader": {
 			Name:        "reader",
-			Permissions: Permissions{Secrets: Read},
+			Permissions: Permissions{Secrets: {Read}},
 		},
 		"admin": {
 			Name:        "admin",
-			Permissions: Permissions{All: Any},
+			Permissions: Permissions{All: {Any}},
+		},
+		"approver": {
+			Name:        "approver",
+			Permissions: Permissions{Instances: {Read, Approve}},
 		},
 	}
 	tests := []struct {
@@ -51,11 +58,13 @@ func TestRolesCheckPermission(t *testing.T) {
 		perms     Permissions
 		permitted bool
 	}{
-		{ClaimedRoles{"reader"}, Permissions{Secrets: Read}, true},
-		{ClaimedRoles{"reader"}, Permissions{Secrets: Write}, false},
-		{ClaimedRoles{"admin"}, Permissions{Secrets: Write}, true},
-		{ClaimedRoles{"reader", "admin"}, Permissions{Instances: Write}, true},
-		{ClaimedRoles{"reader"}, Permissions{Instances: Read}, false},
+		{ClaimedRoles{"reader"}, Permissions{Secrets: {Read}}, true},
+		{ClaimedRoles{"reader"}, Permissions{Secrets: {Write}}, false},
+		{ClaimedRoles{"admin"}, Permissions{Secrets: {Write}}, true},
+		{ClaimedRoles{"reader", "admin"}, Permissions{Instances: {Write}}, true},
+		{ClaimedRoles{"reader"}, Permissions{Instances: {Read}}, false},
+		{ClaimedRoles{"approver"}, Permissions{Instances: {Read, Approve}}, true},
+		{ClaimedRoles{"approver"}, Permissions{Instances: {Approve, Write}}, false},
 	}
 	for _, tc := range tests {
 		t.Run("", func(t *testing.T) {
@@ -67,14 +76,111 @@ func TestRolesCheckPermission(t *testing.T) {
 	}
 }
 
+func TestRolesCheckPermission_inheritsAndDeny(t *testing.T) {
+	roles := Roles{
+		"reader": {
+			Name:        "reader",
+			Permissions: Permissions{Secrets: {List, Read}, Instances: {Read}},
+		},
+		"operator": {
+			Name:        "operator",
+			Permissions: Permissions{Instances: {Write}},
+			Inherits:    []RoleName{"reader"},
+			Deny:        Permissions{Instances: {Force}},
+		},
+		"admin": {
+			Name:        "admin",
+			Permissions: Permissions{All: {Any}},
+		},
+		"no-audit": {
+			Name: "no-audit",
+			Deny: Permissions{Audit: {Any}},
+		},
+		"writer": {
+			Name:        "writer",
+			Permissions: Permissions{Secrets: {Write}},
+		},
+	}
+	tests := []struct {
+		name      string
+		claims    ClaimedRoles
+		perms     Permissions
+		permitted bool
+	}{
+		{"inherited", ClaimedRoles{"operator"}, Permissions{Secrets: {Read}, Instances: {Write}}, true},
+		{"denied by own role", ClaimedRoles{"operator"}, Permissions{Instances: {Force}}, false},
+		{"denied across roles", ClaimedRoles{"operator", "admin"}, Permissions{Instances: {Force}}, false},
+		{"deny any", ClaimedRoles{"admin", "no-audit"}, Permissions{Audit: {Read}}, false},
+		{"deny leaves other subjects", ClaimedRoles{"admin", "no-audit"}, Permissions{Secrets: {Write}}, true},
+		{"merged across roles", ClaimedRoles{"operator", "writer"}, Permissions{Secrets: {Write}, Instances: {Write}}, true},
+		{"no roles", ClaimedRoles{}, Permissions{Secrets: {Read}}, false},
+		{"no permissions required", ClaimedRoles{}, Permissions{}, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := roles.CheckPermission(tc.claims, tc.perms)
+			if got != tc.permitted {
+				t.Errorf("CheckPermission(%v, %v): expected %v, got %v", tc.claims, tc.perms, tc.permitted, got)
+			}
+		})
+	}
+}
+
+func TestRolesEffective(t *testing.T) {
+	roles := Roles{
+		"reader":   {Name: "reader", Permissions: Permissions{Secrets: {Read}}},
+		"operator": {Name: "operator", Permissions: Permissions{Secrets: {Write}}, Inherits: []RoleName{"reader"}, Deny: Permissions{Instances: {Force}}},
+		"auditor":  {Name: "auditor", Permissions: Permissions{Audit: {Read}}, Inherits: []RoleName{"reader"}},
+	}
+	got := roles.Effective(ClaimedRoles{"operator", "auditor"})
+	want := EffectivePermissions{
+		Granted: Permissions{Secrets: {Write, Read}, Audit: {Read}},
+		Denied:  Permissions{Instances: {Force}},
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("Effective:\n%s", cmp.Diff(want, got))
+	}
+}
+
+func TestRolesUnmarshalJSON_inherits(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		wantErr bool
+	}{
+		{"inherits", `{"reader":{"permissions":{"secrets":"read"}},"operator":{"permissions":{},"inherits":["reader"],"deny":{"instances":"force"}}}`, false},
+		{"unknown role", `{"operator":{"permissions":{},"inherits":["reader"]}}`, true},
+		{"cycle", `{"a":{"permissions":{},"inherits":["b"]},"b":{"permissions":{},"inherits":["a"]}}`, true},
+		{"self", `{"a":{"permissions":{},"inherits":["a"]}}`, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var roles Roles
+			err := json.Unmarshal([]byte(tc.json), &roles)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Unmarshal err = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+
+	var roles Roles
+	if err := json.Unmarshal([]byte(tests[0].json), &roles); err != nil {
+		t.Fatal(err)
+	}
+	want := Role{Name: "operator", Permissions: Permissions{}, Inherits: []RoleName{"reader"}, Deny: Permissions{Instances: {Force}}}
+	if !cmp.Equal(roles["operator"], want) {
+		t.Errorf("Unmarshal operator:\n%s", cmp.Diff(want, roles["operator"]))
+	}
+}
+
 func TestRolesAssertPermission(t *testing.T) {
 	roles := Roles{
-		"reader": {Name: "reader", Permissions: Permissions{Secrets: Read}},
+		"reader": {Name: "reader", Permissions: Permissions{Secrets: {Read}}},
 	}
-	if err := roles.AssertPermission(ClaimedRoles{"reader"}, Permissions{Secrets: Read}); err != nil {
+	if err := roles.AssertPermission(ClaimedRoles{"reader"}, Permissions{Secrets: {Read}}); err != nil {
 		t.Errorf("expected no error, got %v", err)
 	}
-	if err := roles.AssertPermission(ClaimedRoles{"reader"}, Permissions{Secrets: Write}); err == nil {
+	if err := roles.AssertPermission(ClaimedRoles{"reader"}, Permissions{Secrets: {Write}}); err == nil {
 		t.Error("expected error for denied permission")
 	}
 }
@@ -98,3 +204,31 @@ func TestClaimedRolesUnmarshalJSON(t *testing.T) {
 		}
 	}
 }
+
+func TestActionsUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		json string
+		want Actions
+	}{
+		{`"read"`, Actions{Read}},
+		{`["read","approve"]`, Actions{Read, Approve}},
+	}
+	for _, tc := range tests {
+		var got Actions
+		if err := json.Unmarshal([]byte(tc.json), &got); err != nil {
+			t.Errorf("Unmarshal(%s): %v", tc.json, err)
+			continue
+		}
+		if !cmp.Equal(got, tc.want) {
+			t.Errorf("Unmarshal(%s):\n%s", tc.json, cmp.Diff(tc.want, got))
+		}
+		roundTrip, err := json.Marshal(got)
+		if err != nil {
+			t.Errorf("Marshal(%v): %v", got, err)
+			continue
+		}
+		if string(roundTrip) != tc.json {
+			t.Errorf("Marshal(%v): expected %s, got %s", got, tc.json, roundTrip)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package bulk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/eliasvasylenko/secret-agent/internal/command"
+	"github.com/eliasvasylenko/secret-agent/internal/logging"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+	"github.com/eliasvasylenko/secret-agent/internal/store"
+	"github.com/eliasvasylenko/secret-agent/internal/validation"
+	"github.com/google/uuid"
+)
+
+// The most operations of a batch which may be performed at once
+const maxConcurrency = 64
+
+// A secret is skipped if the operation applies to its active instance and it has none
+var errNoActiveInstance = errors.New("no active instance")
+
+// A secret is skipped if an earlier operation in the batch failed and the batch does not keep going
+var errStopped = errors.New("not attempted after an earlier failure")
+
+// A selection of secrets by their IDs or labels
+type Selector struct {
+	// Patterns, as understood by path.Match, at least one of which the ID of a selected secret matches
+	Names []string `json:"names,omitempty"`
+	// Labels which a selected secret carries with the given values
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// A request to perform an operation across a selection of secrets. Operations other than create are
+// performed upon the active instance of each secret.
+type Request struct {
+	Name   secrets.OperationName `json:"name" openapi:"required"`
+	Select Selector              `json:"select" openapi:"required"`
+	// The most operations which may be performed at once, or one at a time if not given. The agent may perform
+	// fewer at once, so that the caller has no more operations in flight than it permits.
+	Concurrency int `json:"concurrency,omitempty"`
+	// Whether to carry on with the rest of the batch after an operation fails
+	KeepGoing bool                `json:"keepGoing,omitzero"`
+	Env       command.Environment `json:"env"`
+	Forced    bool                `json:"forced"`
+	Reason    string              `json:"reason"`
+}
+
+// The outcome of the operation on one secret of a batch
+type Result struct {
+	SecretId string            `json:"secretId"`
+	Instance *secrets.Instance `json:"instance,omitempty"`
+	Skipped  bool              `json:"skipped,omitzero"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// A summary of the outcome of a batch, with a result for each selected secret in order of their IDs
+type Report struct {
+	BatchId   string                `json:"batchId"`
+	Name      secrets.OperationName `json:"name"`
+	Results   []*Result             `json:"results"`
+	Succeeded int                   `json:"succeeded"`
+	Failed    int                   `json:"failed"`
+	Skipped   int                   `json:"skipped"`
+}
+
+func (s Selector) validate(v *validation.Validator, pointer string) {
+	if len(s.Names) == 0 && len(s.Labels) == 0 {
+		v.Field(pointer, "selector must give names or labels")
+	}
+	for i, pattern := range s.Names {
+		if _, err := path.Match(pattern, ""); err != nil {
+			v.Field(pointer+validation.Pointer("names", strconv.Itoa(i)), "invalid name pattern %q: %v", pattern, err)
+		}
+	}
+}
+
+// Matches reports whether the secret with the given ID is selected
+func (s Selector) Matches(secretId string, secret *secrets.Secret) bool {
+	for label, value := range s.Labels {
+		if actual, ok := secret.Labels[label]; !ok || actual != value {
+			return false
+		}
+	}
+	if len(s.Names) == 0 {
+		return true
+	}
+	for _, pattern := range s.Names {
+		if matched, _ := path.Match(pattern, secretId); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Select gives the IDs of the selected secrets in order
+func (s Selector) Select(all secrets.Secrets) []string {
+	var selected []string
+	for _, secretId := range slices.Sorted(maps.Keys(all)) {
+		if s.Matches(secretId, all[secretId]) {
+			selected = append(selected, secretId)
+		}
+	}
+	return selected
+}
+
+// Validate reports any problems with a request, by the JSON pointers of the fields at fault
+func (r *Request) Validate() error {
+	var v validation.Validator
+	switch r.Name {
+	case secrets.Create, secrets.Activate, secrets.Deactivate, secrets.Destroy, secrets.Test:
+	default:
+		v.Field("/name", "cannot perform operation %q in bulk", r.Name)
+	}
+	if r.Concurrency < 0 || r.Concurrency > maxConcurrency {
+		v.Field("/concurrency", "must not be negative or exceed %d", maxConcurrency)
+	}
+	r.Select.validate(&v, "/select")
+	r.Env.Validate(&v, "/env")
+	return v.Err()
+}
+
+// Err reports an error if any operation of the batch failed
+func (r *Report) Err() error {
+	if r.Failed > 0 {
+		return fmt.Errorf("%d of %d operations of batch %s failed", r.Failed, len(r.Results), r.BatchId)
+	}
+	return nil
+}
+
+// Run performs the requested operation upon each selected secret on behalf of a principal, and under their
+// elevation if any, tagging each with the ID of the batch. Each secret is first given to authorise, if given,
+// which may refuse the operation upon it by returning an error.
+func Run(ctx context.Context, secretStore store.Secrets, request Request, startedBy string, elevationId string, authorise func(secretId string) error) (*Report, error) {
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+	all, err := secretStore.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	selected := request.Select.Select(all)
+
+	report := &Report{
+		BatchId: uuid.NewString(),
+		Name:    request.Name,
+		Results: make([]*Result, len(selected)),
+	}
+	parameters := secrets.OperationParameters{
+		Env:         request.Env,
+		Forced:      request.Forced,
+		Reason:      request.Reason,
+		StartedBy:   startedBy,
+		ElevationId: elevationId,
+		BatchId:     report.BatchId,
+		RequestId:   logging.RequestId(ctx),
+	}
+
+	concurrency := max(request.Concurrency, 1)
+	slots := make(chan struct{}, concurrency)
+	var failed bool
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i, secretId := range selected {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			mu.Lock()
+			stopped := failed && !request.KeepGoing
+			mu.Unlock()
+
+			var instance *secrets.Instance
+			err := errStopped
+			if !stopped {
+				instance, err = perform(ctx, secretStore, request.Name, secretId, parameters, authorise)
+			}
+
+			result := &Result{SecretId: secretId, Instance: instance}
+			if err != nil {
+				result.Error = err.Error()
+				result.Skipped = err == errStopped || err == errNoActiveInstance
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			report.Results[i] = result
+			if err != nil && !result.Skipped {
+				failed = true
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, result := range report.Results {
+		if result.Skipped {
+			report.Skipped++
+		} else if result.Error != "" {
+			report.Failed++
+		} else {
+			report.Succeeded++
+		}
+	}
+	return report, nil
+}
+
+// perform the operation upon a secret, or upon its active instance for operations other than create
+func perform(ctx context.Context, secretStore store.Secrets, name secrets.OperationName, secretId string, parameters secrets.OperationParameters, authorise func(secretId string) error) (*secrets.Instance, error) {
+	if authorise != nil {
+		if err := authorise(secretId); err != nil {
+			return nil, err
+		}
+	}
+	instances := secretStore.Instances(secretId)
+	if name == secrets.Create {
+		return instances.Create(ctx, parameters)
+	}
+
+	active, err := instances.GetActive(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if active == nil {
+		return nil, errNoActiveInstance
+	}
+	switch name {
+	case secrets.Activate:
+		return instances.Activate(ctx, active.Id, parameters)
+	case secrets.Deactivate:
+		return instances.Deactivate(ctx, active.Id, parameters)
+	case secrets.Destroy:
+		return instances.Destroy(ctx, active.Id, parameters)
+	default:
+		return instances.Test(ctx, active.Id, parameters)
+	}
+}
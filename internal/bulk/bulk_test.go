@@ -0,0 +1,153 @@
+package bulk
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/eliasvasylenko/secret-agent/internal/command"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+	"github.com/eliasvasylenko/secret-agent/internal/sqlite"
+	"github.com/eliasvasylenko/secret-agent/internal/store"
+)
+
+func TestSelector_Matches(t *testing.T) {
+	db := &secrets.Secret{Name: "db-a", Labels: map[string]string{"tier": "db", "env": "prod"}}
+	tests := []struct {
+		name     string
+		selector Selector
+		want     bool
+	}{
+		{"name pattern", Selector{Names: []string{"db-*"}}, true},
+		{"any name pattern", Selector{Names: []string{"web", "db-?"}}, true},
+		{"no name pattern", Selector{Names: []string{"web*"}}, false},
+		{"label", Selector{Labels: map[string]string{"tier": "db"}}, true},
+		{"all labels", Selector{Labels: map[string]string{"tier": "db", "env": "dev"}}, false},
+		{"name and label", Selector{Names: []string{"db-*"}, Labels: map[string]string{"env": "prod"}}, true},
+		{"name but not label", Selector{Names: []string{"db-*"}, Labels: map[string]string{"owner": "ops"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.selector.Matches("db-a", db); got != tt.want {
+				t.Errorf("Matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequest_Validate(t *testing.T) {
+	valid := Request{Name: secrets.Test, Select: Selector{Names: []string{"db-*"}}}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Validate = %v, want nil", err)
+	}
+	for name, request := range map[string]Request{
+		"unknown operation": {Name: "rotate", Select: valid.Select},
+		"no selector":       {Name: secrets.Test},
+		"bad pattern":       {Name: secrets.Test, Select: Selector{Names: []string{"db-["}}},
+		"concurrency":       {Name: secrets.Test, Select: valid.Select, Concurrency: maxConcurrency + 1},
+	} {
+		if err := request.Validate(); err == nil {
+			t.Errorf("Validate %s = nil, want error", name)
+		}
+	}
+}
+
+// repoSecrets adapts the sqlite repository to store.Secrets
+type repoSecrets struct {
+	*sqlite.SecretRespository
+}
+
+func (s repoSecrets) Instances(secretId string) store.Instances {
+	return s.SecretRespository.Instances(secretId)
+}
+
+// newActiveRepo creates a store with an active instance of each secret
+func newActiveRepo(t *testing.T, all secrets.Secrets) repoSecrets {
+	t.Helper()
+	ctx := context.Background()
+	repo, err := sqlite.NewSecretRepository(ctx, filepath.Join(t.TempDir(), "store.db"), all, false, 256)
+	if err != nil {
+		t.Fatalf("NewSecretRepository: %v", err)
+	}
+	t.Cleanup(repo.Close)
+	for secretId := range all {
+		instances := repo.Instances(secretId)
+		instance, err := instances.Create(ctx, secrets.OperationParameters{StartedBy: "user"})
+		if err == nil {
+			_, err = instances.Activate(ctx, instance.Id, secrets.OperationParameters{StartedBy: "user"})
+		}
+		if err != nil {
+			t.Fatalf("activating %s: %v", secretId, err)
+		}
+	}
+	return repoSecrets{repo}
+}
+
+func TestRun(t *testing.T) {
+	all := secrets.Secrets{
+		"db-a": {Name: "db-a", Test: &command.Command{Script: "true"}},
+		"db-b": {Name: "db-b", Test: &command.Command{Script: "false"}},
+		"db-c": {Name: "db-c", Test: &command.Command{Script: "true"}},
+		"web":  {Name: "web", Test: &command.Command{Script: "true"}},
+	}
+	tests := []struct {
+		name      string
+		keepGoing bool
+		want      map[string]string
+	}{
+		{"stop on failure", false, map[string]string{"db-a": "succeeded", "db-b": "failed", "db-c": "skipped"}},
+		{"keep going", true, map[string]string{"db-a": "succeeded", "db-b": "failed", "db-c": "succeeded"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newActiveRepo(t, all)
+			ctx := context.Background()
+			request := Request{Name: secrets.Test, Select: Selector{Names: []string{"db-*"}}, KeepGoing: tt.keepGoing, Reason: "incident"}
+			report, err := Run(ctx, repo, request, "user", "", nil)
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			if len(report.Results) != len(tt.want) {
+				t.Fatalf("Results = %v, want one per selected secret", report.Results)
+			}
+			for _, result := range report.Results {
+				outcome := "succeeded"
+				if result.Skipped {
+					outcome = "skipped"
+				} else if result.Error != "" {
+					outcome = "failed"
+				}
+				if outcome != tt.want[result.SecretId] {
+					t.Errorf("%s %s (%s), want %s", result.SecretId, outcome, result.Error, tt.want[result.SecretId])
+				}
+				if result.Instance != nil && (result.Instance.Status.BatchId == nil || *result.Instance.Status.BatchId != report.BatchId) {
+					t.Errorf("%s status = %+v, want batch %s", result.SecretId, result.Instance.Status, report.BatchId)
+				}
+			}
+			if report.Err() == nil {
+				t.Error("Err = nil, want failure of batch")
+			}
+		})
+	}
+}
+
+func TestRun_authorise(t *testing.T) {
+	all := secrets.Secrets{"db-a": {Name: "db-a"}, "db-b": {Name: "db-b"}}
+	repo := newActiveRepo(t, all)
+	denied := errors.New("denied")
+	authorise := func(secretId string) error {
+		if secretId == "db-b" {
+			return denied
+		}
+		return nil
+	}
+	request := Request{Name: secrets.Create, Select: Selector{Names: []string{"*"}}, Concurrency: 2, KeepGoing: true}
+	report, err := Run(context.Background(), repo, request, "user", "", authorise)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if report.Succeeded != 1 || report.Failed != 1 || report.Results[1].Error != denied.Error() {
+		t.Errorf("report = %+v, want db-b refused", report)
+	}
+}
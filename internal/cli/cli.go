@@ -2,14 +2,28 @@ package cli
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"iter"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/alecthomas/kong"
+	"github.com/eliasvasylenko/secret-agent/internal/audit"
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/bulk"
+	"github.com/eliasvasylenko/secret-agent/internal/client"
 	"github.com/eliasvasylenko/secret-agent/internal/command"
+	"github.com/eliasvasylenko/secret-agent/internal/config"
+	"github.com/eliasvasylenko/secret-agent/internal/events"
+	"github.com/eliasvasylenko/secret-agent/internal/logging"
 	"github.com/eliasvasylenko/secret-agent/internal/marshal"
+	"github.com/eliasvasylenko/secret-agent/internal/metrics"
+	"github.com/eliasvasylenko/secret-agent/internal/notify"
 	"github.com/eliasvasylenko/secret-agent/internal/secrets"
 	"github.com/eliasvasylenko/secret-agent/internal/server"
 	"github.com/eliasvasylenko/secret-agent/internal/store"
@@ -19,11 +33,19 @@ type CLI struct {
 	SecretsFile     string          `short:"S" env:"SECRETS_FILE" help:"Path to secrets configuration file"`
 	PermissionsFile string          `short:"P" env:"PERMISSIONS_FILE" help:"Path to permissions (roles/claims) configuration file"`
 	DbFile          string          `short:"D" env:"DB_FILE" help:"Path to sqlite database file"`
-	ClientSocket    string          `short:"c" env:"CLIENT_SOCKET" help:"Unix socket for connecting to a running secret-agent server"`
+	ClientSocket    string          `short:"c" env:"CLIENT_SOCKET" help:"Unix socket for connecting to a running secret-agent server, or else the first reachable of $XDG_RUNTIME_DIR/secret-agent.sock and /run/secret-agent/socket"`
+	Local           bool            `env:"LOCAL" help:"Operate directly upon the sqlite database rather than through a running server"`
+	ConnectTimeout  time.Duration   `env:"CONNECT_TIMEOUT" default:"5s" help:"Time allowed to connect to a running server"`
+	ReadTimeout     time.Duration   `env:"READ_TIMEOUT" default:"5m" help:"Time allowed for a running server to respond to a read; operations are waited upon for as long as they take"`
+	Retries         int             `env:"RETRIES" default:"3" help:"Times a read from a running server is retried after failing transiently"`
 	MaxReasonLength int             `short:"R" env:"MAX_REASON_LENGTH" default:"4096" help:"Max length of audit reason strings"`
 	Debug           bool            `short:"d" env:"DEBUG" help:"Enable debug logging"`
+	LogFormat       string          `env:"LOG_FORMAT" enum:"text,json" default:"text" help:"Format of log lines written to stderr, one of text or json"`
 	Pretty          bool            `short:"p" env:"PRETTY" help:"Pretty-print JSON output"`
+	Output          string          `env:"OUTPUT" enum:"json,jsonl,table,yaml,env" default:"json" help:"Format of results written to stdout, one of json, jsonl (a line for each item of a list), table, yaml or env (shell variables identifying the result, for eval)"`
+	Template        string          `env:"TEMPLATE" help:"Go text/template with which to write the result, or each item of a list, in place of --output; fields are named as in JSON, e.g. '{{.id}} {{.status.name}}'"`
 	Secrets         Secrets         `cmd:"" help:"List secrets"`
+	Status          Status          `cmd:"" help:"Summarise every secret at once, with its active instance, latest and running operations, live instances and a verdict upon its health"`
 	Secret          Secret          `cmd:"" help:"Show a secret"`
 	Instances       Instances       `cmd:"" help:"List instances of a secret"`
 	Instance        Instance        `cmd:"" help:"Show an instance of a secret"`
@@ -34,12 +56,76 @@ type CLI struct {
 	Activate        InstanceCommand `cmd:"" help:"Activate an instance of a secret"`
 	Deactivate      InstanceCommand `cmd:"" help:"Deactivate an instance of a secret"`
 	Test            InstanceCommand `cmd:"" help:"Test an instance of a secret"`
+	Bulk            Bulk            `cmd:"" help:"Perform an operation across secrets selected by name or label, upon the active instance of each unless creating one"`
+	Approvals       Approvals       `cmd:"" help:"Manage approval requests for operations"`
+	Elevate         Elevate         `cmd:"" help:"Elevate to a role for a bounded time, for break-glass access"`
+	Elevations      Elevations      `cmd:"" help:"Manage break-glass elevations"`
+	Audit           Audit           `cmd:"" help:"Inspect the audit trail"`
+	Whoami          Whoami          `cmd:"" help:"Show the caller's identity and effective permissions, and check whether an operation would be permitted"`
+	Events          Events          `cmd:"" help:"Stream events as they happen, writing each as it is received"`
+	Follow          Follow          `cmd:"" help:"Run a command whenever the active instance of a secret changes"`
 	Serve           Serve           `cmd:"" help:"Serve the secret agent API"`
+	Completion      Completion      `cmd:"" help:"Write a script which completes commands, secret IDs and instance IDs in the given shell"`
+	Complete        Complete        `cmd:"" name:"__complete" hidden:"" help:"Give the completions of the last word of a command line"`
 
 	ctx         kongContext
 	secretStore store.Secrets
+	approvals   approvals
+	denials     denials
+	whoami      whoami
+	elevations  elevations
+	events      eventStream
+	bulk        bulkOperations
+	summaries   summaries
+
+	template *template.Template
+	// The ID of the active instance of the secret whose instances are written, to mark it in tables
+	activeInstanceId string
+	// Whether a result has been written, e.g. so that a stream of events is written as a single table
+	written bool
+}
+
+// Approval requests are only decided upon through a running server, which identifies the caller
+type approvals interface {
+	List(ctx context.Context, from int, to int) ([]*secrets.Approval, error)
+	Approve(ctx context.Context, approvalId string, comment string) (*secrets.Approval, error)
+	Reject(ctx context.Context, approvalId string, comment string) (*secrets.Approval, error)
+}
+
+// The caller is only identified by a running server
+type whoami interface {
+	Whoami(ctx context.Context, query *server.OperationQuery) (*server.Whoami, error)
+}
+
+// Elevations are only granted through a running server, which identifies the caller
+type elevations interface {
+	List(ctx context.Context, from int, to int) ([]*auth.Elevation, error)
+	Elevate(ctx context.Context, parameters server.ElevationParameters) (*auth.Elevation, error)
+	Revoke(ctx context.Context, elevationId string) (*auth.Elevation, error)
+}
+
+// Events are only streamed from a running server
+type eventStream interface {
+	Stream(ctx context.Context, query client.EventQuery) iter.Seq2[*events.Event, error]
+}
+
+// Operations are performed in bulk through a running server, which authorises each, or directly upon the store
+type bulkOperations interface {
+	Run(ctx context.Context, request bulk.Request) (*bulk.Report, error)
+}
+
+// Secrets are summarised by a running server, which judges operations stuck by its own threshold, or directly from
+// the database
+type summaries interface {
+	Summaries(ctx context.Context) ([]*secrets.Summary, error)
 }
 
+type denials interface {
+	List(ctx context.Context, from int, to int) ([]*audit.Denial, error)
+}
+
+var errNoServer = errors.New("command requires a running secret-agent server, and cannot operate directly upon the database")
+
 type kongContext interface {
 	Command() string
 	FatalIfErrorf(err error, args ...any)
@@ -49,22 +135,77 @@ func NewCLI(ctx context.Context) *CLI {
 	var c CLI
 	c.ctx = kong.Parse(&c)
 
-	if c.Debug {
-		log.Default().Printf("cli %v", c)
-	}
+	logger, err := logging.NewLogger(os.Stderr, c.LogFormat, c.Debug)
+	c.ctx.FatalIfErrorf(err)
+	slog.SetDefault(logger)
+	slog.Debug("parsed command line", "cli", fmt.Sprintf("%+v", c))
 
-	var err error
-	c.secretStore, err = NewStore(ctx, c.ClientSocket, c.SecretsFile, c.DbFile, c.Debug, c.MaxReasonLength)
+	c.template, err = parseTemplate(c.Template)
 	c.ctx.FatalIfErrorf(err)
+
+	// completion only connects to the store configured by the command line being completed, if it needs to
+	switch c.ctx.Command() {
+	case "completion <shell>", "__complete", "__complete <words>":
+		return &c
+	}
+	c.ctx.FatalIfErrorf(c.connect(ctx))
 	return &c
 }
 
+// connect connects to the store, through a running server unless the command operates directly upon the database
+func (c *CLI) connect(ctx context.Context) error {
+	if c.secretStore != nil {
+		return nil
+	}
+	// the server itself always operates upon the database
+	var clientConfig *client.Config
+	if !c.Local && c.ctx.Command() != "serve" {
+		socket, err := client.FindSocket(ctx, c.ClientSocket, c.ConnectTimeout)
+		if errors.Is(err, client.ErrNoServer) {
+			err = fmt.Errorf("%w, give --client-socket, or --local to operate directly upon the database", err)
+		}
+		if err != nil {
+			return err
+		}
+		clientConfig = &client.Config{
+			Socket:         socket,
+			ConnectTimeout: c.ConnectTimeout,
+			ReadTimeout:    c.ReadTimeout,
+			Retries:        c.Retries,
+		}
+	}
+	var err error
+	c.secretStore, err = NewStore(ctx, clientConfig, c.SecretsFile, c.DbFile, c.Debug, c.MaxReasonLength)
+	if err != nil {
+		return err
+	}
+	switch store := c.secretStore.(type) {
+	case clientSecrets:
+		c.approvals = store.Approvals()
+		c.denials = store.Denials()
+		c.whoami = store
+		c.elevations = store.Elevations()
+		c.events = store.Events()
+		c.bulk = store.Bulk()
+		c.summaries = store
+	case sqliteSecrets:
+		c.denials = store.Denials()
+		c.bulk = localBulk{store}
+		c.summaries = localSummaries{store.Health(), c.Status.StuckAfter}
+	}
+	return nil
+}
+
 func (c *CLI) Run(ctx context.Context) {
+	c.ctx.FatalIfErrorf(c.resolveInstanceIds(ctx))
+
 	var result any
 	var err error
 	switch c.ctx.Command() {
 	case "secrets":
 		result, err = c.secretStore.List(ctx)
+	case "status":
+		result, err = c.summaries.Summaries(ctx)
 	case "secret <secret-id>":
 		result, err = c.secretStore.Get(ctx, c.Secret.SecretID)
 	case "instances <secret-id>":
@@ -72,11 +213,11 @@ func (c *CLI) Run(ctx context.Context) {
 	case "instance <secret-id> <instance-id>":
 		result, err = c.secretStore.Instances(c.Instance.SecretID).Get(ctx, c.Instance.InstanceID)
 	case "active <secret-id>":
-		result, err = c.secretStore.Instances(c.Instance.SecretID).GetActive(ctx)
+		result, err = c.secretStore.Instances(c.Active.SecretID).GetActive(ctx)
 	case "history <secret-id>":
 		result, err = c.secretStore.History(ctx, c.History.SecretID, c.History.From, c.History.To)
 	case "history <secret-id> <instance-id>":
-		result, err = c.secretStore.Instances(c.Instance.SecretID).History(ctx, c.Instance.InstanceID, c.History.From, c.History.To)
+		result, err = c.secretStore.Instances(c.History.SecretID).History(ctx, c.History.InstanceID, c.History.From, c.History.To)
 	case "create <secret-id>":
 		result, err = c.secretStore.Instances(c.Create.SecretID).Create(ctx, c.Create.parameters())
 	case "destroy <secret-id> <instance-id>":
@@ -87,37 +228,178 @@ func (c *CLI) Run(ctx context.Context) {
 		result, err = c.secretStore.Instances(c.Deactivate.SecretID).Deactivate(ctx, c.Deactivate.InstanceID, c.Deactivate.parameters())
 	case "test <secret-id> <instance-id>":
 		result, err = c.secretStore.Instances(c.Test.SecretID).Test(ctx, c.Test.InstanceID, c.Test.parameters())
+	case "bulk <operation>":
+		report, err := c.bulk.Run(ctx, c.Bulk.request())
+		c.ctx.FatalIfErrorf(err)
+		c.ctx.FatalIfErrorf(c.writeResult(report))
+		c.ctx.FatalIfErrorf(report.Err())
+		return
+	case "approvals list", "approvals approve <approval-id>", "approvals reject <approval-id>":
+		result, err = c.runApprovals(ctx)
+	case "elevate <role>", "elevations list", "elevations revoke <elevation-id>":
+		result, err = c.runElevations(ctx)
+	case "whoami":
+		result, err = c.runWhoami(ctx)
+	case "events":
+		c.ctx.FatalIfErrorf(c.runEvents(ctx))
+		return
+	case "follow <secret-id> <command>":
+		ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		c.ctx.FatalIfErrorf(c.runFollow(ctx))
+		return
+	case "audit denials":
+		result, err = c.denials.List(ctx, c.Audit.Denials.From, c.Audit.Denials.To)
+	case "completion <shell>":
+		c.ctx.FatalIfErrorf(c.writeCompletionScript())
+		return
+	case "__complete", "__complete <words>":
+		c.ctx.FatalIfErrorf(c.runComplete(ctx))
+		return
 	case "serve":
-		permissionsConfig, err := server.LoadPermissions(c.PermissionsFile)
+		repository, ok := c.secretStore.(sqliteSecrets)
+		if !ok {
+			c.ctx.FatalIfErrorf(errors.New("cannot serve through a client socket"))
+		}
+		var permissionsConfig *server.Permissions
+		permissionsConfig, err = server.LoadPermissions(c.PermissionsFile)
 		c.ctx.FatalIfErrorf(err)
+		secretsConfig, err := config.LoadSecretsConfig(c.SecretsFile)
+		c.ctx.FatalIfErrorf(err)
+		dispatcher, err := notify.NewDispatcher(repository.Events(), secretsConfig.Notifications, c.Serve.EventRetention)
+		c.ctx.FatalIfErrorf(err)
+		configHashes := make(map[string]string)
+		for _, file := range []string{c.SecretsFile, c.PermissionsFile} {
+			configHashes[file], err = config.HashFile(file)
+			c.ctx.FatalIfErrorf(err)
+		}
 		config := server.ServerConfig{
-			Socket:        c.Serve.ServerSocket,
-			RequestLimit:  c.Serve.RequestLimit,
-			RequestWindow: c.Serve.RequestWindow,
+			RequestLimit:     c.Serve.RequestLimit,
+			RequestWindow:    c.Serve.RequestWindow,
+			MaxInFlight:      c.Serve.MaxInFlight,
+			DenialRetention:  c.Serve.DenialRetention,
+			ShutdownGrace:    c.Serve.ShutdownGrace,
+			MetricsAddress:   c.Serve.MetricsAddress,
+			StuckAfter:       c.Serve.StuckAfter,
+			ConfigHashes:     configHashes,
+			LastSchedulerRun: dispatcher.LastRun,
 		}
-		server := server.New(config, c.secretStore, permissionsConfig)
-		err = server.Serve()
+		repository.RegisterMetrics(metrics.Default)
+		for _, socket := range c.Serve.ServerSockets {
+			config.Sockets = append(config.Sockets, server.ParseSocket(socket))
+		}
+		if c.Serve.AuditSink != "" {
+			sink, err := os.OpenFile(c.Serve.AuditSink, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+			c.ctx.FatalIfErrorf(err)
+			defer sink.Close()
+			config.AuditSink = sink
+		}
+		server := server.New(config, c.secretStore, repository.Approvals(), repository.Denials(), repository.Elevations(), repository.Events(), repository.Health(), permissionsConfig)
+		ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		dispatched := make(chan struct{})
+		go func() {
+			dispatcher.Run(ctx)
+			close(dispatched)
+		}()
+		err = server.Serve(ctx)
+		stop()
+		<-dispatched
+		c.ctx.FatalIfErrorf(err)
+		return
 	default:
 		panic(fmt.Errorf("unknown command: %s", c.ctx.Command()))
 	}
 
 	c.ctx.FatalIfErrorf(err)
-
-	var bytes []byte
-	if c.Pretty {
-		bytes, err = marshal.JSONIndent(result)
-	} else {
-		bytes, err = marshal.JSON(result)
+	if c.Output == outputTable && c.template == nil {
+		c.lookupActive(ctx, result)
 	}
-	c.ctx.FatalIfErrorf(err)
+	c.ctx.FatalIfErrorf(c.writeResult(result))
+}
 
+// writeResult writes a result to stdout in the chosen format
+func (c *CLI) writeResult(result any) error {
+	bytes, err := c.formatResult(result)
+	if err != nil {
+		return err
+	}
+	c.written = true
 	_, err = os.Stdout.Write(bytes)
+	return err
+}
 
-	c.ctx.FatalIfErrorf(err)
+func (c *CLI) runApprovals(ctx context.Context) (any, error) {
+	if c.approvals == nil {
+		return nil, errNoServer
+	}
+	switch c.ctx.Command() {
+	case "approvals list":
+		return c.approvals.List(ctx, c.Approvals.List.From, c.Approvals.List.To)
+	case "approvals approve <approval-id>":
+		return c.approvals.Approve(ctx, c.Approvals.Approve.ApprovalID, c.Approvals.Approve.Comment)
+	default:
+		return c.approvals.Reject(ctx, c.Approvals.Reject.ApprovalID, c.Approvals.Reject.Comment)
+	}
+}
+
+func (c *CLI) runElevations(ctx context.Context) (any, error) {
+	if c.elevations == nil {
+		return nil, errNoServer
+	}
+	switch c.ctx.Command() {
+	case "elevate <role>":
+		return c.elevations.Elevate(ctx, server.ElevationParameters{
+			Role:     auth.RoleName(c.Elevate.Role),
+			Reason:   c.Elevate.Reason,
+			Duration: marshal.Duration(c.Elevate.Duration),
+		})
+	case "elevations list":
+		return c.elevations.List(ctx, c.Elevations.List.From, c.Elevations.List.To)
+	default:
+		return c.elevations.Revoke(ctx, c.Elevations.Revoke.ElevationID)
+	}
+}
+
+func (c *CLI) runWhoami(ctx context.Context) (any, error) {
+	if c.whoami == nil {
+		return nil, errNoServer
+	}
+	var query *server.OperationQuery
+	if c.Whoami.Operation != "" {
+		query = &server.OperationQuery{
+			SecretId:  c.Whoami.Secret,
+			Operation: secrets.OperationName(c.Whoami.Operation),
+			Forced:    c.Whoami.Force,
+			Reason:    c.Whoami.Reason,
+		}
+	}
+	return c.whoami.Whoami(ctx, query)
+}
+
+// runEvents writes each event to stdout as it is received, until the stream ends
+func (c *CLI) runEvents(ctx context.Context) error {
+	if c.events == nil {
+		return errNoServer
+	}
+	query := client.EventQuery{SecretId: c.Events.Secret, After: c.Events.After}
+	for event, err := range c.events.Stream(ctx, query) {
+		if err != nil {
+			return err
+		}
+		if err := c.writeResult(event); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 type Secrets struct{}
 
+type Status struct {
+	StuckAfter time.Duration `default:"1h" help:"Time for which an operation may run before it is reported stuck, when operating directly upon the database rather than through a running server"`
+}
+
 type Secret struct {
 	SecretID string `arg:"" help:"ID of the secret"`
 }
@@ -155,21 +437,108 @@ type InstanceCommand struct {
 }
 
 type Command struct {
-	Force  bool   `short:"f" help:"Force the operation, overriding safety checks where allowed"`
-	Reason string `short:"r" help:"Audit reason for the operation"`
+	Force          bool   `short:"f" help:"Force the operation, overriding safety checks where allowed"`
+	Reason         string `short:"r" help:"Audit reason for the operation"`
+	IdempotencyKey string `short:"k" help:"Key identifying the request, so that retrying it gives the original result rather than performing the operation again"`
+	IfMatch        *int   `help:"Only perform the operation if this is the number of the latest operation on the secret"`
 }
 
 func (c *Command) parameters() secrets.OperationParameters {
 	return secrets.OperationParameters{
-		Env:       command.NewEnvironment().Load(os.Environ()),
-		Forced:    c.Force,
-		Reason:    c.Reason,
-		StartedBy: "user",
+		Env:            command.NewEnvironment().Load(os.Environ()),
+		Forced:         c.Force,
+		Reason:         c.Reason,
+		StartedBy:      "user",
+		IdempotencyKey: c.IdempotencyKey,
+		IfMatch:        c.IfMatch,
+	}
+}
+
+type Bulk struct {
+	Operation   string            `arg:"" enum:"create,activate,deactivate,destroy,test" help:"Name of the operation to perform (create, activate, deactivate, destroy or test)"`
+	Select      []string          `short:"s" help:"Pattern matched by the IDs of secrets to select, as understood by path.Match; may be repeated"`
+	Label       map[string]string `short:"l" help:"Label which selected secrets carry, as key=value; may be repeated"`
+	Concurrency int               `short:"j" default:"1" help:"Maximum number of operations to perform at once"`
+	KeepGoing   bool              `short:"k" help:"Carry on with the remaining secrets after an operation fails, rather than stopping"`
+	Force       bool              `short:"f" help:"Force the operations, overriding safety checks where allowed"`
+	Reason      string            `short:"r" help:"Audit reason for the operations"`
+}
+
+func (b *Bulk) request() bulk.Request {
+	return bulk.Request{
+		Name:        secrets.OperationName(b.Operation),
+		Select:      bulk.Selector{Names: b.Select, Labels: b.Label},
+		Concurrency: b.Concurrency,
+		KeepGoing:   b.KeepGoing,
+		Env:         command.NewEnvironment().Load(os.Environ()),
+		Forced:      b.Force,
+		Reason:      b.Reason,
 	}
 }
 
+type Approvals struct {
+	List    ApprovalList     `cmd:"" help:"List approval requests"`
+	Approve ApprovalDecision `cmd:"" help:"Approve a pending request, performing the operation once sufficiently approved"`
+	Reject  ApprovalDecision `cmd:"" help:"Reject a pending request"`
+}
+
+type ApprovalList struct {
+	Bounds
+}
+
+type ApprovalDecision struct {
+	ApprovalID string `arg:"" help:"ID of the approval request"`
+	Comment    string `short:"m" help:"Comment to record with the decision"`
+}
+
+type Elevate struct {
+	Role     string        `arg:"" help:"Name of the role to elevate to"`
+	Reason   string        `short:"r" required:"" help:"Audit reason for the elevation"`
+	Duration time.Duration `short:"t" help:"Duration of the elevation, or the longest permitted if not given"`
+}
+
+type Elevations struct {
+	List   ElevationList   `cmd:"" help:"List elevations"`
+	Revoke ElevationRevoke `cmd:"" help:"Revoke an active elevation"`
+}
+
+type ElevationList struct {
+	Bounds
+}
+
+type ElevationRevoke struct {
+	ElevationID string `arg:"" help:"ID of the elevation"`
+}
+
+type Events struct {
+	Secret string `short:"s" help:"ID of the secret to stream events about, or all secrets if not given"`
+	After  *int64 `short:"a" help:"Resume the stream after the event with this ID, rather than from the latest event"`
+}
+
+type Whoami struct {
+	Operation string `short:"o" help:"Name of an operation to check, without performing it"`
+	Secret    string `short:"s" help:"ID of the secret to check the operation on"`
+	Force     bool   `short:"f" help:"Check the operation as forced"`
+	Reason    string `short:"r" help:"Reason to check the operation with"`
+}
+
+type Audit struct {
+	Denials AuditDenials `cmd:"" help:"List denied attempts to call the API or perform operations, most recent first"`
+}
+
+type AuditDenials struct {
+	Bounds
+}
+
 type Serve struct {
-	ServerSocket  string        `short:"s" help:"Unix socket path for serving the HTTP API"`
-	RequestLimit  uint32        `short:"L" default:"100" help:"Maximum number of requests per request window"`
-	RequestWindow time.Duration `short:"W" default:"1m" help:"Window of time over which the request limit is enforced"`
+	ServerSockets   []string      `short:"s" name:"server-socket" help:"Unix socket path for serving the HTTP API, as [name=]path to name it for a listener profile; may be repeated"`
+	RequestLimit    uint32        `short:"L" default:"100" help:"Maximum number of requests per request window"`
+	RequestWindow   time.Duration `short:"W" default:"1m" help:"Window of time over which the request limit is enforced"`
+	MaxInFlight     uint32        `default:"4" help:"Maximum number of concurrent operations per principal, or unlimited if zero"`
+	ShutdownGrace   time.Duration `default:"30s" help:"Time for which running operations may finish on shutdown before they are interrupted"`
+	MetricsAddress  string        `help:"TCP address, or unix socket path, on which to serve Prometheus metrics at /metrics"`
+	DenialRetention time.Duration `default:"2160h" help:"Time for which denied attempts are retained in the audit trail, or forever if zero"`
+	AuditSink       string        `help:"Path of a file to which denied attempts are also appended as JSON lines"`
+	EventRetention  time.Duration `default:"720h" help:"Time for which events are retained in the outbox, and at least until delivered to every subscriber, or forever if zero"`
+	StuckAfter      time.Duration `default:"1h" help:"Time for which an operation may run before the server reports that it is not ready"`
 }
@@ -5,11 +5,22 @@ import (
 	"context"
 	"encoding/json"
 	"io"
+	"iter"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/alecthomas/kong"
+	"github.com/eliasvasylenko/secret-agent/internal/audit"
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/bulk"
+	"github.com/eliasvasylenko/secret-agent/internal/client"
+	"github.com/eliasvasylenko/secret-agent/internal/events"
+	"github.com/eliasvasylenko/secret-agent/internal/marshal"
 	"github.com/eliasvasylenko/secret-agent/internal/mocks"
 	sec "github.com/eliasvasylenko/secret-agent/internal/secrets"
+	"github.com/eliasvasylenko/secret-agent/internal/server"
 	"github.com/eliasvasylenko/secret-agent/internal/store"
 	"github.com/google/go-cmp/cmp"
 )
@@ -26,6 +37,12 @@ func (s stubKongContext) FatalIfErrorf(err error, _ ...any) {
 	}
 }
 
+func TestCLI_grammar(t *testing.T) {
+	if _, err := kong.New(&CLI{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestRun_secrets(t *testing.T) {
 	mockStore := &mocks.MockSecrets{}
 	defer mockStore.Mock.Validate(t)
@@ -76,6 +93,69 @@ func TestRun_secret(t *testing.T) {
 	}
 }
 
+func TestRun_active(t *testing.T) {
+	mockStore := &mocks.MockSecrets{}
+	defer mockStore.Mock.Validate(t)
+	mockInstances := &mocks.MockInstances{}
+	defer mockInstances.Mock.Validate(t)
+	mocks.Expect(&mockStore.Mock, mockStore.Instances, func(secretId string) store.Instances {
+		if secretId != "my-secret" {
+			t.Errorf("Instances called with secretId=%q, want my-secret", secretId)
+		}
+		return mockInstances
+	})
+	mocks.Expect(&mockInstances.Mock, mockInstances.GetActive, func(ctx context.Context) (*sec.Instance, error) {
+		return &sec.Instance{Id: "i1"}, nil
+	})
+
+	cli := &CLI{
+		ctx:         stubKongContext{command: "active <secret-id>"},
+		secretStore: mockStore,
+		Active:      Secret{SecretID: "my-secret"},
+	}
+	stdout := captureStdout(t, func() {
+		cli.Run(context.Background())
+	})
+	if !bytes.Contains(stdout, []byte(`"i1"`)) {
+		t.Errorf("stdout should contain instance i1, got %s", stdout)
+	}
+}
+
+func TestRun_instanceHistory(t *testing.T) {
+	mockStore := &mocks.MockSecrets{}
+	defer mockStore.Mock.Validate(t)
+	mockInstances := &mocks.MockInstances{}
+	defer mockInstances.Mock.Validate(t)
+	instanceId := "0b230422-0434-4f08-83fb-3b7e6c50e843"
+	instancesReturn := func(secretId string) store.Instances {
+		if secretId != "my-secret" {
+			t.Errorf("Instances called with secretId=%q, want my-secret", secretId)
+		}
+		return mockInstances
+	}
+	// once to resolve the instance ID, which is given in full, and once to read its history
+	mocks.Expect(&mockStore.Mock, mockStore.Instances, instancesReturn)
+	mocks.Expect(&mockStore.Mock, mockStore.Instances, instancesReturn)
+	mocks.Expect(&mockInstances.Mock, mockInstances.History, func(ctx context.Context, gotInstanceId string, from int, to int) ([]*sec.Operation, error) {
+		if gotInstanceId != instanceId || from != 0 || to != 10 {
+			t.Errorf("History called with instanceId=%q from=%d to=%d, want %s 0 10", gotInstanceId, from, to, instanceId)
+		}
+		return []*sec.Operation{{InstanceId: instanceId}}, nil
+	})
+
+	cli := &CLI{
+		ctx:         stubKongContext{command: "history <secret-id> <instance-id>"},
+		secretStore: mockStore,
+		History:     History{SecretID: "my-secret", InstanceID: instanceId, Bounds: Bounds{From: 0, To: 10}},
+	}
+	stdout := captureStdout(t, func() {
+		cli.Run(context.Background())
+	})
+	if !bytes.Contains(stdout, []byte(instanceId)) {
+		t.Errorf("stdout should contain instance %s, got %s", instanceId, stdout)
+	}
+}
+
 func TestRun_instances(t *testing.T) {
 	mockStore := &mocks.MockSecrets{}
 	defer mockStore.Mock.Validate(t)
@@ -114,6 +194,232 @@ func TestRun_instances(t *testing.T) {
 	}
 }
 
+// stubApprovals implements approvals for tests, recording the last decision.
+type stubApprovals struct {
+	decided  string
+	approved bool
+	comment  string
+}
+
+func (s *stubApprovals) List(ctx context.Context, from int, to int) ([]*sec.Approval, error) {
+	return []*sec.Approval{{Id: "a1", Status: sec.Pending}}, nil
+}
+func (s *stubApprovals) Approve(ctx context.Context, approvalId string, comment string) (*sec.Approval, error) {
+	s.decided, s.approved, s.comment = approvalId, true, comment
+	return &sec.Approval{Id: approvalId, Status: sec.Approved}, nil
+}
+func (s *stubApprovals) Reject(ctx context.Context, approvalId string, comment string) (*sec.Approval, error) {
+	s.decided, s.approved, s.comment = approvalId, false, comment
+	return &sec.Approval{Id: approvalId, Status: sec.Rejected}, nil
+}
+
+func TestRun_approvals(t *testing.T) {
+	approvals := &stubApprovals{}
+	cli := &CLI{
+		ctx:       stubKongContext{command: "approvals approve <approval-id>"},
+		approvals: approvals,
+		Approvals: Approvals{Approve: ApprovalDecision{ApprovalID: "a1", Comment: "lgtm"}},
+	}
+	stdout := captureStdout(t, func() {
+		cli.Run(context.Background())
+	})
+	if approvals.decided != "a1" || !approvals.approved || approvals.comment != "lgtm" {
+		t.Errorf("decision = %+v", approvals)
+	}
+	var got sec.Approval
+	if err := json.Unmarshal(stdout, &got); err != nil {
+		t.Fatalf("stdout should be valid JSON: %v\noutput: %s", err, stdout)
+	}
+	if got.Status != sec.Approved {
+		t.Errorf("stdout approval status = %s", got.Status)
+	}
+}
+
+func TestRun_approvals_requiresServer(t *testing.T) {
+	cli := &CLI{
+		ctx: stubKongContext{command: "approvals list"},
+	}
+	defer func() {
+		if r := recover(); r != errNoServer {
+			t.Errorf("recovered %v, want %v", r, errNoServer)
+		}
+	}()
+	cli.Run(context.Background())
+}
+
+// stubDenials implements denials for tests, recording the requested bounds.
+type stubDenials struct {
+	from, to int
+}
+
+func (s *stubDenials) List(ctx context.Context, from int, to int) ([]*audit.Denial, error) {
+	s.from, s.to = from, to
+	return []*audit.Denial{{Id: 1, Route: "GET /secrets", Reason: "denied"}}, nil
+}
+
+func TestRun_auditDenials(t *testing.T) {
+	denials := &stubDenials{}
+	cli := &CLI{
+		ctx:     stubKongContext{command: "audit denials"},
+		denials: denials,
+		Audit:   Audit{Denials: AuditDenials{Bounds{From: 5, To: 15}}},
+	}
+	stdout := captureStdout(t, func() {
+		cli.Run(context.Background())
+	})
+	if denials.from != 5 || denials.to != 15 {
+		t.Errorf("List bounds = %d, %d, want 5, 15", denials.from, denials.to)
+	}
+	var got []*audit.Denial
+	if err := json.Unmarshal(stdout, &got); err != nil {
+		t.Fatalf("stdout should be valid JSON: %v\noutput: %s", err, stdout)
+	}
+	if len(got) != 1 || got[0].Reason != "denied" {
+		t.Errorf("stdout denials = %+v", got)
+	}
+}
+
+// stubWhoami implements whoami for tests, recording the last query.
+type stubWhoami struct {
+	query *server.OperationQuery
+}
+
+func (s *stubWhoami) Whoami(ctx context.Context, query *server.OperationQuery) (*server.Whoami, error) {
+	s.query = query
+	return &server.Whoami{Principal: "linux:bob/1000"}, nil
+}
+
+func TestRun_whoami(t *testing.T) {
+	whoami := &stubWhoami{}
+	cli := &CLI{
+		ctx:    stubKongContext{command: "whoami"},
+		whoami: whoami,
+		Whoami: Whoami{Operation: "destroy", Secret: "db", Force: true},
+	}
+	stdout := captureStdout(t, func() {
+		cli.Run(context.Background())
+	})
+	want := &server.OperationQuery{SecretId: "db", Operation: sec.Destroy, Forced: true}
+	if diff := cmp.Diff(want, whoami.query); diff != "" {
+		t.Errorf("query mismatch (-want +got):\n%s", diff)
+	}
+	var got server.Whoami
+	if err := json.Unmarshal(stdout, &got); err != nil {
+		t.Fatalf("stdout should be valid JSON: %v\noutput: %s", err, stdout)
+	}
+	if got.Principal != "linux:bob/1000" {
+		t.Errorf("stdout principal = %s", got.Principal)
+	}
+}
+
+// stubElevations implements elevations for tests, recording the last call.
+type stubElevations struct {
+	elevated *server.ElevationParameters
+	revoked  string
+}
+
+func (s *stubElevations) List(ctx context.Context, from int, to int) ([]*auth.Elevation, error) {
+	return []*auth.Elevation{{Id: "e1"}}, nil
+}
+func (s *stubElevations) Elevate(ctx context.Context, parameters server.ElevationParameters) (*auth.Elevation, error) {
+	s.elevated = &parameters
+	return &auth.Elevation{Id: "e1", Role: parameters.Role}, nil
+}
+func (s *stubElevations) Revoke(ctx context.Context, elevationId string) (*auth.Elevation, error) {
+	s.revoked = elevationId
+	return &auth.Elevation{Id: elevationId}, nil
+}
+
+func TestRun_elevate(t *testing.T) {
+	elevations := &stubElevations{}
+	cli := &CLI{
+		ctx:        stubKongContext{command: "elevate <role>"},
+		elevations: elevations,
+		Elevate:    Elevate{Role: "operator", Reason: "incident", Duration: 10 * time.Minute},
+	}
+	stdout := captureStdout(t, func() {
+		cli.Run(context.Background())
+	})
+	want := &server.ElevationParameters{Role: "operator", Reason: "incident", Duration: marshal.Duration(10 * time.Minute)}
+	if diff := cmp.Diff(want, elevations.elevated); diff != "" {
+		t.Errorf("Elevate mismatch (-want +got):\n%s", diff)
+	}
+	var got auth.Elevation
+	if err := json.Unmarshal(stdout, &got); err != nil {
+		t.Fatalf("stdout should be valid JSON: %v\noutput: %s", err, stdout)
+	}
+	if got.Id != "e1" {
+		t.Errorf("stdout elevation = %+v", got)
+	}
+}
+
+func TestRun_elevations_requiresServer(t *testing.T) {
+	cli := &CLI{
+		ctx: stubKongContext{command: "elevations revoke <elevation-id>"},
+	}
+	defer func() {
+		if r := recover(); r != errNoServer {
+			t.Errorf("recovered %v, want %v", r, errNoServer)
+		}
+	}()
+	cli.Run(context.Background())
+}
+
+// stubEvents implements eventStream for tests, yielding the given events and recording the query.
+// If done is given it is called once the events are exhausted.
+type stubEvents struct {
+	events []*events.Event
+	query  client.EventQuery
+	done   func()
+}
+
+func (s *stubEvents) Stream(ctx context.Context, query client.EventQuery) iter.Seq2[*events.Event, error] {
+	s.query = query
+	return func(yield func(*events.Event, error) bool) {
+		if query.Opened != nil {
+			if err := query.Opened(); err != nil {
+				yield(nil, err)
+				return
+			}
+		}
+		for _, event := range s.events {
+			if !yield(event, nil) {
+				return
+			}
+		}
+		if s.done != nil {
+			s.done()
+		}
+	}
+}
+
+func TestRun_events(t *testing.T) {
+	stream := &stubEvents{events: []*events.Event{
+		{Id: 1, Type: events.OperationStarted},
+		{Id: 2, Type: events.OperationCompleted},
+	}}
+	after := int64(0)
+	cli := &CLI{
+		ctx:    stubKongContext{command: "events"},
+		events: stream,
+		Events: Events{Secret: "s1", After: &after},
+	}
+	stdout := captureStdout(t, func() {
+		cli.Run(context.Background())
+	})
+	if diff := cmp.Diff(client.EventQuery{SecretId: "s1", After: &after}, stream.query); diff != "" {
+		t.Errorf("query mismatch (-want +got):\n%s", diff)
+	}
+	lines := bytes.Split(bytes.TrimSpace(stdout), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("stdout = %s, want a line per event", stdout)
+	}
+	var got events.Event
+	if err := json.Unmarshal(lines[1], &got); err != nil || got.Id != 2 {
+		t.Errorf("second line = %s, %v, want event 2", lines[1], err)
+	}
+}
+
 func captureStdout(t *testing.T, f func()) []byte {
 	t.Helper()
 	old := os.Stdout
@@ -132,3 +438,89 @@ func captureStdout(t *testing.T, f func()) []byte {
 	}
 	return out
 }
+
+func TestRun_follow(t *testing.T) {
+	mockStore := &mocks.MockSecrets{}
+	defer mockStore.Mock.Validate(t)
+	mockInstances := &mocks.MockInstances{}
+	defer mockInstances.Mock.Validate(t)
+	mocks.Expect(&mockStore.Mock, mockStore.Get, func(ctx context.Context, secretId string) (*sec.Secret, error) {
+		return &sec.Secret{Name: "my-secret"}, nil
+	})
+	mocks.Expect(&mockStore.Mock, mockStore.Instances, func(secretId string) store.Instances {
+		return mockInstances
+	})
+	mocks.Expect(&mockInstances.Mock, mockInstances.GetActive, func(ctx context.Context) (*sec.Instance, error) {
+		return &sec.Instance{Id: "i1"}, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := &stubEvents{events: []*events.Event{
+		{Id: 1, Type: events.ActiveInstanceChanged, SecretId: "s1", Data: json.RawMessage(`{"activeInstanceId":"i2"}`)},
+		{Id: 2, Type: events.OperationCompleted, SecretId: "s1"},
+		{Id: 3, Type: events.ActiveInstanceChanged, SecretId: "s1", Data: json.RawMessage(`{"activeInstanceId":null}`)},
+	}, done: cancel}
+
+	output := filepath.Join(t.TempDir(), "output")
+	cli := &CLI{
+		ctx:         stubKongContext{command: "follow <secret-id> <command>"},
+		secretStore: mockStore,
+		events:      stream,
+		Follow:      Follow{SecretID: "s1", Command: []string{"sh", "-c", `echo "$SECRET_ID $INSTANCE_ID $QID" >> ` + output}},
+	}
+	cli.Run(ctx)
+
+	if stream.query.SecretId != "s1" || stream.query.After != nil || stream.query.Opened == nil {
+		t.Errorf("query = %+v, want to read the active instance once the latest events of s1 are streamed", stream.query)
+	}
+	got, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "s1 i1 my-secret/i1\ns1 i2 my-secret/i2\ns1  \n"
+	if string(got) != want {
+		t.Errorf("command runs = %q, want %q", got, want)
+	}
+}
+
+// stubBulk implements bulkOperations for tests, recording the request and reporting the given failures.
+type stubBulk struct {
+	request bulk.Request
+	failed  int
+}
+
+func (s *stubBulk) Run(ctx context.Context, request bulk.Request) (*bulk.Report, error) {
+	s.request = request
+	return &bulk.Report{BatchId: "b1", Name: request.Name, Failed: s.failed}, nil
+}
+
+func TestRun_bulk(t *testing.T) {
+	operations := &stubBulk{}
+	cli := &CLI{
+		ctx:  stubKongContext{command: "bulk <operation>"},
+		bulk: operations,
+		Bulk: Bulk{Operation: "test", Select: []string{"db-*"}, Concurrency: 2, KeepGoing: true, Reason: "incident"},
+	}
+	stdout := captureStdout(t, func() {
+		cli.Run(context.Background())
+	})
+	request := operations.request
+	if request.Name != sec.Test || !cmp.Equal(request.Select.Names, []string{"db-*"}) || request.Concurrency != 2 || !request.KeepGoing || request.Reason != "incident" {
+		t.Errorf("request = %+v", request)
+	}
+	var got bulk.Report
+	if err := json.Unmarshal(stdout, &got); err != nil || got.BatchId != "b1" {
+		t.Errorf("stdout = %s, %v, want report", stdout, err)
+	}
+
+	operations.failed = 1
+	defer func() {
+		if recover() == nil {
+			t.Error("Run did not fail for a batch with failures")
+		}
+	}()
+	captureStdout(t, func() {
+		cli.Run(context.Background())
+	})
+}
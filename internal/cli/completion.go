@@ -0,0 +1,320 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/alecthomas/kong"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+	"github.com/eliasvasylenko/secret-agent/internal/store"
+	"github.com/google/uuid"
+)
+
+// The number of instances requested in each page when every instance of a secret is needed
+const instancePageSize = 100
+
+// Scripts which complete command lines in each shell by calling back to the hidden __complete command
+var completionScripts = map[string]string{
+	"bash": `# bash completion for secret-agent
+_secret_agent() {
+	local IFS=$'\n'
+	COMPREPLY=($(secret-agent __complete -- "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+}
+complete -o default -F _secret_agent secret-agent
+`,
+	"zsh": `#compdef secret-agent
+# zsh completion for secret-agent
+_secret_agent() {
+	local -a completions
+	completions=(${(f)"$(secret-agent __complete -- "${(@)words[2,CURRENT]}" 2>/dev/null)"})
+	if (( ${#completions} )); then
+		compadd -a completions
+	else
+		_files
+	fi
+}
+compdef _secret_agent secret-agent
+`,
+	"fish": `# fish completion for secret-agent
+function __secret_agent_complete
+	secret-agent __complete -- (commandline -opc)[2..-1] (commandline -ct) 2>/dev/null
+end
+complete -c secret-agent -f -a '(__secret_agent_complete)'
+`,
+}
+
+type Completion struct {
+	Shell string `arg:"" enum:"bash,zsh,fish" help:"Shell to complete in, one of bash, zsh or fish"`
+}
+
+type Complete struct {
+	Words []string `arg:"" optional:"" passthrough:"" help:"Words of the command line after the name of the program, given after --, the last of which is completed"`
+}
+
+// runComplete writes the completions of the last word of a command line, one per line. The command line is
+// configured by its own flags, e.g. to complete IDs from the database rather than through a running server.
+func (c *CLI) runComplete(ctx context.Context) error {
+	words := completionWords(c.Complete.Words)
+	current := words[len(words)-1]
+
+	line := &CLI{}
+	parser, err := kong.New(line)
+	if err != nil {
+		return err
+	}
+	// the command line is incomplete, so it is applied as far as it goes rather than validated
+	trace, err := kong.Trace(parser, words[:len(words)-1])
+	if err != nil {
+		return err
+	}
+	if trace.Reset() == nil && trace.Resolve() == nil {
+		trace.Apply()
+	}
+	line.ctx = trace
+
+	for _, candidate := range line.completions(ctx, trace, words) {
+		if strings.HasPrefix(candidate, current) {
+			fmt.Println(candidate)
+		}
+	}
+	return nil
+}
+
+// completionWords gives the words of a command line as kong reads them, without the -- which precedes them. Bash
+// splits a flag from its value at an equals sign, so the value is given as the next word instead.
+func completionWords(words []string) []string {
+	if len(words) > 0 && words[0] == "--" {
+		words = words[1:]
+	}
+	var joined []string
+	for i, word := range words {
+		if word != "=" {
+			joined = append(joined, word)
+		} else if i == len(words)-1 {
+			joined = append(joined, "")
+		}
+	}
+	if len(joined) == 0 {
+		joined = []string{""}
+	}
+	return joined
+}
+
+// completions gives the candidates for the last word of a command line, which has been traced up to that word
+func (c *CLI) completions(ctx context.Context, trace *kong.Context, words []string) []string {
+	current := words[len(words)-1]
+	flags := trace.Flags()
+
+	if len(words) > 1 {
+		previous := words[len(words)-2]
+		if flag := findFlag(flags, previous); flag != nil && !flag.IsBool() && !flag.IsCounter() {
+			return enumValues(flag.Value)
+		}
+	}
+	if name, _, ok := strings.Cut(current, "="); ok && strings.HasPrefix(name, "--") {
+		var candidates []string
+		if flag := findFlag(flags, name); flag != nil {
+			for _, value := range enumValues(flag.Value) {
+				candidates = append(candidates, name+"="+value)
+			}
+		}
+		return candidates
+	}
+	if strings.HasPrefix(current, "-") {
+		var candidates []string
+		for _, flag := range flags {
+			if !flag.Hidden {
+				candidates = append(candidates, "--"+flag.Name)
+			}
+		}
+		return candidates
+	}
+
+	node := trace.Selected()
+	if node == nil {
+		node = trace.Model.Node
+	}
+	var given int
+	for _, path := range trace.Path {
+		if path.Positional != nil {
+			given++
+		}
+	}
+	if given == 0 && len(node.Children) > 0 {
+		var candidates []string
+		for _, child := range node.Children {
+			if !child.Hidden {
+				candidates = append(candidates, child.Name)
+				candidates = append(candidates, child.Aliases...)
+			}
+		}
+		return candidates
+	}
+	if given >= len(node.Positional) {
+		return nil
+	}
+	switch positional := node.Positional[given]; positional.Name {
+	case "secret-id":
+		return c.completeSecrets(ctx)
+	case "instance-id":
+		return c.completeInstances(ctx, trace, secrets.OperationName(node.Name))
+	default:
+		return enumValues(positional)
+	}
+}
+
+// findFlag finds the flag named by a word of a command line, in its long or short form
+func findFlag(flags []*kong.Flag, word string) *kong.Flag {
+	for _, flag := range flags {
+		if word == "--"+flag.Name || (flag.Short != 0 && word == "-"+string(flag.Short)) || slices.Contains(flag.Aliases, strings.TrimPrefix(word, "--")) {
+			return flag
+		}
+	}
+	return nil
+}
+
+func enumValues(value *kong.Value) []string {
+	if value.Enum == "" {
+		return nil
+	}
+	return value.EnumSlice()
+}
+
+// completeSecrets gives the IDs of every secret, or none if the store cannot be reached
+func (c *CLI) completeSecrets(ctx context.Context) []string {
+	if c.connect(ctx) != nil {
+		return nil
+	}
+	all, err := c.secretStore.List(ctx)
+	if err != nil {
+		return nil
+	}
+	var ids []string
+	for _, secret := range all.Sorted() {
+		ids = append(ids, secret.Name)
+	}
+	return ids
+}
+
+// completeInstances gives the IDs of the instances of the secret given on the command line upon which an operation
+// may be performed, or every instance if the command does not perform an operation, most recent first
+func (c *CLI) completeInstances(ctx context.Context, trace *kong.Context, operation secrets.OperationName) []string {
+	var secretId string
+	var forced bool
+	for _, path := range trace.Path {
+		switch {
+		case path.Positional != nil && path.Positional.Name == "secret-id":
+			secretId = trace.Value(path).String()
+		case path.Flag != nil && path.Flag.Name == "force":
+			forced = trace.Value(path).Bool()
+		}
+	}
+	if secretId == "" || c.connect(ctx) != nil {
+		return nil
+	}
+	instances := c.secretStore.Instances(secretId)
+	all, err := listInstances(ctx, instances)
+	if err != nil {
+		return nil
+	}
+	active, err := instances.GetActive(ctx)
+	if err != nil {
+		return nil
+	}
+	var activeInstanceId *string
+	if active != nil {
+		activeInstanceId = &active.Id
+	}
+
+	var ids []string
+	for _, instance := range all {
+		switch operation {
+		case secrets.Destroy, secrets.Activate, secrets.Deactivate, secrets.Test:
+			destroyed := instance.Status.Name == secrets.Destroy && instance.Status.CompletedAt != nil
+			if destroyed || (!forced && secrets.CheckOperation(operation, instance.Id, instance.Status, activeInstanceId) != "") {
+				continue
+			}
+		}
+		ids = append(ids, instance.Id)
+	}
+	return ids
+}
+
+// listInstances gives every instance of a secret, most recent first
+func listInstances(ctx context.Context, instances store.Instances) ([]*secrets.Instance, error) {
+	all := secrets.Instances{}
+	for from := 0; ; from += instancePageSize {
+		page, err := instances.List(ctx, from, from+instancePageSize)
+		if err != nil {
+			return nil, err
+		}
+		maps.Copy(all, page)
+		if len(page) < instancePageSize {
+			return all.Sorted(), nil
+		}
+	}
+}
+
+// resolveInstanceId expands an instance ID given as a prefix to the ID of the one instance of the secret which it
+// begins. An ID which begins no instance is given unchanged, so that the store reports that it is not found.
+func resolveInstanceId(ctx context.Context, instances store.Instances, instanceId string) (string, error) {
+	if _, err := uuid.Parse(instanceId); err == nil || instanceId == "" {
+		return instanceId, nil
+	}
+	all, err := listInstances(ctx, instances)
+	if err != nil {
+		return "", err
+	}
+	var matches []string
+	for _, instance := range all {
+		if strings.HasPrefix(instance.Id, instanceId) {
+			matches = append(matches, instance.Id)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return instanceId, nil
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("instance ID %s is ambiguous, matching %s", instanceId, strings.Join(matches, ", "))
+	}
+}
+
+// resolveInstanceIds expands the instance ID given to the command, if it is given as a prefix
+func (c *CLI) resolveInstanceIds(ctx context.Context) error {
+	var secretId string
+	var instanceId *string
+	switch c.ctx.Command() {
+	case "instance <secret-id> <instance-id>":
+		secretId, instanceId = c.Instance.SecretID, &c.Instance.InstanceID
+	case "history <secret-id> <instance-id>":
+		secretId, instanceId = c.History.SecretID, &c.History.InstanceID
+	case "destroy <secret-id> <instance-id>":
+		secretId, instanceId = c.Destroy.SecretID, &c.Destroy.InstanceID
+	case "activate <secret-id> <instance-id>":
+		secretId, instanceId = c.Activate.SecretID, &c.Activate.InstanceID
+	case "deactivate <secret-id> <instance-id>":
+		secretId, instanceId = c.Deactivate.SecretID, &c.Deactivate.InstanceID
+	case "test <secret-id> <instance-id>":
+		secretId, instanceId = c.Test.SecretID, &c.Test.InstanceID
+	default:
+		return nil
+	}
+	resolved, err := resolveInstanceId(ctx, c.secretStore.Instances(secretId), *instanceId)
+	if err != nil {
+		return err
+	}
+	*instanceId = resolved
+	return nil
+}
+
+// writeCompletionScript writes the script which completes command lines in the chosen shell
+func (c *CLI) writeCompletionScript() error {
+	_, err := os.Stdout.WriteString(completionScripts[c.Completion.Shell])
+	return err
+}
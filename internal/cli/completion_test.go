@@ -0,0 +1,198 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"github.com/eliasvasylenko/secret-agent/internal/mocks"
+	sec "github.com/eliasvasylenko/secret-agent/internal/secrets"
+	"github.com/eliasvasylenko/secret-agent/internal/store"
+	"github.com/google/go-cmp/cmp"
+)
+
+// complete traces a command line with the last word incomplete, and gives the completions of that word
+func complete(t *testing.T, secretStore store.Secrets, words ...string) []string {
+	t.Helper()
+	line := &CLI{secretStore: secretStore}
+	parser, err := kong.New(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trace, err := kong.Trace(parser, words[:len(words)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	line.ctx = trace
+	return line.completions(context.Background(), trace, words)
+}
+
+// expectInstances expects the instances of db to be listed, with i2 active and i3 destroyed
+func expectInstances(mockStore *mocks.MockSecrets, mockInstances *mocks.MockInstances) {
+	completedAt := time.Now()
+	mocks.Expect(&mockStore.Mock, mockStore.Instances, func(secretId string) store.Instances {
+		return mockInstances
+	})
+	mocks.Expect(&mockInstances.Mock, mockInstances.List, func(ctx context.Context, from int, to int) (sec.Instances, error) {
+		return sec.Instances{
+			"i1": {Id: "i1", Status: sec.Status{OperationNumber: 1, Name: sec.Create, CompletedAt: &completedAt}},
+			"i2": {Id: "i2", Status: sec.Status{OperationNumber: 3, Name: sec.Activate, CompletedAt: &completedAt}},
+			"i3": {Id: "i3", Status: sec.Status{OperationNumber: 4, Name: sec.Destroy, CompletedAt: &completedAt}},
+		}, nil
+	})
+	mocks.Expect(&mockInstances.Mock, mockInstances.GetActive, func(ctx context.Context) (*sec.Instance, error) {
+		return &sec.Instance{Id: "i2"}, nil
+	})
+}
+
+func TestCompletions_commands(t *testing.T) {
+	got := complete(t, nil, "")
+	if !slices.Contains(got, "deactivate") || !slices.Contains(got, "completion") {
+		t.Errorf("expected commands, got %v", got)
+	}
+	if slices.Contains(got, "__complete") {
+		t.Errorf("expected hidden commands to be left out, got %v", got)
+	}
+}
+
+func TestCompletions_flags(t *testing.T) {
+	got := complete(t, nil, "create", "db", "--")
+	for _, flag := range []string{"--force", "--reason", "--output"} {
+		if !slices.Contains(got, flag) {
+			t.Errorf("expected %s among %v", flag, got)
+		}
+	}
+	if diff := cmp.Diff([]string{"json", "jsonl", "table", "yaml", "env"}, complete(t, nil, "--output", "")); diff != "" {
+		t.Errorf("unexpected flag values (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"--output=json", "--output=jsonl", "--output=table", "--output=yaml", "--output=env"}, complete(t, nil, "--output=j")); diff != "" {
+		t.Errorf("unexpected flag values (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"bash", "zsh", "fish"}, complete(t, nil, "completion", "")); diff != "" {
+		t.Errorf("unexpected arguments (-want +got):\n%s", diff)
+	}
+}
+
+func TestCompletions_secrets(t *testing.T) {
+	mockStore := &mocks.MockSecrets{}
+	defer mockStore.Mock.Validate(t)
+	mocks.Expect(&mockStore.Mock, mockStore.List, func(ctx context.Context) (sec.Secrets, error) {
+		return sec.Secrets{"db": {Name: "db"}, "api": {Name: "api"}}, nil
+	})
+	if diff := cmp.Diff([]string{"api", "db"}, complete(t, mockStore, "activate", "")); diff != "" {
+		t.Errorf("unexpected secrets (-want +got):\n%s", diff)
+	}
+}
+
+func TestCompletions_instances(t *testing.T) {
+	tests := []struct {
+		words []string
+		want  []string
+	}{
+		{[]string{"instance", "db", ""}, []string{"i3", "i2", "i1"}},
+		{[]string{"deactivate", "db", ""}, []string{"i2"}},
+		{[]string{"test", "db", ""}, []string{"i2"}},
+		{[]string{"destroy", "db", ""}, []string{"i2", "i1"}},
+		{[]string{"activate", "db", ""}, nil},
+		{[]string{"activate", "--force", "db", ""}, []string{"i2", "i1"}},
+	}
+	for _, test := range tests {
+		t.Run(strings.Join(test.words, " "), func(t *testing.T) {
+			mockStore := &mocks.MockSecrets{}
+			defer mockStore.Mock.Validate(t)
+			mockInstances := &mocks.MockInstances{}
+			defer mockInstances.Mock.Validate(t)
+			expectInstances(mockStore, mockInstances)
+
+			if diff := cmp.Diff(test.want, complete(t, mockStore, test.words...)); diff != "" {
+				t.Errorf("unexpected instances (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCompletionWords(t *testing.T) {
+	tests := []struct {
+		words []string
+		want  []string
+	}{
+		{nil, []string{""}},
+		{[]string{"--"}, []string{""}},
+		{[]string{"--", "follow", "db", "--", "sh"}, []string{"follow", "db", "--", "sh"}},
+		{[]string{"--", "--output", "=", "ta"}, []string{"--output", "ta"}},
+		{[]string{"--", "--output", "="}, []string{"--output", ""}},
+	}
+	for _, test := range tests {
+		if diff := cmp.Diff(test.want, completionWords(test.words)); diff != "" {
+			t.Errorf("unexpected words for %q (-want +got):\n%s", test.words, diff)
+		}
+	}
+}
+
+func TestResolveInstanceId(t *testing.T) {
+	tests := []struct {
+		given   string
+		want    string
+		wantErr bool
+	}{
+		{"i2", "i2", false},
+		{"x", "x", false},
+		{"i", "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.given, func(t *testing.T) {
+			mockInstances := &mocks.MockInstances{}
+			defer mockInstances.Mock.Validate(t)
+			mocks.Expect(&mockInstances.Mock, mockInstances.List, func(ctx context.Context, from int, to int) (sec.Instances, error) {
+				return sec.Instances{"i1": {Id: "i1"}, "i2": {Id: "i2"}}, nil
+			})
+
+			got, err := resolveInstanceId(context.Background(), mockInstances, test.given)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if got != test.want {
+				t.Errorf("expected %q, got %q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestResolveInstanceId_pages(t *testing.T) {
+	mockInstances := &mocks.MockInstances{}
+	defer mockInstances.Mock.Validate(t)
+	page := func(wantFrom int, size int, prefix string) func(ctx context.Context, from int, to int) (sec.Instances, error) {
+		return func(ctx context.Context, from int, to int) (sec.Instances, error) {
+			if from != wantFrom || to != wantFrom+instancePageSize {
+				t.Errorf("expected page [%d, %d), got [%d, %d)", wantFrom, wantFrom+instancePageSize, from, to)
+			}
+			instances := sec.Instances{}
+			for i := range size {
+				id := fmt.Sprintf("%s%03d", prefix, i)
+				instances[id] = &sec.Instance{Id: id}
+			}
+			return instances, nil
+		}
+	}
+	mocks.Expect(&mockInstances.Mock, mockInstances.List, page(0, instancePageSize, "a"))
+	mocks.Expect(&mockInstances.Mock, mockInstances.List, page(instancePageSize, 1, "b"))
+
+	got, err := resolveInstanceId(context.Background(), mockInstances, "b0")
+	if err != nil || got != "b000" {
+		t.Errorf("expected b000 from the second page, got %q, %v", got, err)
+	}
+}
+
+func TestResolveInstanceId_fullId(t *testing.T) {
+	mockInstances := &mocks.MockInstances{}
+	defer mockInstances.Mock.Validate(t)
+	id := "0b230422-0434-4f08-83fb-3b7e6c50e843"
+	got, err := resolveInstanceId(context.Background(), mockInstances, id)
+	if err != nil || got != id {
+		t.Errorf("expected %s unchanged, got %q, %v", id, got, err)
+	}
+}
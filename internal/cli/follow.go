@@ -0,0 +1,145 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/client"
+	"github.com/eliasvasylenko/secret-agent/internal/events"
+	"github.com/eliasvasylenko/secret-agent/internal/store"
+)
+
+// The shortest and longest times to wait before reconnecting to the server, doubling with each failure
+var (
+	followMinBackoff = time.Second
+	followMaxBackoff = time.Minute
+)
+
+type Follow struct {
+	SecretID string   `arg:"" help:"ID of the secret to follow"`
+	Command  []string `arg:"" passthrough:"" help:"Command to run whenever the active instance changes, given after --, with SECRET_ID, INSTANCE_ID and QID in its environment"`
+}
+
+// A follower of the active instance of a secret, which runs a command whenever it changes
+type follower struct {
+	secretId    string
+	secretStore store.Secrets
+	events      eventStream
+	command     []string
+
+	// The qualified name of the secret, once it is known
+	qname string
+	// Whether the command has run, and the active instance it last ran for
+	ran        bool
+	instanceId *string
+}
+
+// runFollow follows the active instance of a secret until the context is done, reconnecting with backoff
+// whenever the connection to the server is lost, e.g. when the agent restarts.
+func (c *CLI) runFollow(ctx context.Context) error {
+	if c.events == nil {
+		return errNoServer
+	}
+	if len(c.Follow.Command) == 0 {
+		return errors.New("no command given to run when the active instance changes")
+	}
+	f := &follower{
+		secretId:    c.Follow.SecretID,
+		secretStore: c.secretStore,
+		events:      c.events,
+		command:     c.Follow.Command,
+	}
+
+	wait := followMinBackoff
+	for {
+		synced, err := f.follow(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if synced {
+			wait = followMinBackoff
+		}
+		slog.Warn("failed to follow secret, reconnecting", "secretId", f.secretId, "error", err, "wait", wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil
+		}
+		wait = min(2*wait, followMaxBackoff)
+	}
+}
+
+// follow brings the command up to date with the active instance, then runs it for each change to the active
+// instance until the event stream ends. It reports whether it synced with the server before it failed.
+func (f *follower) follow(ctx context.Context) (bool, error) {
+	if f.qname == "" {
+		secret, err := f.secretStore.Get(ctx, f.secretId)
+		if err != nil {
+			return false, err
+		}
+		f.qname = secret.Name
+	}
+
+	// the active instance is read once the stream is open, so that it is followed from there without missing any
+	// change, including those made while disconnected
+	synced := false
+	sync := func() error {
+		active, err := f.secretStore.Instances(f.secretId).GetActive(ctx)
+		if err != nil {
+			return err
+		}
+		synced = true
+		var instanceId *string
+		if active != nil {
+			instanceId = &active.Id
+		}
+		return f.update(ctx, instanceId)
+	}
+
+	for event, err := range f.events.Stream(ctx, client.EventQuery{SecretId: f.secretId, Opened: sync}) {
+		if err != nil {
+			return synced, err
+		}
+		if event.Type != events.ActiveInstanceChanged || event.SecretId != f.secretId {
+			continue
+		}
+		var changed events.ActiveInstance
+		if err := json.Unmarshal(event.Data, &changed); err != nil {
+			return true, fmt.Errorf("failed to parse event %d: %w", event.Id, err)
+		}
+		if err := f.update(ctx, changed.ActiveInstanceId); err != nil {
+			return true, err
+		}
+	}
+	return synced, errors.New("event stream ended")
+}
+
+// update runs the command if the active instance differs from the one it last ran for
+func (f *follower) update(ctx context.Context, instanceId *string) error {
+	if f.ran && (instanceId == nil) == (f.instanceId == nil) && (instanceId == nil || *instanceId == *f.instanceId) {
+		return nil
+	}
+
+	var id, qid string
+	if instanceId != nil {
+		id = *instanceId
+		qid = fmt.Sprintf("%s/%s", f.qname, id)
+	}
+	command := exec.CommandContext(ctx, f.command[0], f.command[1:]...)
+	command.Env = append(os.Environ(), "SECRET_ID="+f.secretId, "INSTANCE_ID="+id, "QID="+qid)
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+	if err := command.Run(); err != nil {
+		return fmt.Errorf("command failed for instance %q: %w", id, err)
+	}
+
+	f.ran = true
+	f.instanceId = instanceId
+	return nil
+}
@@ -0,0 +1,419 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/audit"
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/bulk"
+	"github.com/eliasvasylenko/secret-agent/internal/events"
+	"github.com/eliasvasylenko/secret-agent/internal/marshal"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+)
+
+// Formats in which results may be written
+const (
+	outputJSON  = "json"
+	outputJSONL = "jsonl"
+	outputTable = "table"
+	outputYAML  = "yaml"
+	outputEnv   = "env"
+)
+
+// Functions available to templates, in addition to those built in to text/template
+var templateFuncs = template.FuncMap{
+	"json": func(value any) (string, error) {
+		bytes, err := marshal.JSON(value)
+		return strings.TrimSuffix(string(bytes), "\n"), err
+	},
+	"age": func(timestamp string) (string, error) {
+		at, err := time.Parse(time.RFC3339Nano, timestamp)
+		return age(time.Since(at)), err
+	},
+}
+
+// parseTemplate parses the template with which results are written, if one is given
+func parseTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	return template.New("template").Funcs(templateFuncs).Parse(text)
+}
+
+// formatResult formats a result with the template, if one is given, or else in the chosen output format
+func (c *CLI) formatResult(result any) ([]byte, error) {
+	switch {
+	case c.template != nil:
+		return formatTemplate(c.template, result)
+	case c.Output == outputJSONL:
+		return formatJSONLines(result)
+	case c.Output == outputTable:
+		return c.formatTable(result)
+	case c.Output == outputYAML:
+		return c.formatYAML(result)
+	case c.Output == outputEnv:
+		return formatEnv(result)
+	case c.Pretty:
+		return marshal.JSONIndent(result)
+	default:
+		return marshal.JSON(result)
+	}
+}
+
+// formatTemplate executes the template for a result, or for each item if the result is a list. The template is
+// given the result as it is written as JSON, so its fields are named as they are in JSON.
+func formatTemplate(tmpl *template.Template, result any) ([]byte, error) {
+	p, err := marshal.JSON(result)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(p))
+	decoder.UseNumber()
+	var data any
+	if err := decoder.Decode(&data); err != nil || data == nil {
+		return nil, err
+	}
+	items, ok := data.([]any)
+	if !ok {
+		items = []any{data}
+	}
+
+	buffer := &bytes.Buffer{}
+	for _, item := range items {
+		if err := tmpl.Execute(buffer, item); err != nil {
+			return nil, err
+		}
+		buffer.WriteString("\n")
+	}
+	return buffer.Bytes(), nil
+}
+
+// formatJSONLines formats each item of a list result as a line of JSON, or any other result as a single line
+func formatJSONLines(result any) ([]byte, error) {
+	p, err := marshal.JSON(result)
+	if err != nil || !bytes.HasPrefix(p, []byte("[")) {
+		return p, err
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(p, &items); err != nil {
+		return nil, err
+	}
+	buffer := &bytes.Buffer{}
+	for _, item := range items {
+		buffer.Write(item)
+		buffer.WriteString("\n")
+	}
+	return buffer.Bytes(), nil
+}
+
+// formatYAML formats a result as a YAML document, separated from any written before it in the same stream
+func (c *CLI) formatYAML(result any) ([]byte, error) {
+	p, err := marshal.YAML(result)
+	if err != nil || !c.written {
+		return p, err
+	}
+	return append([]byte("---\n"), p...), nil
+}
+
+// formatEnv formats the identifiers of a result as shell variable assignments, e.g. for eval. Nothing is written
+// for an empty result, such as when a secret has no active instance.
+func formatEnv(result any) ([]byte, error) {
+	var variables [][2]string
+	switch result := result.(type) {
+	case *secrets.Instance:
+		if result != nil {
+			variables = [][2]string{
+				{"SECRET_ID", result.Secret.Name},
+				{"INSTANCE_ID", result.Id},
+				{"QID", fmt.Sprintf("%s/%s", result.Secret.Name, result.Id)},
+			}
+		}
+	case *secrets.Secret:
+		variables = [][2]string{{"SECRET_ID", result.Name}}
+	case *secrets.Approval:
+		variables = [][2]string{
+			{"APPROVAL_ID", result.Id},
+			{"SECRET_ID", result.SecretId},
+			{"INSTANCE_ID", result.InstanceId},
+		}
+	case *auth.Elevation:
+		variables = [][2]string{{"ELEVATION_ID", result.Id}}
+	case *bulk.Report:
+		variables = [][2]string{{"BATCH_ID", result.BatchId}}
+	default:
+		return nil, fmt.Errorf("results of this command cannot be written as env, choose another --output")
+	}
+
+	buffer := &bytes.Buffer{}
+	for _, variable := range variables {
+		fmt.Fprintf(buffer, "%s='%s'\n", variable[0], strings.ReplaceAll(variable[1], "'", `'\''`))
+	}
+	return buffer.Bytes(), nil
+}
+
+// lookupActive finds the active instance of the secret whose instances are in a result, so that tables can mark it.
+// The result has already been obtained, so failure is only logged.
+func (c *CLI) lookupActive(ctx context.Context, result any) {
+	var secretId string
+	switch result := result.(type) {
+	case *secrets.Instance:
+		if result != nil {
+			secretId = result.Secret.Name
+		}
+	case secrets.Instances:
+		for _, instance := range result {
+			secretId = instance.Secret.Name
+		}
+	}
+	if secretId == "" {
+		return
+	}
+	active, err := c.secretStore.Instances(secretId).GetActive(ctx)
+	if err != nil {
+		slog.Warn("failed to find the active instance", "secretId", secretId, "error", err)
+		return
+	}
+	if active != nil {
+		c.activeInstanceId = active.Id
+	}
+}
+
+// A table of cells aligned in columns under a header
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+func (t *table) row(cells ...string) {
+	t.rows = append(t.rows, cells)
+}
+
+func (t *table) format(withHeader bool) []byte {
+	buffer := &bytes.Buffer{}
+	writer := tabwriter.NewWriter(buffer, 0, 8, 2, ' ', 0)
+	if withHeader {
+		fmt.Fprintln(writer, strings.Join(t.header, "\t"))
+	}
+	for _, row := range t.rows {
+		for i, cell := range row {
+			if cell == "" {
+				row[i] = "-"
+			}
+		}
+		fmt.Fprintln(writer, strings.Join(row, "\t"))
+	}
+	writer.Flush()
+	return buffer.Bytes()
+}
+
+// formatTable formats a result as a table of the fields an operator most needs, or as YAML if it has no table view
+func (c *CLI) formatTable(result any) ([]byte, error) {
+	now := time.Now()
+	switch result := result.(type) {
+	case secrets.Secrets:
+		return secretTable(result.Sorted()...).format(true), nil
+	case *secrets.Secret:
+		return secretTable(result).format(true), nil
+	case []*secrets.Summary:
+		return summaryTable(now, result...).format(true), nil
+	case secrets.Instances:
+		return instanceTable(c.activeInstanceId, now, result.Sorted()...).format(true), nil
+	case *secrets.Instance:
+		if result == nil {
+			return nil, nil
+		}
+		return instanceTable(c.activeInstanceId, now, result).format(true), nil
+	case []*secrets.Operation:
+		return operationTable(now, result...).format(true), nil
+	case []*secrets.Approval:
+		return approvalTable(now, result...).format(true), nil
+	case *secrets.Approval:
+		return approvalTable(now, result).format(true), nil
+	case []*auth.Elevation:
+		return elevationTable(now, result...).format(true), nil
+	case *auth.Elevation:
+		return elevationTable(now, result).format(true), nil
+	case []*audit.Denial:
+		return denialTable(now, result...).format(true), nil
+	case *bulk.Report:
+		summary := fmt.Sprintf("%d succeeded, %d failed, %d skipped in batch %s\n", result.Succeeded, result.Failed, result.Skipped, result.BatchId)
+		return append(reportTable(result).format(true), summary...), nil
+	case *events.Event:
+		// events are streamed, so columns are of fixed width rather than aligned with rows which are yet to come
+		var buffer bytes.Buffer
+		if !c.written {
+			fmt.Fprintf(&buffer, "%-8s  %-20s  %-28s  %-24s  %-36s  %s\n", "ID", "TIME", "TYPE", "SECRET", "INSTANCE", "PRINCIPAL")
+		}
+		fmt.Fprintf(&buffer, "%-8d  %-20s  %-28s  %-24s  %-36s  %s\n", result.Id, result.OccurredAt.Local().Format(time.DateTime), result.Type, orNone(result.SecretId), orNone(result.InstanceId), orNone(result.Principal))
+		return buffer.Bytes(), nil
+	default:
+		return c.formatYAML(result)
+	}
+}
+
+func secretTable(secretList ...*secrets.Secret) *table {
+	t := &table{header: []string{"NAME", "LABELS", "OPERATIONS"}}
+	for _, secret := range secretList {
+		var labels []string
+		for _, key := range slices.Sorted(maps.Keys(secret.Labels)) {
+			labels = append(labels, key+"="+secret.Labels[key])
+		}
+		var operations []string
+		for _, operation := range []secrets.OperationName{secrets.Create, secrets.Activate, secrets.Deactivate, secrets.Destroy, secrets.Test} {
+			if secret.Command(operation) != nil {
+				operations = append(operations, string(operation))
+			}
+		}
+		t.row(secret.Name, strings.Join(labels, ","), strings.Join(operations, ","))
+	}
+	return t
+}
+
+func summaryTable(now time.Time, summaries ...*secrets.Summary) *table {
+	t := &table{header: []string{"SECRET", "VERDICT", "ACTIVE", "AGE", "LIVE", "LATEST", "RUNNING", "TEST"}}
+	for _, summary := range summaries {
+		var active, activeAge, latest, running, test string
+		if summary.ActiveInstanceId != nil {
+			active = *summary.ActiveInstanceId
+		}
+		if summary.ActiveCreatedAt != nil {
+			activeAge = age(now.Sub(*summary.ActiveCreatedAt))
+		}
+		if operation := summary.LatestOperation; operation != nil {
+			latest = fmt.Sprintf("%s %s", operation.Name, outcome(operation.Status))
+		}
+		if operation := summary.RunningOperation; operation != nil {
+			running = fmt.Sprintf("%s for %s", operation.Name, age(now.Sub(operation.StartedAt)))
+		}
+		if summary.LastTest != nil {
+			test = outcome(summary.LastTest.Status)
+		}
+		t.row(summary.SecretId, string(summary.Verdict), active, activeAge, fmt.Sprint(summary.LiveInstances), latest, running, test)
+	}
+	return t
+}
+
+func instanceTable(activeInstanceId string, now time.Time, instances ...*secrets.Instance) *table {
+	t := &table{header: []string{"ID", "ACTIVE", "STATUS", "AGE", "UPDATED", "OPERATOR"}}
+	for _, instance := range instances {
+		var active string
+		if instance.Id == activeInstanceId {
+			active = "*"
+		}
+		status := fmt.Sprintf("%s %s", instance.Status.Name, outcome(instance.Status))
+		t.row(instance.Id, active, status, age(now.Sub(instance.CreatedAt)), age(now.Sub(instance.Status.StartedAt)), instance.Status.StartedBy)
+	}
+	return t
+}
+
+func operationTable(now time.Time, operations ...*secrets.Operation) *table {
+	t := &table{header: []string{"NUMBER", "INSTANCE", "OPERATION", "OUTCOME", "AGE", "OPERATOR", "REASON"}}
+	for _, operation := range operations {
+		t.row(fmt.Sprint(operation.OperationNumber), operation.InstanceId, string(operation.Name), outcome(operation.Status), age(now.Sub(operation.StartedAt)), operation.StartedBy, operation.Reason)
+	}
+	return t
+}
+
+func approvalTable(now time.Time, approvals ...*secrets.Approval) *table {
+	t := &table{header: []string{"ID", "SECRET", "OPERATION", "STATUS", "APPROVALS", "REQUESTED BY", "AGE"}}
+	for _, approval := range approvals {
+		var approved int
+		for _, decision := range approval.Decisions {
+			if decision.Approved {
+				approved++
+			}
+		}
+		t.row(approval.Id, approval.SecretId, string(approval.Name), string(approval.Status), fmt.Sprintf("%d/%d", approved, approval.Required), approval.RequestedBy, age(now.Sub(approval.RequestedAt)))
+	}
+	return t
+}
+
+func elevationTable(now time.Time, elevations ...*auth.Elevation) *table {
+	t := &table{header: []string{"ID", "PRINCIPAL", "ROLE", "STATUS", "EXPIRES", "REASON"}}
+	for _, elevation := range elevations {
+		status := "active"
+		if elevation.RevokedAt != nil {
+			status = "revoked"
+		} else if !elevation.Active(now) {
+			status = "expired"
+		}
+		expires := "in " + age(elevation.ExpiresAt.Sub(now))
+		if !elevation.ExpiresAt.After(now) {
+			expires = age(now.Sub(elevation.ExpiresAt)) + " ago"
+		}
+		t.row(elevation.Id, elevation.Principal, string(elevation.Role), status, expires, elevation.Reason)
+	}
+	return t
+}
+
+func denialTable(now time.Time, denials ...*audit.Denial) *table {
+	t := &table{header: []string{"ID", "PRINCIPAL", "ROUTE", "SECRET", "REASON", "AGE"}}
+	for _, denial := range denials {
+		t.row(fmt.Sprint(denial.Id), denial.Principal, denial.Route, denial.SecretId, denial.Reason, age(now.Sub(denial.DeniedAt)))
+	}
+	return t
+}
+
+func reportTable(report *bulk.Report) *table {
+	t := &table{header: []string{"SECRET", "OUTCOME", "INSTANCE", "ERROR"}}
+	for _, result := range report.Results {
+		outcome := "succeeded"
+		if result.Skipped {
+			outcome = "skipped"
+		} else if result.Error != "" {
+			outcome = "failed"
+		}
+		var instanceId string
+		if result.Instance != nil {
+			instanceId = result.Instance.Id
+		}
+		t.row(result.SecretId, outcome, instanceId, result.Error)
+	}
+	return t
+}
+
+// outcome describes how far an operation got
+func outcome(status secrets.Status) string {
+	switch {
+	case status.InterruptedAt != nil:
+		return "interrupted"
+	case status.FailedAt != nil:
+		return "failed"
+	case status.CompletedAt != nil:
+		return "completed"
+	default:
+		return "running"
+	}
+}
+
+// age gives a duration roughly, in its largest whole unit, e.g. 3h or 12d
+func age(duration time.Duration) string {
+	duration = max(duration, 0)
+	switch {
+	case duration < time.Minute:
+		return fmt.Sprintf("%ds", int(duration.Seconds()))
+	case duration < time.Hour:
+		return fmt.Sprintf("%dm", int(duration.Minutes()))
+	case duration < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(duration.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(duration.Hours()/24))
+	}
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,169 @@
+package cli
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/bulk"
+	"github.com/eliasvasylenko/secret-agent/internal/mocks"
+	sec "github.com/eliasvasylenko/secret-agent/internal/secrets"
+	"github.com/eliasvasylenko/secret-agent/internal/store"
+	"github.com/google/go-cmp/cmp"
+)
+
+func testInstances() sec.Instances {
+	startedAt := time.Now().Add(-3 * time.Hour)
+	createdAt := time.Now().Add(-50 * time.Hour)
+	return sec.Instances{
+		"i1": {Id: "i1", Secret: sec.Secret{Name: "db"}, Status: sec.Status{OperationNumber: 1, Name: sec.Create, StartedBy: "alice", StartedAt: startedAt, CompletedAt: &startedAt}, CreatedAt: startedAt},
+		"i2": {Id: "i2", Secret: sec.Secret{Name: "db"}, Status: sec.Status{OperationNumber: 2, Name: sec.Activate, StartedBy: "bob", StartedAt: startedAt, FailedAt: &startedAt}, CreatedAt: createdAt},
+	}
+}
+
+func TestRun_instancesTable(t *testing.T) {
+	mockStore := &mocks.MockSecrets{}
+	defer mockStore.Mock.Validate(t)
+	mockInstances := &mocks.MockInstances{}
+	defer mockInstances.Mock.Validate(t)
+	instancesReturn := func(secretId string) store.Instances {
+		return mockInstances
+	}
+	mocks.Expect(&mockStore.Mock, mockStore.Instances, instancesReturn)
+	mocks.Expect(&mockInstances.Mock, mockInstances.List, func(ctx context.Context, from int, to int) (sec.Instances, error) {
+		return testInstances(), nil
+	})
+	mocks.Expect(&mockStore.Mock, mockStore.Instances, func(secretId string) store.Instances {
+		if secretId != "db" {
+			t.Errorf("Instances called with secretId=%q, want db", secretId)
+		}
+		return mockInstances
+	})
+	mocks.Expect(&mockInstances.Mock, mockInstances.GetActive, func(ctx context.Context) (*sec.Instance, error) {
+		return testInstances()["i1"], nil
+	})
+
+	cli := &CLI{
+		ctx:         stubKongContext{command: "instances <secret-id>"},
+		secretStore: mockStore,
+		Instances:   Instances{SecretID: "db", Bounds: Bounds{From: 0, To: 10}},
+		Output:      outputTable,
+	}
+	stdout := captureStdout(t, func() {
+		cli.Run(context.Background())
+	})
+	want := `ID  ACTIVE  STATUS            AGE  UPDATED  OPERATOR
+i2  -       activate failed   2d   3h       bob
+i1  *       create completed  3h   3h       alice
+`
+	if diff := cmp.Diff(want, string(stdout)); diff != "" {
+		t.Errorf("unexpected table (-want +got):\n%s", diff)
+	}
+}
+
+func TestFormatResult(t *testing.T) {
+	approval := &sec.Approval{Id: "a1", SecretId: "db", Name: sec.Create}
+	results := []*bulk.Result{{SecretId: "a"}, {SecretId: "b", Skipped: true}}
+	tests := []struct {
+		name     string
+		output   string
+		template string
+		result   any
+		want     string
+	}{
+		{"json", outputJSON, "", results, `[{"secretId":"a"},{"secretId":"b","skipped":true}]` + "\n"},
+		{"jsonl", outputJSONL, "", results, `{"secretId":"a"}` + "\n" + `{"secretId":"b","skipped":true}` + "\n"},
+		{"jsonl object", outputJSONL, "", results[0], `{"secretId":"a"}` + "\n"},
+		{"yaml", outputYAML, "", results, "- secretId: a\n- secretId: b\n  skipped: true\n"},
+		{"env", outputEnv, "", testInstances()["i1"], "SECRET_ID='db'\nINSTANCE_ID='i1'\nQID='db/i1'\n"},
+		{"env quoted", outputEnv, "", &sec.Secret{Name: "it's"}, `SECRET_ID='it'\''s'` + "\n"},
+		{"env empty", outputEnv, "", (*sec.Instance)(nil), ""},
+		{"template list", outputTable, "{{.id}} {{.status.name}}", testInstances(), "i2 activate\ni1 create\n"},
+		{"template object", outputJSON, "{{.secretId}}/{{.id}}", approval, "db/a1\n"},
+		{"template null", outputJSON, "{{.id}}", (*sec.Instance)(nil), ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmpl, err := parseTemplate(test.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cli := &CLI{Output: test.output, template: tmpl}
+			got, err := cli.formatResult(test.result)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.want, string(got)); diff != "" {
+				t.Errorf("unexpected output (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFormatResult_envUnsupported(t *testing.T) {
+	cli := &CLI{Output: outputEnv}
+	if _, err := cli.formatResult(testInstances()); err == nil {
+		t.Error("expected an error writing a list as env")
+	}
+}
+
+func TestParseTemplate_invalid(t *testing.T) {
+	if _, err := parseTemplate("{{.id"); err == nil {
+		t.Error("expected an error for an unterminated action")
+	}
+}
+
+func TestAge(t *testing.T) {
+	for duration, want := range map[time.Duration]string{
+		-time.Second:             "0s",
+		59 * time.Second:         "59s",
+		90 * time.Minute:         "1h",
+		47 * time.Hour:           "47h",
+		72*time.Hour + time.Hour: "3d",
+	} {
+		if got := age(duration); got != want {
+			t.Errorf("age(%v) = %s, want %s", duration, got, want)
+		}
+	}
+}
+
+// A fixed summary of every secret
+type stubSummaries []*sec.Summary
+
+func (s stubSummaries) Summaries(ctx context.Context) ([]*sec.Summary, error) {
+	return s, nil
+}
+
+func TestRun_statusTable(t *testing.T) {
+	createdAt := time.Now().Add(-3 * time.Hour)
+	startedAt := time.Now().Add(-2 * time.Hour)
+	activeInstanceId := "i1"
+	cli := &CLI{
+		ctx: stubKongContext{command: "status"},
+		summaries: stubSummaries{
+			{
+				SecretId:         "api",
+				ActiveInstanceId: &activeInstanceId,
+				ActiveCreatedAt:  &createdAt,
+				LatestOperation:  &sec.Operation{InstanceId: "i2", Status: sec.Status{Name: sec.Create, StartedAt: startedAt}},
+				RunningOperation: &sec.Operation{InstanceId: "i2", Status: sec.Status{Name: sec.Create, StartedAt: startedAt}},
+				Stuck:            true,
+				LiveInstances:    2,
+				LastTest:         &sec.Operation{InstanceId: "i1", Status: sec.Status{Name: sec.Test, FailedAt: &createdAt}},
+				Verdict:          sec.Stuck,
+			},
+			{SecretId: "db", Verdict: sec.Inactive},
+		},
+		Output: outputTable,
+	}
+	stdout := captureStdout(t, func() {
+		cli.Run(context.Background())
+	})
+	want := `SECRET  VERDICT   ACTIVE  AGE  LIVE  LATEST          RUNNING        TEST
+api     stuck     i1      3h   2     create running  create for 2h  failed
+db      inactive  -       -    0     -               -              -
+`
+	if diff := cmp.Diff(want, string(stdout)); diff != "" {
+		t.Errorf("unexpected table (-want +got):\n%s", diff)
+	}
+}
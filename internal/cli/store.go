@@ -2,9 +2,12 @@ package cli
 
 import (
 	"context"
+	"time"
 
+	"github.com/eliasvasylenko/secret-agent/internal/bulk"
 	"github.com/eliasvasylenko/secret-agent/internal/client"
 	"github.com/eliasvasylenko/secret-agent/internal/config"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
 	"github.com/eliasvasylenko/secret-agent/internal/sqlite"
 	"github.com/eliasvasylenko/secret-agent/internal/store"
 )
@@ -22,13 +25,65 @@ type sqliteSecrets struct {
 	*sqlite.SecretRespository
 }
 
+func (s clientSecrets) Elevations() *client.ElevationClient {
+	return s.SecretClient.Elevations()
+}
+
+func (s clientSecrets) Events() *client.EventClient {
+	return s.SecretClient.Events()
+}
+
+func (s clientSecrets) Bulk() *client.BulkClient {
+	return s.SecretClient.Bulk()
+}
+
+func (s clientSecrets) Denials() *client.DenialClient {
+	return s.SecretClient.Denials()
+}
+
 func (s sqliteSecrets) Instances(secretId string) store.Instances {
 	return s.SecretRespository.Instances(secretId)
 }
 
-func NewStore(ctx context.Context, socket string, secretsFile string, dbFile string, debug bool, maxReasonLen int) (store.Secrets, error) {
-	if socket != "" {
-		store := client.NewSecretStore(socket)
+func (s sqliteSecrets) Approvals() store.Approvals {
+	return s.SecretRespository.Approvals()
+}
+
+func (s sqliteSecrets) Denials() store.Denials {
+	return s.SecretRespository.Denials()
+}
+
+func (s sqliteSecrets) Health() store.Health {
+	return s.SecretRespository.Health()
+}
+
+func (s sqliteSecrets) Elevations() store.Elevations {
+	return s.SecretRespository.Elevations()
+}
+
+// Performs operations in bulk directly upon a store, without authorisation
+type localBulk struct {
+	secretStore store.Secrets
+}
+
+func (b localBulk) Run(ctx context.Context, request bulk.Request) (*bulk.Report, error) {
+	return bulk.Run(ctx, b.secretStore, request, "user", "", nil)
+}
+
+// Summarises secrets directly from a store, judging operations stuck once they have run for the given time
+type localSummaries struct {
+	health     store.Health
+	stuckAfter time.Duration
+}
+
+func (s localSummaries) Summaries(ctx context.Context) ([]*secrets.Summary, error) {
+	return s.health.Summaries(ctx, time.Now().Add(-s.stuckAfter))
+}
+
+// NewStore connects to a running server if given the config to do so, or else opens the database directly
+func NewStore(ctx context.Context, clientConfig *client.Config, secretsFile string, dbFile string, debug bool, maxReasonLen int) (store.Secrets, error) {
+	if clientConfig != nil {
+		store := client.NewSecretStore(*clientConfig)
 		return clientSecrets{
 			SecretClient: store,
 		}, nil
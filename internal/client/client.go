@@ -1,19 +1,31 @@
 package client
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"iter"
 	"net"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/eliasvasylenko/secret-agent/internal/audit"
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/bulk"
+	"github.com/eliasvasylenko/secret-agent/internal/events"
 	"github.com/eliasvasylenko/secret-agent/internal/secrets"
 	"github.com/eliasvasylenko/secret-agent/internal/server"
 )
 
+// ErrIncompatible is returned when the agent does not serve the version of the API which the client requires
+var ErrIncompatible = errors.New("incompatible agent")
+
 type SecretClient struct {
 	socket string
 	client httpClient
@@ -25,24 +37,72 @@ type InstanceClient struct {
 	secretId string
 }
 
+type ApprovalClient struct {
+	client httpClient
+}
+
+type DenialClient struct {
+	client httpClient
+}
+
+type ElevationClient struct {
+	client httpClient
+}
+
+type EventClient struct {
+	client httpClient
+}
+
+type BulkClient struct {
+	client httpClient
+}
+
 type httpClient interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
-func NewSecretStore(socket string) *SecretClient {
-	client := &http.Client{
-		Transport: &http.Transport{
-			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
-				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
-			},
-		},
+// Config for connecting to a running server
+type Config struct {
+	// Path of the unix socket on which the server listens
+	Socket string
+	// Time allowed to connect to the server, or unlimited if zero
+	ConnectTimeout time.Duration
+	// Time allowed for the server to respond to a read once it is sent, or unlimited if zero. Event streams are not
+	// cut short once they start, and operations are not timed out, since the server interrupts an operation if the
+	// client which requested it hangs up.
+	ReadTimeout time.Duration
+	// Times an idempotent request is retried after failing transiently
+	Retries int
+}
+
+func NewSecretStore(config Config) *SecretClient {
+	dial := func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return (&net.Dialer{Timeout: config.ConnectTimeout}).DialContext(ctx, "unix", config.Socket)
+	}
+	client := readTimeoutClient{
+		reads:  &http.Client{Transport: &http.Transport{DialContext: dial, ResponseHeaderTimeout: config.ReadTimeout}},
+		writes: &http.Client{Transport: &http.Transport{DialContext: dial}},
 	}
 	return &SecretClient{
-		socket: socket,
-		client: client,
+		socket: config.Socket,
+		client: &retryingClient{client: client, retries: config.Retries, backoff: retryBackoff},
 	}
 }
 
+// readTimeoutClient sends reads through a client which times out their responses, and anything else through one
+// which waits for as long as it takes
+type readTimeoutClient struct {
+	reads  httpClient
+	writes httpClient
+}
+
+func (c readTimeoutClient) Do(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return c.reads.Do(req)
+	}
+	return c.writes.Do(req)
+}
+
 func BuildRequest(ctx context.Context, method string, path string, body any) (*http.Request, error) {
 	buffer := &bytes.Buffer{}
 	var err error
@@ -55,16 +115,27 @@ func BuildRequest(ctx context.Context, method string, path string, body any) (*h
 			return nil, err
 		}
 	}
-	return http.NewRequestWithContext(ctx, method, "http://unix"+path, buffer)
+	req, err := http.NewRequestWithContext(ctx, method, "http://unix"+server.APIPrefix+path, buffer)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(server.APIVersionHeader, server.APIVersion)
+	return req, nil
 }
 
 func Do[T any](client httpClient, req *http.Request, err error) (T, error) {
 	response, err := client.Do(req)
-	var body T
 	if err != nil {
+		var body T
 		return body, err
 	}
 	defer response.Body.Close()
+	return decodeResponse[T](response)
+}
+
+// decodeResponse decodes the body of a response, or the error which it reports
+func decodeResponse[T any](response *http.Response) (T, error) {
+	var body T
 	bodyBytes, err := io.ReadAll(response.Body)
 	if err != nil {
 		return body, err
@@ -76,10 +147,23 @@ func Do[T any](client httpClient, req *http.Request, err error) (T, error) {
 		return body, &errorResponse
 	}
 
+	if response.StatusCode == http.StatusNotFound && response.Header.Get(server.SupportedVersionsHeader) == "" {
+		return body, fmt.Errorf("%w, it does not support version %s of the API which this client requires and may be older than the client", ErrIncompatible, server.APIVersion)
+	}
+
 	if response.StatusCode >= 300 {
 		return body, server.NewErrorResponse(response.StatusCode, nil)
 	}
 
+	if response.StatusCode == http.StatusAccepted {
+		var approval secrets.Approval
+		err = json.Unmarshal(bodyBytes, &approval)
+		if err != nil {
+			return body, fmt.Errorf("failed to parse approval request, %w - '%s'", err, string(bodyBytes))
+		}
+		return body, &secrets.ApprovalRequiredError{Approval: &approval}
+	}
+
 	err = json.Unmarshal(bodyBytes, &body)
 	if err != nil {
 		err = fmt.Errorf("failed to parse response, %w - '%s'", err, string(bodyBytes))
@@ -111,6 +195,24 @@ func (c *SecretClient) History(ctx context.Context, secretId string, from int, t
 	return Do[[]*secrets.Operation](c.client, req, err)
 }
 
+// LatestOperation reads the number of the latest operation on a secret from the entity tag of its active instance
+func (c *SecretClient) LatestOperation(ctx context.Context, secretId string) (int, error) {
+	req, err := BuildRequest(ctx, http.MethodGet, "/secrets/"+secretId+"/active", nil)
+	if err != nil {
+		return 0, err
+	}
+	response, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	_, err = decodeResponse[*secrets.Instance](response)
+	if err != nil {
+		return 0, err
+	}
+	return server.ParseETag(response.Header.Get("ETag"))
+}
+
 func (c *SecretClient) Instances(secretId string) *InstanceClient {
 	return &InstanceClient{
 		socket:   c.socket,
@@ -121,6 +223,10 @@ func (c *SecretClient) Instances(secretId string) *InstanceClient {
 
 func (c *InstanceClient) List(ctx context.Context, from int, to int) (secrets.Instances, error) {
 	req, err := BuildRequest(ctx, http.MethodGet, "/secrets/"+c.secretId+"/instances", nil)
+	query := req.URL.Query()
+	query.Set("from", strconv.FormatInt(int64(from), 10))
+	query.Set("to", strconv.FormatInt(int64(to), 10))
+	req.URL.RawQuery = query.Encode()
 	items, err := Do[server.ItemsResponse[secrets.Instances]](c.client, req, err)
 	if err != nil {
 		return nil, err
@@ -139,67 +245,50 @@ func (c *InstanceClient) GetActive(ctx context.Context) (*secrets.Instance, erro
 }
 
 func (c *InstanceClient) Create(ctx context.Context, parameters secrets.OperationParameters) (*secrets.Instance, error) {
-	instance := server.CreateOperationParameters{
-		OperationParameters: server.OperationParameters{
-			Env:    parameters.Env,
-			Forced: parameters.Forced,
-			Reason: parameters.Reason,
-		},
-	}
-	req, err := BuildRequest(ctx, http.MethodPost, "/secrets/"+c.secretId+"/instances", instance)
+	req, err := operationRequest(ctx, "/secrets/"+c.secretId+"/instances", "", parameters)
 	return Do[*secrets.Instance](c.client, req, err)
 }
 
 func (c *InstanceClient) Destroy(ctx context.Context, instanceId string, parameters secrets.OperationParameters) (*secrets.Instance, error) {
-	instance := server.CreateOperationParameters{
-		Name: secrets.Destroy,
-		OperationParameters: server.OperationParameters{
-			Env:    parameters.Env,
-			Forced: parameters.Forced,
-			Reason: parameters.Reason,
-		},
-	}
-	req, err := BuildRequest(ctx, http.MethodPost, "/secrets/"+c.secretId+"/instances/"+instanceId+"/operations", instance)
+	req, err := operationRequest(ctx, "/secrets/"+c.secretId+"/instances/"+instanceId+"/operations", secrets.Destroy, parameters)
 	return Do[*secrets.Instance](c.client, req, err)
 }
 
 func (c *InstanceClient) Activate(ctx context.Context, instanceId string, parameters secrets.OperationParameters) (*secrets.Instance, error) {
-	instance := server.CreateOperationParameters{
-		Name: secrets.Activate,
-		OperationParameters: server.OperationParameters{
-			Env:    parameters.Env,
-			Forced: parameters.Forced,
-			Reason: parameters.Reason,
-		},
-	}
-	req, err := BuildRequest(ctx, http.MethodPost, "/secrets/"+c.secretId+"/instances/"+instanceId+"/operations", instance)
+	req, err := operationRequest(ctx, "/secrets/"+c.secretId+"/instances/"+instanceId+"/operations", secrets.Activate, parameters)
 	return Do[*secrets.Instance](c.client, req, err)
 }
 
 func (c *InstanceClient) Deactivate(ctx context.Context, instanceId string, parameters secrets.OperationParameters) (*secrets.Instance, error) {
-	instance := server.CreateOperationParameters{
-		Name: secrets.Deactivate,
-		OperationParameters: server.OperationParameters{
-			Env:    parameters.Env,
-			Forced: parameters.Forced,
-			Reason: parameters.Reason,
-		},
-	}
-	req, err := BuildRequest(ctx, http.MethodPost, "/secrets/"+c.secretId+"/instances/"+instanceId+"/operations", instance)
+	req, err := operationRequest(ctx, "/secrets/"+c.secretId+"/instances/"+instanceId+"/operations", secrets.Deactivate, parameters)
 	return Do[*secrets.Instance](c.client, req, err)
 }
 
 func (c *InstanceClient) Test(ctx context.Context, instanceId string, parameters secrets.OperationParameters) (*secrets.Instance, error) {
-	instance := server.CreateOperationParameters{
-		Name: secrets.Test,
+	req, err := operationRequest(ctx, "/secrets/"+c.secretId+"/instances/"+instanceId+"/operations", secrets.Test, parameters)
+	return Do[*secrets.Instance](c.client, req, err)
+}
+
+// operationRequest builds a request for an operation, giving its idempotency key and precondition in headers
+func operationRequest(ctx context.Context, path string, name secrets.OperationName, parameters secrets.OperationParameters) (*http.Request, error) {
+	req, err := BuildRequest(ctx, http.MethodPost, path, server.CreateOperationParameters{
+		Name: name,
 		OperationParameters: server.OperationParameters{
 			Env:    parameters.Env,
 			Forced: parameters.Forced,
 			Reason: parameters.Reason,
 		},
+	})
+	if err != nil {
+		return nil, err
 	}
-	req, err := BuildRequest(ctx, http.MethodPost, "/secrets/"+c.secretId+"/instances/"+instanceId+"/operations", instance)
-	return Do[*secrets.Instance](c.client, req, err)
+	if parameters.IdempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", parameters.IdempotencyKey)
+	}
+	if parameters.IfMatch != nil {
+		req.Header.Set("If-Match", server.FormatETag(*parameters.IfMatch))
+	}
+	return req, nil
 }
 
 func (c *InstanceClient) History(ctx context.Context, instanceId string, from int, to int) (operations []*secrets.Operation, err error) {
@@ -210,3 +299,220 @@ func (c *InstanceClient) History(ctx context.Context, instanceId string, from in
 	req.URL.RawQuery = query.Encode()
 	return Do[[]*secrets.Operation](c.client, req, err)
 }
+
+func (c *SecretClient) Whoami(ctx context.Context, query *server.OperationQuery) (*server.Whoami, error) {
+	req, err := BuildRequest(ctx, http.MethodGet, "/whoami", nil)
+	if err == nil && query != nil {
+		req.URL.RawQuery = query.Values().Encode()
+	}
+	return Do[*server.Whoami](c.client, req, err)
+}
+
+func (c *SecretClient) Approvals() *ApprovalClient {
+	return &ApprovalClient{
+		client: c.client,
+	}
+}
+
+func (c *ApprovalClient) List(ctx context.Context, from int, to int) ([]*secrets.Approval, error) {
+	req, err := BuildRequest(ctx, http.MethodGet, "/approvals", nil)
+	query := req.URL.Query()
+	query.Set("from", strconv.FormatInt(int64(from), 10))
+	query.Set("to", strconv.FormatInt(int64(to), 10))
+	req.URL.RawQuery = query.Encode()
+	items, err := Do[server.ItemsResponse[[]*secrets.Approval]](c.client, req, err)
+	if err != nil {
+		return nil, err
+	}
+	return items.Items, nil
+}
+
+func (c *ApprovalClient) Get(ctx context.Context, approvalId string) (*secrets.Approval, error) {
+	req, err := BuildRequest(ctx, http.MethodGet, "/approvals/"+approvalId, nil)
+	return Do[*secrets.Approval](c.client, req, err)
+}
+
+func (c *ApprovalClient) Approve(ctx context.Context, approvalId string, comment string) (*secrets.Approval, error) {
+	req, err := BuildRequest(ctx, http.MethodPost, "/approvals/"+approvalId+"/approve", server.DecisionParameters{Comment: comment})
+	return Do[*secrets.Approval](c.client, req, err)
+}
+
+func (c *ApprovalClient) Reject(ctx context.Context, approvalId string, comment string) (*secrets.Approval, error) {
+	req, err := BuildRequest(ctx, http.MethodPost, "/approvals/"+approvalId+"/reject", server.DecisionParameters{Comment: comment})
+	return Do[*secrets.Approval](c.client, req, err)
+}
+
+func (c *SecretClient) Denials() *DenialClient {
+	return &DenialClient{
+		client: c.client,
+	}
+}
+
+func (c *DenialClient) List(ctx context.Context, from int, to int) ([]*audit.Denial, error) {
+	req, err := BuildRequest(ctx, http.MethodGet, "/audit/denials", nil)
+	query := req.URL.Query()
+	query.Set("from", strconv.FormatInt(int64(from), 10))
+	query.Set("to", strconv.FormatInt(int64(to), 10))
+	req.URL.RawQuery = query.Encode()
+	items, err := Do[server.ItemsResponse[[]*audit.Denial]](c.client, req, err)
+	if err != nil {
+		return nil, err
+	}
+	return items.Items, nil
+}
+
+func (c *SecretClient) Elevations() *ElevationClient {
+	return &ElevationClient{
+		client: c.client,
+	}
+}
+
+func (c *ElevationClient) List(ctx context.Context, from int, to int) ([]*auth.Elevation, error) {
+	req, err := BuildRequest(ctx, http.MethodGet, "/elevations", nil)
+	query := req.URL.Query()
+	query.Set("from", strconv.FormatInt(int64(from), 10))
+	query.Set("to", strconv.FormatInt(int64(to), 10))
+	req.URL.RawQuery = query.Encode()
+	items, err := Do[server.ItemsResponse[[]*auth.Elevation]](c.client, req, err)
+	if err != nil {
+		return nil, err
+	}
+	return items.Items, nil
+}
+
+func (c *ElevationClient) Elevate(ctx context.Context, parameters server.ElevationParameters) (*auth.Elevation, error) {
+	req, err := BuildRequest(ctx, http.MethodPost, "/elevations", parameters)
+	return Do[*auth.Elevation](c.client, req, err)
+}
+
+func (c *ElevationClient) Revoke(ctx context.Context, elevationId string) (*auth.Elevation, error) {
+	req, err := BuildRequest(ctx, http.MethodPost, "/elevations/"+elevationId+"/revoke", nil)
+	return Do[*auth.Elevation](c.client, req, err)
+}
+
+// Summaries gives a summary of every secret in order of ID, in which operations are judged stuck by the threshold
+// of the server
+func (c *SecretClient) Summaries(ctx context.Context) ([]*secrets.Summary, error) {
+	req, err := BuildRequest(ctx, http.MethodGet, "/status/secrets", nil)
+	items, err := Do[server.ItemsResponse[[]*secrets.Summary]](c.client, req, err)
+	if err != nil {
+		return nil, err
+	}
+	return items.Items, nil
+}
+
+func (c *SecretClient) Bulk() *BulkClient {
+	return &BulkClient{
+		client: c.client,
+	}
+}
+
+func (c *BulkClient) Run(ctx context.Context, request bulk.Request) (*bulk.Report, error) {
+	req, err := BuildRequest(ctx, http.MethodPost, "/bulk/operations", request)
+	return Do[*bulk.Report](c.client, req, err)
+}
+
+func (c *SecretClient) Events() *EventClient {
+	return &EventClient{
+		client: c.client,
+	}
+}
+
+// A query for a stream of events
+type EventQuery struct {
+	// Only stream events about the secret with this ID, if given
+	SecretId string
+	// Resume the stream after the event with this ID, or start from the latest event if nil
+	After *int64
+	// Called once the stream is open, if given, so that state which the events update may be read without missing
+	// any change to it. An error ends the stream.
+	Opened func() error
+}
+
+// Stream the events which the caller may observe as they are recorded, until the context is done or the
+// stream ends. An error ends the sequence, after which the stream may be resumed after the last event received.
+func (c *EventClient) Stream(ctx context.Context, query EventQuery) iter.Seq2[*events.Event, error] {
+	return func(yield func(*events.Event, error) bool) {
+		req, err := BuildRequest(ctx, http.MethodGet, "/events", nil)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		if query.SecretId != "" {
+			values := req.URL.Query()
+			values.Set("secretId", query.SecretId)
+			req.URL.RawQuery = values.Encode()
+		}
+		if query.After != nil {
+			req.Header.Set("Last-Event-ID", strconv.FormatInt(*query.After, 10))
+		}
+		req.Header.Set("Accept", "text/event-stream")
+
+		response, err := c.client.Do(req)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			_, err = decodeResponse[any](response)
+			if err == nil {
+				err = server.NewErrorResponse(response.StatusCode, fmt.Errorf("unexpected response to event stream"))
+			}
+			yield(nil, err)
+			return
+		}
+		if query.Opened != nil {
+			if err := query.Opened(); err != nil {
+				yield(nil, err)
+				return
+			}
+		}
+
+		for event, err := range readEvents(response.Body) {
+			if !yield(event, err) || err != nil {
+				return
+			}
+		}
+		if ctx.Err() != nil {
+			yield(nil, ctx.Err())
+		}
+	}
+}
+
+// readEvents reads events from a stream in the text/event-stream format, ignoring comments and any fields
+// other than the data, which holds the whole event
+func readEvents(body io.Reader) iter.Seq2[*events.Event, error] {
+	return func(yield func(*events.Event, error) bool) {
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(nil, 1<<20)
+		var data bytes.Buffer
+		for scanner.Scan() {
+			line := scanner.Text()
+			if line == "" {
+				if data.Len() == 0 {
+					continue
+				}
+				event := &events.Event{}
+				err := json.Unmarshal(data.Bytes(), event)
+				if err != nil {
+					err = fmt.Errorf("failed to parse event, %w - '%s'", err, data.String())
+				}
+				data.Reset()
+				if !yield(event, err) || err != nil {
+					return
+				}
+				continue
+			}
+			if value, ok := strings.CutPrefix(line, "data:"); ok {
+				if data.Len() > 0 {
+					data.WriteByte('\n')
+				}
+				data.WriteString(strings.TrimPrefix(value, " "))
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			yield(nil, err)
+		}
+	}
+}
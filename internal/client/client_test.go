@@ -3,12 +3,19 @@ package client
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/eliasvasylenko/secret-agent/internal/audit"
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/bulk"
 	"github.com/eliasvasylenko/secret-agent/internal/command"
+	"github.com/eliasvasylenko/secret-agent/internal/events"
+	"github.com/eliasvasylenko/secret-agent/internal/marshal"
 	"github.com/eliasvasylenko/secret-agent/internal/secrets"
 	"github.com/eliasvasylenko/secret-agent/internal/server"
 	"github.com/google/go-cmp/cmp"
@@ -65,10 +72,13 @@ func TestBuildRequest(t *testing.T) {
 			t.Fatal(err)
 		}
 		got := requestString(req)
-		want := "GET /secrets\n"
+		want := "GET /v1/secrets\n"
 		if got != want {
 			t.Errorf("request:\n%s", cmp.Diff(want, got))
 		}
+		if version := req.Header.Get(server.APIVersionHeader); version != server.APIVersion {
+			t.Errorf("%s = %q, want %s", server.APIVersionHeader, version, server.APIVersion)
+		}
 	})
 	t.Run("with body", func(t *testing.T) {
 		body := map[string]string{"name": "create"}
@@ -77,7 +87,7 @@ func TestBuildRequest(t *testing.T) {
 			t.Fatal(err)
 		}
 		got := requestString(req)
-		want := "POST /secrets/s1/instances\n" + `{"name":"create"}` + "\n"
+		want := "POST /v1/secrets/s1/instances\n" + `{"name":"create"}` + "\n"
 		if got != want {
 			t.Errorf("request:\n%s", cmp.Diff(want, got))
 		}
@@ -128,6 +138,28 @@ func TestDo_serverErrorResponse(t *testing.T) {
 	}
 }
 
+func TestDo_invalidRequest(t *testing.T) {
+	ctx := context.Background()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "http://unix/v1/secrets/s1/instances", nil)
+	stub := &stubClient{resp: stubResponse(400, `{"error":{"status":400,"message":"invalid request","fields":[{"pointer":"/forcd","message":"unknown field"},{"parameter":"to","message":"must be an integer"}]}}`)}
+	_, err := Do[*secrets.Instance](stub, req, nil)
+	want := "400 Bad Request - invalid request\n  /forcd: unknown field\n  parameter to: must be an integer"
+	if err == nil || err.Error() != want {
+		t.Errorf("err = %v, want %q", err, want)
+	}
+}
+
+func TestDo_incompatibleServer(t *testing.T) {
+	ctx := context.Background()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://unix/v1/secrets", nil)
+	// a server which predates versioning has no route for the prefix, and does not report its versions
+	stub := &stubClient{resp: stubResponse(404, "404 page not found\n")}
+	_, err := Do[secrets.Secrets](stub, req, nil)
+	if !errors.Is(err, ErrIncompatible) || !strings.Contains(err.Error(), "does not support version 1 of the API") {
+		t.Errorf("err = %v, want an incompatible version", err)
+	}
+}
+
 func TestSecretClient_List(t *testing.T) {
 	ctx := context.Background()
 	stub := &stubClient{resp: stubResponse(200, `{"items":[{"name":"x"}]}`)}
@@ -136,8 +168,8 @@ func TestSecretClient_List(t *testing.T) {
 	if err != nil {
 		t.Fatalf("List: %v", err)
 	}
-	if got := requestString(stub.lastReq); got != "GET /secrets\n" {
-		t.Errorf("request:\n%s", cmp.Diff("GET /secrets\n", got))
+	if got := requestString(stub.lastReq); got != "GET /v1/secrets\n" {
+		t.Errorf("request:\n%s", cmp.Diff("GET /v1/secrets\n", got))
 	}
 	want := secrets.Secrets{"x": {Name: "x"}}
 	if !cmp.Equal(got, want, cmpSecretOpts) {
@@ -153,8 +185,8 @@ func TestSecretClient_Get(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Get: %v", err)
 	}
-	if gotReq := requestString(stub.lastReq); gotReq != "GET /secrets/my-secret\n" {
-		t.Errorf("request:\n%s", cmp.Diff("GET /secrets/my-secret\n", gotReq))
+	if gotReq := requestString(stub.lastReq); gotReq != "GET /v1/secrets/my-secret\n" {
+		t.Errorf("request:\n%s", cmp.Diff("GET /v1/secrets/my-secret\n", gotReq))
 	}
 	want := &secrets.Secret{Name: "my-secret"}
 	if !cmp.Equal(got, want, cmpSecretOpts) {
@@ -170,8 +202,8 @@ func TestInstanceClient_List(t *testing.T) {
 	if err != nil {
 		t.Fatalf("List: %v", err)
 	}
-	if gotReq := requestString(stub.lastReq); gotReq != "GET /secrets/sid/instances\n" {
-		t.Errorf("request:\n%s", cmp.Diff("GET /secrets/sid/instances\n", gotReq))
+	if gotReq := requestString(stub.lastReq); gotReq != "GET /v1/secrets/sid/instances?from=0&to=10\n" {
+		t.Errorf("request:\n%s", cmp.Diff("GET /v1/secrets/sid/instances?from=0&to=10\n", gotReq))
 	}
 	want := secrets.Instances{}
 	if !cmp.Equal(got, want, cmpInstanceOpts) {
@@ -187,8 +219,8 @@ func TestInstanceClient_Get(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Get: %v", err)
 	}
-	if gotReq := requestString(stub.lastReq); gotReq != "GET /secrets/sid/instances/i1\n" {
-		t.Errorf("request:\n%s", cmp.Diff("GET /secrets/sid/instances/i1\n", gotReq))
+	if gotReq := requestString(stub.lastReq); gotReq != "GET /v1/secrets/sid/instances/i1\n" {
+		t.Errorf("request:\n%s", cmp.Diff("GET /v1/secrets/sid/instances/i1\n", gotReq))
 	}
 	want := &secrets.Instance{Id: "i1", Secret: secrets.Secret{Name: "s1"}, Status: secrets.Status{}}
 	if !cmp.Equal(got, want, cmpInstanceOpts) {
@@ -205,7 +237,7 @@ func TestInstanceClient_Create(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Create: %v", err)
 	}
-	wantReq := "POST /secrets/sid/instances\n" + `{"name":"","env":null,"forced":false,"reason":"test"}` + "\n"
+	wantReq := "POST /v1/secrets/sid/instances\n" + `{"name":"","env":null,"forced":false,"reason":"test"}` + "\n"
 	if gotReq := requestString(stub.lastReq); gotReq != wantReq {
 		t.Errorf("request:\n%s", cmp.Diff(wantReq, gotReq))
 	}
@@ -223,7 +255,7 @@ func TestSecretClient_History(t *testing.T) {
 	if err != nil {
 		t.Fatalf("History: %v", err)
 	}
-	wantReq := "GET /secrets/sid/operations?from=0&to=10\n"
+	wantReq := "GET /v1/secrets/sid/operations?from=0&to=10\n"
 	if gotReq := requestString(stub.lastReq); gotReq != wantReq {
 		t.Errorf("request:\n%s", cmp.Diff(wantReq, gotReq))
 	}
@@ -241,7 +273,7 @@ func TestInstanceClient_GetActive(t *testing.T) {
 	if err != nil {
 		t.Fatalf("GetActive: %v", err)
 	}
-	wantReq := "GET /secrets/sid/active\n"
+	wantReq := "GET /v1/secrets/sid/active\n"
 	if gotReq := requestString(stub.lastReq); gotReq != wantReq {
 		t.Errorf("request:\n%s", cmp.Diff(wantReq, gotReq))
 	}
@@ -260,7 +292,7 @@ func TestInstanceClient_Destroy(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Destroy: %v", err)
 	}
-	wantReq := "POST /secrets/sid/instances/i1/operations\n" + `{"name":"destroy","env":null,"forced":false,"reason":"r"}` + "\n"
+	wantReq := "POST /v1/secrets/sid/instances/i1/operations\n" + `{"name":"destroy","env":null,"forced":false,"reason":"r"}` + "\n"
 	if gotReq := requestString(stub.lastReq); gotReq != wantReq {
 		t.Errorf("request:\n%s", cmp.Diff(wantReq, gotReq))
 	}
@@ -279,7 +311,7 @@ func TestInstanceClient_Activate(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Activate: %v", err)
 	}
-	wantReq := "POST /secrets/sid/instances/i1/operations\n" + `{"name":"activate","env":null,"forced":false,"reason":"activate-reason"}` + "\n"
+	wantReq := "POST /v1/secrets/sid/instances/i1/operations\n" + `{"name":"activate","env":null,"forced":false,"reason":"activate-reason"}` + "\n"
 	if gotReq := requestString(stub.lastReq); gotReq != wantReq {
 		t.Errorf("request:\n%s", cmp.Diff(wantReq, gotReq))
 	}
@@ -289,6 +321,41 @@ func TestInstanceClient_Activate(t *testing.T) {
 	}
 }
 
+func TestInstanceClient_Activate_preconditions(t *testing.T) {
+	ctx := context.Background()
+	stub := &stubClient{resp: stubResponse(200, `{"id":"i1","secret":{"name":"s1"},"status":{}}`)}
+	c := &InstanceClient{client: stub, secretId: "sid"}
+	latest := 9
+	_, err := c.Activate(ctx, "i1", secrets.OperationParameters{Reason: "r", IdempotencyKey: "k1", IfMatch: &latest})
+	if err != nil {
+		t.Fatalf("Activate: %v", err)
+	}
+	if got := stub.lastReq.Header.Get("Idempotency-Key"); got != "k1" {
+		t.Errorf("Idempotency-Key = %q, want k1", got)
+	}
+	if got := stub.lastReq.Header.Get("If-Match"); got != `"9"` {
+		t.Errorf("If-Match = %s, want \"9\"", got)
+	}
+}
+
+func TestSecretClient_LatestOperation(t *testing.T) {
+	ctx := context.Background()
+	resp := stubResponse(200, `null`)
+	resp.Header = http.Header{"Etag": {`"12"`}}
+	stub := &stubClient{resp: resp}
+	c := &SecretClient{client: stub}
+	got, err := c.LatestOperation(ctx, "sid")
+	if err != nil {
+		t.Fatalf("LatestOperation: %v", err)
+	}
+	if got != 12 {
+		t.Errorf("LatestOperation = %d, want 12", got)
+	}
+	if gotReq := requestString(stub.lastReq); gotReq != "GET /v1/secrets/sid/active\n" {
+		t.Errorf("request = %q", gotReq)
+	}
+}
+
 func TestInstanceClient_Deactivate(t *testing.T) {
 	ctx := context.Background()
 	stub := &stubClient{resp: stubResponse(200, `{"id":"i1","secret":{"name":"s1"},"status":{}}`)}
@@ -298,7 +365,7 @@ func TestInstanceClient_Deactivate(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Deactivate: %v", err)
 	}
-	wantReq := "POST /secrets/sid/instances/i1/operations\n" + `{"name":"deactivate","env":null,"forced":false,"reason":"deact"}` + "\n"
+	wantReq := "POST /v1/secrets/sid/instances/i1/operations\n" + `{"name":"deactivate","env":null,"forced":false,"reason":"deact"}` + "\n"
 	if gotReq := requestString(stub.lastReq); gotReq != wantReq {
 		t.Errorf("request:\n%s", cmp.Diff(wantReq, gotReq))
 	}
@@ -317,7 +384,7 @@ func TestInstanceClient_Test(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Test: %v", err)
 	}
-	wantReq := "POST /secrets/sid/instances/i1/operations\n" + `{"name":"test","env":null,"forced":false,"reason":"test-run"}` + "\n"
+	wantReq := "POST /v1/secrets/sid/instances/i1/operations\n" + `{"name":"test","env":null,"forced":false,"reason":"test-run"}` + "\n"
 	if gotReq := requestString(stub.lastReq); gotReq != wantReq {
 		t.Errorf("request:\n%s", cmp.Diff(wantReq, gotReq))
 	}
@@ -335,7 +402,7 @@ func TestInstanceClient_History(t *testing.T) {
 	if err != nil {
 		t.Fatalf("History: %v", err)
 	}
-	wantReq := "GET /secrets/sid/instances/i1/operations?from=5&to=15\n"
+	wantReq := "GET /v1/secrets/sid/instances/i1/operations?from=5&to=15\n"
 	if gotReq := requestString(stub.lastReq); gotReq != wantReq {
 		t.Errorf("request:\n%s", cmp.Diff(wantReq, gotReq))
 	}
@@ -344,3 +411,276 @@ func TestInstanceClient_History(t *testing.T) {
 		t.Errorf("History response:\n%s", cmp.Diff(want, got, cmpInstanceOpts))
 	}
 }
+
+func TestInstanceClient_Destroy_approvalRequired(t *testing.T) {
+	ctx := context.Background()
+	stub := &stubClient{resp: stubResponse(http.StatusAccepted, `{"id":"a1","secretId":"sid","instanceId":"i1","name":"destroy","required":1,"status":"pending","decisions":[]}`)}
+	c := &InstanceClient{client: stub, secretId: "sid"}
+	got, err := c.Destroy(ctx, "i1", secrets.OperationParameters{Reason: "tidy"})
+	if got != nil {
+		t.Errorf("Destroy = %v, want nil instance", got)
+	}
+	var approvalErr *secrets.ApprovalRequiredError
+	if !errors.As(err, &approvalErr) {
+		t.Fatalf("Destroy err = %v, want *secrets.ApprovalRequiredError", err)
+	}
+	if approvalErr.Approval.Id != "a1" || approvalErr.Approval.Status != secrets.Pending {
+		t.Errorf("approval = %+v", approvalErr.Approval)
+	}
+}
+
+func TestApprovalClient_List(t *testing.T) {
+	ctx := context.Background()
+	stub := &stubClient{resp: stubResponse(200, `{"items":[{"id":"a1","name":"destroy","status":"pending","decisions":[]}]}`)}
+	c := (&SecretClient{client: stub}).Approvals()
+	got, err := c.List(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	wantReq := "GET /v1/approvals?from=0&to=10\n"
+	if gotReq := requestString(stub.lastReq); gotReq != wantReq {
+		t.Errorf("request:\n%s", cmp.Diff(wantReq, gotReq))
+	}
+	want := []*secrets.Approval{{Id: "a1", Name: secrets.Destroy, Status: secrets.Pending, Decisions: []*secrets.Decision{}}}
+	if !cmp.Equal(got, want) {
+		t.Errorf("List response:\n%s", cmp.Diff(want, got))
+	}
+}
+
+func TestSecretClient_Whoami(t *testing.T) {
+	ctx := context.Background()
+	stub := &stubClient{resp: stubResponse(200, `{"principal":"linux:bob/1000","roles":"operator","permissions":{"granted":{"secrets":"write"}},"check":{"secretId":"db","operation":"destroy","forced":true,"permitted":false,"denial":"no"}}`)}
+	c := &SecretClient{client: stub}
+	got, err := c.Whoami(ctx, &server.OperationQuery{SecretId: "db", Operation: secrets.Destroy, Forced: true})
+	if err != nil {
+		t.Fatalf("Whoami: %v", err)
+	}
+	wantReq := "GET /v1/whoami?forced=true&operation=destroy&secretId=db\n"
+	if gotReq := requestString(stub.lastReq); gotReq != wantReq {
+		t.Errorf("request:\n%s", cmp.Diff(wantReq, gotReq))
+	}
+	want := &server.Whoami{
+		Principal:   "linux:bob/1000",
+		Roles:       auth.ClaimedRoles{"operator"},
+		Permissions: auth.EffectivePermissions{Granted: auth.Permissions{auth.Secrets: {auth.Write}}},
+		Check: &server.OperationCheck{
+			OperationQuery: server.OperationQuery{SecretId: "db", Operation: secrets.Destroy, Forced: true},
+			Denial:         "no",
+		},
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("Whoami response:\n%s", cmp.Diff(want, got))
+	}
+}
+
+func TestElevationClient(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		call    func(c *ElevationClient) (any, error)
+		body    string
+		wantReq string
+		want    any
+	}{
+		{
+			name:    "list",
+			call:    func(c *ElevationClient) (any, error) { return c.List(ctx, 0, 10) },
+			body:    `{"items":[{"id":"e1","principal":"alice","role":"operator"}]}`,
+			wantReq: "GET /v1/elevations?from=0&to=10\n",
+			want:    []*auth.Elevation{{Id: "e1", Principal: "alice", Role: "operator"}},
+		},
+		{
+			name: "elevate",
+			call: func(c *ElevationClient) (any, error) {
+				return c.Elevate(ctx, server.ElevationParameters{Role: "operator", Reason: "incident", Duration: marshal.Duration(time.Hour)})
+			},
+			body:    `{"id":"e1","principal":"alice","role":"operator","reason":"incident"}`,
+			wantReq: "POST /v1/elevations\n" + `{"role":"operator","reason":"incident","duration":"1h0m0s"}` + "\n",
+			want:    &auth.Elevation{Id: "e1", Principal: "alice", Role: "operator", Reason: "incident"},
+		},
+		{
+			name:    "revoke",
+			call:    func(c *ElevationClient) (any, error) { return c.Revoke(ctx, "e1") },
+			body:    `{"id":"e1","revokedBy":"alice"}`,
+			wantReq: "POST /v1/elevations/e1/revoke\n",
+			want:    &auth.Elevation{Id: "e1", RevokedBy: "alice"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &stubClient{resp: stubResponse(200, tt.body)}
+			got, err := tt.call((&SecretClient{client: stub}).Elevations())
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if gotReq := requestString(stub.lastReq); gotReq != tt.wantReq {
+				t.Errorf("request:\n%s", cmp.Diff(tt.wantReq, gotReq))
+			}
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("response:\n%s", cmp.Diff(tt.want, got))
+			}
+		})
+	}
+}
+
+func TestDenialClient_List(t *testing.T) {
+	ctx := context.Background()
+	stub := &stubClient{resp: stubResponse(200, `{"items":[{"id":3,"principal":"linux:bob/1000","credentials":{"uid":1000,"gid":100,"pid":42},"route":"GET /secrets","permission":{"secrets":"list"},"roles":["reader"],"reason":"denied"}]}`)}
+	c := (&SecretClient{client: stub}).Denials()
+	got, err := c.List(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	wantReq := "GET /v1/audit/denials?from=0&to=10\n"
+	if gotReq := requestString(stub.lastReq); gotReq != wantReq {
+		t.Errorf("request:\n%s", cmp.Diff(wantReq, gotReq))
+	}
+	want := []*audit.Denial{{
+		Id:          3,
+		Principal:   "linux:bob/1000",
+		Credentials: &auth.Credentials{Uid: 1000, Gid: 100, Pid: 42},
+		Route:       "GET /secrets",
+		Permission:  auth.Permissions{auth.Secrets: {auth.List}},
+		Roles:       auth.ClaimedRoles{"reader"},
+		Reason:      "denied",
+	}}
+	if !cmp.Equal(got, want) {
+		t.Errorf("List response:\n%s", cmp.Diff(want, got))
+	}
+}
+
+func TestApprovalClient_Approve_Reject(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		decide  func(c *ApprovalClient) (*secrets.Approval, error)
+		wantReq string
+	}{
+		{
+			name:    "approve",
+			decide:  func(c *ApprovalClient) (*secrets.Approval, error) { return c.Approve(ctx, "a1", "lgtm") },
+			wantReq: "POST /v1/approvals/a1/approve\n" + `{"comment":"lgtm"}` + "\n",
+		},
+		{
+			name:    "reject",
+			decide:  func(c *ApprovalClient) (*secrets.Approval, error) { return c.Reject(ctx, "a1", "no") },
+			wantReq: "POST /v1/approvals/a1/reject\n" + `{"comment":"no"}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &stubClient{resp: stubResponse(200, `{"id":"a1"}`)}
+			got, err := tt.decide((&SecretClient{client: stub}).Approvals())
+			if err != nil {
+				t.Fatalf("decide: %v", err)
+			}
+			if gotReq := requestString(stub.lastReq); gotReq != tt.wantReq {
+				t.Errorf("request:\n%s", cmp.Diff(tt.wantReq, gotReq))
+			}
+			if got.Id != "a1" {
+				t.Errorf("approval = %+v", got)
+			}
+		})
+	}
+}
+
+func TestEventClient_Stream(t *testing.T) {
+	body := ": keep-alive\n\n" +
+		"id: 4\nevent: operation.started\ndata: {\"id\":4,\"type\":\"operation.started\",\"secretId\":\"s1\",\"occurredAt\":\"2026-01-02T03:04:05Z\"}\n\n" +
+		"id: 5\nevent: operation.completed\ndata: {\"id\":5,\"type\":\"operation.completed\",\"secretId\":\"s1\",\"occurredAt\":\"2026-01-02T03:04:06Z\"}\n\n"
+	stub := &stubClient{resp: stubResponse(200, body)}
+	after := int64(3)
+
+	var got []*events.Event
+	for event, err := range (&SecretClient{client: stub}).Events().Stream(context.Background(), EventQuery{SecretId: "s1", After: &after}) {
+		if err != nil {
+			t.Fatalf("Stream: %v", err)
+		}
+		got = append(got, event)
+	}
+
+	if gotReq := requestString(stub.lastReq); gotReq != "GET /v1/events?secretId=s1\n" {
+		t.Errorf("request = %q", gotReq)
+	}
+	if lastEventId := stub.lastReq.Header.Get("Last-Event-ID"); lastEventId != "3" {
+		t.Errorf("Last-Event-ID = %q, want 3", lastEventId)
+	}
+	want := []*events.Event{
+		{Id: 4, Type: events.OperationStarted, SecretId: "s1", OccurredAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{Id: 5, Type: events.OperationCompleted, SecretId: "s1", OccurredAt: time.Date(2026, 1, 2, 3, 4, 6, 0, time.UTC)},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("events mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestEventClient_Stream_opened(t *testing.T) {
+	body := "id: 4\nevent: operation.started\ndata: {\"id\":4,\"type\":\"operation.started\"}\n\n"
+	stub := &stubClient{resp: stubResponse(200, body)}
+
+	var order []string
+	opened := func() error {
+		order = append(order, "opened")
+		return nil
+	}
+	for event, err := range (&SecretClient{client: stub}).Events().Stream(context.Background(), EventQuery{Opened: opened}) {
+		if err != nil {
+			t.Fatalf("Stream: %v", err)
+		}
+		order = append(order, string(event.Type))
+	}
+	if diff := cmp.Diff([]string{"opened", "operation.started"}, order); diff != "" {
+		t.Errorf("order mismatch (-want +got):\n%s", diff)
+	}
+
+	failed := errors.New("failed to read state")
+	yielded := 0
+	for event, err := range (&SecretClient{client: &stubClient{resp: stubResponse(200, body)}}).Events().Stream(context.Background(), EventQuery{Opened: func() error { return failed }}) {
+		if event != nil || !errors.Is(err, failed) {
+			t.Errorf("Stream = %+v, %v, want %v", event, err, failed)
+		}
+		yielded++
+	}
+	if yielded != 1 {
+		t.Errorf("yielded %d times, want once with the error", yielded)
+	}
+}
+
+func TestEventClient_Stream_error(t *testing.T) {
+	stub := &stubClient{resp: stubResponse(403, `{"error":{"status":403,"message":"forbidden"}}`)}
+	yielded := 0
+	for event, err := range (&SecretClient{client: stub}).Events().Stream(context.Background(), EventQuery{}) {
+		if event != nil {
+			t.Errorf("event = %+v, want none", event)
+		}
+		var respErr *server.ErrorResponse
+		if !errors.As(err, &respErr) || respErr.HttpError.Code != 403 {
+			t.Errorf("err = %v, want 403 error response", err)
+		}
+		yielded++
+	}
+	if yielded != 1 {
+		t.Errorf("yielded %d times, want once with the error", yielded)
+	}
+	if lastEventId := stub.lastReq.Header.Get("Last-Event-ID"); lastEventId != "" {
+		t.Errorf("Last-Event-ID = %q, want none to start from the latest event", lastEventId)
+	}
+}
+
+func TestBulkClient_Run(t *testing.T) {
+	ctx := context.Background()
+	stub := &stubClient{resp: stubResponse(200, `{"batchId":"b1","name":"test","results":[{"secretId":"db-a"}],"succeeded":1,"failed":0,"skipped":0}`)}
+	c := (&SecretClient{client: stub}).Bulk()
+	got, err := c.Run(ctx, bulk.Request{Name: secrets.Test, Select: bulk.Selector{Names: []string{"db-*"}}})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	wantReq := "POST /v1/bulk/operations\n" + `{"name":"test","select":{"names":["db-*"]},"env":null,"forced":false,"reason":""}` + "\n"
+	if gotReq := requestString(stub.lastReq); gotReq != wantReq {
+		t.Errorf("request:\n%s", cmp.Diff(wantReq, gotReq))
+	}
+	want := &bulk.Report{BatchId: "b1", Name: secrets.Test, Results: []*bulk.Result{{SecretId: "db-a"}}, Succeeded: 1}
+	if !cmp.Equal(got, want) {
+		t.Errorf("Run response:\n%s", cmp.Diff(want, got))
+	}
+}
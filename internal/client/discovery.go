@@ -0,0 +1,49 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNoServer is returned when no socket is given and no running server is found at any of the default sockets
+var ErrNoServer = errors.New("no running secret-agent server was found")
+
+// The sockets at which a running server is looked for, in order, if none is given
+func defaultSockets() []string {
+	var sockets []string
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		sockets = append(sockets, filepath.Join(runtimeDir, "secret-agent.sock"))
+	}
+	return append(sockets, "/run/secret-agent/socket")
+}
+
+// FindSocket gives the socket through which to connect to a running server. This is the given socket if there is
+// one, as taken from a flag or the environment, or else the first of the default sockets which accepts a connection
+// within the timeout.
+func FindSocket(ctx context.Context, given string, timeout time.Duration) (string, error) {
+	if given != "" {
+		return given, nil
+	}
+	sockets := defaultSockets()
+	for _, socket := range sockets {
+		if reachable(ctx, socket, timeout) {
+			return socket, nil
+		}
+	}
+	return "", fmt.Errorf("%w at %s", ErrNoServer, strings.Join(sockets, " or "))
+}
+
+func reachable(ctx context.Context, socket string, timeout time.Duration) bool {
+	connection, err := (&net.Dialer{Timeout: timeout}).DialContext(ctx, "unix", socket)
+	if err != nil {
+		return false
+	}
+	connection.Close()
+	return true
+}
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+)
+
+func TestFindSocket(t *testing.T) {
+	ctx := context.Background()
+	runtimeDir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+
+	t.Run("given", func(t *testing.T) {
+		socket, err := FindSocket(ctx, "/given.sock", time.Second)
+		if err != nil || socket != "/given.sock" {
+			t.Errorf("FindSocket = %q, %v, want the given socket", socket, err)
+		}
+	})
+
+	t.Run("none reachable", func(t *testing.T) {
+		_, err := FindSocket(ctx, "", time.Second)
+		if !errors.Is(err, ErrNoServer) {
+			t.Errorf("err = %v, want %v", err, ErrNoServer)
+		}
+	})
+
+	t.Run("runtime directory", func(t *testing.T) {
+		path := filepath.Join(runtimeDir, "secret-agent.sock")
+		listener, err := net.Listen("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+
+		socket, err := FindSocket(ctx, "", time.Second)
+		if err != nil || socket != path {
+			t.Errorf("FindSocket = %q, %v, want %q", socket, err, path)
+		}
+	})
+}
+
+func TestNewSecretStore_readTimeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "slow.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responded := make(chan struct{})
+	defer close(responded)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-responded
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	c := NewSecretStore(Config{Socket: path, ConnectTimeout: time.Second, ReadTimeout: 20 * time.Millisecond})
+	started := time.Now()
+	_, err = c.List(context.Background())
+	if err == nil {
+		t.Fatal("List succeeded, want a timeout")
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("List took %s, want it to time out", elapsed)
+	}
+}
+
+func TestNewSecretStore_operationsNotTimedOut(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "slow.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte(`{"id":"i1"}`))
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	c := NewSecretStore(Config{Socket: path, ConnectTimeout: time.Second, ReadTimeout: 20 * time.Millisecond})
+	instance, err := c.Instances("s1").Create(context.Background(), secrets.OperationParameters{})
+	if err != nil || instance.Id != "i1" {
+		t.Errorf("Create = %+v, %v, want instance i1 once the operation finishes", instance, err)
+	}
+}
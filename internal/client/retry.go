@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The delay before the first retry of a request, which doubles with each further retry up to the longest delay
+const (
+	retryBackoff    = 100 * time.Millisecond
+	maxRetryBackoff = 2 * time.Second
+)
+
+// retryingClient retries idempotent requests which fail transiently, such as while the server restarts, after a
+// delay with jitter so that waiting clients do not return all at once
+type retryingClient struct {
+	client  httpClient
+	retries int
+	backoff time.Duration
+}
+
+func (c *retryingClient) Do(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return c.client.Do(req)
+	}
+	for attempt := 0; ; attempt++ {
+		response, err := c.client.Do(req)
+		delay, retry := c.retryAfter(attempt, response, err)
+		if !retry || req.Context().Err() != nil {
+			return response, err
+		}
+		if response != nil {
+			io.Copy(io.Discard, response.Body)
+			response.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// retryAfter gives the delay before a request is retried after the given attempt, and whether it is retried at all
+func (c *retryingClient) retryAfter(attempt int, response *http.Response, err error) (time.Duration, bool) {
+	if attempt >= c.retries {
+		return 0, false
+	}
+	delay := rand.N(min(c.backoff<<attempt, maxRetryBackoff)) + 1
+	if err != nil {
+		return delay, !errors.Is(err, context.Canceled)
+	}
+	switch response.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return delay, true
+	case http.StatusTooManyRequests:
+		// the caller is only kept waiting for a limit which is soon lifted
+		seconds, err := strconv.Atoi(response.Header.Get("Retry-After"))
+		if err != nil || time.Duration(seconds)*time.Second > maxRetryBackoff {
+			return 0, false
+		}
+		return max(delay, time.Duration(seconds)*time.Second), true
+	}
+	return 0, false
+}
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// sequenceClient responds to each request with the next of a sequence of responses or errors
+type sequenceClient struct {
+	responses []*http.Response
+	errs      []error
+	calls     int
+}
+
+func (s *sequenceClient) Do(req *http.Request) (*http.Response, error) {
+	i := s.calls
+	s.calls++
+	return s.responses[i], s.errs[i]
+}
+
+func TestRetryingClient(t *testing.T) {
+	refused := errors.New("connection refused")
+	limited := stubResponse(http.StatusTooManyRequests, "")
+	limited.Header = http.Header{"Retry-After": {"1"}}
+	limitedLong := stubResponse(http.StatusTooManyRequests, "")
+	limitedLong.Header = http.Header{"Retry-After": {"60"}}
+
+	tests := []struct {
+		name      string
+		method    string
+		responses []*http.Response
+		errs      []error
+		wantCalls int
+		wantCode  int
+	}{
+		{
+			name:      "retries connection errors",
+			method:    http.MethodGet,
+			responses: []*http.Response{nil, nil, stubResponse(200, "{}")},
+			errs:      []error{refused, refused, nil},
+			wantCalls: 3,
+			wantCode:  200,
+		},
+		{
+			name:      "retries unavailable",
+			method:    http.MethodGet,
+			responses: []*http.Response{stubResponse(503, ""), stubResponse(200, "{}")},
+			errs:      []error{nil, nil},
+			wantCalls: 2,
+			wantCode:  200,
+		},
+		{
+			name:      "gives up after retries",
+			method:    http.MethodGet,
+			responses: []*http.Response{stubResponse(503, ""), stubResponse(503, ""), stubResponse(503, ""), stubResponse(503, "")},
+			errs:      []error{nil, nil, nil, nil},
+			wantCalls: 4,
+			wantCode:  503,
+		},
+		{
+			name:      "does not retry client errors",
+			method:    http.MethodGet,
+			responses: []*http.Response{stubResponse(404, "")},
+			errs:      []error{nil},
+			wantCalls: 1,
+			wantCode:  404,
+		},
+		{
+			name:      "does not retry operations",
+			method:    http.MethodPost,
+			responses: []*http.Response{stubResponse(503, "")},
+			errs:      []error{nil},
+			wantCalls: 1,
+			wantCode:  503,
+		},
+		{
+			name:      "waits for a short rate limit",
+			method:    http.MethodGet,
+			responses: []*http.Response{limited, stubResponse(200, "{}")},
+			errs:      []error{nil, nil},
+			wantCalls: 2,
+			wantCode:  200,
+		},
+		{
+			name:      "does not wait for a long rate limit",
+			method:    http.MethodGet,
+			responses: []*http.Response{limitedLong},
+			errs:      []error{nil},
+			wantCalls: 1,
+			wantCode:  429,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &sequenceClient{responses: tt.responses, errs: tt.errs}
+			c := &retryingClient{client: stub, retries: 3, backoff: time.Millisecond}
+			req, err := BuildRequest(context.Background(), tt.method, "/secrets", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			response, err := c.Do(req)
+			if err != nil {
+				t.Fatalf("Do: %v", err)
+			}
+			if response.StatusCode != tt.wantCode {
+				t.Errorf("status = %d, want %d", response.StatusCode, tt.wantCode)
+			}
+			if stub.calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", stub.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryingClient_cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stub := &sequenceClient{responses: []*http.Response{stubResponse(503, "")}, errs: []error{nil}}
+	c := &retryingClient{client: stub, retries: 3, backoff: time.Hour}
+	req, err := BuildRequest(ctx, http.MethodGet, "/secrets", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.AfterFunc(10*time.Millisecond, cancel)
+	_, err = c.Do(req)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want cancelled while waiting to retry", err)
+	}
+	if stub.calls != 1 {
+		t.Errorf("calls = %d, want 1", stub.calls)
+	}
+}
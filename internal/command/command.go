@@ -5,16 +5,33 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 
 	"github.com/eliasvasylenko/secret-agent/internal/marshal"
+	"github.com/eliasvasylenko/secret-agent/internal/metrics"
 )
 
 // The function to execute a command
 var execCommand = exec.CommandContext
 
+var commandDuration = metrics.Default.NewHistogramVec(
+	"secret_agent_command_duration_seconds",
+	"Duration of commands run for operations, by command name and outcome.",
+	metrics.DurationBuckets,
+	"command", "outcome",
+)
+
+type nameKey struct{}
+
+// WithName names the commands which are processed with the context, to identify them in metrics
+func WithName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, nameKey{}, name)
+}
+
 // A command to execute.
 // A command consists of the name of the program to run, the arguments to pass to the program, and the environment to supply to the program.
 type Command struct {
@@ -75,7 +92,15 @@ func (c *Command) Process(ctx context.Context, input string, environment Environ
 	subProcess.Stdin = strings.NewReader(input)
 	subProcess.Stderr = os.Stderr
 
+	name, _ := ctx.Value(nameKey{}).(string)
+	started := time.Now()
 	output, err := subProcess.Output()
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+	}
+	commandDuration.Observe(time.Since(started).Seconds(), name, outcome)
+	slog.DebugContext(ctx, "command finished", "command", name, "outcome", outcome, "duration", time.Since(started))
 	if err != nil {
 		return "", fmt.Errorf("process failed '%v' - %s", c, err.Error())
 	}
@@ -4,9 +4,16 @@ import (
 	"fmt"
 	"maps"
 	"os"
+	"regexp"
+	"slices"
 	"strings"
+
+	"github.com/eliasvasylenko/secret-agent/internal/validation"
 )
 
+// The names which may be given to environment variables
+var variableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
 // The environment for a command
 type Environment map[string]string
 
@@ -22,6 +29,16 @@ func (e Environment) Load(vars []string) Environment {
 	return e
 }
 
+// Validate reports any variables with names which may not be given to a command, as fields of the environment at
+// the given JSON pointer
+func (e Environment) Validate(v *validation.Validator, pointer string) {
+	for _, key := range slices.Sorted(maps.Keys(e)) {
+		if !variableName.MatchString(key) {
+			v.Field(pointer+validation.Pointer(key), "invalid environment variable name %q", key)
+		}
+	}
+}
+
 func (e Environment) Render() []string {
 	var vars []string
 	for key, value := range e {
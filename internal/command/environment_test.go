@@ -1,6 +1,10 @@
 package command
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/eliasvasylenko/secret-agent/internal/validation"
+)
 
 func TestExpand(t *testing.T) {
 	e := Environment{
@@ -52,3 +56,19 @@ func TestExpandMutuallyRecursive(t *testing.T) {
 		t.Errorf("expected '%v', got '%v'", expected, expanded)
 	}
 }
+
+func TestValidate(t *testing.T) {
+	e := Environment{
+		"GOOD_1": "x",
+		"_ok":    "x",
+		"1BAD":   "x",
+		"a/b":    "x",
+	}
+	var v validation.Validator
+	e.Validate(&v, "/env")
+	expected := "/env/1BAD: invalid environment variable name \"1BAD\"; /env/a~1b: invalid environment variable name \"a/b\""
+
+	if err := v.Err(); err == nil || err.Error() != expected {
+		t.Errorf("expected '%v', got '%v'", expected, err)
+	}
+}
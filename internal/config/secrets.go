@@ -1,15 +1,19 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"io"
 	"os"
 
+	"github.com/eliasvasylenko/secret-agent/internal/notify"
 	"github.com/eliasvasylenko/secret-agent/internal/secrets"
 )
 
 type Secrets struct {
-	Secrets secrets.Secrets `json:"secrets"`
+	Secrets       secrets.Secrets    `json:"secrets"`
+	Notifications notify.Subscribers `json:"notifications,omitempty"`
 }
 
 func LoadSecretsConfig(secretsFileName string) (*Secrets, error) {
@@ -28,3 +32,18 @@ func LoadSecretsConfig(secretsFileName string) (*Secrets, error) {
 
 	return &secrets, err
 }
+
+// HashFile gives the SHA-256 digest of a configuration file in hex, to identify the version which was loaded
+func HashFile(fileName string) (string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,60 @@
+package events
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// The type of an event
+type Type string
+
+const (
+	// An operation on a secret instance was started
+	OperationStarted Type = "operation.started"
+	// An operation on a secret instance completed successfully
+	OperationCompleted Type = "operation.completed"
+	// An operation on a secret instance failed, or was interrupted
+	OperationFailed Type = "operation.failed"
+	// The active instance of a secret was set or unset
+	ActiveInstanceChanged Type = "secret.activeInstanceChanged"
+	// Approval was requested for an operation
+	ApprovalRequested Type = "approval.requested"
+	// A decision was made upon an approval request
+	ApprovalDecided Type = "approval.decided"
+	// An attempt to call the API or perform an operation was denied
+	AccessDenied Type = "access.denied"
+	// A principal was elevated to a role
+	ElevationGranted Type = "elevation.granted"
+	// An elevation was revoked
+	ElevationRevoked Type = "elevation.revoked"
+)
+
+// An event in the lifecycle of secrets, or of the policies governing them
+type Event struct {
+	// The position of the event in the order in which events were recorded
+	Id         int64     `json:"id"`
+	Type       Type      `json:"type"`
+	SecretId   string    `json:"secretId,omitempty"`
+	InstanceId string    `json:"instanceId,omitempty"`
+	Principal  string    `json:"principal,omitempty"`
+	OccurredAt time.Time `json:"occurredAt"`
+	// The subject of the event, e.g. the operation, approval request or elevation
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// The progress of delivery of events to a subscriber
+type Subscription struct {
+	Subscriber string `json:"subscriber"`
+	// The ID of the last event which was delivered, or skipped
+	DeliveredEventId int64 `json:"deliveredEventId"`
+	// Failed attempts to deliver the event following the last delivered
+	Attempts      int        `json:"attempts,omitzero"`
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty"`
+	LastError     string     `json:"lastError,omitempty"`
+}
+
+// The data of an ActiveInstanceChanged event
+type ActiveInstance struct {
+	// The ID of the instance which is now active, or nil if no instance is active
+	ActiveInstanceId *string `json:"activeInstanceId"`
+}
@@ -0,0 +1,74 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/google/uuid"
+)
+
+// The formats in which logs may be written
+const (
+	Text = "text"
+	JSON = "json"
+)
+
+type requestIdKey struct{}
+
+// WithRequestId identifies the request on whose behalf work is done with the context, so that it is
+// attached to every line logged with the context
+func WithRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, requestId)
+}
+
+// RequestId gives the ID of the request on whose behalf work is done with the context, or "" if there is none
+func RequestId(ctx context.Context) string {
+	requestId, _ := ctx.Value(requestIdKey{}).(string)
+	return requestId
+}
+
+// NewRequestId generates an ID for a request which was not given one by the caller
+func NewRequestId() string {
+	return uuid.NewString()
+}
+
+// NewLogger creates a logger which writes in the given format, at debug level if debug is set,
+// and which attaches the request ID of the context to each line logged with one
+func NewLogger(w io.Writer, format string, debug bool) (*slog.Logger, error) {
+	options := &slog.HandlerOptions{Level: slog.LevelInfo}
+	if debug {
+		options.Level = slog.LevelDebug
+	}
+	var handler slog.Handler
+	switch format {
+	case Text, "":
+		handler = slog.NewTextHandler(w, options)
+	case JSON:
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+	return slog.New(contextHandler{handler}), nil
+}
+
+// A handler which attaches values from the context of a record to it
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if requestId := RequestId(ctx); requestId != "" {
+		record.AddAttrs(slog.String("requestId", requestId))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,53 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNewLogger_json(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := NewLogger(&out, JSON, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := WithRequestId(context.Background(), "req-1")
+	logger.With("component", "test").InfoContext(ctx, "hello", "n", 1)
+	logger.DebugContext(ctx, "hidden")
+
+	var line map[string]any
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("%v: %s", err, out.Bytes())
+	}
+	delete(line, "time")
+	want := map[string]any{"level": "INFO", "msg": "hello", "component": "test", "n": 1.0, "requestId": "req-1"}
+	if diff := cmp.Diff(want, line); diff != "" {
+		t.Errorf("line mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestNewLogger_textDebug(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := NewLogger(&out, Text, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger.Debug("hello")
+
+	if got := out.String(); !strings.Contains(got, "level=DEBUG msg=hello") || strings.Contains(got, "requestId") {
+		t.Errorf("line = %q", got)
+	}
+}
+
+func TestNewLogger_unknownFormat(t *testing.T) {
+	if _, err := NewLogger(&bytes.Buffer{}, "xml", false); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,23 @@
+package marshal
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// A duration which is marshalled to JSON as a duration string, e.g. "1h30m"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(p []byte) error {
+	var s string
+	if err := json.Unmarshal(p, &s); err != nil || s == "" {
+		return err
+	}
+	duration, err := time.ParseDuration(s)
+	*d = Duration(duration)
+	return err
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return JSON(time.Duration(d).String())
+}
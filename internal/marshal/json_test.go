@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestJSON(t *testing.T) {
@@ -32,3 +33,97 @@ func TestJSONIndent(t *testing.T) {
 		t.Errorf("expected indented output")
 	}
 }
+
+func TestDuration(t *testing.T) {
+	var d Duration
+	if err := json.Unmarshal([]byte(`"1h30m"`), &d); err != nil {
+		t.Fatal(err)
+	}
+	if d != Duration(90*time.Minute) {
+		t.Errorf("unmarshal: got %v", time.Duration(d))
+	}
+	b, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `"1h30m0s"` {
+		t.Errorf("marshal: got %s", b)
+	}
+	if err := json.Unmarshal([]byte(`"soon"`), &d); err == nil {
+		t.Error("expected error for invalid duration")
+	}
+}
+
+func TestRegexp(t *testing.T) {
+	var r Regexp
+	if err := json.Unmarshal([]byte(`"^OPS-[0-9]+"`), &r); err != nil {
+		t.Fatal(err)
+	}
+	if !r.MatchString("OPS-1234 rotate") || r.MatchString("rotate") {
+		t.Errorf("unexpected matching for %v", r)
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `"^OPS-[0-9]+"` {
+		t.Errorf("marshal: got %s", b)
+	}
+	if err := json.Unmarshal([]byte(`"("`), &r); err == nil {
+		t.Error("expected error for invalid regexp")
+	}
+}
+
+func TestYAML(t *testing.T) {
+	type item struct {
+		Name   string            `json:"name"`
+		Labels map[string]string `json:"labels"`
+		Tags   []string          `json:"tags"`
+		Count  int               `json:"count"`
+		Extra  *item             `json:"extra"`
+	}
+	b, err := YAML([]item{
+		{Name: "db", Labels: map[string]string{"env": "prod"}, Tags: []string{"a b", "yes", "10", "x: y", ""}, Count: 2},
+		{Name: "cache", Labels: map[string]string{}, Tags: []string{}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `- name: db
+  labels:
+    env: prod
+  tags:
+    - a b
+    - "yes"
+    - "10"
+    - "x: y"
+    - ""
+  count: 2
+  extra: null
+- name: cache
+  labels: {}
+  tags: []
+  count: 0
+  extra: null
+`
+	if string(b) != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, b)
+	}
+}
+
+func TestYAML_scalar(t *testing.T) {
+	for value, want := range map[any]string{
+		"plain":       "plain\n",
+		"line\nbreak": `"line\nbreak"` + "\n",
+		true:          "true\n",
+		1.5:           "1.5\n",
+	} {
+		b, err := YAML(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Errorf("expected %q for %v, got %q", want, value, b)
+		}
+	}
+}
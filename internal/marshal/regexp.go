@@ -0,0 +1,28 @@
+package marshal
+
+import (
+	"encoding/json"
+	"regexp"
+)
+
+// A regular expression which is marshalled to JSON as its source string
+type Regexp struct {
+	*regexp.Regexp
+}
+
+func (r *Regexp) UnmarshalJSON(p []byte) error {
+	var s string
+	if err := json.Unmarshal(p, &s); err != nil {
+		return err
+	}
+	compiled, err := regexp.Compile(s)
+	r.Regexp = compiled
+	return err
+}
+
+func (r Regexp) MarshalJSON() ([]byte, error) {
+	if r.Regexp == nil {
+		return JSON("")
+	}
+	return JSON(r.String())
+}
@@ -0,0 +1,155 @@
+package marshal
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Strings which may be written as plain YAML scalars, unless they would be read as some other type
+var (
+	plainScalar     = regexp.MustCompile(`^[A-Za-z0-9_./()][A-Za-z0-9_./:@+=()-]*(?: [A-Za-z0-9_./:@+=()-]+)*$`)
+	ambiguousScalar = regexp.MustCompile(`^(?i:y|n|yes|no|true|false|on|off|null|[-+]?\.inf|\.nan|[-+.0-9][-+.0-9_:a-fxo]*)$`)
+)
+
+// YAML marshals a value to YAML by way of its JSON encoding, so that it has the same fields in the same order as
+// when it is marshalled to JSON
+func YAML(value any) ([]byte, error) {
+	p, err := JSON(value)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(p))
+	decoder.UseNumber()
+	node, err := decodeYAMLNode(decoder)
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := &bytes.Buffer{}
+	switch node := node.(type) {
+	case yamlMapping:
+		if len(node) == 0 {
+			buffer.WriteString("{}\n")
+		}
+		writeYAMLMapping(buffer, node, 0, false)
+	case []any:
+		if len(node) == 0 {
+			buffer.WriteString("[]\n")
+		}
+		writeYAMLSequence(buffer, node, 0)
+	default:
+		buffer.WriteString(yamlScalar(node) + "\n")
+	}
+	return buffer.Bytes(), nil
+}
+
+// The members of a JSON object, in the order in which they were encoded
+type yamlMapping []yamlMember
+
+type yamlMember struct {
+	key   string
+	value any
+}
+
+func decodeYAMLNode(decoder *json.Decoder) (any, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		mapping := yamlMapping{}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeYAMLNode(decoder)
+			if err != nil {
+				return nil, err
+			}
+			mapping = append(mapping, yamlMember{key.(string), value})
+		}
+		_, err = decoder.Token()
+		return mapping, err
+	case json.Delim('['):
+		sequence := []any{}
+		for decoder.More() {
+			value, err := decodeYAMLNode(decoder)
+			if err != nil {
+				return nil, err
+			}
+			sequence = append(sequence, value)
+		}
+		_, err = decoder.Token()
+		return sequence, err
+	default:
+		return token, nil
+	}
+}
+
+// writeYAMLMapping writes the members of a mapping at the given depth, with the first on the current line if inline
+func writeYAMLMapping(buffer *bytes.Buffer, mapping yamlMapping, depth int, inline bool) {
+	for i, member := range mapping {
+		if i > 0 || !inline {
+			buffer.WriteString(strings.Repeat("  ", depth))
+		}
+		buffer.WriteString(yamlScalar(member.key) + ":")
+		writeYAMLValue(buffer, member.value, depth+1)
+	}
+}
+
+func writeYAMLSequence(buffer *bytes.Buffer, sequence []any, depth int) {
+	for _, item := range sequence {
+		buffer.WriteString(strings.Repeat("  ", depth) + "-")
+		if mapping, ok := item.(yamlMapping); ok && len(mapping) > 0 {
+			buffer.WriteString(" ")
+			writeYAMLMapping(buffer, mapping, depth+1, true)
+		} else {
+			writeYAMLValue(buffer, item, depth+1)
+		}
+	}
+}
+
+// writeYAMLValue writes a value following the key or dash which introduces it on the current line
+func writeYAMLValue(buffer *bytes.Buffer, value any, depth int) {
+	switch value := value.(type) {
+	case yamlMapping:
+		if len(value) == 0 {
+			buffer.WriteString(" {}\n")
+			return
+		}
+		buffer.WriteString("\n")
+		writeYAMLMapping(buffer, value, depth, false)
+	case []any:
+		if len(value) == 0 {
+			buffer.WriteString(" []\n")
+			return
+		}
+		buffer.WriteString("\n")
+		writeYAMLSequence(buffer, value, depth)
+	default:
+		buffer.WriteString(" " + yamlScalar(value) + "\n")
+	}
+}
+
+func yamlScalar(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(value)
+	case json.Number:
+		return value.String()
+	case string:
+		if plainScalar.MatchString(value) && !ambiguousScalar.MatchString(value) && !strings.Contains(value, ": ") && !strings.HasSuffix(value, ":") {
+			return value
+		}
+		return strconv.Quote(value)
+	default:
+		panic("unexpected JSON token")
+	}
+}
@@ -0,0 +1,289 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The registry to which the metrics of the agent are added
+var Default = NewRegistry()
+
+// Buckets for durations in seconds, from quick local commands to slow remote ones
+var DurationBuckets = []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300}
+
+// A Registry holds metrics and writes them in the Prometheus text exposition format
+type Registry struct {
+	metrics []metric
+	mu      sync.Mutex
+}
+
+type metric interface {
+	write(w *bufio.Writer) error
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteText writes every metric in the registry in the Prometheus text exposition format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	metrics := slices.Clone(r.metrics)
+	r.mu.Unlock()
+
+	buffered := bufio.NewWriter(w)
+	var errs []error
+	for _, m := range metrics {
+		errs = append(errs, m.write(buffered))
+	}
+	return errors.Join(append(errs, buffered.Flush())...)
+}
+
+// Handler serves the metrics in the registry for scraping
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var body bytes.Buffer
+		if err := r.WriteText(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(body.Bytes())
+	})
+}
+
+// The labelled series of a metric
+type family[V any] struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	series map[string]*series[V]
+	mu     sync.Mutex
+}
+
+type series[V any] struct {
+	labelValues []string
+	value       V
+}
+
+func newFamily[V any](name string, help string, kind string, labels []string) *family[V] {
+	return &family[V]{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: make(map[string]*series[V]),
+	}
+}
+
+// with calls the function with the value of the series with the given label values, creating it if necessary
+func (f *family[V]) with(labelValues []string, update func(value *V)) {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Errorf("metric %s has labels %v, given values %v", f.name, f.labels, labelValues))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series[V]{labelValues: slices.Clone(labelValues)}
+		f.series[key] = s
+	}
+	update(&s.value)
+}
+
+// each calls the function with every series of the family in order of their label values, under the lock of the family
+func (f *family[V]) each(write func(labelValues []string, value *V) error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		if err := write(s.labelValues, &s.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *family[V]) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+}
+
+// A counter of events, partitioned by labels
+type CounterVec struct {
+	family *family[float64]
+}
+
+func (r *Registry) NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newFamily[float64](name, help, "counter", labels)}
+	r.register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	c.family.with(labelValues, func(value *float64) { *value += delta })
+}
+
+func (c *CounterVec) write(w *bufio.Writer) error {
+	c.family.writeHeader(w)
+	return c.family.each(func(labelValues []string, value *float64) error {
+		writeSample(w, c.family.name, c.family.labels, labelValues, *value)
+		return nil
+	})
+}
+
+// A value which may go up and down, partitioned by labels
+type GaugeVec struct {
+	family *family[float64]
+}
+
+func (r *Registry) NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{newFamily[float64](name, help, "gauge", labels)}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.family.with(labelValues, func(v *float64) { *v = value })
+}
+
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.family.with(labelValues, func(v *float64) { *v += delta })
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) error {
+	g.family.writeHeader(w)
+	return g.family.each(func(labelValues []string, value *float64) error {
+		writeSample(w, g.family.name, g.family.labels, labelValues, *value)
+		return nil
+	})
+}
+
+// A sample of a gauge collected when metrics are written
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+// A gauge whose samples are collected by a function each time metrics are written
+type GaugeFunc struct {
+	family  *family[float64]
+	collect func() ([]Sample, error)
+}
+
+func (r *Registry) NewGaugeFunc(name string, help string, labels []string, collect func() ([]Sample, error)) *GaugeFunc {
+	g := &GaugeFunc{newFamily[float64](name, help, "gauge", labels), collect}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) error {
+	samples, err := g.collect()
+	if err != nil {
+		return fmt.Errorf("failed to collect %s: %w", g.family.name, err)
+	}
+	g.family.writeHeader(w)
+	for _, sample := range samples {
+		writeSample(w, g.family.name, g.family.labels, sample.LabelValues, sample.Value)
+	}
+	return nil
+}
+
+// A histogram of observations, partitioned by labels
+type HistogramVec struct {
+	family  *family[histogram]
+	buckets []float64
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (r *Registry) NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{newFamily[histogram](name, help, "histogram", labels), buckets}
+	r.register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.family.with(labelValues, func(v *histogram) {
+		if v.counts == nil {
+			v.counts = make([]uint64, len(h.buckets))
+		}
+		for i, bound := range h.buckets {
+			if value <= bound {
+				v.counts[i]++
+			}
+		}
+		v.count++
+		v.sum += value
+	})
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) error {
+	h.family.writeHeader(w)
+	labels := append(slices.Clone(h.family.labels), "le")
+	return h.family.each(func(labelValues []string, value *histogram) error {
+		for i, bound := range h.buckets {
+			writeSample(w, h.family.name+"_bucket", labels, append(slices.Clone(labelValues), formatFloat(bound)), float64(value.counts[i]))
+		}
+		writeSample(w, h.family.name+"_bucket", labels, append(slices.Clone(labelValues), "+Inf"), float64(value.count))
+		writeSample(w, h.family.name+"_sum", h.family.labels, labelValues, value.sum)
+		writeSample(w, h.family.name+"_count", h.family.labels, labelValues, float64(value.count))
+		return nil
+	})
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeSample(w *bufio.Writer, name string, labels []string, labelValues []string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, label, labelValueEscaper.Replace(labelValues[i]))
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
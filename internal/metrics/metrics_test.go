@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRegistry_WriteText(t *testing.T) {
+	r := NewRegistry()
+	operations := r.NewCounterVec("operations_total", "Operations performed.", "secret", "outcome")
+	inFlight := r.NewGaugeVec("in_flight", "Operations in flight.")
+	durations := r.NewHistogramVec("duration_seconds", "Durations.", []float64{1, 5}, "secret")
+	r.NewGaugeFunc("age_seconds", "Ages.", []string{"secret"}, func() ([]Sample, error) {
+		return []Sample{{LabelValues: []string{"b"}, Value: 90}}, nil
+	})
+
+	operations.Inc("b", "failed")
+	operations.Inc("a", "completed")
+	operations.Add(2, "a", "completed")
+	inFlight.Add(1)
+	inFlight.Add(-1)
+	durations.Observe(0.5, "a")
+	durations.Observe(3, "a")
+	durations.Observe(10, "a")
+
+	var out strings.Builder
+	if err := r.WriteText(&out); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	want := `# HELP operations_total Operations performed.
+# TYPE operations_total counter
+operations_total{secret="a",outcome="completed"} 3
+operations_total{secret="b",outcome="failed"} 1
+# HELP in_flight Operations in flight.
+# TYPE in_flight gauge
+in_flight 0
+# HELP duration_seconds Durations.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{secret="a",le="1"} 1
+duration_seconds_bucket{secret="a",le="5"} 2
+duration_seconds_bucket{secret="a",le="+Inf"} 3
+duration_seconds_sum{secret="a"} 13.5
+duration_seconds_count{secret="a"} 3
+# HELP age_seconds Ages.
+# TYPE age_seconds gauge
+age_seconds{secret="b"} 90
+`
+	if diff := cmp.Diff(want, out.String()); diff != "" {
+		t.Errorf("WriteText mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRegistry_WriteText_escapesLabelValues(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("total", "Total.", "route").Inc("GET /\"a\"\n")
+
+	var out strings.Builder
+	r.WriteText(&out)
+	if want := `total{route="GET /\"a\"\n"} 1`; !strings.Contains(out.String(), want) {
+		t.Errorf("WriteText = %q, want to contain %q", out.String(), want)
+	}
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("total", "Total.").Inc()
+	r.NewGaugeFunc("broken", "Broken.", nil, func() ([]Sample, error) {
+		return nil, errors.New("unavailable")
+	})
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d when a gauge cannot be collected", rec.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestCounterVec_wrongLabels(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("total", "Total.", "secret")
+	defer func() {
+		if recover() == nil {
+			t.Error("Inc with wrong number of label values did not panic")
+		}
+	}()
+	c.Inc()
+}
@@ -2,7 +2,10 @@ package mocks
 
 import (
 	"context"
+	"time"
 
+	"github.com/eliasvasylenko/secret-agent/internal/audit"
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
 	"github.com/eliasvasylenko/secret-agent/internal/secrets"
 	"github.com/eliasvasylenko/secret-agent/internal/store"
 )
@@ -20,6 +23,9 @@ func (s *MockSecrets) Get(ctx context.Context, secretId string) (*secrets.Secret
 func (s *MockSecrets) History(ctx context.Context, secretId string, from int, to int) ([]*secrets.Operation, error) {
 	return nextCall(&s.Mock, s.History)(ctx, secretId, from, to)
 }
+func (s *MockSecrets) LatestOperation(ctx context.Context, secretId string) (int, error) {
+	return nextCall(&s.Mock, s.LatestOperation)(ctx, secretId)
+}
 func (s *MockSecrets) Instances(secretId string) store.Instances {
 	return nextCall(&s.Mock, s.Instances)(secretId)
 }
@@ -55,3 +61,60 @@ func (i *MockInstances) Test(ctx context.Context, instanceId string, parameters
 func (i *MockInstances) History(ctx context.Context, instanceId string, from int, to int) ([]*secrets.Operation, error) {
 	return nextCall(&i.Mock, i.History)(ctx, instanceId, from, to)
 }
+
+type MockApprovals struct {
+	Mock
+}
+
+func (a *MockApprovals) List(ctx context.Context, from int, to int) ([]*secrets.Approval, error) {
+	return nextCall(&a.Mock, a.List)(ctx, from, to)
+}
+func (a *MockApprovals) Get(ctx context.Context, approvalId string) (*secrets.Approval, error) {
+	return nextCall(&a.Mock, a.Get)(ctx, approvalId)
+}
+func (a *MockApprovals) Request(ctx context.Context, approval *secrets.Approval) (*secrets.Approval, error) {
+	return nextCall(&a.Mock, a.Request)(ctx, approval)
+}
+func (a *MockApprovals) Decide(ctx context.Context, approvalId string, decision secrets.Decision) (*secrets.Approval, error) {
+	return nextCall(&a.Mock, a.Decide)(ctx, approvalId, decision)
+}
+func (a *MockApprovals) Complete(ctx context.Context, approvalId string, operationNumber int) error {
+	return nextCall(&a.Mock, a.Complete)(ctx, approvalId, operationNumber)
+}
+func (a *MockApprovals) Fail(ctx context.Context, approvalId string) error {
+	return nextCall(&a.Mock, a.Fail)(ctx, approvalId)
+}
+
+type MockDenials struct {
+	Mock
+}
+
+func (d *MockDenials) Record(ctx context.Context, denial *audit.Denial) error {
+	return nextCall(&d.Mock, d.Record)(ctx, denial)
+}
+func (d *MockDenials) List(ctx context.Context, from int, to int) ([]*audit.Denial, error) {
+	return nextCall(&d.Mock, d.List)(ctx, from, to)
+}
+func (d *MockDenials) Prune(ctx context.Context, before time.Time) (int, error) {
+	return nextCall(&d.Mock, d.Prune)(ctx, before)
+}
+
+type MockElevations struct {
+	Mock
+}
+
+func (e *MockElevations) Grant(ctx context.Context, elevation *auth.Elevation) (*auth.Elevation, error) {
+	return nextCall(&e.Mock, e.Grant)(ctx, elevation)
+}
+func (e *MockElevations) Active(ctx context.Context, principal string) (*auth.Elevation, error) {
+	return nextCall(&e.Mock, e.Active)(ctx, principal)
+}
+func (e *MockElevations) Get(ctx context.Context, elevationId string) (*auth.Elevation, error) {
+	return nextCall(&e.Mock, e.Get)(ctx, elevationId)
+}
+func (e *MockElevations) List(ctx context.Context, from int, to int) ([]*auth.Elevation, error) {
+	return nextCall(&e.Mock, e.List)(ctx, from, to)
+}
+func (e *MockElevations) Revoke(ctx context.Context, elevationId string, revokedBy string) (*auth.Elevation, error) {
+	return nextCall(&e.Mock, e.Revoke)(ctx, elevationId, revokedBy)
+}
@@ -0,0 +1,190 @@
+package notify
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/events"
+	"github.com/eliasvasylenko/secret-agent/internal/metrics"
+	"github.com/eliasvasylenko/secret-agent/internal/store"
+)
+
+// The number of events read from the outbox at a time
+const batchSize = 100
+
+// The longest time to wait between attempts to deliver an event
+const maxBackoff = 5 * time.Minute
+
+// Interval between prunings of the outbox
+const pruneInterval = time.Hour
+
+var deliveriesTotal = metrics.Default.NewCounterVec(
+	"secret_agent_event_deliveries_total",
+	"Attempts to deliver events to subscribers, by subscriber and outcome.",
+	"subscriber", "outcome",
+)
+
+// A Dispatcher delivers events from the outbox to each subscriber at least once, in the order they were recorded.
+// The progress of each subscriber is recorded in the store, so delivery resumes where it left off after a restart.
+type Dispatcher struct {
+	store        store.Events
+	subscribers  Subscribers
+	retention    time.Duration
+	pollInterval time.Duration
+	// The time at which the dispatcher last finished a round of work
+	lastRun atomic.Pointer[time.Time]
+}
+
+// NewDispatcher creates a dispatcher which retains events in the outbox for the given time, or forever if zero, and
+// in any case until they have been delivered to every subscriber
+func NewDispatcher(store store.Events, subscribers Subscribers, retention time.Duration) (*Dispatcher, error) {
+	if err := subscribers.Validate(); err != nil {
+		return nil, err
+	}
+	return &Dispatcher{
+		store:        store,
+		subscribers:  subscribers,
+		retention:    retention,
+		pollInterval: time.Second,
+	}, nil
+}
+
+// Run delivers events to every subscriber until the context is done
+func (d *Dispatcher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for name, subscriber := range d.subscribers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.poll(ctx, d.pollInterval, func() error { return d.dispatch(ctx, name, subscriber) })
+		}()
+	}
+	if d.retention > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.poll(ctx, pruneInterval, func() error { return d.prune(ctx) })
+		}()
+	}
+	wg.Wait()
+}
+
+// LastRun gives the time at which the dispatcher last delivered events or pruned the outbox, or nil if it has not
+func (d *Dispatcher) LastRun() *time.Time {
+	return d.lastRun.Load()
+}
+
+// poll calls the function at the given interval until the context is done, logging any errors
+func (d *Dispatcher) poll(ctx context.Context, interval time.Duration, call func() error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := call(); err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "event notification error", "error", err)
+		}
+		now := time.Now()
+		d.lastRun.Store(&now)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// prune deletes events which are older than the retention from the outbox, except those which have yet to be
+// delivered to every subscriber
+func (d *Dispatcher) prune(ctx context.Context) error {
+	delivered, err := d.store.Latest(ctx)
+	if err != nil {
+		return err
+	}
+	for name := range d.subscribers {
+		subscription, err := d.store.Subscription(ctx, name)
+		if err != nil {
+			return err
+		}
+		delivered = min(delivered, subscription.DeliveredEventId)
+	}
+	_, err = d.store.Prune(ctx, time.Now().Add(-d.retention), delivered)
+	return err
+}
+
+// dispatch delivers any pending events to the subscriber, stopping at the first which fails to be delivered
+func (d *Dispatcher) dispatch(ctx context.Context, name string, subscriber *Subscriber) error {
+	subscription, err := d.store.Subscription(ctx, name)
+	if err != nil {
+		return err
+	}
+	if subscription.NextAttemptAt != nil && time.Now().Before(*subscription.NextAttemptAt) {
+		return nil
+	}
+
+	for {
+		pending, err := d.store.After(ctx, subscription.DeliveredEventId, batchSize)
+		if err != nil || len(pending) == 0 {
+			return err
+		}
+
+		for _, event := range pending {
+			if !subscriber.Matches(event) {
+				subscription.DeliveredEventId = event.Id
+				continue
+			}
+
+			err = subscriber.Deliver(ctx, event)
+			if err != nil && ctx.Err() != nil {
+				return err
+			}
+			if err != nil {
+				d.failed(name, subscriber, subscription, event, err)
+				return d.store.UpdateSubscription(ctx, subscription)
+			}
+
+			deliveriesTotal.Inc(name, "delivered")
+			subscription.DeliveredEventId = event.Id
+			subscription.Attempts = 0
+			subscription.NextAttemptAt = nil
+			subscription.LastError = ""
+			if err := d.store.UpdateSubscription(ctx, subscription); err != nil {
+				return err
+			}
+		}
+
+		// record progress past events to which the subscriber is not subscribed
+		if err := d.store.UpdateSubscription(ctx, subscription); err != nil {
+			return err
+		}
+	}
+}
+
+// failed records a failed attempt to deliver an event, scheduling the next attempt with exponential backoff,
+// or skipping the event once the subscriber's attempts are exhausted.
+func (d *Dispatcher) failed(name string, subscriber *Subscriber, subscription *events.Subscription, event *events.Event, err error) {
+	subscription.Attempts++
+	if subscription.Attempts >= subscriber.maxAttempts() {
+		deliveriesTotal.Inc(name, "abandoned")
+		slog.Warn("abandoning delivery of event", "eventId", event.Id, "subscriber", name, "attempts", subscription.Attempts, "error", err)
+		subscription.DeliveredEventId = event.Id
+		subscription.Attempts = 0
+		subscription.NextAttemptAt = nil
+		subscription.LastError = err.Error()
+		return
+	}
+
+	deliveriesTotal.Inc(name, "failed")
+	nextAttemptAt := time.Now().Add(backoff(subscription.Attempts))
+	subscription.NextAttemptAt = &nextAttemptAt
+	subscription.LastError = err.Error()
+}
+
+// backoff gives the time to wait after the given number of failed attempts, doubling from a second
+func backoff(attempts int) time.Duration {
+	if attempts > 16 {
+		return maxBackoff
+	}
+	return min(time.Second<<(attempts-1), maxBackoff)
+}
@@ -0,0 +1,223 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/events"
+	"github.com/google/go-cmp/cmp"
+)
+
+// An outbox held in memory
+type memoryEvents struct {
+	events        []*events.Event
+	subscriptions map[string]*events.Subscription
+}
+
+func (m *memoryEvents) After(ctx context.Context, eventId int64, limit int) ([]*events.Event, error) {
+	list := []*events.Event{}
+	for _, event := range m.events {
+		if event.Id > eventId && len(list) < limit {
+			list = append(list, event)
+		}
+	}
+	return list, nil
+}
+
+func (m *memoryEvents) Latest(ctx context.Context) (int64, error) {
+	if len(m.events) == 0 {
+		return 0, nil
+	}
+	return m.events[len(m.events)-1].Id, nil
+}
+
+func (m *memoryEvents) Pruned(ctx context.Context) (int64, error) {
+	return 0, errors.New("not implemented")
+}
+
+func (m *memoryEvents) Prune(ctx context.Context, before time.Time, throughEventId int64) (int, error) {
+	kept := []*events.Event{}
+	for _, event := range m.events {
+		if event.OccurredAt.After(before) || event.Id > throughEventId {
+			kept = append(kept, event)
+		}
+	}
+	pruned := len(m.events) - len(kept)
+	m.events = kept
+	return pruned, nil
+}
+
+func (m *memoryEvents) Subscription(ctx context.Context, subscriber string) (*events.Subscription, error) {
+	subscription, ok := m.subscriptions[subscriber]
+	if !ok {
+		subscription = &events.Subscription{Subscriber: subscriber}
+		m.subscriptions[subscriber] = subscription
+	}
+	copy := *subscription
+	return &copy, nil
+}
+
+func (m *memoryEvents) UpdateSubscription(ctx context.Context, subscription *events.Subscription) error {
+	copy := *subscription
+	m.subscriptions[subscription.Subscriber] = &copy
+	return nil
+}
+
+func newMemoryEvents(types ...events.Type) *memoryEvents {
+	m := &memoryEvents{subscriptions: map[string]*events.Subscription{}}
+	for i, eventType := range types {
+		m.events = append(m.events, &events.Event{Id: int64(i + 1), Type: eventType})
+	}
+	return m
+}
+
+// hook serves a webhook which fails the given number of times before recording deliveries
+func hook(t *testing.T, failures int) (*Webhook, *[]string) {
+	delivered := &[]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		*delivered = append(*delivered, r.Header.Get("X-Secret-Agent-Delivery"))
+	}))
+	t.Cleanup(srv.Close)
+	return &Webhook{URL: srv.URL}, delivered
+}
+
+func TestDispatcher_dispatch(t *testing.T) {
+	store := newMemoryEvents(events.OperationStarted, events.ElevationGranted, events.OperationCompleted)
+	webhook, delivered := hook(t, 0)
+	subscriber := &Subscriber{Events: []string{"operation.*"}, Webhook: webhook}
+	d, err := NewDispatcher(store, Subscribers{"hook": subscriber}, 0)
+	if err != nil {
+		t.Fatalf("NewDispatcher: %v", err)
+	}
+
+	if err := d.dispatch(context.Background(), "hook", subscriber); err != nil {
+		t.Fatalf("dispatch: %v", err)
+	}
+	if diff := cmp.Diff([]string{"1", "3"}, *delivered); diff != "" {
+		t.Errorf("delivered mismatch (-want +got):\n%s", diff)
+	}
+	if got := store.subscriptions["hook"].DeliveredEventId; got != 3 {
+		t.Errorf("delivered event = %d, want 3", got)
+	}
+
+	if err := d.dispatch(context.Background(), "hook", subscriber); err != nil {
+		t.Fatalf("dispatch: %v", err)
+	}
+	if len(*delivered) != 2 {
+		t.Errorf("delivered = %v after redispatch, want no redelivery", *delivered)
+	}
+}
+
+func TestDispatcher_dispatch_retries(t *testing.T) {
+	store := newMemoryEvents(events.OperationStarted, events.OperationCompleted)
+	webhook, delivered := hook(t, 1)
+	subscriber := &Subscriber{Webhook: webhook}
+	d, _ := NewDispatcher(store, Subscribers{"hook": subscriber}, 0)
+
+	d.dispatch(context.Background(), "hook", subscriber)
+	subscription := store.subscriptions["hook"]
+	if subscription.DeliveredEventId != 0 || subscription.Attempts != 1 || subscription.NextAttemptAt == nil || subscription.LastError == "" {
+		t.Fatalf("subscription = %+v, want failed attempt scheduled for retry", subscription)
+	}
+
+	d.dispatch(context.Background(), "hook", subscriber)
+	if len(*delivered) != 0 {
+		t.Errorf("delivered = %v before backoff elapsed, want none", *delivered)
+	}
+
+	past := time.Now().Add(-time.Second)
+	subscription.NextAttemptAt = &past
+	d.dispatch(context.Background(), "hook", subscriber)
+	if diff := cmp.Diff([]string{"1", "2"}, *delivered); diff != "" {
+		t.Errorf("delivered mismatch (-want +got):\n%s", diff)
+	}
+	if got := store.subscriptions["hook"]; got.Attempts != 0 || got.LastError != "" {
+		t.Errorf("subscription = %+v, want attempts reset", got)
+	}
+}
+
+func TestDispatcher_dispatch_abandons(t *testing.T) {
+	store := newMemoryEvents(events.OperationStarted, events.OperationCompleted)
+	webhook, delivered := hook(t, 2)
+	subscriber := &Subscriber{Webhook: webhook, MaxAttempts: 2}
+	d, _ := NewDispatcher(store, Subscribers{"hook": subscriber}, 0)
+
+	for i := 0; i < 2; i++ {
+		store.subscriptions["hook"] = &events.Subscription{Subscriber: "hook", Attempts: i, DeliveredEventId: 0}
+		d.dispatch(context.Background(), "hook", subscriber)
+	}
+	if got := store.subscriptions["hook"]; got.DeliveredEventId != 1 || got.Attempts != 0 {
+		t.Fatalf("subscription = %+v, want event 1 abandoned", got)
+	}
+
+	d.dispatch(context.Background(), "hook", subscriber)
+	if diff := cmp.Diff([]string{"2"}, *delivered); diff != "" {
+		t.Errorf("delivered mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDispatcher_prune(t *testing.T) {
+	store := newMemoryEvents(events.OperationStarted, events.OperationCompleted, events.OperationStarted)
+	store.events[2].OccurredAt = time.Now()
+	store.subscriptions["behind"] = &events.Subscription{Subscriber: "behind", DeliveredEventId: 1}
+	store.subscriptions["ahead"] = &events.Subscription{Subscriber: "ahead", DeliveredEventId: 3}
+	webhook, _ := hook(t, 0)
+	d, _ := NewDispatcher(store, Subscribers{"behind": {Webhook: webhook}, "ahead": {Webhook: webhook}}, time.Hour)
+
+	if err := d.prune(context.Background()); err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	var kept []int64
+	for _, event := range store.events {
+		kept = append(kept, event.Id)
+	}
+	if diff := cmp.Diff([]int64{2, 3}, kept); diff != "" {
+		t.Errorf("kept events mismatch (-want +got):\n%s", diff)
+	}
+
+	store.subscriptions["behind"].DeliveredEventId = 3
+	if err := d.prune(context.Background()); err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	if len(store.events) != 1 || store.events[0].Id != 3 {
+		t.Errorf("events = %v once delivered to every subscriber, want only the recent event kept", store.events)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 5: 16 * time.Second, 9: 256 * time.Second, 10: maxBackoff, 64: maxBackoff} {
+		if got := backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
+
+func TestDispatcher_LastRun(t *testing.T) {
+	store := newMemoryEvents(events.OperationStarted)
+	webhook, _ := hook(t, 0)
+	d, err := NewDispatcher(store, Subscribers{"hook": {Webhook: webhook}}, 0)
+	if err != nil {
+		t.Fatalf("NewDispatcher: %v", err)
+	}
+	if d.LastRun() != nil {
+		t.Errorf("LastRun = %v before running, want nil", d.LastRun())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	before := time.Now()
+	d.Run(ctx)
+
+	if lastRun := d.LastRun(); lastRun == nil || lastRun.Before(before) {
+		t.Errorf("LastRun = %v, want after %v", lastRun, before)
+	}
+}
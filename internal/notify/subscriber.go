@@ -0,0 +1,213 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/command"
+	"github.com/eliasvasylenko/secret-agent/internal/events"
+	"github.com/eliasvasylenko/secret-agent/internal/marshal"
+)
+
+// The number of attempts to deliver an event, if not configured
+const defaultMaxAttempts = 10
+
+// The time allowed to deliver an event, if not configured
+const defaultTimeout = 10 * time.Second
+
+// Subscribers to events, by name
+type Subscribers map[string]*Subscriber
+
+// A subscriber to which events are delivered, by executing a command or by posting to a local webhook
+type Subscriber struct {
+	// Patterns of the types of events to deliver, as matched by path.Match, or every type if empty
+	Events []string `json:"events,omitempty"`
+	// Patterns of the IDs of secrets about which to deliver events, as matched by path.Match, or every secret if empty.
+	// Events which are not about a secret, e.g. elevations, are only delivered if empty.
+	Secrets []string `json:"secrets,omitempty"`
+	// A command to which events are given as JSON on stdin
+	Exec *command.Command `json:"exec,omitempty"`
+	// A local webhook to which events are posted as JSON
+	Webhook *Webhook `json:"webhook,omitempty"`
+	// Path of a file containing the key with which events are signed by HMAC-SHA256, if given
+	HmacKeyFile string `json:"hmacKeyFile,omitempty"`
+	// The number of attempts to deliver an event before it is skipped
+	MaxAttempts int `json:"maxAttempts,omitzero"`
+	// The time allowed for each attempt to deliver an event
+	Timeout marshal.Duration `json:"timeout,omitzero"`
+}
+
+// A webhook served on the local host, over TCP or a unix socket
+type Webhook struct {
+	// The URL to which events are posted, which must be on a loopback address unless a socket is given
+	URL string `json:"url"`
+	// Path of a unix socket through which to connect, if given
+	Socket string `json:"socket,omitempty"`
+}
+
+// Validate checks that every subscriber has exactly one means of delivery, and valid patterns
+func (s Subscribers) Validate() error {
+	var errs []error
+	for name, subscriber := range s {
+		if err := subscriber.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("subscriber %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Subscriber) validate() error {
+	if (s.Exec == nil) == (s.Webhook == nil) {
+		return errors.New("exactly one of exec or webhook must be given")
+	}
+	for _, pattern := range slices.Concat(s.Events, s.Secrets) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	if s.Webhook != nil {
+		return s.Webhook.validate()
+	}
+	return nil
+}
+
+func (w *Webhook) validate() error {
+	target, err := url.Parse(w.URL)
+	if err != nil {
+		return err
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return fmt.Errorf("webhook URL %s is not http or https", w.URL)
+	}
+	if w.Socket != "" {
+		return nil
+	}
+	host := target.Hostname()
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("webhook URL %s is not on a loopback address", w.URL)
+	}
+	return nil
+}
+
+// Matches reports whether the subscriber is subscribed to the event
+func (s *Subscriber) Matches(event *events.Event) bool {
+	return matchesAny(s.Events, string(event.Type)) && (len(s.Secrets) == 0 || event.SecretId != "" && matchesAny(s.Secrets, event.SecretId))
+}
+
+func matchesAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Subscriber) maxAttempts() int {
+	if s.MaxAttempts <= 0 {
+		return defaultMaxAttempts
+	}
+	return s.MaxAttempts
+}
+
+// Deliver delivers an event to the subscriber, once
+func (s *Subscriber) Deliver(ctx context.Context, event *events.Event) error {
+	payload, err := marshal.JSON(event)
+	if err != nil {
+		return err
+	}
+	signature, err := s.sign(payload)
+	if err != nil {
+		return err
+	}
+
+	timeout := time.Duration(s.Timeout)
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if s.Exec != nil {
+		env := command.Environment{
+			"EVENT_ID":   strconv.FormatInt(event.Id, 10),
+			"EVENT_TYPE": string(event.Type),
+		}
+		if signature != "" {
+			env["EVENT_SIGNATURE"] = signature
+		}
+		_, err = s.Exec.Process(command.WithName(ctx, "notify:"+string(event.Type)), string(payload), env)
+		return err
+	}
+	return s.Webhook.post(ctx, event, payload, signature)
+}
+
+// sign gives the signature of the payload as "sha256=<hex>", or an empty string if there is no key.
+// The key is read for each event so that it may be rotated without restarting the server.
+func (s *Subscriber) sign(payload []byte) (string, error) {
+	if s.HmacKeyFile == "" {
+		return "", nil
+	}
+	key, err := os.ReadFile(s.HmacKeyFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read HMAC key: %w", err)
+	}
+	mac := hmac.New(sha256.New, bytes.TrimSpace(key))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+func (w *Webhook) post(ctx context.Context, event *events.Event, payload []byte, signature string) error {
+	client := http.DefaultClient
+	if w.Socket != "" {
+		client = &http.Client{
+			Transport: &http.Transport{
+				DisableKeepAlives: true,
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", w.Socket)
+				},
+			},
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Secret-Agent-Event", string(event.Type))
+	req.Header.Set("X-Secret-Agent-Delivery", strconv.FormatInt(event.Id, 10))
+	if signature != "" {
+		req.Header.Set("X-Secret-Agent-Signature", signature)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s", strings.TrimSpace(res.Status))
+	}
+	return nil
+}
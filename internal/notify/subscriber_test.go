@@ -0,0 +1,174 @@
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/eliasvasylenko/secret-agent/internal/command"
+	"github.com/eliasvasylenko/secret-agent/internal/events"
+)
+
+func TestSubscriber_Matches(t *testing.T) {
+	started := &events.Event{Type: events.OperationStarted, SecretId: "db-password"}
+	elevated := &events.Event{Type: events.ElevationGranted}
+
+	tests := []struct {
+		name       string
+		subscriber Subscriber
+		event      *events.Event
+		want       bool
+	}{
+		{"everything", Subscriber{}, started, true},
+		{"type pattern", Subscriber{Events: []string{"operation.*"}}, started, true},
+		{"other type", Subscriber{Events: []string{"approval.*"}}, started, false},
+		{"secret pattern", Subscriber{Secrets: []string{"db-*"}}, started, true},
+		{"other secret", Subscriber{Secrets: []string{"tls-*"}}, started, false},
+		{"no secret with secret patterns", Subscriber{Secrets: []string{"*"}}, elevated, false},
+		{"no secret without secret patterns", Subscriber{Events: []string{"elevation.*"}}, elevated, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.subscriber.Matches(tt.event); got != tt.want {
+				t.Errorf("Matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscribers_Validate(t *testing.T) {
+	tests := []struct {
+		name       string
+		subscriber Subscriber
+		wantErr    bool
+	}{
+		{"exec", Subscriber{Exec: command.New("true", nil, "")}, false},
+		{"loopback webhook", Subscriber{Webhook: &Webhook{URL: "http://127.0.0.1:8080/hook"}}, false},
+		{"localhost webhook", Subscriber{Webhook: &Webhook{URL: "http://localhost/hook"}}, false},
+		{"socket webhook", Subscriber{Webhook: &Webhook{URL: "http://hooks/event", Socket: "/run/hooks.sock"}}, false},
+		{"remote webhook", Subscriber{Webhook: &Webhook{URL: "https://example.com/hook"}}, true},
+		{"no delivery", Subscriber{}, true},
+		{"both deliveries", Subscriber{Exec: command.New("true", nil, ""), Webhook: &Webhook{URL: "http://localhost/"}}, true},
+		{"bad pattern", Subscriber{Exec: command.New("true", nil, ""), Events: []string{"["}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Subscribers{"s": &tt.subscriber}.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func writeKey(t *testing.T, key string) string {
+	t.Helper()
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte(key+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return keyFile
+}
+
+func signature(key string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestSubscriber_Deliver_webhook(t *testing.T) {
+	var received *http.Request
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	subscriber := &Subscriber{
+		Webhook:     &Webhook{URL: srv.URL + "/hook"},
+		HmacKeyFile: writeKey(t, "secret"),
+	}
+	event := &events.Event{Id: 7, Type: events.OperationCompleted, SecretId: "s1"}
+	if err := subscriber.Deliver(context.Background(), event); err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+
+	if received.URL.Path != "/hook" || received.Header.Get("X-Secret-Agent-Event") != "operation.completed" || received.Header.Get("X-Secret-Agent-Delivery") != "7" {
+		t.Errorf("request = %s %v, want event headers", received.URL.Path, received.Header)
+	}
+	if got, want := received.Header.Get("X-Secret-Agent-Signature"), signature("secret", body); got != want {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+	var got events.Event
+	if err := json.Unmarshal(body, &got); err != nil || got.Id != 7 || got.SecretId != "s1" {
+		t.Errorf("body = %s, %v", body, err)
+	}
+}
+
+func TestSubscriber_Deliver_webhookError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	subscriber := &Subscriber{Webhook: &Webhook{URL: srv.URL}}
+	if err := subscriber.Deliver(context.Background(), &events.Event{Id: 1}); err == nil {
+		t.Error("Deliver = nil, want error for unsuccessful response")
+	}
+}
+
+func TestSubscriber_Deliver_webhookSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "hook.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivered := make(chan string, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered <- r.Header.Get("X-Secret-Agent-Delivery")
+	})}
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	subscriber := &Subscriber{Webhook: &Webhook{URL: "http://hooks/event", Socket: socket}}
+	if err := subscriber.Deliver(context.Background(), &events.Event{Id: 3}); err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+	if got := <-delivered; got != "3" {
+		t.Errorf("delivery = %q, want 3", got)
+	}
+}
+
+func TestSubscriber_Deliver_exec(t *testing.T) {
+	dir := t.TempDir()
+	subscriber := &Subscriber{
+		Exec:        command.New(`cat > "$DIR/event.json"; printf '%s %s %s' "$EVENT_ID" "$EVENT_TYPE" "$EVENT_SIGNATURE" > "$DIR/env"`, command.Environment{"DIR": dir}, ""),
+		HmacKeyFile: writeKey(t, "secret"),
+	}
+	event := &events.Event{Id: 5, Type: events.ApprovalRequested}
+	if err := subscriber.Deliver(context.Background(), event); err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+
+	payload, err := os.ReadFile(filepath.Join(dir, "event.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := os.ReadFile(filepath.Join(dir, "env"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "5 approval.requested " + signature("secret", payload); string(env) != want {
+		t.Errorf("environment = %q, want %q", env, want)
+	}
+}
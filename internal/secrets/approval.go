@@ -0,0 +1,94 @@
+package secrets
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/command"
+)
+
+// A request for an operation which must be approved by other principals before it is performed
+type Approval struct {
+	Id         string        `json:"id"`
+	SecretId   string        `json:"secretId"`
+	InstanceId string        `json:"instanceId,omitempty"`
+	Name       OperationName `json:"name"`
+	Forced     bool          `json:"forced,omitzero"`
+	Reason     string        `json:"reason,omitzero"`
+
+	// The environment of the requested operation, which is retained to perform it once approved
+	Env command.Environment `json:"-"`
+
+	RequestedBy string         `json:"requestedBy"`
+	RequestedAt time.Time      `json:"requestedAt"`
+	ExpiresAt   time.Time      `json:"expiresAt"`
+	Required    int            `json:"required"`
+	Status      ApprovalStatus `json:"status"`
+	Decisions   []*Decision    `json:"decisions"`
+
+	// The operation which was started once the request was approved
+	OperationNumber *int `json:"operationNumber,omitempty"`
+
+	// The key which identified the request for approval, if any
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+
+	// The latest operation on the secret which the request expected, if any, which is expected again once approved
+	IfMatch *int `json:"ifMatch,omitempty"`
+
+	// The elevation which was active for the requester, if any, under which the operation is performed once approved
+	ElevationId string `json:"elevationId,omitempty"`
+}
+
+// A decision by a principal to approve or reject a request
+type Decision struct {
+	Principal string    `json:"principal"`
+	Approved  bool      `json:"approved"`
+	Comment   string    `json:"comment,omitzero"`
+	DecidedAt time.Time `json:"decidedAt"`
+}
+
+// The status of an approval request
+type ApprovalStatus string
+
+const (
+	Pending  ApprovalStatus = "pending"
+	Approved ApprovalStatus = "approved"
+	Rejected ApprovalStatus = "rejected"
+	Expired  ApprovalStatus = "expired"
+	// The request was approved, but the operation could not be started, e.g. because another instance was
+	// activated in the meantime. The operation must be requested again.
+	Failed ApprovalStatus = "failed"
+)
+
+// The parameters with which the requested operation is performed once approved
+func (a *Approval) Parameters() OperationParameters {
+	return OperationParameters{
+		Env:         a.Env,
+		Forced:      a.Forced,
+		Reason:      a.Reason,
+		StartedBy:   a.RequestedBy,
+		ApprovalId:  a.Id,
+		ElevationId: a.ElevationId,
+		IfMatch:     a.IfMatch,
+	}
+}
+
+// The number of approving decisions
+func (a *Approval) Approvals() int {
+	approvals := 0
+	for _, decision := range a.Decisions {
+		if decision.Approved {
+			approvals++
+		}
+	}
+	return approvals
+}
+
+// ApprovalRequiredError is returned when an operation is not performed until it has been approved
+type ApprovalRequiredError struct {
+	Approval *Approval
+}
+
+func (e *ApprovalRequiredError) Error() string {
+	return fmt.Sprintf("%s requires %d approval(s), pending approval request %s", e.Approval.Name, e.Approval.Required, e.Approval.Id)
+}
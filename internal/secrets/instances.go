@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"slices"
+	"time"
 
 	"github.com/eliasvasylenko/secret-agent/internal/marshal"
 )
@@ -19,6 +20,9 @@ type Instance struct {
 
 	// The current status of the instance, indicated by the last operation performed
 	Status Status `json:"status"`
+
+	// When the instance was created, i.e. when its create operation started
+	CreatedAt time.Time `json:"createdAt"`
 }
 
 type Instances map[string]*Instance
@@ -48,6 +52,11 @@ func (i *Instances) UnmarshalJSON(p []byte) error {
 }
 
 func (i Instances) MarshalJSON() ([]byte, error) {
+	return marshal.JSON(i.Sorted())
+}
+
+// Sorted gives the instances in order of their latest operations, most recent first
+func (i Instances) Sorted() []*Instance {
 	instances := make([]*Instance, 0)
 	for _, instance := range i {
 		instances = append(instances, instance)
@@ -55,5 +64,5 @@ func (i Instances) MarshalJSON() ([]byte, error) {
 	slices.SortFunc(instances, func(a *Instance, b *Instance) int {
 		return cmp.Compare(b.Status.OperationNumber, a.Status.OperationNumber)
 	})
-	return marshal.JSON(instances)
+	return instances
 }
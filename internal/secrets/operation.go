@@ -1,6 +1,7 @@
 package secrets
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
@@ -20,6 +21,26 @@ type OperationParameters struct {
 	Forced    bool                `json:"forced"`
 	Reason    string              `json:"reason"`
 	StartedBy string              `json:"startedBy"`
+
+	// The approval request which authorised the operation, if any
+	ApprovalId string `json:"approvalId,omitempty"`
+
+	// The break-glass elevation which was active for the caller, if any
+	ElevationId string `json:"elevationId,omitempty"`
+
+	// A key chosen by the caller to identify the request, so that a retry returns the operation
+	// started by the original request rather than starting another
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+
+	// The batch of operations across many secrets to which the operation belongs, if any
+	BatchId string `json:"batchId,omitempty"`
+
+	// The ID of the API request which started the operation, if any
+	RequestId string `json:"requestId,omitempty"`
+
+	// The number of the latest operation on the secret, if the operation may only start when
+	// nothing else has happened to the secret since
+	IfMatch *int `json:"ifMatch,omitempty"`
 }
 
 // Validate enforces basic constraints
@@ -43,6 +64,16 @@ type Status struct {
 	StartedAt       time.Time     `json:"startedAt"`
 	CompletedAt     *time.Time    `json:"completedAt,omitempty"`
 	FailedAt        *time.Time    `json:"failedAt,omitempty"`
+	// Set along with FailedAt if the operation was interrupted, e.g. by shutdown of the server
+	InterruptedAt *time.Time `json:"interruptedAt,omitempty"`
+	ApprovalId    *string    `json:"approvalId,omitempty"`
+	ElevationId   *string    `json:"elevationId,omitempty"`
+	// The key which identified the request which started the operation, if any
+	IdempotencyKey *string `json:"idempotencyKey,omitempty"`
+	// The batch of operations across many secrets to which the operation belongs, if any
+	BatchId *string `json:"batchId,omitempty"`
+	// The ID of the API request which started the operation, if any
+	RequestId *string `json:"requestId,omitempty"`
 }
 
 const (
@@ -52,3 +83,34 @@ const (
 	Deactivate OperationName = "deactivate"
 	Test       OperationName = "test"
 )
+
+// CheckOperation explains why an operation may not be performed upon an instance unless it is forced, given the
+// status of the latest operation upon the instance and the active instance of its secret, if any. It gives an empty
+// string if the operation may be performed.
+func CheckOperation(operationName OperationName, instanceId string, latest Status, activeInstanceId *string) string {
+	switch {
+	case latest.CompletedAt == nil && operationName != latest.Name:
+		return fmt.Sprintf("%s when previous %s has not succeeded", operationName, latest.Name)
+	case operationName == Activate && activeInstanceId != nil:
+		return fmt.Sprintf("%s when instance %s is active", operationName, *activeInstanceId)
+	case (operationName == Test || operationName == Deactivate) && (activeInstanceId == nil || *activeInstanceId != instanceId):
+		return fmt.Sprintf("%s when instance is not active", operationName)
+	default:
+		return ""
+	}
+}
+
+// ErrIdempotencyKeyReused is returned when an idempotency key is given for a request other than the
+// one which it first identified
+var ErrIdempotencyKeyReused = errors.New("idempotency key was already used for a different request")
+
+// PreconditionFailedError is returned when an operation is not started because the latest operation on
+// the secret is not the one the caller expected
+type PreconditionFailedError struct {
+	Expected int
+	Latest   int
+}
+
+func (e *PreconditionFailedError) Error() string {
+	return fmt.Sprintf("latest operation on secret is %d, not %d", e.Latest, e.Expected)
+}
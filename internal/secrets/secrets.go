@@ -1,9 +1,11 @@
 package secrets
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"fmt"
+	"slices"
 	"strconv"
 
 	"github.com/eliasvasylenko/secret-agent/internal/command"
@@ -21,6 +23,9 @@ type Secret struct {
 	// The environment variables for the secret plan
 	Environment command.Environment `json:"environment,omitempty"`
 
+	// Labels by which the secret may be selected for bulk operations
+	Labels map[string]string `json:"labels,omitempty"`
+
 	// Create a new instance of the secret
 	Create *command.Command `json:"create,omitempty"`
 
@@ -38,6 +43,9 @@ type Secret struct {
 
 	// Derive sub-secrets
 	Derive Secrets `json:"derive,omitempty"`
+
+	// The age at which the active instance is due to be replaced by a new one, or never if zero
+	RotateAfter marshal.Duration `json:"rotateAfter,omitzero"`
 }
 
 type Secrets map[string]*Secret
@@ -67,11 +75,19 @@ func (s *Secrets) UnmarshalJSON(p []byte) error {
 }
 
 func (s Secrets) MarshalJSON() ([]byte, error) {
+	return marshal.JSON(s.Sorted())
+}
+
+// Sorted gives the secrets in order of their names
+func (s Secrets) Sorted() []*Secret {
 	secrets := make([]*Secret, 0)
 	for _, secret := range s {
 		secrets = append(secrets, secret)
 	}
-	return marshal.JSON(secrets)
+	slices.SortFunc(secrets, func(a *Secret, b *Secret) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+	return secrets
 }
 
 func (s *Secret) Command(operation OperationName) *command.Command {
@@ -107,11 +123,12 @@ func (s *Secret) Process(ctx context.Context, operation OperationName, input str
 		"FORCE":      strconv.FormatBool(parameters.Forced),
 		"REASON":     parameters.Reason,
 		"STARTED_BY": parameters.StartedBy,
+		"REQUEST_ID": parameters.RequestId,
 	}).ExpandWith(parameters.Env)
 
 	command := s.Command(operation)
 	if command != nil {
-		commandOutput, err := processCommand(command, ctx, input, env)
+		commandOutput, err := processCommand(command, withCommandName(ctx, qname, operation), input, env)
 		if err != nil {
 			return err
 		}
@@ -125,6 +142,7 @@ func (s *Secret) Process(ctx context.Context, operation OperationName, input str
 		Forced:    parameters.Forced,
 		Reason:    parameters.Reason,
 		StartedBy: parameters.StartedBy,
+		RequestId: parameters.RequestId,
 	}, instanceId)
 }
 
@@ -136,3 +154,8 @@ func (s *Secret) processSubsteps(ctx context.Context, operation OperationName, i
 	}
 	return nil
 }
+
+// withCommandName names the command for an operation on a secret, identified by its qualified name
+func withCommandName(ctx context.Context, qname string, operation OperationName) context.Context {
+	return command.WithName(ctx, fmt.Sprintf("%s:%s", qname, operation))
+}
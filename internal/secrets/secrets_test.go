@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/eliasvasylenko/secret-agent/internal/command"
 	"github.com/google/go-cmp/cmp"
@@ -66,6 +67,17 @@ func TestSecrets_MarshalJSON(t *testing.T) {
 	}
 }
 
+func TestSecrets_MarshalJSON_sorted(t *testing.T) {
+	secrets := Secrets{"b": {Name: "b"}, "c": {Name: "c"}, "a": {Name: "a"}}
+	data, err := json.Marshal(secrets)
+	if err != nil {
+		t.Fatalf("MarshalJSON: %v", err)
+	}
+	if want := `[{"name":"a"},{"name":"b"},{"name":"c"}]`; string(data) != want {
+		t.Errorf("MarshalJSON = %s, want %s", data, want)
+	}
+}
+
 // processCommandCall records a single call to the processCommand mock.
 type processCommandCall struct {
 	Script string
@@ -97,7 +109,7 @@ func TestSecret_Process(t *testing.T) {
 	if call.Input != "" {
 		t.Errorf("processCommand called with Input = %q, want %q", call.Input, "")
 	}
-	wantEnv := map[string]string{"ID": "inst-1", "NAME": "test-secret", "QID": "test-secret/inst-1", "QNAME": "test-secret", "FORCE": "false", "REASON": "", "STARTED_BY": ""}
+	wantEnv := map[string]string{"ID": "inst-1", "NAME": "test-secret", "QID": "test-secret/inst-1", "QNAME": "test-secret", "FORCE": "false", "REASON": "", "STARTED_BY": "", "REQUEST_ID": ""}
 	for k, v := range wantEnv {
 		if call.Env[k] != v {
 			t.Errorf("processCommand Env[%q] = %q, want %q", k, call.Env[k], v)
@@ -123,6 +135,7 @@ func TestSecret_Process_withEnv(t *testing.T) {
 		Env:       command.Environment{"QNAME": "parent"},
 		Reason:    "test",
 		StartedBy: "tests",
+		RequestId: "req-1",
 	}
 	err := s.Process(ctx, Create, "stdin", params, "inst-1")
 	if err != nil {
@@ -140,6 +153,9 @@ func TestSecret_Process_withEnv(t *testing.T) {
 	if call.Env["REASON"] != "test" || call.Env["STARTED_BY"] != "tests" {
 		t.Errorf("processCommand Env REASON=%q STARTED_BY=%q, want test, tests", call.Env["REASON"], call.Env["STARTED_BY"])
 	}
+	if call.Env["REQUEST_ID"] != "req-1" {
+		t.Errorf("processCommand Env[REQUEST_ID] = %q, want req-1", call.Env["REQUEST_ID"])
+	}
 }
 
 func TestSecret_Process_noCommandForOp(t *testing.T) {
@@ -415,3 +431,34 @@ func TestOperationParameters_Validate(t *testing.T) {
 		}
 	})
 }
+
+func TestSummary_Judge(t *testing.T) {
+	now := time.Now()
+	hourAgo := now.Add(-time.Hour)
+	activeInstanceId := "i1"
+	tests := []struct {
+		name        string
+		summary     Summary
+		rotateAfter time.Duration
+		want        Verdict
+	}{
+		{"inactive", Summary{}, 0, Inactive},
+		{"healthy", Summary{ActiveInstanceId: &activeInstanceId, ActiveCreatedAt: &hourAgo}, 2 * time.Hour, Healthy},
+		{"passed", Summary{ActiveInstanceId: &activeInstanceId, LastTest: &Operation{Status: Status{CompletedAt: &now}}}, 0, Healthy},
+		{"failing", Summary{ActiveInstanceId: &activeInstanceId, LastTest: &Operation{Status: Status{FailedAt: &now}}}, 0, Failing},
+		{"overdue", Summary{ActiveInstanceId: &activeInstanceId, ActiveCreatedAt: &hourAgo}, time.Hour, Overdue},
+		{"running", Summary{ActiveInstanceId: &activeInstanceId, RunningOperation: &Operation{Status: Status{StartedAt: now}}}, 0, Healthy},
+		{"stuck", Summary{ActiveInstanceId: &activeInstanceId, RunningOperation: &Operation{Status: Status{StartedAt: hourAgo}}}, 0, Stuck},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.summary.Judge(now, now.Add(-time.Minute), test.rotateAfter)
+			if test.summary.Verdict != test.want {
+				t.Errorf("Verdict = %s, want %s", test.summary.Verdict, test.want)
+			}
+			if test.summary.Stuck != (test.want == Stuck) {
+				t.Errorf("Stuck = %t", test.summary.Stuck)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package secrets
+
+import (
+	"time"
+)
+
+// A verdict upon the health of a secret
+type Verdict string
+
+const (
+	// The secret has an active instance which is not due for rotation, and which passed its latest test if it has
+	// been tested
+	Healthy Verdict = "healthy"
+	// The secret has no active instance
+	Inactive Verdict = "inactive"
+	// The latest test of the active instance failed
+	Failing Verdict = "failing"
+	// The active instance is older than the secret allows before it is rotated
+	Overdue Verdict = "overdue"
+	// An operation upon the secret has run for longer than it should
+	Stuck Verdict = "stuck"
+)
+
+// A Summary describes the state of a secret at a glance, for an overview of every secret at once
+type Summary struct {
+	SecretId string `json:"secretId"`
+	// The active instance, if any, and when it was created
+	ActiveInstanceId *string    `json:"activeInstanceId,omitempty"`
+	ActiveCreatedAt  *time.Time `json:"activeCreatedAt,omitempty"`
+	// The latest operation upon any instance of the secret, if any
+	LatestOperation *Operation `json:"latestOperation,omitempty"`
+	// The longest running operation upon the secret which has neither completed nor failed, if any
+	RunningOperation *Operation `json:"runningOperation,omitempty"`
+	// Whether the running operation has run for longer than it should
+	Stuck bool `json:"stuck,omitzero"`
+	// The number of instances of the secret which have not been destroyed
+	LiveInstances int `json:"liveInstances"`
+	// The latest test of the active instance, if it has been tested
+	LastTest *Operation `json:"lastTest,omitempty"`
+	// The time from which the active instance is due to be replaced, if the secret is rotated
+	RotationDueAt *time.Time `json:"rotationDueAt,omitempty"`
+	Verdict       Verdict    `json:"verdict"`
+}
+
+// Judge gives a verdict upon the summarised secret at the given time, given that operations started before
+// stuckBefore should have finished, and that the active instance is replaced once older than rotateAfter unless it
+// is zero. Whatever is most in need of attention decides the verdict.
+func (s *Summary) Judge(now time.Time, stuckBefore time.Time, rotateAfter time.Duration) {
+	s.Stuck = s.RunningOperation != nil && s.RunningOperation.StartedAt.Before(stuckBefore)
+	s.RotationDueAt = nil
+	if rotateAfter > 0 && s.ActiveCreatedAt != nil {
+		dueAt := s.ActiveCreatedAt.Add(rotateAfter)
+		s.RotationDueAt = &dueAt
+	}
+
+	switch {
+	case s.Stuck:
+		s.Verdict = Stuck
+	case s.ActiveInstanceId == nil:
+		s.Verdict = Inactive
+	case s.LastTest != nil && s.LastTest.FailedAt != nil:
+		s.Verdict = Failing
+	case s.RotationDueAt != nil && !now.Before(*s.RotationDueAt):
+		s.Verdict = Overdue
+	default:
+		s.Verdict = Healthy
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/marshal"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+)
+
+// The time after which a pending approval request expires, unless a policy specifies otherwise
+const defaultApprovalExpiry = 24 * time.Hour
+
+// An approval policy requires matching operations to be approved by other principals before they are performed
+type ApprovalPolicy struct {
+	// The operations which require approval
+	OperationSelector `json:""`
+
+	// Only require approval for forced operations
+	Forced bool `json:"forced,omitempty"`
+
+	// The number of approvals required
+	Approvals int `json:"approvals"`
+
+	// The time after which a pending request expires
+	Expiry marshal.Duration `json:"expiry,omitempty"`
+}
+
+type ApprovalPolicies []ApprovalPolicy
+
+// DecisionParameters are the parameters for approving or rejecting an approval request
+type DecisionParameters struct {
+	Comment string `json:"comment"`
+}
+
+func (p ApprovalPolicy) matches(secretId string, operation secrets.OperationName, forced bool) bool {
+	return (forced || !p.Forced) && p.OperationSelector.Matches(secretId, operation)
+}
+
+// Required returns the number of approvals required for an operation, and the time after which a request for it expires.
+// Where several policies match, the greatest number of approvals and the shortest expiry apply.
+func (p ApprovalPolicies) Required(secretId string, operation secrets.OperationName, forced bool) (int, time.Duration) {
+	approvals := 0
+	expiry := time.Duration(0)
+	for _, policy := range p {
+		if !policy.matches(secretId, operation, forced) {
+			continue
+		}
+		approvals = max(approvals, policy.Approvals)
+		policyExpiry := time.Duration(policy.Expiry)
+		if policyExpiry > 0 && (expiry == 0 || policyExpiry < expiry) {
+			expiry = policyExpiry
+		}
+	}
+	if expiry == 0 {
+		expiry = defaultApprovalExpiry
+	}
+	return approvals, expiry
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/marshal"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+)
+
+func TestApprovalPolicies_Required(t *testing.T) {
+	policies := ApprovalPolicies{
+		{OperationSelector: OperationSelector{Operations: []secrets.OperationName{secrets.Destroy}}, Approvals: 1},
+		{Forced: true, Approvals: 2, Expiry: marshal.Duration(time.Hour)},
+		{OperationSelector: OperationSelector{Secrets: []string{"db-*"}, Operations: []secrets.OperationName{secrets.Activate}}, Approvals: 1, Expiry: marshal.Duration(2 * time.Hour)},
+	}
+	tests := []struct {
+		name       string
+		secretId   string
+		operation  secrets.OperationName
+		forced     bool
+		wantCount  int
+		wantExpiry time.Duration
+	}{
+		{"unmatched", "web", secrets.Activate, false, 0, defaultApprovalExpiry},
+		{"operation", "web", secrets.Destroy, false, 1, defaultApprovalExpiry},
+		{"forced", "web", secrets.Test, true, 2, time.Hour},
+		{"forced and operation", "web", secrets.Destroy, true, 2, time.Hour},
+		{"secret pattern", "db-main", secrets.Activate, false, 1, 2 * time.Hour},
+		{"shortest expiry", "db-main", secrets.Activate, true, 2, time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			count, expiry := policies.Required(tt.secretId, tt.operation, tt.forced)
+			if count != tt.wantCount || expiry != tt.wantExpiry {
+				t.Errorf("Required(%s, %s, %v) = %d, %v, want %d, %v", tt.secretId, tt.operation, tt.forced, count, expiry, tt.wantCount, tt.wantExpiry)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/bulk"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+)
+
+// AuthoriseBulk asserts that the caller has the permissions required to request the operation upon any secret,
+// which the route does not know until the operation is read from the request
+func (p *Permissions) AuthoriseBulk(identity *auth.Identity, operation secrets.OperationName) error {
+	err := p.assertPermission(identity, requiredPermissions(operation))
+	if err != nil {
+		return NewErrorResponse(http.StatusForbidden, err)
+	}
+	return nil
+}
+
+// bulkOperation performs an operation across a selection of secrets, authorising it upon each in turn.
+// Operations which would require approval are refused, as approval must be requested for each secret alone.
+// No more operations are performed at once than the caller may have in flight.
+func (s *Controller) bulkOperation(w http.ResponseWriter, r *http.Request) {
+	identity := identityFromContext(r.Context())
+	if identity == nil {
+		writeError(w, NewErrorResponse(http.StatusInternalServerError, fmt.Errorf("identity not found in context")))
+		return
+	}
+	var request bulk.Request
+	err := readBody(w, r, &request)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err = request.Validate(); err != nil {
+		writeError(w, NewErrorResponse(http.StatusBadRequest, err))
+		return
+	}
+	if err = s.permissions.AuthoriseBulk(identity, request.Name); err != nil {
+		s.recordDenial(r, requiredPermissions(request.Name), request.Name, err)
+		writeError(w, err)
+		return
+	}
+
+	operation := OperationParameters{Env: request.Env, Forced: request.Forced, Reason: request.Reason}
+	authorise := func(secretId string) error {
+		err := s.permissions.AuthoriseOperation(identity, secretId, request.Name, operation)
+		if err != nil {
+			denial := newDenial(r, nil, request.Name, err)
+			denial.SecretId = secretId
+			s.storeDenial(r.Context(), denial)
+			return err
+		}
+		if required, _ := s.permissions.RequiredApprovals(secretId, request.Name, request.Forced); required > 0 {
+			return fmt.Errorf("%s requires %d approval(s), which must be requested for the secret alone", request.Name, required)
+		}
+		return nil
+	}
+
+	// the request counts as one operation in flight, so the rest of the batch may only run alongside it while the
+	// caller has others to spare
+	reserved, release := s.limiter.Reserve(identity.Principal, uint32(max(request.Concurrency, 1)-1))
+	defer release()
+	request.Concurrency = 1 + int(reserved)
+
+	elevationId := ""
+	if identity.Elevation != nil {
+		elevationId = identity.Elevation.Id
+	}
+	report, err := bulk.Run(r.Context(), s.secretStore, request, identity.Principal, elevationId, authorise)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeResult(w, report, http.StatusOK)
+}
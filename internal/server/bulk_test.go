@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eliasvasylenko/secret-agent/internal/audit"
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/bulk"
+	"github.com/eliasvasylenko/secret-agent/internal/mocks"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+	"github.com/eliasvasylenko/secret-agent/internal/store"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestController_bulkOperation(t *testing.T) {
+	tests := []struct {
+		name          string
+		approvals     int
+		wantSucceeded int
+		wantFailed    int
+	}{
+		{"performed", 0, 2, 0},
+		{"requires approval", 1, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStore := &mocks.MockSecrets{}
+			defer mockStore.Mock.Validate(t)
+			mockInstances := &mocks.MockInstances{}
+			defer mockInstances.Mock.Validate(t)
+			mocks.Expect(&mockStore.Mock, mockStore.List, func(ctx context.Context) (secrets.Secrets, error) {
+				return secrets.Secrets{"db-a": {Name: "db-a"}, "db-b": {Name: "db-b"}, "web": {Name: "web"}}, nil
+			})
+			for range tt.wantSucceeded {
+				mocks.Expect(&mockStore.Mock, mockStore.Instances, func(secretId string) store.Instances {
+					return mockInstances
+				})
+				mocks.Expect(&mockInstances.Mock, mockInstances.Create, func(ctx context.Context, params secrets.OperationParameters) (*secrets.Instance, error) {
+					if params.StartedBy != "op-user" || params.BatchId == "" || params.Reason != "incident" {
+						t.Errorf("Create parameters = %+v", params)
+					}
+					return &secrets.Instance{Id: "i1"}, nil
+				})
+			}
+
+			c := NewController(mockStore, nil, nil, nil, nil, noopLimiter{}, noopPermissions{identity: &auth.Identity{Principal: "op-user"}, approvals: tt.approvals})
+			mux := http.NewServeMux()
+			c.buildHandler(mux.Handle)
+
+			body := `{"name":"create","select":{"names":["db-*"]},"reason":"incident"}`
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://test/bulk/operations", bytes.NewReader([]byte(body))))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200\nbody: %s", rec.Code, rec.Body.Bytes())
+			}
+			var got bulk.Report
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if got.Succeeded != tt.wantSucceeded || got.Failed != tt.wantFailed || got.BatchId == "" {
+				t.Errorf("report = %+v", got)
+			}
+		})
+	}
+}
+
+func TestController_bulkOperation_invalid(t *testing.T) {
+	c := NewController(&mocks.MockSecrets{}, nil, nil, nil, nil, noopLimiter{}, noopPermissions{identity: &auth.Identity{Principal: "op-user"}})
+	mux := http.NewServeMux()
+	c.buildHandler(mux.Handle)
+
+	body := `{"name":"create","select":{}}`
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://test/bulk/operations", bytes.NewReader([]byte(body))))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400\nbody: %s", rec.Code, rec.Body.Bytes())
+	}
+}
+
+func TestController_bulkOperation_permissions(t *testing.T) {
+	server, exe := peerConnection(t)
+	p := &Permissions{
+		Roles:  auth.Roles{"creator": {Name: "creator", Permissions: auth.Permissions{auth.Instances: {auth.Write}}}},
+		Claims: auth.Claims{PlatformClaims: auth.PlatformClaims{Executables: map[string]auth.ClaimedRoles{exe: {"creator"}}}},
+	}
+	mockStore := &mocks.MockSecrets{}
+	defer mockStore.Mock.Validate(t)
+	mockDenials := &mocks.MockDenials{}
+	defer mockDenials.Mock.Validate(t)
+	c := NewController(mockStore, nil, mockDenials, nil, nil, noopLimiter{}, p)
+	mux := http.NewServeMux()
+	c.buildHandler(mux.Handle)
+
+	tests := []struct {
+		operation  secrets.OperationName
+		wantStatus int
+	}{
+		{secrets.Create, http.StatusOK},
+		{secrets.Activate, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.operation), func(t *testing.T) {
+			if tt.wantStatus == http.StatusOK {
+				mocks.Expect(&mockStore.Mock, mockStore.List, func(ctx context.Context) (secrets.Secrets, error) {
+					return secrets.Secrets{}, nil
+				})
+			} else {
+				mocks.Expect(&mockDenials.Mock, mockDenials.Record, func(ctx context.Context, denial *audit.Denial) error {
+					if diff := cmp.Diff(requiredPermissions(tt.operation), denial.Permission); diff != "" || denial.Operation != tt.operation {
+						t.Errorf("denial = %+v, want the permissions of %s", denial, tt.operation)
+					}
+					return nil
+				})
+			}
+
+			body := `{"name":"` + string(tt.operation) + `","select":{"names":["db-*"]}}`
+			req := httptest.NewRequest(http.MethodPost, "http://test/bulk/operations", bytes.NewReader([]byte(body)))
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req.WithContext(context.WithValue(req.Context(), connectionKey{}, server)))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d\nbody: %s", rec.Code, tt.wantStatus, rec.Body.Bytes())
+			}
+		})
+	}
+}
@@ -1,73 +1,200 @@
 package server
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io"
+	"log/slog"
 	"net/http"
-	"net/url"
-	"strconv"
+	"time"
 
+	"github.com/eliasvasylenko/secret-agent/internal/audit"
 	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/events"
+	"github.com/eliasvasylenko/secret-agent/internal/logging"
 	"github.com/eliasvasylenko/secret-agent/internal/secrets"
 	"github.com/eliasvasylenko/secret-agent/internal/store"
 )
 
 type Controller struct {
-	secretStore store.Secrets
-	middleware  func(perms auth.Permissions, next http.HandlerFunc) http.Handler
+	secretStore    store.Secrets
+	approvalStore  store.Approvals
+	denialStore    store.Denials
+	elevationStore store.Elevations
+	eventStore     store.Events
+	permissions    permissions
+	limiter        limiter
+	middleware     func(class LimitClass, perms auth.Permissions, next http.HandlerFunc) http.Handler
+	// Serves routes which are open to unauthenticated callers, such as health checks
+	unauthenticated func(next http.HandlerFunc) http.Handler
+	monitor         *Monitor
+	// Interval at which event streams poll the store for new events
+	eventPollInterval time.Duration
 }
 
-func NewController(secretStore store.Secrets, limiter limiter, permissions permissions) *Controller {
-	limiterKey := func(r *http.Request) string {
-		return identityFromContext(r.Context()).Principal
+func NewController(secretStore store.Secrets, approvalStore store.Approvals, denialStore store.Denials, elevationStore store.Elevations, eventStore store.Events, limiter limiter, permissions permissions) *Controller {
+	c := &Controller{
+		secretStore:       secretStore,
+		approvalStore:     approvalStore,
+		denialStore:       denialStore,
+		elevationStore:    elevationStore,
+		eventStore:        eventStore,
+		permissions:       permissions,
+		limiter:           limiter,
+		eventPollInterval: time.Second,
 	}
-	middleware := func(perms auth.Permissions, next http.HandlerFunc) http.Handler {
-		return limiter.Middleware(limiterKey, permissions.Middleware(perms, next))
+	// the caller is authenticated before rate limiting, so that requests are limited per principal, while the
+	// denials of callers who are not authenticated are limited per peer as they are recorded
+	c.middleware = func(class LimitClass, perms auth.Permissions, next http.HandlerFunc) http.Handler {
+		denied := func(r *http.Request, err error) {
+			c.recordDenial(r, perms, "", err)
+		}
+		return permissions.Middleware(perms, denied, limiter.Middleware(class, denied, next))
 	}
-	return &Controller{
-		secretStore: secretStore,
-		middleware:  middleware,
+	c.unauthenticated = func(next http.HandlerFunc) http.Handler {
+		return next
 	}
+	return c
 }
 
 type limiter interface {
-	Middleware(keyFunc func(r *http.Request) string, next http.Handler) http.Handler
+	Middleware(class LimitClass, denied func(r *http.Request, err error), next http.Handler) http.Handler
+	Reserve(principal string, wanted uint32) (uint32, func())
 }
 
 type permissions interface {
-	Middleware(perms auth.Permissions, next http.Handler) http.Handler
+	Middleware(perms auth.Permissions, denied func(r *http.Request, err error), next http.Handler) http.Handler
+	RequiredApprovals(secretId string, operation secrets.OperationName, forced bool) (int, time.Duration)
+	AuthoriseOperation(identity *auth.Identity, secretId string, operation secrets.OperationName, parameters OperationParameters) error
+	AuthoriseBulk(identity *auth.Identity, operation secrets.OperationName) error
+	Whoami(identity *auth.Identity, query *OperationQuery) *Whoami
+	AuthoriseElevation(identity *auth.Identity, parameters ElevationParameters) (time.Duration, error)
+	AuthoriseRevocation(identity *auth.Identity, elevation *auth.Elevation) error
+	AuthoriseEvent(identity *auth.Identity, event *events.Event) error
 }
 
 func (c *Controller) buildHandler(registerHandler func(pattern string, handler http.Handler)) {
 	registerHandler("GET /secrets", c.middleware(
-		auth.Permissions{auth.Secrets: auth.List},
+		ReadClass,
+		auth.Permissions{auth.Secrets: {auth.List}},
 		c.listSecrets,
 	))
 	registerHandler("GET /secrets/{secretId}", c.middleware(
-		auth.Permissions{auth.Secrets: auth.Read},
+		ReadClass,
+		auth.Permissions{auth.Secrets: {auth.Read}},
 		c.getSecret,
 	))
 	registerHandler("GET /secrets/{secretId}/instances", c.middleware(
-		auth.Permissions{auth.Instances: auth.Read},
+		ReadClass,
+		auth.Permissions{auth.Instances: {auth.Read}},
 		c.listInstances,
 	))
 	registerHandler("POST /secrets/{secretId}/instances", c.middleware(
-		auth.Permissions{auth.Instances: auth.Write},
+		OperationClass,
+		requiredPermissions(secrets.Create),
 		c.createInstance,
 	))
+	registerHandler("GET /secrets/{secretId}/active", c.middleware(
+		ReadClass,
+		auth.Permissions{auth.Instances: {auth.Read}},
+		c.getActiveInstance,
+	))
 	registerHandler("GET /secrets/{secretId}/instances/{instanceId}", c.middleware(
-		auth.Permissions{auth.Instances: auth.Read},
+		ReadClass,
+		auth.Permissions{auth.Instances: {auth.Read}},
 		c.getInstance,
 	))
 	registerHandler("GET /secrets/{secretId}/instances/{instanceId}/operations", c.middleware(
-		auth.Permissions{auth.Instances: auth.Read},
+		ReadClass,
+		auth.Permissions{auth.Instances: {auth.Read}},
 		c.getOperations,
 	))
 	registerHandler("POST /secrets/{secretId}/instances/{instanceId}/operations", c.middleware(
-		auth.Permissions{auth.Secrets: auth.Write, auth.Instances: auth.Write},
+		OperationClass,
+		requiredPermissions(secrets.Activate),
 		c.createOperation,
 	))
+	// the permissions required by the operation are checked once it is read from the request
+	registerHandler("POST /bulk/operations", c.middleware(
+		OperationClass,
+		requiredPermissions(secrets.Create),
+		c.bulkOperation,
+	))
+	registerHandler("GET /approvals", c.middleware(
+		ReadClass,
+		auth.Permissions{auth.Instances: {auth.Read}},
+		c.listApprovals,
+	))
+	registerHandler("GET /approvals/{approvalId}", c.middleware(
+		ReadClass,
+		auth.Permissions{auth.Instances: {auth.Read}},
+		c.getApproval,
+	))
+	registerHandler("POST /approvals/{approvalId}/approve", c.middleware(
+		OperationClass,
+		auth.Permissions{auth.Instances: {auth.Approve}},
+		c.approve,
+	))
+	registerHandler("POST /approvals/{approvalId}/reject", c.middleware(
+		OperationClass,
+		auth.Permissions{auth.Instances: {auth.Approve}},
+		c.reject,
+	))
+	registerHandler("GET /audit/denials", c.middleware(
+		AdminClass,
+		auth.Permissions{auth.Audit: {auth.Read}},
+		c.listDenials,
+	))
+	registerHandler("GET /elevations", c.middleware(
+		AdminClass,
+		auth.Permissions{auth.Elevations: {auth.Read}},
+		c.listElevations,
+	))
+	registerHandler("POST /elevations", c.middleware(
+		AdminClass,
+		auth.Permissions{auth.Elevations: {auth.Elevate}},
+		c.elevate,
+	))
+	registerHandler("POST /elevations/{elevationId}/revoke", c.middleware(
+		AdminClass,
+		auth.Permissions{auth.Elevations: {auth.Elevate}},
+		c.revokeElevation,
+	))
+	registerHandler("GET /events", c.middleware(
+		ReadClass,
+		auth.Permissions{auth.Instances: {auth.Read}},
+		c.streamEvents,
+	))
+	registerHandler("GET /whoami", c.middleware(
+		ReadClass,
+		auth.Permissions{},
+		c.whoami,
+	))
+	registerHandler("GET /healthz", c.unauthenticated(c.healthz))
+	registerHandler("GET /readyz", c.unauthenticated(c.readyz))
+	registerHandler("GET /status", c.middleware(
+		ReadClass,
+		auth.Permissions{auth.Status: {auth.Read}},
+		c.getStatus,
+	))
+	registerHandler("GET /status/secrets", c.middleware(
+		ReadClass,
+		auth.Permissions{auth.Secrets: {auth.List}, auth.Instances: {auth.Read}},
+		c.listSecretSummaries,
+	))
+	registerHandler("GET /openapi.json", c.middleware(
+		ReadClass,
+		auth.Permissions{},
+		c.getOpenAPI,
+	))
+}
+
+// The permissions required to request an operation
+func requiredPermissions(operation secrets.OperationName) auth.Permissions {
+	if operation == secrets.Create {
+		return auth.Permissions{auth.Instances: {auth.Write}}
+	}
+	return auth.Permissions{auth.Secrets: {auth.Write}, auth.Instances: {auth.Write}}
 }
 
 func (s *Controller) listSecrets(w http.ResponseWriter, r *http.Request) {
@@ -91,8 +218,17 @@ func (s *Controller) getSecret(w http.ResponseWriter, r *http.Request) {
 
 func (s *Controller) listInstances(w http.ResponseWriter, r *http.Request) {
 	secretId := r.PathValue("secretId")
-	instances := s.secretStore.Instances(secretId)
+	err := s.writeETag(w, r, secretId)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
 	from, to, err := parseRange(*r.URL)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	instances := s.secretStore.Instances(secretId)
 	insts, err := instances.List(r.Context(), from, to)
 	if err != nil {
 		writeError(w, NewErrorResponse(http.StatusBadRequest, err))
@@ -103,25 +239,29 @@ func (s *Controller) listInstances(w http.ResponseWriter, r *http.Request) {
 
 func (s *Controller) createInstance(w http.ResponseWriter, r *http.Request) {
 	secretId := r.PathValue("secretId")
-	instances := s.secretStore.Instances(secretId)
-	var operation OperationParameters
-	err := readBody(r, &operation)
+	var operation CreateOperationParameters
+	err := readBody(w, r, &operation)
 	if err != nil {
 		writeError(w, err)
 		return
 	}
-	identity := identityFromContext(r.Context())
-	if identity == nil {
-		writeError(w, NewErrorResponse(http.StatusInternalServerError, fmt.Errorf("identity not found in context")))
+	if operation.Name != "" && operation.Name != secrets.Create {
+		writeError(w, fieldError("/name", "cannot create an instance by operation %s", operation.Name))
 		return
 	}
-	parameters := secrets.OperationParameters{
-		Env:       operation.Env,
-		Forced:    operation.Forced,
-		Reason:    operation.Reason,
-		StartedBy: identity.Principal,
+	s.requestOperation(w, r, secretId, "", secrets.Create, operation.OperationParameters)
+}
+
+func (s *Controller) getInstance(w http.ResponseWriter, r *http.Request) {
+	secretId := r.PathValue("secretId")
+	instanceId := r.PathValue("instanceId")
+	err := s.writeETag(w, r, secretId)
+	if err != nil {
+		writeError(w, err)
+		return
 	}
-	instance, err := instances.Create(r.Context(), parameters)
+	instances := s.secretStore.Instances(secretId)
+	instance, err := instances.Get(r.Context(), instanceId)
 	if err != nil {
 		writeError(w, err)
 		return
@@ -129,11 +269,15 @@ func (s *Controller) createInstance(w http.ResponseWriter, r *http.Request) {
 	writeResult(w, instance, http.StatusOK)
 }
 
-func (s *Controller) getInstance(w http.ResponseWriter, r *http.Request) {
+// getActiveInstance gives the active instance of a secret, or null if no instance is active
+func (s *Controller) getActiveInstance(w http.ResponseWriter, r *http.Request) {
 	secretId := r.PathValue("secretId")
-	instanceId := r.PathValue("instanceId")
-	instances := s.secretStore.Instances(secretId)
-	instance, err := instances.Get(r.Context(), instanceId)
+	err := s.writeETag(w, r, secretId)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	instance, err := s.secretStore.Instances(secretId).GetActive(r.Context())
 	if err != nil {
 		writeError(w, err)
 		return
@@ -141,14 +285,30 @@ func (s *Controller) getInstance(w http.ResponseWriter, r *http.Request) {
 	writeResult(w, instance, http.StatusOK)
 }
 
+// writeETag identifies the state of a secret by its latest operation, so that the caller may make further
+// operations conditional upon it. It is read before the state so that it is never newer than what the caller sees.
+func (s *Controller) writeETag(w http.ResponseWriter, r *http.Request, secretId string) error {
+	latest, err := s.secretStore.LatestOperation(r.Context(), secretId)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", FormatETag(latest))
+	return nil
+}
+
 func (s *Controller) getOperations(w http.ResponseWriter, r *http.Request) {
 	secretId := r.PathValue("secretId")
 	instanceId := r.PathValue("instanceId")
 	from, to, err := parseRange(*r.URL)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
 	instances := s.secretStore.Instances(secretId)
 	operations, err := instances.History(r.Context(), instanceId, int(from), int(to))
 	if err != nil {
 		writeError(w, err)
+		return
 	}
 	writeResult(w, operations, http.StatusOK)
 }
@@ -157,73 +317,313 @@ func (s *Controller) createOperation(w http.ResponseWriter, r *http.Request) {
 	secretId := r.PathValue("secretId")
 	instanceId := r.PathValue("instanceId")
 	var operation CreateOperationParameters
-	err := readBody(r, &operation)
+	err := readBody(w, r, &operation)
 	if err != nil {
 		writeError(w, err)
 		return
 	}
 
+	switch operation.Name {
+	case secrets.Activate, secrets.Deactivate, secrets.Destroy, secrets.Test:
+		s.requestOperation(w, r, secretId, instanceId, operation.Name, operation.OperationParameters)
+	default:
+		writeError(w, fieldError("/name", "cannot post operation %s to an instance", operation.Name))
+	}
+}
+
+// Perform an operation on behalf of the caller, or request approval for it if required by policy
+func (s *Controller) requestOperation(w http.ResponseWriter, r *http.Request, secretId string, instanceId string, name secrets.OperationName, operation OperationParameters) {
 	identity := identityFromContext(r.Context())
 	if identity == nil {
 		writeError(w, NewErrorResponse(http.StatusInternalServerError, fmt.Errorf("identity not found in context")))
 		return
 	}
+
+	idempotencyKey, ifMatch, err := readPreconditions(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	err = s.permissions.AuthoriseOperation(identity, secretId, name, operation)
+	if err != nil {
+		s.recordDenial(r, nil, name, err)
+		writeError(w, err)
+		return
+	}
+
+	required, expiry := s.permissions.RequiredApprovals(secretId, name, operation.Forced)
+	if required > 0 {
+		// the precondition is checked upon request, and again once approved, as others may operate on the secret
+		// in the meantime
+		if ifMatch != nil {
+			latest, err := s.secretStore.LatestOperation(r.Context(), secretId)
+			if err == nil && latest != *ifMatch {
+				err = &secrets.PreconditionFailedError{Expected: *ifMatch, Latest: latest}
+			}
+			if err != nil {
+				writeError(w, operationError(err))
+				return
+			}
+		}
+		approval := &secrets.Approval{
+			SecretId:       secretId,
+			InstanceId:     instanceId,
+			Name:           name,
+			Forced:         operation.Forced,
+			Reason:         operation.Reason,
+			Env:            operation.Env,
+			RequestedBy:    identity.Principal,
+			ExpiresAt:      time.Now().Add(expiry),
+			Required:       required,
+			IdempotencyKey: idempotencyKey,
+			IfMatch:        ifMatch,
+		}
+		if identity.Elevation != nil {
+			approval.ElevationId = identity.Elevation.Id
+		}
+		approval, err = s.approvalStore.Request(r.Context(), approval)
+		if err != nil {
+			writeError(w, operationError(err))
+			return
+		}
+		writeResult(w, approval, http.StatusAccepted)
+		return
+	}
+
 	parameters := secrets.OperationParameters{
-		Env:       operation.Env,
-		Forced:    operation.Forced,
-		Reason:    operation.Reason,
-		StartedBy: identity.Principal,
+		Env:            operation.Env,
+		Forced:         operation.Forced,
+		Reason:         operation.Reason,
+		StartedBy:      identity.Principal,
+		IdempotencyKey: idempotencyKey,
+		IfMatch:        ifMatch,
+		RequestId:      logging.RequestId(r.Context()),
 	}
+	if identity.Elevation != nil {
+		parameters.ElevationId = identity.Elevation.Id
+	}
+	instance, err := s.performOperation(r.Context(), secretId, instanceId, name, parameters)
+	if err != nil {
+		writeError(w, operationError(err))
+		return
+	}
+	writeResult(w, instance, http.StatusOK)
+}
+
+// operationError gives the response to a request for an operation which failed its preconditions
+func operationError(err error) error {
+	var precondition *secrets.PreconditionFailedError
+	if errors.As(err, &precondition) {
+		response := NewErrorResponse(http.StatusPreconditionFailed, err)
+		response.Headers["ETag"] = FormatETag(precondition.Latest)
+		return response
+	}
+	if errors.Is(err, secrets.ErrIdempotencyKeyReused) {
+		return NewErrorResponse(http.StatusUnprocessableEntity, err)
+	}
+	return err
+}
+
+func (s *Controller) performOperation(ctx context.Context, secretId string, instanceId string, name secrets.OperationName, parameters secrets.OperationParameters) (*secrets.Instance, error) {
 	instances := s.secretStore.Instances(secretId)
-	var instance *secrets.Instance
-	switch operation.Name {
+	switch name {
+	case secrets.Create:
+		return instances.Create(ctx, parameters)
 	case secrets.Activate:
-		instance, err = instances.Activate(r.Context(), instanceId, parameters)
+		return instances.Activate(ctx, instanceId, parameters)
 	case secrets.Deactivate:
-		instance, err = instances.Deactivate(r.Context(), instanceId, parameters)
+		return instances.Deactivate(ctx, instanceId, parameters)
 	case secrets.Destroy:
-		instance, err = instances.Destroy(r.Context(), instanceId, parameters)
+		return instances.Destroy(ctx, instanceId, parameters)
 	case secrets.Test:
-		instance, err = instances.Test(r.Context(), instanceId, parameters)
+		return instances.Test(ctx, instanceId, parameters)
 	default:
-		writeError(w, NewErrorResponse(http.StatusBadRequest, fmt.Errorf("Cannot post operation %s", operation.Name)))
+		return nil, NewErrorResponse(http.StatusBadRequest, fmt.Errorf("Cannot perform operation %s", name))
+	}
+}
+
+func (s *Controller) listApprovals(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseRange(*r.URL)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
+	approvals, err := s.approvalStore.List(r.Context(), from, to)
 	if err != nil {
 		writeError(w, err)
 		return
 	}
-	writeResult(w, instance, http.StatusOK)
+	writeResult(w, ItemsResponse[[]*secrets.Approval]{approvals}, http.StatusOK)
 }
 
-func readBody(r *http.Request, v any) error {
-	bytes, err := io.ReadAll(r.Body)
-	if err == nil {
-		err = json.Unmarshal(bytes, v)
+func (s *Controller) listDenials(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseRange(*r.URL)
+	if err != nil {
+		writeError(w, err)
+		return
 	}
+	denials, err := s.denialStore.List(r.Context(), from, to)
 	if err != nil {
-		return NewErrorResponse(http.StatusBadRequest, err)
+		writeError(w, err)
+		return
 	}
-	return nil
+	writeResult(w, ItemsResponse[[]*audit.Denial]{denials}, http.StatusOK)
+}
+
+func (s *Controller) listElevations(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseRange(*r.URL)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	elevations, err := s.elevationStore.List(r.Context(), from, to)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeResult(w, ItemsResponse[[]*auth.Elevation]{elevations}, http.StatusOK)
+}
+
+func (s *Controller) elevate(w http.ResponseWriter, r *http.Request) {
+	identity := identityFromContext(r.Context())
+	if identity == nil {
+		writeError(w, NewErrorResponse(http.StatusInternalServerError, fmt.Errorf("identity not found in context")))
+		return
+	}
+	var parameters ElevationParameters
+	err := readBody(w, r, &parameters)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	duration, err := s.permissions.AuthoriseElevation(identity, parameters)
+	if err != nil {
+		s.recordDenial(r, nil, "", err)
+		writeError(w, err)
+		return
+	}
+	elevation, err := s.elevationStore.Grant(r.Context(), &auth.Elevation{
+		Principal: identity.Principal,
+		Role:      parameters.Role,
+		Reason:    parameters.Reason,
+		ExpiresAt: time.Now().Add(duration),
+	})
+	if err != nil {
+		writeError(w, NewErrorResponse(http.StatusConflict, err))
+		return
+	}
+	writeResult(w, elevation, http.StatusOK)
+}
+
+func (s *Controller) revokeElevation(w http.ResponseWriter, r *http.Request) {
+	identity := identityFromContext(r.Context())
+	if identity == nil {
+		writeError(w, NewErrorResponse(http.StatusInternalServerError, fmt.Errorf("identity not found in context")))
+		return
+	}
+	elevationId := r.PathValue("elevationId")
+	elevation, err := s.elevationStore.Get(r.Context(), elevationId)
+	if err != nil {
+		writeError(w, NewErrorResponse(http.StatusNotFound, err))
+		return
+	}
+
+	err = s.permissions.AuthoriseRevocation(identity, elevation)
+	if err != nil {
+		s.recordDenial(r, auth.Permissions{auth.Elevations: {auth.Write}}, "", err)
+		writeError(w, err)
+		return
+	}
+	elevation, err = s.elevationStore.Revoke(r.Context(), elevationId, identity.Principal)
+	if err != nil {
+		writeError(w, NewErrorResponse(http.StatusConflict, err))
+		return
+	}
+	writeResult(w, elevation, http.StatusOK)
+}
+
+func (s *Controller) whoami(w http.ResponseWriter, r *http.Request) {
+	identity := identityFromContext(r.Context())
+	if identity == nil {
+		writeError(w, NewErrorResponse(http.StatusInternalServerError, fmt.Errorf("identity not found in context")))
+		return
+	}
+	query, err := parseOperationQuery(*r.URL)
+	if err != nil {
+		writeError(w, NewErrorResponse(http.StatusBadRequest, err))
+		return
+	}
+	writeResult(w, s.permissions.Whoami(identity, query), http.StatusOK)
 }
 
-func parseRange(url url.URL) (int, int, error) {
-	from, err := parseInt(url, "from", 32)
+func (s *Controller) getApproval(w http.ResponseWriter, r *http.Request) {
+	approvalId := r.PathValue("approvalId")
+	approval, err := s.approvalStore.Get(r.Context(), approvalId)
 	if err != nil {
-		return from, 0, err
+		writeError(w, NewErrorResponse(http.StatusNotFound, err))
+		return
 	}
-	to, err := parseInt(url, "to", 32)
-	return from, to, err
+	writeResult(w, approval, http.StatusOK)
 }
 
-func parseInt(url url.URL, name string, defaultValue int) (int, error) {
-	numString := url.Query().Get(name)
-	if numString == "" {
-		return defaultValue, nil
+func (s *Controller) approve(w http.ResponseWriter, r *http.Request) {
+	s.decide(w, r, true)
+}
+
+func (s *Controller) reject(w http.ResponseWriter, r *http.Request) {
+	s.decide(w, r, false)
+}
+
+// Record a decision upon an approval request, and perform the requested operation once it is approved
+func (s *Controller) decide(w http.ResponseWriter, r *http.Request, approved bool) {
+	approvalId := r.PathValue("approvalId")
+	var parameters DecisionParameters
+	err := readBody(w, r, &parameters)
+	if err != nil {
+		writeError(w, err)
+		return
 	}
-	num, err := strconv.ParseInt(numString, 10, 32)
+
+	identity := identityFromContext(r.Context())
+	if identity == nil {
+		writeError(w, NewErrorResponse(http.StatusInternalServerError, fmt.Errorf("identity not found in context")))
+		return
+	}
+
+	approval, err := s.approvalStore.Decide(r.Context(), approvalId, secrets.Decision{
+		Principal: identity.Principal,
+		Approved:  approved,
+		Comment:   parameters.Comment,
+	})
 	if err != nil {
-		return 0, NewErrorResponse(http.StatusBadRequest, fmt.Errorf("failed to parse '%s' - %w", name, err))
+		writeError(w, NewErrorResponse(http.StatusConflict, err))
+		return
+	}
+
+	if approval.Status == secrets.Approved {
+		// the operation is started by the request which approved it
+		parameters := approval.Parameters()
+		parameters.RequestId = logging.RequestId(r.Context())
+		instance, err := s.performOperation(r.Context(), approval.SecretId, approval.InstanceId, approval.Name, parameters)
+		if instance != nil {
+			approval.OperationNumber = &instance.Status.OperationNumber
+			if completeErr := s.approvalStore.Complete(r.Context(), approval.Id, instance.Status.OperationNumber); completeErr != nil && err == nil {
+				err = completeErr
+			}
+		} else if err != nil {
+			// the operation never started, e.g. as another instance was activated since it was requested, so the
+			// approval is not left waiting upon an operation which will never be recorded
+			approval.Status = secrets.Failed
+			if failErr := s.approvalStore.Fail(r.Context(), approval.Id); failErr != nil {
+				slog.ErrorContext(r.Context(), "failed to record approved operation not starting", "approvalId", approval.Id, "error", failErr)
+			}
+		}
+		if err != nil {
+			writeError(w, operationError(err))
+			return
+		}
 	}
-	return int(num), nil
+	writeResult(w, approval, http.StatusOK)
 }
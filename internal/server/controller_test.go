@@ -4,30 +4,74 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/eliasvasylenko/secret-agent/internal/audit"
 	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/events"
+	"github.com/eliasvasylenko/secret-agent/internal/logging"
 	"github.com/eliasvasylenko/secret-agent/internal/mocks"
 	"github.com/eliasvasylenko/secret-agent/internal/secrets"
 	"github.com/eliasvasylenko/secret-agent/internal/store"
+	"github.com/eliasvasylenko/secret-agent/internal/validation"
 	"github.com/google/go-cmp/cmp"
 )
 
 // noopLimiter and noopPermissions allow testing controller handlers without rate limiting or auth.
 type noopLimiter struct{}
 
-func (noopLimiter) Middleware(_ func(*http.Request) string, next http.Handler) http.Handler {
+func (noopLimiter) Middleware(_ LimitClass, _ func(*http.Request, error), next http.Handler) http.Handler {
 	return next
 }
 
+func (noopLimiter) Reserve(_ string, wanted uint32) (uint32, func()) {
+	return wanted, func() {}
+}
+
 type noopPermissions struct {
-	identity *auth.Identity
+	identity  *auth.Identity
+	approvals int
+	denied    error
+}
+
+func (p noopPermissions) Whoami(identity *auth.Identity, query *OperationQuery) *Whoami {
+	whoami := &Whoami{Principal: identity.Principal, Roles: identity.Roles}
+	if query != nil {
+		whoami.Check = &OperationCheck{OperationQuery: *query, Permitted: p.denied == nil}
+	}
+	return whoami
+}
+
+func (p noopPermissions) AuthoriseElevation(*auth.Identity, ElevationParameters) (time.Duration, error) {
+	return time.Hour, p.denied
+}
+
+func (p noopPermissions) AuthoriseRevocation(*auth.Identity, *auth.Elevation) error {
+	return p.denied
+}
+
+func (p noopPermissions) AuthoriseEvent(*auth.Identity, *events.Event) error {
+	return p.denied
+}
+
+func (p noopPermissions) AuthoriseOperation(*auth.Identity, string, secrets.OperationName, OperationParameters) error {
+	return p.denied
+}
+
+func (p noopPermissions) AuthoriseBulk(*auth.Identity, secrets.OperationName) error {
+	return p.denied
+}
+
+func (p noopPermissions) RequiredApprovals(string, secrets.OperationName, bool) (int, time.Duration) {
+	return p.approvals, time.Hour
 }
 
-func (p noopPermissions) Middleware(_ auth.Permissions, next http.Handler) http.Handler {
+func (p noopPermissions) Middleware(_ auth.Permissions, _ func(*http.Request, error), next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if p.identity != nil {
 			r = r.WithContext(context.WithValue(r.Context(), identityKey{}, p.identity))
@@ -43,7 +87,7 @@ func TestController_listSecrets(t *testing.T) {
 		return secrets.Secrets{"s1": {Name: "s1"}}, nil
 	})
 
-	c := NewController(mockStore, noopLimiter{}, noopPermissions{})
+	c := NewController(mockStore, nil, nil, nil, nil, noopLimiter{}, noopPermissions{})
 	mux := http.NewServeMux()
 	c.buildHandler(mux.Handle)
 
@@ -74,7 +118,7 @@ func TestController_getSecret(t *testing.T) {
 		return &secrets.Secret{Name: "my-secret"}, nil
 	})
 
-	c := NewController(mockStore, noopLimiter{}, noopPermissions{})
+	c := NewController(mockStore, nil, nil, nil, nil, noopLimiter{}, noopPermissions{})
 	mux := http.NewServeMux()
 	c.buildHandler(mux.Handle)
 
@@ -94,11 +138,57 @@ func TestController_getSecret(t *testing.T) {
 	}
 }
 
+func TestController_getActiveInstance(t *testing.T) {
+	mockStore := &mocks.MockSecrets{}
+	defer mockStore.Mock.Validate(t)
+	mockInstances := &mocks.MockInstances{}
+	defer mockInstances.Mock.Validate(t)
+	expectLatestOperation(mockStore, 7)
+	mocks.Expect(&mockStore.Mock, mockStore.Instances, func(secretId string) store.Instances {
+		if secretId != "sid" {
+			t.Errorf("Instances secretId = %q", secretId)
+		}
+		return mockInstances
+	})
+	mocks.Expect(&mockInstances.Mock, mockInstances.GetActive, func(ctx context.Context) (*secrets.Instance, error) {
+		return &secrets.Instance{Id: "iid"}, nil
+	})
+
+	c := NewController(mockStore, nil, nil, nil, nil, noopLimiter{}, noopPermissions{})
+	mux := http.NewServeMux()
+	c.buildHandler(mux.Handle)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://test/secrets/sid/active", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200", rec.Code)
+	}
+	var got secrets.Instance
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.Id != "iid" {
+		t.Errorf("instance id = %q", got.Id)
+	}
+	if etag := rec.Header().Get("ETag"); etag != `"7"` {
+		t.Errorf("ETag = %s, want the latest operation", etag)
+	}
+}
+
+// expectLatestOperation expects the latest operation on a secret to be read for its entity tag
+func expectLatestOperation(mockStore *mocks.MockSecrets, latest int) {
+	mocks.Expect(&mockStore.Mock, mockStore.LatestOperation, func(ctx context.Context, secretId string) (int, error) {
+		return latest, nil
+	})
+}
+
 func TestController_listInstances(t *testing.T) {
 	mockStore := &mocks.MockSecrets{}
 	defer mockStore.Mock.Validate(t)
 	mockInstances := &mocks.MockInstances{}
 	defer mockInstances.Mock.Validate(t)
+	expectLatestOperation(mockStore, 7)
 	mocks.Expect(&mockStore.Mock, mockStore.Instances, func(secretId string) store.Instances {
 		if secretId != "sid" {
 			t.Errorf("Instances secretId = %q", secretId)
@@ -109,7 +199,7 @@ func TestController_listInstances(t *testing.T) {
 		return secrets.Instances{}, nil
 	})
 
-	c := NewController(mockStore, noopLimiter{}, noopPermissions{})
+	c := NewController(mockStore, nil, nil, nil, nil, noopLimiter{}, noopPermissions{})
 	mux := http.NewServeMux()
 	c.buildHandler(mux.Handle)
 
@@ -134,6 +224,7 @@ func TestController_getInstance(t *testing.T) {
 	defer mockStore.Mock.Validate(t)
 	mockInstances := &mocks.MockInstances{}
 	defer mockInstances.Mock.Validate(t)
+	expectLatestOperation(mockStore, 7)
 	mocks.Expect(&mockStore.Mock, mockStore.Instances, func(secretId string) store.Instances {
 		return mockInstances
 	})
@@ -144,7 +235,7 @@ func TestController_getInstance(t *testing.T) {
 		return &secrets.Instance{Id: "i1", Secret: secrets.Secret{Name: "s1"}, Status: secrets.Status{}}, nil
 	})
 
-	c := NewController(mockStore, noopLimiter{}, noopPermissions{})
+	c := NewController(mockStore, nil, nil, nil, nil, noopLimiter{}, noopPermissions{})
 	mux := http.NewServeMux()
 	c.buildHandler(mux.Handle)
 
@@ -179,10 +270,13 @@ func TestController_createInstance(t *testing.T) {
 		if params.Reason != "create-reason" {
 			t.Errorf("Reason = %q", params.Reason)
 		}
+		if params.RequestId != "req-1" {
+			t.Errorf("RequestId = %q, want req-1", params.RequestId)
+		}
 		return &secrets.Instance{Id: "new-id", Secret: secrets.Secret{Name: "s1"}, Status: secrets.Status{}}, nil
 	})
 
-	c := NewController(mockStore, noopLimiter{}, noopPermissions{identity: &auth.Identity{Principal: "test-user"}})
+	c := NewController(mockStore, nil, nil, nil, nil, noopLimiter{}, noopPermissions{identity: &auth.Identity{Principal: "test-user"}})
 	mux := http.NewServeMux()
 	c.buildHandler(mux.Handle)
 
@@ -190,6 +284,7 @@ func TestController_createInstance(t *testing.T) {
 	rec := httptest.NewRecorder()
 	req := httptest.NewRequest(http.MethodPost, "http://test/secrets/sid/instances", bytes.NewReader([]byte(body)))
 	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(logging.WithRequestId(req.Context(), "req-1"))
 	mux.ServeHTTP(rec, req)
 
 	if rec.Code != http.StatusOK {
@@ -290,7 +385,7 @@ func TestController_createOperation(t *testing.T) {
 			})
 			tt.expect(mockInstances, tt.reason)
 
-			c := NewController(mockStore, noopLimiter{}, noopPermissions{identity: &auth.Identity{Principal: "op-user"}})
+			c := NewController(mockStore, nil, nil, nil, nil, noopLimiter{}, noopPermissions{identity: &auth.Identity{Principal: "op-user"}})
 			mux := http.NewServeMux()
 			c.buildHandler(mux.Handle)
 
@@ -314,6 +409,60 @@ func TestController_createOperation(t *testing.T) {
 	}
 }
 
+func TestController_createOperation_invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		fields validation.Errors
+	}{
+		{
+			name:   "misspelled field",
+			path:   "/secrets/sid/instances/i1/operations",
+			body:   `{"name":"destroy","forcd":true,"reason":"r"}`,
+			fields: validation.Errors{{Pointer: "/forcd", Message: "unknown field"}},
+		},
+		{
+			name:   "create posted to instance",
+			path:   "/secrets/sid/instances/i1/operations",
+			body:   `{"name":"create"}`,
+			fields: validation.Errors{{Pointer: "/name", Message: "cannot post operation create to an instance"}},
+		},
+		{
+			name:   "other operation posted to instances",
+			path:   "/secrets/sid/instances",
+			body:   `{"name":"destroy"}`,
+			fields: validation.Errors{{Pointer: "/name", Message: "cannot create an instance by operation destroy"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// no operation is performed, so the store is never asked for instances
+			mockStore := &mocks.MockSecrets{}
+			defer mockStore.Mock.Validate(t)
+
+			c := NewController(mockStore, nil, nil, nil, nil, noopLimiter{}, noopPermissions{identity: &auth.Identity{Principal: "op-user"}})
+			mux := http.NewServeMux()
+			c.buildHandler(mux.Handle)
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "http://test"+tt.path, bytes.NewReader([]byte(tt.body)))
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400\nbody: %s", rec.Code, rec.Body.Bytes())
+			}
+			var got ErrorResponse
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if diff := cmp.Diff(tt.fields, got.HttpError.Fields); diff != "" {
+				t.Errorf("fields mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
 func TestController_getOperations(t *testing.T) {
 	mockStore := &mocks.MockSecrets{}
 	defer mockStore.Mock.Validate(t)
@@ -329,7 +478,7 @@ func TestController_getOperations(t *testing.T) {
 		return []*secrets.Operation{}, nil
 	})
 
-	c := NewController(mockStore, noopLimiter{}, noopPermissions{})
+	c := NewController(mockStore, nil, nil, nil, nil, noopLimiter{}, noopPermissions{})
 	mux := http.NewServeMux()
 	c.buildHandler(mux.Handle)
 
@@ -348,3 +497,345 @@ func TestController_getOperations(t *testing.T) {
 		t.Errorf("operations = %v", got)
 	}
 }
+
+func TestController_createOperation_requiresApproval(t *testing.T) {
+	mockStore := &mocks.MockSecrets{}
+	defer mockStore.Mock.Validate(t)
+	mockApprovals := &mocks.MockApprovals{}
+	defer mockApprovals.Mock.Validate(t)
+	mocks.Expect(&mockApprovals.Mock, mockApprovals.Request, func(ctx context.Context, approval *secrets.Approval) (*secrets.Approval, error) {
+		if approval.SecretId != "sid" || approval.InstanceId != "i1" || approval.Name != secrets.Destroy {
+			t.Errorf("Request approval = %+v", approval)
+		}
+		if approval.RequestedBy != "op-user" || approval.Required != 2 || approval.Reason != "tidy" {
+			t.Errorf("Request approval = %+v", approval)
+		}
+		if approval.ElevationId != "e1" {
+			t.Errorf("Request approval ElevationId = %q, want the elevation of the requester", approval.ElevationId)
+		}
+		approval.Id = "a1"
+		approval.Status = secrets.Pending
+		return approval, nil
+	})
+
+	identity := &auth.Identity{Principal: "op-user", Elevation: &auth.Elevation{Id: "e1"}}
+	c := NewController(mockStore, mockApprovals, nil, nil, nil, noopLimiter{}, noopPermissions{identity: identity, approvals: 2})
+	mux := http.NewServeMux()
+	c.buildHandler(mux.Handle)
+
+	body := `{"name":"destroy","env":{},"forced":false,"reason":"tidy"}`
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://test/secrets/sid/instances/i1/operations", bytes.NewReader([]byte(body)))
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("status = %d, want 202\nbody: %s", rec.Code, rec.Body.Bytes())
+	}
+	var got secrets.Approval
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.Id != "a1" || got.Status != secrets.Pending {
+		t.Errorf("approval = %+v", got)
+	}
+}
+
+func TestController_createOperation_preconditions(t *testing.T) {
+	tests := []struct {
+		name     string
+		ifMatch  string
+		storeErr error
+		wantCode int
+		wantETag string
+	}{
+		{"applied", `"9"`, nil, http.StatusOK, ""},
+		{"any", "*", nil, http.StatusOK, ""},
+		{"changed", `"8"`, &secrets.PreconditionFailedError{Expected: 8, Latest: 9}, http.StatusPreconditionFailed, `"9"`},
+		{"key reused", `"9"`, secrets.ErrIdempotencyKeyReused, http.StatusUnprocessableEntity, ""},
+		{"invalid", "9", nil, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStore := &mocks.MockSecrets{}
+			defer mockStore.Mock.Validate(t)
+			mockInstances := &mocks.MockInstances{}
+			defer mockInstances.Mock.Validate(t)
+			if tt.wantCode != http.StatusBadRequest {
+				mocks.Expect(&mockStore.Mock, mockStore.Instances, func(secretId string) store.Instances {
+					return mockInstances
+				})
+				mocks.Expect(&mockInstances.Mock, mockInstances.Activate, func(ctx context.Context, instanceId string, params secrets.OperationParameters) (*secrets.Instance, error) {
+					if params.IdempotencyKey != "k1" {
+						t.Errorf("IdempotencyKey = %q, want k1", params.IdempotencyKey)
+					}
+					if tt.ifMatch == "*" && params.IfMatch != nil || tt.ifMatch != "*" && (params.IfMatch == nil || FormatETag(*params.IfMatch) != tt.ifMatch) {
+						t.Errorf("IfMatch = %v, want %s", params.IfMatch, tt.ifMatch)
+					}
+					return &secrets.Instance{Id: instanceId}, tt.storeErr
+				})
+			}
+
+			c := NewController(mockStore, nil, nil, nil, nil, noopLimiter{}, noopPermissions{identity: &auth.Identity{Principal: "op-user"}})
+			mux := http.NewServeMux()
+			c.buildHandler(mux.Handle)
+
+			body := `{"name":"activate","env":{},"forced":false,"reason":"rotate"}`
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "http://test/secrets/sid/instances/i1/operations", bytes.NewReader([]byte(body)))
+			req.Header.Set("Idempotency-Key", "k1")
+			req.Header.Set("If-Match", tt.ifMatch)
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d\nbody: %s", rec.Code, tt.wantCode, rec.Body.Bytes())
+			}
+			if etag := rec.Header().Get("ETag"); etag != tt.wantETag {
+				t.Errorf("ETag = %s, want %s", etag, tt.wantETag)
+			}
+		})
+	}
+}
+
+func TestController_createOperation_requiresApproval_precondition(t *testing.T) {
+	mockStore := &mocks.MockSecrets{}
+	defer mockStore.Mock.Validate(t)
+	expectLatestOperation(mockStore, 9)
+
+	c := NewController(mockStore, &mocks.MockApprovals{}, nil, nil, nil, noopLimiter{}, noopPermissions{identity: &auth.Identity{Principal: "op-user"}, approvals: 2})
+	mux := http.NewServeMux()
+	c.buildHandler(mux.Handle)
+
+	body := `{"name":"destroy","env":{},"forced":false,"reason":"tidy"}`
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://test/secrets/sid/instances/i1/operations", bytes.NewReader([]byte(body)))
+	req.Header.Set("If-Match", `"8"`)
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("status = %d, want 412\nbody: %s", rec.Code, rec.Body.Bytes())
+	}
+}
+
+func TestController_approve(t *testing.T) {
+	approval := &secrets.Approval{
+		Id:          "a1",
+		SecretId:    "sid",
+		InstanceId:  "i1",
+		Name:        secrets.Destroy,
+		Reason:      "tidy",
+		RequestedBy: "requester",
+		Required:    1,
+	}
+	tests := []struct {
+		name       string
+		path       string
+		status     secrets.ApprovalStatus
+		wantDecide bool
+		performs   bool
+	}{
+		{"partially approved", "/approve", secrets.Pending, true, false},
+		{"approved", "/approve", secrets.Approved, true, true},
+		{"rejected", "/reject", secrets.Rejected, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStore := &mocks.MockSecrets{}
+			defer mockStore.Mock.Validate(t)
+			mockInstances := &mocks.MockInstances{}
+			defer mockInstances.Mock.Validate(t)
+			mockApprovals := &mocks.MockApprovals{}
+			defer mockApprovals.Mock.Validate(t)
+			mocks.Expect(&mockApprovals.Mock, mockApprovals.Decide, func(ctx context.Context, approvalId string, decision secrets.Decision) (*secrets.Approval, error) {
+				if approvalId != "a1" || decision.Principal != "approver" || decision.Approved != tt.wantDecide || decision.Comment != "lgtm" {
+					t.Errorf("Decide(%s, %+v)", approvalId, decision)
+				}
+				decided := *approval
+				decided.Status = tt.status
+				return &decided, nil
+			})
+			if tt.performs {
+				mocks.Expect(&mockStore.Mock, mockStore.Instances, func(secretId string) store.Instances {
+					return mockInstances
+				})
+				mocks.Expect(&mockInstances.Mock, mockInstances.Destroy, func(ctx context.Context, instanceId string, params secrets.OperationParameters) (*secrets.Instance, error) {
+					if instanceId != "i1" || params.StartedBy != "requester" || params.ApprovalId != "a1" || params.Reason != "tidy" {
+						t.Errorf("Destroy(%s, %+v)", instanceId, params)
+					}
+					return &secrets.Instance{Id: "i1", Status: secrets.Status{OperationNumber: 7}}, nil
+				})
+				mocks.Expect(&mockApprovals.Mock, mockApprovals.Complete, func(ctx context.Context, approvalId string, operationNumber int) error {
+					if approvalId != "a1" || operationNumber != 7 {
+						t.Errorf("Complete(%s, %d)", approvalId, operationNumber)
+					}
+					return nil
+				})
+			}
+
+			c := NewController(mockStore, mockApprovals, nil, nil, nil, noopLimiter{}, noopPermissions{identity: &auth.Identity{Principal: "approver"}})
+			mux := http.NewServeMux()
+			c.buildHandler(mux.Handle)
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "http://test/approvals/a1"+tt.path, bytes.NewReader([]byte(`{"comment":"lgtm"}`)))
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Errorf("status = %d, want 200\nbody: %s", rec.Code, rec.Body.Bytes())
+			}
+			var got secrets.Approval
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if got.Status != tt.status {
+				t.Errorf("status = %s, want %s", got.Status, tt.status)
+			}
+			if tt.performs && (got.OperationNumber == nil || *got.OperationNumber != 7) {
+				t.Errorf("operationNumber = %v, want 7", got.OperationNumber)
+			}
+		})
+	}
+}
+
+func TestController_approve_operationNotStarted(t *testing.T) {
+	mockStore := &mocks.MockSecrets{}
+	defer mockStore.Mock.Validate(t)
+	mockInstances := &mocks.MockInstances{}
+	defer mockInstances.Mock.Validate(t)
+	mockApprovals := &mocks.MockApprovals{}
+	defer mockApprovals.Mock.Validate(t)
+	mocks.Expect(&mockApprovals.Mock, mockApprovals.Decide, func(ctx context.Context, approvalId string, decision secrets.Decision) (*secrets.Approval, error) {
+		return &secrets.Approval{Id: "a1", SecretId: "sid", InstanceId: "i2", Name: secrets.Activate, RequestedBy: "requester", Status: secrets.Approved}, nil
+	})
+	mocks.Expect(&mockStore.Mock, mockStore.Instances, func(secretId string) store.Instances {
+		return mockInstances
+	})
+	mocks.Expect(&mockInstances.Mock, mockInstances.Activate, func(ctx context.Context, instanceId string, params secrets.OperationParameters) (*secrets.Instance, error) {
+		return nil, errors.New("cannot activate when instance i1 is active")
+	})
+	mocks.Expect(&mockApprovals.Mock, mockApprovals.Fail, func(ctx context.Context, approvalId string) error {
+		if approvalId != "a1" {
+			t.Errorf("Fail(%s)", approvalId)
+		}
+		return nil
+	})
+
+	c := NewController(mockStore, mockApprovals, nil, nil, nil, noopLimiter{}, noopPermissions{identity: &auth.Identity{Principal: "approver"}})
+	mux := http.NewServeMux()
+	c.buildHandler(mux.Handle)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://test/approvals/a1/approve", bytes.NewReader([]byte(`{}`)))
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code == http.StatusOK {
+		t.Errorf("status = 200, want an error\nbody: %s", rec.Body.Bytes())
+	}
+}
+
+func TestController_approve_precondition(t *testing.T) {
+	mockStore := &mocks.MockSecrets{}
+	defer mockStore.Mock.Validate(t)
+	mockInstances := &mocks.MockInstances{}
+	defer mockInstances.Mock.Validate(t)
+	mockApprovals := &mocks.MockApprovals{}
+	defer mockApprovals.Mock.Validate(t)
+	ifMatch := 8
+	mocks.Expect(&mockApprovals.Mock, mockApprovals.Decide, func(ctx context.Context, approvalId string, decision secrets.Decision) (*secrets.Approval, error) {
+		return &secrets.Approval{Id: "a1", SecretId: "sid", InstanceId: "i1", Name: secrets.Destroy, RequestedBy: "requester", Status: secrets.Approved, IfMatch: &ifMatch}, nil
+	})
+	mocks.Expect(&mockStore.Mock, mockStore.Instances, func(secretId string) store.Instances {
+		return mockInstances
+	})
+	mocks.Expect(&mockInstances.Mock, mockInstances.Destroy, func(ctx context.Context, instanceId string, params secrets.OperationParameters) (*secrets.Instance, error) {
+		if params.IfMatch == nil || *params.IfMatch != ifMatch {
+			t.Errorf("IfMatch = %v, want %d", params.IfMatch, ifMatch)
+		}
+		return nil, &secrets.PreconditionFailedError{Expected: ifMatch, Latest: 9}
+	})
+	mocks.Expect(&mockApprovals.Mock, mockApprovals.Fail, func(ctx context.Context, approvalId string) error {
+		return nil
+	})
+
+	c := NewController(mockStore, mockApprovals, nil, nil, nil, noopLimiter{}, noopPermissions{identity: &auth.Identity{Principal: "approver"}})
+	mux := http.NewServeMux()
+	c.buildHandler(mux.Handle)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://test/approvals/a1/approve", bytes.NewReader([]byte(`{}`)))
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusPreconditionFailed || rec.Header().Get("ETag") != `"9"` {
+		t.Errorf("status = %d, ETag = %s, want 412 with the latest operation\nbody: %s", rec.Code, rec.Header().Get("ETag"), rec.Body.Bytes())
+	}
+}
+
+func TestController_createOperation_deniedByPolicy(t *testing.T) {
+	mockStore := &mocks.MockSecrets{}
+	defer mockStore.Mock.Validate(t)
+	mockDenials := &mocks.MockDenials{}
+	defer mockDenials.Mock.Validate(t)
+	mocks.Expect(&mockDenials.Mock, mockDenials.Record, func(ctx context.Context, denial *audit.Denial) error {
+		want := &audit.Denial{
+			Principal:  "op-user",
+			Route:      "POST /secrets/{secretId}/instances/{instanceId}/operations",
+			SecretId:   "sid",
+			InstanceId: "i1",
+			Operation:  secrets.Destroy,
+			Reason:     "destroy of sid requires a reason",
+		}
+		if diff := cmp.Diff(want, denial); diff != "" {
+			t.Errorf("Record denial mismatch (-want +got):\n%s", diff)
+		}
+		return nil
+	})
+
+	denied := NewErrorResponse(http.StatusForbidden, fmt.Errorf("destroy of sid requires a reason"))
+	c := NewController(mockStore, nil, mockDenials, nil, nil, noopLimiter{}, noopPermissions{identity: &auth.Identity{Principal: "op-user"}, denied: denied})
+	mux := http.NewServeMux()
+	c.buildHandler(mux.Handle)
+
+	body := `{"name":"destroy","env":{},"forced":false,"reason":""}`
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://test/secrets/sid/instances/i1/operations", bytes.NewReader([]byte(body)))
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403\nbody: %s", rec.Code, rec.Body.Bytes())
+	}
+}
+
+func TestController_whoami(t *testing.T) {
+	c := NewController(&mocks.MockSecrets{}, nil, nil, nil, nil, noopLimiter{}, noopPermissions{identity: &auth.Identity{Principal: "op-user", Roles: auth.ClaimedRoles{"reader"}}})
+	mux := http.NewServeMux()
+	c.buildHandler(mux.Handle)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://test/whoami?secretId=sid&operation=destroy&forced=true", nil)
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200\nbody: %s", rec.Code, rec.Body.Bytes())
+	}
+	var got Whoami
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	want := Whoami{
+		Principal: "op-user",
+		Roles:     auth.ClaimedRoles{"reader"},
+		Check: &OperationCheck{
+			OperationQuery: OperationQuery{SecretId: "sid", Operation: secrets.Destroy, Forced: true},
+			Permitted:      true,
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("whoami mismatch (-want +got):\n%s", diff)
+	}
+
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "http://test/whoami?operation=destroy", nil)
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status without secretId = %d, want 400", rec.Code)
+	}
+}
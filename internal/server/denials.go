@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/audit"
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/marshal"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+	"github.com/eliasvasylenko/secret-agent/internal/store"
+)
+
+// Number of denials which may wait to be written, beyond which further denials are dropped
+const denialQueueSize = 256
+
+// Interval between deletions of denials older than the retention period
+const denialPruneInterval = time.Hour
+
+// The rate at which the denials of each peer are recorded, beyond which they are only counted, so that a caller
+// cannot flood the store by making requests which are denied
+var denialLimit = RateLimit{Limit: 60, Window: marshal.Duration(time.Minute)}
+
+// The class under which the denials of each peer are limited
+const deniedClass LimitClass = "denied"
+
+// A DenialLog records denied attempts in a store, deleting those older than the retention period,
+// and mirrors them to an optional sink as JSON lines. Denials are written in the background by Run.
+type DenialLog struct {
+	store     store.Denials
+	retention time.Duration
+	sink      io.Writer
+	limiter   *Limiter
+	queue     chan *audit.Denial
+}
+
+func NewDenialLog(store store.Denials, retention time.Duration, sink io.Writer) *DenialLog {
+	return &DenialLog{
+		store:     store,
+		retention: retention,
+		sink:      sink,
+		limiter:   NewLimiter(denialLimit, RateLimits{}, 0),
+		queue:     make(chan *audit.Denial, denialQueueSize),
+	}
+}
+
+// Record queues the denial to be written, unless the peer which was denied is over its limit or the queue is full
+func (l *DenialLog) Record(ctx context.Context, denial *audit.Denial) error {
+	if _, err := l.limiter.Allow(denialPeer(denial), nil, deniedClass); err != nil {
+		denialsDropped.Inc("rate")
+		return nil
+	}
+	select {
+	case l.queue <- denial:
+	default:
+		denialsDropped.Inc("queue")
+	}
+	return nil
+}
+
+// Run writes queued denials and periodically prunes those older than the retention period, until the context is
+// cancelled, after which any denials still queued are written before it returns
+func (l *DenialLog) Run(ctx context.Context) {
+	prune := time.NewTicker(denialPruneInterval)
+	defer prune.Stop()
+	l.prune(ctx)
+	for {
+		select {
+		case denial := <-l.queue:
+			l.write(ctx, denial)
+		case <-prune.C:
+			l.prune(ctx)
+		case <-ctx.Done():
+			ctx = context.WithoutCancel(ctx)
+			for {
+				select {
+				case denial := <-l.queue:
+					l.write(ctx, denial)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (l *DenialLog) write(ctx context.Context, denial *audit.Denial) {
+	err := l.store.Record(ctx, denial)
+	if err == nil && l.sink != nil {
+		err = json.NewEncoder(l.sink).Encode(denial)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to record denial", "error", err)
+	}
+}
+
+func (l *DenialLog) prune(ctx context.Context) {
+	if l.retention <= 0 {
+		return
+	}
+	if _, err := l.store.Prune(ctx, time.Now().Add(-l.retention)); err != nil {
+		slog.ErrorContext(ctx, "failed to prune denials", "error", err)
+	}
+}
+
+// The peer by which denials are limited, identified by its uid where its credentials are known, so that the
+// requests of a peer which could not be authenticated are limited alike
+func denialPeer(denial *audit.Denial) string {
+	if denial.Credentials != nil {
+		return fmt.Sprintf("uid:%d", denial.Credentials.Uid)
+	}
+	return denial.Principal
+}
+
+func (l *DenialLog) List(ctx context.Context, from int, to int) ([]*audit.Denial, error) {
+	return l.store.List(ctx, from, to)
+}
+
+func (l *DenialLog) Prune(ctx context.Context, before time.Time) (int, error) {
+	return l.store.Prune(ctx, before)
+}
+
+// Record a request which was denied, along with what is known of the identity of the caller
+func (c *Controller) recordDenial(r *http.Request, permission auth.Permissions, operation secrets.OperationName, err error) {
+	c.storeDenial(r.Context(), newDenial(r, permission, operation, err))
+}
+
+// newDenial describes a request which was denied, identifying the secret and instance by the path of the request
+func newDenial(r *http.Request, permission auth.Permissions, operation secrets.OperationName, err error) *audit.Denial {
+	reason := err.Error()
+	var response *ErrorResponse
+	if errors.As(err, &response) {
+		reason = response.HttpError.Message
+	}
+	denial := &audit.Denial{
+		Route:      r.Pattern,
+		SecretId:   r.PathValue("secretId"),
+		InstanceId: r.PathValue("instanceId"),
+		Operation:  operation,
+		Permission: permission,
+		Listener:   listenerFromContext(r.Context()),
+		Reason:     reason,
+	}
+	if identity := identityFromContext(r.Context()); identity != nil {
+		denial.Principal = identity.Principal
+		denial.Credentials = identity.Credentials
+		denial.Roles = identity.Roles
+	}
+	return denial
+}
+
+func (c *Controller) storeDenial(ctx context.Context, denial *audit.Denial) {
+	if err := c.denialStore.Record(ctx, denial); err != nil {
+		slog.ErrorContext(ctx, "failed to record denial", "error", err)
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/audit"
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/mocks"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDenialLog_Run(t *testing.T) {
+	deniedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	mockDenials := &mocks.MockDenials{}
+	defer mockDenials.Mock.Validate(t)
+	mocks.Expect(&mockDenials.Mock, mockDenials.Prune, func(ctx context.Context, before time.Time) (int, error) {
+		if want := time.Now().Add(-time.Hour); before.Sub(want).Abs() > time.Minute {
+			t.Errorf("Prune before = %v, want %v", before, want)
+		}
+		return 0, nil
+	})
+	mocks.Expect(&mockDenials.Mock, mockDenials.Record, func(ctx context.Context, denial *audit.Denial) error {
+		denial.Id = 7
+		denial.DeniedAt = deniedAt
+		return nil
+	})
+
+	var sink bytes.Buffer
+	log := NewDenialLog(mockDenials, time.Hour, &sink)
+	err := log.Record(context.Background(), &audit.Denial{Route: "GET /secrets", Reason: "denied"})
+	if err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if sink.Len() != 0 {
+		t.Errorf("sink = %s, want nothing written until run", sink.Bytes())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	log.Run(ctx)
+
+	var got audit.Denial
+	if err := json.Unmarshal(sink.Bytes(), &got); err != nil {
+		t.Fatalf("sink should hold a JSON line: %v\noutput: %s", err, sink.Bytes())
+	}
+	want := audit.Denial{Id: 7, Route: "GET /secrets", Reason: "denied", DeniedAt: deniedAt}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("sink mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDenialLog_Record_limitedPerPeer(t *testing.T) {
+	log := NewDenialLog(&mocks.MockDenials{}, 0, nil)
+	deny := func(uid uint32) {
+		log.Record(context.Background(), &audit.Denial{Credentials: &auth.Credentials{Uid: uid}, Reason: "denied"})
+	}
+	for range denialLimit.Limit + 10 {
+		deny(1000)
+	}
+	deny(1001)
+
+	if queued := len(log.queue); queued != int(denialLimit.Limit)+1 {
+		t.Errorf("queued = %d, want %d from the limited peer and 1 from another", queued, denialLimit.Limit+1)
+	}
+}
+
+// denyingLimiter rejects every request
+type denyingLimiter struct{}
+
+func (denyingLimiter) Middleware(_ LimitClass, denied func(*http.Request, error), next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := NewErrorResponse(http.StatusTooManyRequests, errors.New("rate limit exceeded"))
+		denied(r, err)
+		writeError(w, err)
+	})
+}
+
+func (denyingLimiter) Reserve(string, uint32) (uint32, func()) {
+	return 0, func() {}
+}
+
+func TestController_middleware_recordsDenial(t *testing.T) {
+	identity := &auth.Identity{
+		Principal:   "linux:bob/1000",
+		Roles:       auth.ClaimedRoles{"reader"},
+		Credentials: &auth.Credentials{Uid: 1000, Gid: 100, Pid: 42},
+	}
+	mockDenials := &mocks.MockDenials{}
+	defer mockDenials.Mock.Validate(t)
+	mocks.Expect(&mockDenials.Mock, mockDenials.Record, func(ctx context.Context, denial *audit.Denial) error {
+		want := &audit.Denial{
+			Principal:   "linux:bob/1000",
+			Credentials: &auth.Credentials{Uid: 1000, Gid: 100, Pid: 42},
+			Route:       "GET /secrets/{secretId}",
+			SecretId:    "sid",
+			Permission:  auth.Permissions{auth.Secrets: {auth.Read}},
+			Roles:       auth.ClaimedRoles{"reader"},
+			Reason:      "rate limit exceeded",
+		}
+		if diff := cmp.Diff(want, denial); diff != "" {
+			t.Errorf("Record denial mismatch (-want +got):\n%s", diff)
+		}
+		return nil
+	})
+
+	c := NewController(&mocks.MockSecrets{}, nil, mockDenials, nil, nil, denyingLimiter{}, noopPermissions{identity: identity})
+	mux := http.NewServeMux()
+	c.buildHandler(mux.Handle)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://test/secrets/sid", nil)
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want 429\nbody: %s", rec.Code, rec.Body.Bytes())
+	}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/marshal"
+	"github.com/eliasvasylenko/secret-agent/internal/validation"
+)
+
+// The maximum duration of an elevation, unless a policy specifies otherwise
+const defaultElevationDuration = time.Hour
+
+// An elevation policy allows principals claiming certain roles to elevate to another role for a bounded time
+type ElevationPolicy struct {
+	// The role which may be elevated to
+	Role auth.RoleName `json:"role"`
+
+	// Claimed roles which are allowed to elevate to the role
+	From auth.ClaimedRoles `json:"from"`
+
+	// The maximum duration of an elevation
+	MaxDuration marshal.Duration `json:"maxDuration,omitempty"`
+}
+
+type ElevationPolicies []ElevationPolicy
+
+// ElevationParameters are the parameters for requesting an elevation
+type ElevationParameters struct {
+	Role     auth.RoleName    `json:"role" openapi:"required"`
+	Reason   string           `json:"reason" openapi:"required"`
+	Duration marshal.Duration `json:"duration,omitempty"`
+}
+
+func (p *ElevationParameters) validate(v *validation.Validator) {
+	if p.Role == "" {
+		v.Field("/role", "is required")
+	}
+	if p.Reason == "" {
+		v.Field("/reason", "is required")
+	}
+	if p.Duration < 0 {
+		v.Field("/duration", "must not be negative")
+	}
+}
+
+// AuthoriseElevation checks that the caller may elevate to a role, returning the duration of the elevation.
+// The duration is the longest allowed by a matching policy if none is requested.
+func (p *Permissions) AuthoriseElevation(identity *auth.Identity, parameters ElevationParameters) (time.Duration, error) {
+	if parameters.Reason == "" {
+		return 0, NewErrorResponse(http.StatusBadRequest, errors.New("elevation requires a reason"))
+	}
+	if _, ok := p.roles(identity)[parameters.Role]; !ok {
+		return 0, NewErrorResponse(http.StatusBadRequest, fmt.Errorf("unknown role %s", parameters.Role))
+	}
+
+	maxDuration := time.Duration(0)
+	for _, policy := range p.Elevations {
+		if policy.Role != parameters.Role || !slices.ContainsFunc(policy.From, func(role auth.RoleName) bool {
+			return slices.Contains(identity.Roles, role)
+		}) {
+			continue
+		}
+		policyDuration := time.Duration(policy.MaxDuration)
+		if policyDuration <= 0 {
+			policyDuration = defaultElevationDuration
+		}
+		maxDuration = max(maxDuration, policyDuration)
+	}
+	if maxDuration == 0 {
+		return 0, NewErrorResponse(http.StatusForbidden, fmt.Errorf("elevation to %s not permitted with claimed roles %v", parameters.Role, identity.Roles))
+	}
+
+	duration := time.Duration(parameters.Duration)
+	if duration <= 0 {
+		return maxDuration, nil
+	}
+	if duration > maxDuration {
+		return 0, NewErrorResponse(http.StatusForbidden, fmt.Errorf("elevation to %s is limited to %s", parameters.Role, maxDuration))
+	}
+	return duration, nil
+}
+
+// AuthoriseRevocation checks that the caller may revoke an elevation, which is
+// permitted for their own elevations or with the write permission on elevations.
+func (p *Permissions) AuthoriseRevocation(identity *auth.Identity, elevation *auth.Elevation) error {
+	if elevation.Principal == identity.Principal {
+		return nil
+	}
+	err := p.assertPermission(identity, auth.Permissions{auth.Elevations: {auth.Write}})
+	if err != nil {
+		return NewErrorResponse(http.StatusForbidden, err)
+	}
+	return nil
+}
+
+// elevate adds the role of the active elevation of the caller, if any, to their identity
+func (p *Permissions) elevate(r *http.Request, identity *auth.Identity) error {
+	if p.elevationStore == nil {
+		return nil
+	}
+	elevation, err := p.elevationStore.Active(r.Context(), identity.Principal)
+	if err != nil || elevation == nil {
+		return err
+	}
+	identity.Roles = append(identity.Roles, elevation.Role)
+	identity.Elevation = elevation
+	return nil
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/audit"
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/marshal"
+	"github.com/eliasvasylenko/secret-agent/internal/mocks"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+	"github.com/eliasvasylenko/secret-agent/internal/store"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPermissions_AuthoriseElevation(t *testing.T) {
+	var permissions Permissions
+	err := json.Unmarshal([]byte(`{
+		"roles": {
+			"reader": {"permissions": {"secrets": "read"}},
+			"oncall": {"permissions": {"secrets": "read"}},
+			"operator": {"permissions": {"instances": "write"}}
+		},
+		"claims": {},
+		"elevations": [{"role": "operator", "from": ["oncall"], "maxDuration": "30m"}]
+	}`), &permissions)
+	if err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	oncall := &auth.Identity{Principal: "alice", Roles: auth.ClaimedRoles{"reader", "oncall"}}
+	reader := &auth.Identity{Principal: "bob", Roles: auth.ClaimedRoles{"reader"}}
+
+	tests := []struct {
+		name         string
+		identity     *auth.Identity
+		parameters   ElevationParameters
+		wantDuration time.Duration
+		wantStatus   int
+	}{
+		{"maximum duration", oncall, ElevationParameters{Role: "operator", Reason: "incident"}, 30 * time.Minute, 0},
+		{"requested duration", oncall, ElevationParameters{Role: "operator", Reason: "incident", Duration: marshal.Duration(10 * time.Minute)}, 10 * time.Minute, 0},
+		{"too long", oncall, ElevationParameters{Role: "operator", Reason: "incident", Duration: marshal.Duration(time.Hour)}, 0, http.StatusForbidden},
+		{"missing reason", oncall, ElevationParameters{Role: "operator"}, 0, http.StatusBadRequest},
+		{"unknown role", oncall, ElevationParameters{Role: "root", Reason: "incident"}, 0, http.StatusBadRequest},
+		{"not permitted", reader, ElevationParameters{Role: "operator", Reason: "incident"}, 0, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			duration, err := permissions.AuthoriseElevation(tt.identity, tt.parameters)
+			var response *ErrorResponse
+			if tt.wantStatus == 0 && err != nil {
+				t.Fatalf("AuthoriseElevation: %v", err)
+			} else if tt.wantStatus != 0 && (!errors.As(err, &response) || response.HttpError.Code != tt.wantStatus) {
+				t.Fatalf("AuthoriseElevation err = %v, want status %d", err, tt.wantStatus)
+			}
+			if duration != tt.wantDuration {
+				t.Errorf("AuthoriseElevation duration = %v, want %v", duration, tt.wantDuration)
+			}
+		})
+	}
+}
+
+func TestPermissions_AuthoriseRevocation(t *testing.T) {
+	permissions := Permissions{Roles: auth.Roles{
+		"security": {Name: "security", Permissions: auth.Permissions{auth.Elevations: {auth.Read, auth.Write}}},
+	}}
+	elevation := &auth.Elevation{Id: "e1", Principal: "alice", Role: "operator"}
+
+	if err := permissions.AuthoriseRevocation(&auth.Identity{Principal: "alice"}, elevation); err != nil {
+		t.Errorf("own elevation: %v", err)
+	}
+	if err := permissions.AuthoriseRevocation(&auth.Identity{Principal: "carol", Roles: auth.ClaimedRoles{"security"}}, elevation); err != nil {
+		t.Errorf("with permission: %v", err)
+	}
+	if err := permissions.AuthoriseRevocation(&auth.Identity{Principal: "bob"}, elevation); err == nil {
+		t.Error("other principal without permission: want error")
+	}
+}
+
+func TestPermissions_elevate(t *testing.T) {
+	elevation := &auth.Elevation{Id: "e1", Principal: "alice", Role: "operator", ExpiresAt: time.Now().Add(time.Hour)}
+	mockElevations := &mocks.MockElevations{}
+	defer mockElevations.Mock.Validate(t)
+	mocks.Expect(&mockElevations.Mock, mockElevations.Active, func(ctx context.Context, principal string) (*auth.Elevation, error) {
+		if principal != "alice" {
+			t.Errorf("Active principal = %s, want alice", principal)
+		}
+		return elevation, nil
+	})
+
+	permissions := &Permissions{elevationStore: mockElevations}
+	identity := &auth.Identity{Principal: "alice", Roles: auth.ClaimedRoles{"reader"}}
+	err := permissions.elevate(httptest.NewRequest(http.MethodGet, "/", nil), identity)
+	if err != nil {
+		t.Fatalf("elevate: %v", err)
+	}
+	if !slices.Equal(identity.Roles, auth.ClaimedRoles{"reader", "operator"}) || identity.Elevation != elevation {
+		t.Errorf("identity = %+v, want elevated to operator", identity)
+	}
+}
+
+func TestPermissions_Middleware_elevateFails(t *testing.T) {
+	server, _ := peerConnection(t)
+	mockElevations := &mocks.MockElevations{}
+	defer mockElevations.Mock.Validate(t)
+	mocks.Expect(&mockElevations.Mock, mockElevations.Active, func(ctx context.Context, principal string) (*auth.Elevation, error) {
+		return nil, errors.New("database is locked")
+	})
+
+	p := &Permissions{elevationStore: mockElevations}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("next handler was called")
+	})
+	var deniedErr error
+	denied := func(r *http.Request, err error) { deniedErr = err }
+	handler := p.Middleware(auth.Permissions{}, denied, next)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req = req.WithContext(context.WithValue(req.Context(), connectionKey{}, server))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if deniedErr == nil {
+		t.Error("denial was not reported")
+	}
+}
+
+func TestController_elevate(t *testing.T) {
+	mockElevations := &mocks.MockElevations{}
+	defer mockElevations.Mock.Validate(t)
+	mocks.Expect(&mockElevations.Mock, mockElevations.Grant, func(ctx context.Context, elevation *auth.Elevation) (*auth.Elevation, error) {
+		if elevation.Principal != "alice" || elevation.Role != "operator" || elevation.Reason != "incident" {
+			t.Errorf("Grant elevation = %+v", elevation)
+		}
+		if remaining := time.Until(elevation.ExpiresAt); remaining <= 0 || remaining > time.Hour {
+			t.Errorf("Grant expiry in %v, want within an hour", remaining)
+		}
+		elevation.Id = "e1"
+		return elevation, nil
+	})
+
+	c := NewController(&mocks.MockSecrets{}, nil, nil, mockElevations, nil, noopLimiter{}, noopPermissions{identity: &auth.Identity{Principal: "alice"}})
+	mux := http.NewServeMux()
+	c.buildHandler(mux.Handle)
+
+	body := `{"role":"operator","reason":"incident"}`
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://test/elevations", bytes.NewReader([]byte(body)))
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200\nbody: %s", rec.Code, rec.Body.Bytes())
+	}
+	var got auth.Elevation
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.Id != "e1" {
+		t.Errorf("elevation = %+v", got)
+	}
+}
+
+func TestController_createOperation_elevated(t *testing.T) {
+	mockStore := &mocks.MockSecrets{}
+	defer mockStore.Mock.Validate(t)
+	mockInstances := &mocks.MockInstances{}
+	defer mockInstances.Mock.Validate(t)
+	mocks.Expect(&mockStore.Mock, mockStore.Instances, func(secretId string) store.Instances {
+		return mockInstances
+	})
+	mocks.Expect(&mockInstances.Mock, mockInstances.Deactivate, func(ctx context.Context, instanceId string, parameters secrets.OperationParameters) (*secrets.Instance, error) {
+		if parameters.ElevationId != "e1" {
+			t.Errorf("Deactivate ElevationId = %q, want e1", parameters.ElevationId)
+		}
+		return &secrets.Instance{Id: instanceId}, nil
+	})
+
+	identity := &auth.Identity{Principal: "alice", Elevation: &auth.Elevation{Id: "e1", Role: "operator"}}
+	c := NewController(mockStore, nil, nil, nil, nil, noopLimiter{}, noopPermissions{identity: identity})
+	mux := http.NewServeMux()
+	c.buildHandler(mux.Handle)
+
+	body := `{"name":"deactivate","env":{},"forced":false,"reason":"incident"}`
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "http://test/secrets/sid/instances/i1/operations", bytes.NewReader([]byte(body)))
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200\nbody: %s", rec.Code, rec.Body.Bytes())
+	}
+}
+
+func TestController_elevate_readOnlyListener(t *testing.T) {
+	server, exe := peerConnection(t)
+
+	p := &Permissions{
+		Roles: auth.Roles{"admin": {Name: "admin", Permissions: auth.Permissions{auth.All: {auth.Any}}}},
+		Listeners: map[string]ListenerProfile{
+			"public": {
+				Claims:  &auth.Claims{PlatformClaims: auth.PlatformClaims{Executables: map[string]auth.ClaimedRoles{exe: {"admin"}}}},
+				Actions: []auth.Action{auth.Read, auth.List},
+			},
+		},
+		Elevations: ElevationPolicies{{Role: "admin", From: auth.ClaimedRoles{"admin"}}},
+	}
+	mockDenials := &mocks.MockDenials{}
+	defer mockDenials.Mock.Validate(t)
+	mockElevations := &mocks.MockElevations{}
+	defer mockElevations.Mock.Validate(t)
+	c := NewController(&mocks.MockSecrets{}, nil, mockDenials, mockElevations, nil, noopLimiter{}, p)
+	mux := http.NewServeMux()
+	c.buildHandler(mux.Handle)
+
+	for _, path := range []string{"/elevations", "/elevations/e1/revoke"} {
+		t.Run(path, func(t *testing.T) {
+			mocks.Expect(&mockDenials.Mock, mockDenials.Record, func(ctx context.Context, denial *audit.Denial) error {
+				want := auth.Permissions{auth.Elevations: {auth.Elevate}}
+				if denial.Listener != "public" || !cmp.Equal(denial.Permission, want) {
+					t.Errorf("denial = %+v, want %v through listener public", denial, want)
+				}
+				return nil
+			})
+
+			body := `{"role":"admin","reason":"incident"}`
+			req := httptest.NewRequest(http.MethodPost, "http://test"+path, bytes.NewReader([]byte(body)))
+			ctx := context.WithValue(req.Context(), connectionKey{}, server)
+			ctx = context.WithValue(ctx, listenerKey{}, "public")
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req.WithContext(ctx))
+
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want 403 through a read-only listener\nbody: %s", rec.Code, rec.Body.Bytes())
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/events"
+	"github.com/eliasvasylenko/secret-agent/internal/marshal"
+)
+
+// The number of events read from the store at a time
+const eventBatchSize = 100
+
+// Interval between keep-alive comments on an idle event stream, so that idle connections are not dropped
+const eventKeepAliveInterval = 15 * time.Second
+
+type shutdownKey struct{}
+
+// shuttingDown gives a channel which is closed once the server starts to shut down, so that long-lived
+// responses may end rather than hold up graceful shutdown
+func shuttingDown(ctx context.Context) <-chan struct{} {
+	done, _ := ctx.Value(shutdownKey{}).(<-chan struct{})
+	return done
+}
+
+// The permissions required to observe an event
+func eventPermissions(eventType events.Type) auth.Permissions {
+	switch eventType {
+	case events.AccessDenied:
+		return auth.Permissions{auth.Audit: {auth.Read}}
+	case events.ElevationGranted, events.ElevationRevoked:
+		return auth.Permissions{auth.Elevations: {auth.Read}}
+	default:
+		return auth.Permissions{auth.Instances: {auth.Read}}
+	}
+}
+
+// AuthoriseEvent asserts that the caller may observe the event
+func (p *Permissions) AuthoriseEvent(identity *auth.Identity, event *events.Event) error {
+	return p.assertPermission(identity, eventPermissions(event.Type))
+}
+
+// streamEvents streams the events which the caller may observe as server-sent events, optionally only those
+// about the secret given by secretId. The stream resumes after the event given by Last-Event-ID, or starts
+// from the latest event if it is not given. The stream is gone if events following the given event have been
+// pruned, since the caller would miss them.
+func (s *Controller) streamEvents(w http.ResponseWriter, r *http.Request) {
+	identity := identityFromContext(r.Context())
+	if identity == nil {
+		writeError(w, NewErrorResponse(http.StatusInternalServerError, fmt.Errorf("identity not found in context")))
+		return
+	}
+	secretId := r.URL.Query().Get("secretId")
+
+	var after int64
+	var err error
+	if lastEventId := r.Header.Get("Last-Event-ID"); lastEventId != "" {
+		after, err = strconv.ParseInt(lastEventId, 10, 64)
+		if err != nil {
+			writeError(w, NewErrorResponse(http.StatusBadRequest, fmt.Errorf("failed to parse 'Last-Event-ID' - %w", err)))
+			return
+		}
+		pruned, err := s.eventStore.Pruned(r.Context())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if after < pruned {
+			writeError(w, NewErrorResponse(http.StatusGone, fmt.Errorf("events following event %d have been pruned, start the stream again from the latest event", after)))
+			return
+		}
+	} else {
+		after, err = s.eventStore.Latest(r.Context())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher := http.NewResponseController(w)
+	flusher.Flush()
+
+	poll := time.NewTicker(s.eventPollInterval)
+	defer poll.Stop()
+	keepAlive := time.NewTicker(eventKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		pending, err := s.eventStore.After(r.Context(), after, eventBatchSize)
+		if err != nil {
+			return
+		}
+		for _, event := range pending {
+			after = event.Id
+			if secretId != "" && event.SecretId != secretId {
+				continue
+			}
+			if s.permissions.AuthoriseEvent(identity, event) != nil {
+				continue
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+		}
+		if len(pending) > 0 {
+			if err := flusher.Flush(); err != nil {
+				return
+			}
+		}
+		if len(pending) == eventBatchSize {
+			continue
+		}
+
+		select {
+		case <-poll.C:
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-shuttingDown(r.Context()):
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeEvent writes an event in the text/event-stream format, with its ID so that the stream can be resumed
+func writeEvent(w io.Writer, event *events.Event) error {
+	data, err := marshal.JSON(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Id, event.Type, bytes.TrimSpace(data))
+	return err
+}
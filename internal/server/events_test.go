@@ -0,0 +1,226 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/events"
+	"github.com/google/go-cmp/cmp"
+)
+
+// An event store held in memory, to which events may be appended while streaming
+type memoryEvents struct {
+	events []*events.Event
+	pruned int64
+	mu     sync.Mutex
+}
+
+func (m *memoryEvents) append(event *events.Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	event.Id = int64(len(m.events) + 1)
+	m.events = append(m.events, event)
+}
+
+func (m *memoryEvents) After(ctx context.Context, eventId int64, limit int) ([]*events.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := []*events.Event{}
+	for _, event := range m.events {
+		if event.Id > eventId && len(list) < limit {
+			list = append(list, event)
+		}
+	}
+	return list, nil
+}
+
+func (m *memoryEvents) Latest(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return int64(len(m.events)), nil
+}
+
+func (m *memoryEvents) Pruned(ctx context.Context) (int64, error) {
+	return m.pruned, nil
+}
+
+func (m *memoryEvents) Prune(ctx context.Context, before time.Time, throughEventId int64) (int, error) {
+	return 0, nil
+}
+
+func (m *memoryEvents) Subscription(ctx context.Context, subscriber string) (*events.Subscription, error) {
+	return &events.Subscription{Subscriber: subscriber}, nil
+}
+
+func (m *memoryEvents) UpdateSubscription(ctx context.Context, subscription *events.Subscription) error {
+	return nil
+}
+
+// auditHiddenPermissions permits every request, but hides access denials from the event stream
+type auditHiddenPermissions struct {
+	noopPermissions
+}
+
+func (p auditHiddenPermissions) AuthoriseEvent(identity *auth.Identity, event *events.Event) error {
+	if event.Type == events.AccessDenied {
+		return errors.New("hidden")
+	}
+	return nil
+}
+
+// streamFrom starts streaming events from the controller, returning a channel of the IDs of events received
+func streamFrom(t *testing.T, c *Controller, query string, lastEventId string) <-chan string {
+	t.Helper()
+	mux := http.NewServeMux()
+	c.buildHandler(mux.Handle)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events"+query, nil)
+	if lastEventId != "" {
+		req.Header.Set("Last-Event-ID", lastEventId)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /events: %v", err)
+	}
+	if got := res.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", got)
+	}
+
+	ids := make(chan string, 16)
+	go func() {
+		defer res.Body.Close()
+		defer close(ids)
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			if id, ok := strings.CutPrefix(scanner.Text(), "id: "); ok {
+				ids <- id
+			}
+		}
+	}()
+	return ids
+}
+
+func receive(t *testing.T, ids <-chan string, count int) []string {
+	t.Helper()
+	var got []string
+	for len(got) < count {
+		select {
+		case id := <-ids:
+			got = append(got, id)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("received events %v, want %d", got, count)
+		}
+	}
+	return got
+}
+
+func TestController_streamEvents(t *testing.T) {
+	store := &memoryEvents{}
+	store.append(&events.Event{Type: events.OperationStarted, SecretId: "s1"})
+	c := NewController(nil, nil, nil, nil, store, noopLimiter{}, auditHiddenPermissions{noopPermissions{identity: &auth.Identity{Principal: "alice"}}})
+	c.eventPollInterval = 10 * time.Millisecond
+
+	ids := streamFrom(t, c, "?secretId=s1", "")
+	store.append(&events.Event{Type: events.OperationStarted, SecretId: "s2"})
+	store.append(&events.Event{Type: events.AccessDenied, SecretId: "s1"})
+	store.append(&events.Event{Type: events.OperationCompleted, SecretId: "s1"})
+
+	if diff := cmp.Diff([]string{"4"}, receive(t, ids, 1)); diff != "" {
+		t.Errorf("events mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestController_streamEvents_lastEventId(t *testing.T) {
+	store := &memoryEvents{}
+	for range 3 {
+		store.append(&events.Event{Type: events.OperationStarted, SecretId: "s1"})
+	}
+	store.pruned = 1
+	c := NewController(nil, nil, nil, nil, store, noopLimiter{}, noopPermissions{identity: &auth.Identity{Principal: "alice"}})
+	c.eventPollInterval = 10 * time.Millisecond
+
+	ids := streamFrom(t, c, "", "1")
+	if diff := cmp.Diff([]string{"2", "3"}, receive(t, ids, 2)); diff != "" {
+		t.Errorf("events mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestController_streamEvents_badLastEventId(t *testing.T) {
+	c := NewController(nil, nil, nil, nil, &memoryEvents{}, noopLimiter{}, noopPermissions{identity: &auth.Identity{Principal: "alice"}})
+	mux := http.NewServeMux()
+	c.buildHandler(mux.Handle)
+
+	req := httptest.NewRequest(http.MethodGet, "/events", nil)
+	req.Header.Set("Last-Event-ID", "latest")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestController_streamEvents_pruned(t *testing.T) {
+	store := &memoryEvents{pruned: 2}
+	c := NewController(nil, nil, nil, nil, store, noopLimiter{}, noopPermissions{identity: &auth.Identity{Principal: "alice"}})
+	mux := http.NewServeMux()
+	c.buildHandler(mux.Handle)
+
+	req := httptest.NewRequest(http.MethodGet, "/events", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusGone {
+		t.Errorf("status = %d, want %d\nbody: %s", rec.Code, http.StatusGone, rec.Body.Bytes())
+	}
+}
+
+func TestController_streamEvents_endsOnShutdown(t *testing.T) {
+	c := NewController(nil, nil, nil, nil, &memoryEvents{}, noopLimiter{}, noopPermissions{identity: &auth.Identity{Principal: "alice"}})
+	mux := http.NewServeMux()
+	c.buildHandler(mux.Handle)
+
+	shutdown := make(chan struct{})
+	ctx := context.WithValue(context.Background(), shutdownKey{}, (<-chan struct{})(shutdown))
+	req := httptest.NewRequest(http.MethodGet, "/events", nil).WithContext(ctx)
+	done := make(chan struct{})
+	go func() {
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+		close(done)
+	}()
+
+	close(shutdown)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("event stream did not end on shutdown")
+	}
+}
+
+func TestPermissions_AuthoriseEvent(t *testing.T) {
+	p := &Permissions{Roles: auth.Roles{
+		"reader": {Name: "reader", Permissions: auth.Permissions{auth.Instances: {auth.Read}}},
+	}}
+	identity := &auth.Identity{Principal: "alice", Roles: auth.ClaimedRoles{"reader"}}
+
+	if err := p.AuthoriseEvent(identity, &events.Event{Type: events.OperationCompleted}); err != nil {
+		t.Errorf("AuthoriseEvent(operation) = %v, want nil", err)
+	}
+	if err := p.AuthoriseEvent(identity, &events.Event{Type: events.AccessDenied}); err == nil {
+		t.Error("AuthoriseEvent(denial) = nil, want error without audit permission")
+	}
+	if err := p.AuthoriseEvent(identity, &events.Event{Type: events.ElevationGranted}); err == nil {
+		t.Error("AuthoriseEvent(elevation) = nil, want error without elevations permission")
+	}
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/marshal"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+	"github.com/eliasvasylenko/secret-agent/internal/store"
+)
+
+// Time for which an operation may run before it is considered stuck, if not configured
+const defaultStuckAfter = time.Hour
+
+// A Monitor reports upon the health and status of the agent
+type Monitor struct {
+	health    store.Health
+	startedAt time.Time
+	// Time for which an operation may run before the agent is no longer ready
+	stuckAfter time.Duration
+	// Digests of the configuration files loaded by the agent, by path
+	configHashes map[string]string
+	// The time at which scheduled background work last ran, if it has
+	lastRun func() *time.Time
+}
+
+func NewMonitor(health store.Health, stuckAfter time.Duration, configHashes map[string]string, lastRun func() *time.Time) *Monitor {
+	if stuckAfter <= 0 {
+		stuckAfter = defaultStuckAfter
+	}
+	if lastRun == nil {
+		lastRun = func() *time.Time { return nil }
+	}
+	return &Monitor{
+		health:       health,
+		startedAt:    time.Now().UTC(),
+		stuckAfter:   stuckAfter,
+		configHashes: configHashes,
+		lastRun:      lastRun,
+	}
+}
+
+// The outcome of a check upon the readiness of the agent
+type Check struct {
+	Name  string `json:"name"`
+	Ok    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// Whether the agent is ready to serve requests, with the outcome of each check
+type Readiness struct {
+	Ready  bool     `json:"ready"`
+	Checks []*Check `json:"checks"`
+}
+
+// The status of the running agent
+type AgentStatus struct {
+	StartedAt time.Time        `json:"startedAt"`
+	Uptime    marshal.Duration `json:"uptime"`
+	// SHA-256 digests of the configuration files loaded by the agent, by path
+	ConfigHashes map[string]string `json:"configHashes"`
+	// The number of configured secrets, and of those with an active instance
+	Secrets       int `json:"secrets"`
+	ActiveSecrets int `json:"activeSecrets"`
+	// The time at which the event dispatcher, which runs the scheduled background work of the agent, last ran
+	LastSchedulerRun *time.Time `json:"lastSchedulerRun,omitempty"`
+}
+
+// healthz reports that the agent is alive, without checking upon anything it depends on
+func (s *Controller) healthz(w http.ResponseWriter, r *http.Request) {
+	writeResult(w, map[string]string{"status": "ok"}, http.StatusOK)
+}
+
+// readyz reports whether the agent is ready to serve requests, as unavailable if any check fails
+func (s *Controller) readyz(w http.ResponseWriter, r *http.Request) {
+	readiness := s.readiness(r.Context())
+	if !readiness.Ready {
+		writeResult(w, readiness, http.StatusServiceUnavailable)
+		return
+	}
+	writeResult(w, readiness, http.StatusOK)
+}
+
+func (s *Controller) readiness(ctx context.Context) *Readiness {
+	readiness := &Readiness{Ready: true}
+	check := func(name string, err error) {
+		result := &Check{Name: name, Ok: err == nil}
+		if err != nil {
+			result.Error = err.Error()
+			readiness.Ready = false
+		}
+		readiness.Checks = append(readiness.Checks, result)
+	}
+
+	check("database", s.monitor.health.Check(ctx))
+
+	_, err := s.secretStore.List(ctx)
+	check("config", err)
+
+	stuck, err := s.monitor.health.RunningSince(ctx, time.Now().Add(-s.monitor.stuckAfter))
+	if err == nil && stuck > 0 {
+		err = fmt.Errorf("%d operations have been running for longer than %s", stuck, s.monitor.stuckAfter)
+	}
+	check("operations", err)
+
+	return readiness
+}
+
+func (s *Controller) getStatus(w http.ResponseWriter, r *http.Request) {
+	secrets, err := s.secretStore.List(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	active, err := s.monitor.health.ActiveSecrets(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeResult(w, &AgentStatus{
+		StartedAt:        s.monitor.startedAt,
+		Uptime:           marshal.Duration(time.Since(s.monitor.startedAt).Round(time.Second)),
+		ConfigHashes:     s.monitor.configHashes,
+		Secrets:          len(secrets),
+		ActiveSecrets:    active,
+		LastSchedulerRun: s.monitor.lastRun(),
+	}, http.StatusOK)
+}
+
+// listSecretSummaries summarises every secret, judging operations stuck by the same threshold as readiness
+func (s *Controller) listSecretSummaries(w http.ResponseWriter, r *http.Request) {
+	summaries, err := s.monitor.health.Summaries(r.Context(), time.Now().Add(-s.monitor.stuckAfter))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeResult(w, ItemsResponse[[]*secrets.Summary]{summaries}, http.StatusOK)
+}
@@ -0,0 +1,251 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/audit"
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/mocks"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+	"github.com/google/go-cmp/cmp"
+)
+
+// A store whose health is fixed
+type stubHealth struct {
+	err    error
+	stuck  int
+	active int
+	// The summaries of secrets
+	summaries []*secrets.Summary
+	// The time before which stuck operations were started, as last asked
+	startedBefore time.Time
+}
+
+func (h *stubHealth) Check(context.Context) error {
+	return h.err
+}
+
+func (h *stubHealth) RunningSince(_ context.Context, startedBefore time.Time) (int, error) {
+	h.startedBefore = startedBefore
+	return h.stuck, nil
+}
+
+func (h *stubHealth) InterruptUnfinished(context.Context) (int, error) {
+	return 0, nil
+}
+
+func (h *stubHealth) ActiveSecrets(context.Context) (int, error) {
+	return h.active, nil
+}
+
+func (h *stubHealth) Summaries(_ context.Context, stuckBefore time.Time) ([]*secrets.Summary, error) {
+	h.startedBefore = stuckBefore
+	return h.summaries, nil
+}
+
+func TestController_healthz_unauthenticated(t *testing.T) {
+	c := NewController(&mocks.MockSecrets{}, nil, &mocks.MockDenials{}, nil, nil, denyingLimiter{}, noopPermissions{})
+	mux := http.NewServeMux()
+	c.buildHandler(mux.Handle)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://test/healthz", nil)
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200\nbody: %s", rec.Code, rec.Body.Bytes())
+	}
+}
+
+func TestController_readyz(t *testing.T) {
+	tests := []struct {
+		name   string
+		health *stubHealth
+		listed error
+		status int
+		want   []*Check
+	}{
+		{
+			name:   "ready",
+			health: &stubHealth{},
+			status: http.StatusOK,
+			want:   []*Check{{Name: "database", Ok: true}, {Name: "config", Ok: true}, {Name: "operations", Ok: true}},
+		},
+		{
+			name:   "database outdated",
+			health: &stubHealth{err: errors.New("outdated")},
+			status: http.StatusServiceUnavailable,
+			want:   []*Check{{Name: "database", Error: "outdated"}, {Name: "config", Ok: true}, {Name: "operations", Ok: true}},
+		},
+		{
+			name:   "config missing",
+			health: &stubHealth{},
+			listed: errors.New("no config"),
+			status: http.StatusServiceUnavailable,
+			want:   []*Check{{Name: "database", Ok: true}, {Name: "config", Error: "no config"}, {Name: "operations", Ok: true}},
+		},
+		{
+			name:   "operations stuck",
+			health: &stubHealth{stuck: 2},
+			status: http.StatusServiceUnavailable,
+			want:   []*Check{{Name: "database", Ok: true}, {Name: "config", Ok: true}, {Name: "operations", Error: "2 operations have been running for longer than 10m0s"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStore := &mocks.MockSecrets{}
+			defer mockStore.Mock.Validate(t)
+			mocks.Expect(&mockStore.Mock, mockStore.List, func(ctx context.Context) (secrets.Secrets, error) {
+				return secrets.Secrets{}, tt.listed
+			})
+
+			c := NewController(mockStore, nil, nil, nil, nil, noopLimiter{}, noopPermissions{})
+			c.monitor = NewMonitor(tt.health, 10*time.Minute, nil, nil)
+			mux := http.NewServeMux()
+			c.buildHandler(mux.Handle)
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://test/readyz", nil)
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d\nbody: %s", rec.Code, tt.status, rec.Body.Bytes())
+			}
+			var got Readiness
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			want := Readiness{Ready: tt.status == http.StatusOK, Checks: tt.want}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("readiness mismatch (-want +got):\n%s", diff)
+			}
+			if since := time.Since(tt.health.startedBefore); since < 10*time.Minute || since > 11*time.Minute {
+				t.Errorf("stuck operations started before %v, want ten minutes ago", tt.health.startedBefore)
+			}
+		})
+	}
+}
+
+func TestController_getStatus(t *testing.T) {
+	mockStore := &mocks.MockSecrets{}
+	defer mockStore.Mock.Validate(t)
+	mocks.Expect(&mockStore.Mock, mockStore.List, func(ctx context.Context) (secrets.Secrets, error) {
+		return secrets.Secrets{"s1": {Name: "s1"}, "s2": {Name: "s2"}}, nil
+	})
+
+	lastRun := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	c := NewController(mockStore, nil, nil, nil, nil, noopLimiter{}, noopPermissions{})
+	c.monitor = NewMonitor(&stubHealth{active: 1}, 0, map[string]string{"/etc/secrets.json": "abc"}, func() *time.Time { return &lastRun })
+	c.monitor.startedAt = time.Now().Add(-time.Hour)
+	mux := http.NewServeMux()
+	c.buildHandler(mux.Handle)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://test/status", nil)
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200\nbody: %s", rec.Code, rec.Body.Bytes())
+	}
+	var got AgentStatus
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if time.Duration(got.Uptime) != time.Hour {
+		t.Errorf("uptime = %s, want 1h", time.Duration(got.Uptime))
+	}
+	got.StartedAt, got.Uptime = time.Time{}, 0
+	want := AgentStatus{
+		ConfigHashes:     map[string]string{"/etc/secrets.json": "abc"},
+		Secrets:          2,
+		ActiveSecrets:    1,
+		LastSchedulerRun: &lastRun,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("status mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestController_getStatus_requiresPermission(t *testing.T) {
+	server, exe := peerConnection(t)
+	p := &Permissions{
+		Roles: auth.Roles{
+			"reader":  {Name: "reader", Permissions: auth.Permissions{auth.Secrets: {auth.Any}, auth.Instances: {auth.Any}}},
+			"monitor": {Name: "monitor", Permissions: auth.Permissions{auth.Status: {auth.Read}}},
+		},
+	}
+	mockStore := &mocks.MockSecrets{}
+	defer mockStore.Mock.Validate(t)
+	mockDenials := &mocks.MockDenials{}
+	defer mockDenials.Mock.Validate(t)
+	c := NewController(mockStore, nil, mockDenials, nil, nil, noopLimiter{}, p)
+	c.monitor = NewMonitor(&stubHealth{}, 0, map[string]string{"/etc/secrets.json": "abc"}, nil)
+	mux := http.NewServeMux()
+	c.buildHandler(mux.Handle)
+
+	tests := []struct {
+		role       auth.RoleName
+		wantStatus int
+	}{
+		{"reader", http.StatusForbidden},
+		{"monitor", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.role), func(t *testing.T) {
+			p.Claims = auth.Claims{PlatformClaims: auth.PlatformClaims{Executables: map[string]auth.ClaimedRoles{exe: {tt.role}}}}
+			if tt.wantStatus == http.StatusOK {
+				mocks.Expect(&mockStore.Mock, mockStore.List, func(ctx context.Context) (secrets.Secrets, error) {
+					return secrets.Secrets{}, nil
+				})
+			} else {
+				mocks.Expect(&mockDenials.Mock, mockDenials.Record, func(ctx context.Context, denial *audit.Denial) error {
+					return nil
+				})
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "http://test/status", nil)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req.WithContext(context.WithValue(req.Context(), connectionKey{}, server)))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d\nbody: %s", rec.Code, tt.wantStatus, rec.Body.Bytes())
+			}
+		})
+	}
+}
+
+func TestController_listSecretSummaries(t *testing.T) {
+	activeInstanceId := "i1"
+	health := &stubHealth{summaries: []*secrets.Summary{
+		{SecretId: "s1", ActiveInstanceId: &activeInstanceId, LiveInstances: 1, Verdict: secrets.Healthy},
+		{SecretId: "s2", Verdict: secrets.Inactive},
+	}}
+	c := NewController(&mocks.MockSecrets{}, nil, nil, nil, nil, noopLimiter{}, noopPermissions{})
+	c.monitor = NewMonitor(health, 10*time.Minute, nil, nil)
+	mux := http.NewServeMux()
+	c.buildHandler(mux.Handle)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://test/status/secrets", nil)
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200\nbody: %s", rec.Code, rec.Body.Bytes())
+	}
+	var got ItemsResponse[[]*secrets.Summary]
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if diff := cmp.Diff(health.summaries, got.Items); diff != "" {
+		t.Errorf("summaries mismatch (-want +got):\n%s", diff)
+	}
+	if since := time.Since(health.startedBefore); since < 10*time.Minute || since > 11*time.Minute {
+		t.Errorf("stuck operations started before %v, want ten minutes ago", health.startedBefore)
+	}
+}
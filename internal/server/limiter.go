@@ -3,60 +3,178 @@ package server
 import (
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/marshal"
 )
 
+// A class of endpoints which share a rate limit
+type LimitClass string
+
+const (
+	// Endpoints which only read state
+	ReadClass LimitClass = "read"
+	// Endpoints which perform or approve operations on secrets
+	OperationClass LimitClass = "operation"
+	// Endpoints which administer the server, e.g. the audit trail and elevations
+	AdminClass LimitClass = "admin"
+)
+
+// Interval between sweeps of the limiter for idle counters
+const sweepInterval = time.Minute
+
+// A limit on the number of requests in a window of time
+type RateLimit struct {
+	Limit  uint32           `json:"limit"`
+	Window marshal.Duration `json:"window"`
+}
+
+// Rate limits per endpoint class, which may be overridden for principals claiming particular roles
+type RateLimits struct {
+	// Limits for each class, falling back to the server default for classes not given
+	Classes map[LimitClass]RateLimit `json:"classes,omitempty"`
+	// Limits for each class which apply to principals claiming a role, in place of those of the class.
+	// Where a principal claims several roles with overrides for a class, the most generous applies.
+	Roles map[auth.RoleName]map[LimitClass]RateLimit `json:"roles,omitempty"`
+}
+
 type Limiter struct {
-	requestLimit  uint32
-	requestWindow time.Duration
-	counters      map[string]*Counter
-	mu            sync.Mutex
+	defaultLimit RateLimit
+	limits       RateLimits
+	maxInFlight  uint32
+	counters     map[counterKey]*Counter
+	inFlight     map[string]uint32
+	lastSweep    time.Time
+	mu           sync.Mutex
+}
+
+type counterKey struct {
+	principal string
+	class     LimitClass
 }
 
 type Counter struct {
 	latestWindowFrom time.Time
 	latestBucket     Bucket
 	previousBucket   Bucket
+	window           time.Duration
 	mu               sync.Mutex
 }
 
 type Bucket uint32
 
-func NewLimiter(limit uint32, window time.Duration) *Limiter {
+// The state of a counter after a request is counted against it
+type Quota struct {
+	Limit     uint32
+	Remaining uint32
+	// Time until the current window of the counter ends
+	Reset time.Duration
+}
+
+// NewLimiter creates a limiter which applies the default limit to any class without a configured limit,
+// and which allows at most maxInFlight concurrent operations per principal, or any number if zero.
+func NewLimiter(defaultLimit RateLimit, limits RateLimits, maxInFlight uint32) *Limiter {
 	return &Limiter{
-		requestLimit:  limit,
-		requestWindow: window,
-		counters:      make(map[string]*Counter),
+		defaultLimit: defaultLimit,
+		limits:       limits,
+		maxInFlight:  maxInFlight,
+		counters:     make(map[counterKey]*Counter),
+		inFlight:     make(map[string]uint32),
 	}
 }
 
-func (l *Limiter) Middleware(keyFunc func(r *http.Request) string, next http.Handler) http.Handler {
+// Middleware limits the rate of requests of the given class per authenticated principal,
+// reporting the state of their quota in X-RateLimit headers.
+func (l *Limiter) Middleware(class LimitClass, denied func(r *http.Request, err error), next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		key := keyFunc(r)
+		var principal string
+		var roles auth.ClaimedRoles
+		if identity := identityFromContext(r.Context()); identity != nil {
+			principal = identity.Principal
+			roles = identity.Roles
+		}
 
-		err := l.Allow(key)
+		quota, err := l.Allow(principal, roles, class)
+		if quota != nil {
+			w.Header().Set("X-RateLimit-Limit", strconv.FormatUint(uint64(quota.Limit), 10))
+			w.Header().Set("X-RateLimit-Remaining", strconv.FormatUint(uint64(quota.Remaining), 10))
+			w.Header().Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(quota.Reset.Seconds()))))
+		}
 		if err != nil {
+			rateLimitRejections.Inc(string(class), "rate")
+			denied(r, err)
 			writeError(w, err)
 			return
 		}
 
+		if class == OperationClass {
+			err = l.acquire(principal)
+			if err != nil {
+				rateLimitRejections.Inc(string(class), "in_flight")
+				denied(r, err)
+				writeError(w, err)
+				return
+			}
+			defer l.release(principal)
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
 
-func (l *Limiter) Allow(key string) error {
-	if l.requestLimit <= 0 || l.requestWindow <= 0 {
-		return nil
+// Allow counts a request of the given class by the principal, returning an error if it exceeds their limit.
+// The returned quota is nil if the class is not limited.
+func (l *Limiter) Allow(principal string, roles auth.ClaimedRoles, class LimitClass) (*Quota, error) {
+	limit := l.limitFor(roles, class)
+	window := time.Duration(limit.Window)
+	if limit.Limit <= 0 || window <= 0 {
+		return nil, nil
 	}
-	return l.getCounter(key).Increment(l.requestWindow, l.requestLimit)
-}
 
-func (l *Limiter) getCounter(key string) *Counter {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
+	return l.getCounter(counterKey{principal, class}).Increment(window, limit.Limit)
+}
+
+// The limit which applies to a class of request by a principal claiming the given roles
+func (l *Limiter) limitFor(roles auth.ClaimedRoles, class LimitClass) RateLimit {
+	limit, ok := l.limits.Classes[class]
+	if !ok {
+		limit = l.defaultLimit
+	}
+	overridden := false
+	for _, role := range roles {
+		override, ok := l.limits.Roles[role][class]
+		if ok && (!overridden || override.rate() > limit.rate()) {
+			limit = override
+			overridden = true
+		}
+	}
+	return limit
+}
+
+// The rate of requests permitted per second, or infinity if unlimited
+func (l RateLimit) rate() float64 {
+	if l.Limit <= 0 || l.Window <= 0 {
+		return math.Inf(1)
+	}
+	return float64(l.Limit) / time.Duration(l.Window).Seconds()
+}
+
+// getCounter finds the counter for a key, which must be incremented before the lock on the limiter is released
+// so that it is not evicted
+func (l *Limiter) getCounter(key counterKey) *Counter {
+	now := time.Now().UTC()
+	if now.Sub(l.lastSweep) > sweepInterval {
+		l.sweep(now)
+	}
+
 	counter, ok := l.counters[key]
 	if !ok {
 		counter = NewCounter()
@@ -65,30 +183,116 @@ func (l *Limiter) getCounter(key string) *Counter {
 	return counter
 }
 
+// sweep evicts counters which have been idle long enough that they no longer count any requests,
+// so that the memory used by the limiter is bounded by the number of recently active principals.
+func (l *Limiter) sweep(now time.Time) {
+	for key, counter := range l.counters {
+		if counter.idle(now) {
+			delete(l.counters, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// acquire counts an operation in flight for the principal, returning an error if they are at the cap
+func (l *Limiter) acquire(principal string) error {
+	if l.maxInFlight <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inFlight[principal] >= l.maxInFlight {
+		err := NewErrorResponse(http.StatusTooManyRequests, fmt.Errorf("too many operations in flight, at most %d are permitted", l.maxInFlight))
+		err.Headers["Retry-After"] = "1"
+		return err
+	}
+	l.inFlight[principal]++
+	return nil
+}
+
+func (l *Limiter) release(principal string) {
+	if l.maxInFlight <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight[principal]--
+	if l.inFlight[principal] == 0 {
+		delete(l.inFlight, principal)
+	}
+}
+
+// Reserve counts up to wanted more operations in flight for the principal, beyond that of the request which they
+// are part of, giving the number which could be counted without exceeding the cap and a function to release them
+func (l *Limiter) Reserve(principal string, wanted uint32) (uint32, func()) {
+	if l.maxInFlight <= 0 {
+		return wanted, func() {}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	reserved := min(wanted, l.maxInFlight-min(l.inFlight[principal], l.maxInFlight))
+	if reserved == 0 {
+		return 0, func() {}
+	}
+	l.inFlight[principal] += reserved
+	return reserved, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		l.inFlight[principal] -= reserved
+		if l.inFlight[principal] == 0 {
+			delete(l.inFlight, principal)
+		}
+	}
+}
+
 func NewCounter() *Counter {
 	return &Counter{}
 }
 
-func (c *Counter) Increment(window time.Duration, limit uint32) error {
+func (c *Counter) Increment(window time.Duration, limit uint32) (*Quota, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	now := time.Now().UTC()
+	c.window = window
 	c.slideWindow(window, now)
 	count := c.approximateCount(window, now)
 
 	ok := count < limit
-
 	if ok {
 		c.latestBucket++
-		return nil
+		count++
+	}
+
+	quota := &Quota{
+		Limit: limit,
+		Reset: c.latestWindowFrom.Add(window).Sub(now),
+	}
+	if count < limit {
+		quota.Remaining = limit - count
+	}
+
+	if ok {
+		return quota, nil
 	} else {
 		err := NewErrorResponse(http.StatusTooManyRequests, errors.New("rate limit exceeded"))
 		err.Headers["Retry-After"] = fmt.Sprintf("%d", int(window.Seconds()))
-		return err
+		return quota, err
 	}
 }
 
+// idle reports whether the counter no longer counts any requests, as both of its buckets have slid out of the window
+func (c *Counter) idle(now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return !now.Before(c.latestWindowFrom.Add(2 * c.window))
+}
+
 func (c *Counter) slideWindow(window time.Duration, now time.Time) {
 	currentWindowFrom := now.Truncate(window)
 	if currentWindowFrom.After(c.latestWindowFrom) {
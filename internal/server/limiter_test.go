@@ -1,13 +1,28 @@
 package server
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/marshal"
 )
 
+func perMinute(limit uint32) RateLimit {
+	return RateLimit{Limit: limit, Window: marshal.Duration(time.Minute)}
+}
+
+// requestAs creates a request from an authenticated principal claiming the given roles
+func requestAs(principal string, roles ...auth.RoleName) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	identity := &auth.Identity{Principal: principal, Roles: roles}
+	return req.WithContext(context.WithValue(req.Context(), identityKey{}, identity))
+}
+
 func TestLimiter_Allow(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -24,14 +39,14 @@ func TestLimiter_Allow(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			l := NewLimiter(tt.limit, tt.window)
+			l := NewLimiter(RateLimit{Limit: tt.limit, Window: marshal.Duration(tt.window)}, RateLimits{}, 0)
 			var lastErr error
 			for i := 0; i < tt.numCalls; i++ {
 				if lastErr != nil {
 					t.Errorf("Allow() = %v, not finished yet, want nil", lastErr)
 					break
 				}
-				lastErr = l.Allow("key")
+				_, lastErr = l.Allow("key", nil, ReadClass)
 			}
 			if tt.wantRetryAfter != "" {
 				if lastErr == nil {
@@ -55,31 +70,85 @@ func TestLimiter_Allow(t *testing.T) {
 }
 
 func TestLimiter_Allow_perKey(t *testing.T) {
-	l := NewLimiter(1, time.Minute)
-	if err := l.Allow("alice"); err != nil {
+	l := NewLimiter(perMinute(1), RateLimits{}, 0)
+	if _, err := l.Allow("alice", nil, ReadClass); err != nil {
 		t.Errorf("Allow(alice) = %v", err)
 	}
-	if err := l.Allow("bob"); err != nil {
+	if _, err := l.Allow("bob", nil, ReadClass); err != nil {
 		t.Errorf("Allow(bob) = %v", err)
 	}
-	err := l.Allow("alice")
+	_, err := l.Allow("alice", nil, ReadClass)
 	if err == nil {
 		t.Fatal("Allow(alice) second call = nil, want rate limit error")
 	}
 }
 
+func TestLimiter_Allow_perClass(t *testing.T) {
+	l := NewLimiter(perMinute(5), RateLimits{
+		Classes: map[LimitClass]RateLimit{OperationClass: perMinute(1)},
+	}, 0)
+	for i := 0; i < 2; i++ {
+		if _, err := l.Allow("alice", nil, ReadClass); err != nil {
+			t.Errorf("Allow(read) = %v, want default limit", err)
+		}
+	}
+	if _, err := l.Allow("alice", nil, OperationClass); err != nil {
+		t.Errorf("Allow(operation) = %v, want separate quota from reads", err)
+	}
+	if _, err := l.Allow("alice", nil, OperationClass); err == nil {
+		t.Error("Allow(operation) second call = nil, want rate limit error")
+	}
+}
+
+func TestLimiter_Allow_roleOverride(t *testing.T) {
+	l := NewLimiter(perMinute(1), RateLimits{
+		Roles: map[auth.RoleName]map[LimitClass]RateLimit{
+			"bulk":  {ReadClass: perMinute(3)},
+			"batch": {ReadClass: perMinute(2)},
+		},
+	}, 0)
+	for i := 0; i < 3; i++ {
+		if _, err := l.Allow("alice", auth.ClaimedRoles{"reader", "batch", "bulk"}, ReadClass); err != nil {
+			t.Errorf("Allow(alice) call %d = %v, want most generous role override", i, err)
+		}
+	}
+	if _, err := l.Allow("alice", auth.ClaimedRoles{"reader", "batch", "bulk"}, ReadClass); err == nil {
+		t.Error("Allow(alice) fourth call = nil, want rate limit error")
+	}
+	if _, err := l.Allow("bob", auth.ClaimedRoles{"reader"}, ReadClass); err != nil {
+		t.Errorf("Allow(bob) = %v", err)
+	}
+	if _, err := l.Allow("bob", auth.ClaimedRoles{"reader"}, ReadClass); err == nil {
+		t.Error("Allow(bob) second call = nil, want default limit without override")
+	}
+}
+
+func TestLimiter_sweep_evictsIdleCounters(t *testing.T) {
+	l := NewLimiter(perMinute(5), RateLimits{}, 0)
+	l.Allow("alice", nil, ReadClass)
+	l.Allow("bob", nil, ReadClass)
+
+	l.sweep(time.Now().UTC().Add(time.Minute))
+	if len(l.counters) != 2 {
+		t.Errorf("counters = %d after one window, want 2 still counting", len(l.counters))
+	}
+	l.sweep(time.Now().UTC().Add(2 * time.Minute))
+	if len(l.counters) != 0 {
+		t.Errorf("counters = %d after two windows, want 0", len(l.counters))
+	}
+}
+
 func TestLimiter_Middleware_callsNextWhenAllowed(t *testing.T) {
-	l := NewLimiter(5, time.Minute)
+	l := NewLimiter(perMinute(5), RateLimits{}, 0)
 	nextCalled := false
 	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		nextCalled = true
 		w.WriteHeader(http.StatusOK)
 	})
-	handler := l.Middleware(func(r *http.Request) string { return "key" }, next)
+	handler := l.Middleware(ReadClass, func(*http.Request, error) {}, next)
 
 	rec := httptest.NewRecorder()
-	req := httptest.NewRequest(http.MethodGet, "/", nil)
-	handler.ServeHTTP(rec, req)
+	handler.ServeHTTP(rec, requestAs("alice"))
 
 	if !nextCalled {
 		t.Error("next handler was not called")
@@ -87,19 +156,29 @@ func TestLimiter_Middleware_callsNextWhenAllowed(t *testing.T) {
 	if rec.Code != http.StatusOK {
 		t.Errorf("status = %d, want 200", rec.Code)
 	}
+	if got := rec.Header().Get("X-RateLimit-Limit"); got != "5" {
+		t.Errorf("X-RateLimit-Limit = %q, want 5", got)
+	}
+	if got := rec.Header().Get("X-RateLimit-Remaining"); got != "4" {
+		t.Errorf("X-RateLimit-Remaining = %q, want 4", got)
+	}
+	if got := rec.Header().Get("X-RateLimit-Reset"); got == "" {
+		t.Error("X-RateLimit-Reset not set")
+	}
 }
 
 func TestLimiter_Middleware_returns429WhenOverLimit(t *testing.T) {
-	l := NewLimiter(1, time.Minute)
+	l := NewLimiter(perMinute(1), RateLimits{}, 0)
 	nextCalled := false
 	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		nextCalled = true
 	})
-	handler := l.Middleware(func(r *http.Request) string { return "key" }, next)
+	var deniedErr error
+	denied := func(r *http.Request, err error) { deniedErr = err }
+	handler := l.Middleware(ReadClass, denied, next)
 
 	rec := httptest.NewRecorder()
-	req := httptest.NewRequest(http.MethodGet, "/", nil)
-	handler.ServeHTTP(rec, req)
+	handler.ServeHTTP(rec, requestAs("alice"))
 	if rec.Code != http.StatusOK {
 		t.Errorf("first request status = %d, want 200", rec.Code)
 	}
@@ -109,8 +188,7 @@ func TestLimiter_Middleware_returns429WhenOverLimit(t *testing.T) {
 
 	nextCalled = false
 	rec = httptest.NewRecorder()
-	req = httptest.NewRequest(http.MethodGet, "/", nil)
-	handler.ServeHTTP(rec, req)
+	handler.ServeHTTP(rec, requestAs("alice"))
 
 	if nextCalled {
 		t.Error("second request: next handler was called (should be rate limited)")
@@ -118,28 +196,102 @@ func TestLimiter_Middleware_returns429WhenOverLimit(t *testing.T) {
 	if rec.Code != http.StatusTooManyRequests {
 		t.Errorf("status = %d, want %d", rec.Code, http.StatusTooManyRequests)
 	}
+	if got := rec.Header().Get("X-RateLimit-Remaining"); got != "0" {
+		t.Errorf("X-RateLimit-Remaining = %q, want 0", got)
+	}
+	if deniedErr == nil {
+		t.Error("second request: denial was not reported")
+	}
 }
 
-func TestLimiter_Middleware_usesKeyFromRequest(t *testing.T) {
-	l := NewLimiter(1, time.Minute)
+func TestLimiter_Middleware_limitsPerPrincipal(t *testing.T) {
+	l := NewLimiter(perMinute(1), RateLimits{}, 0)
 	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
-	handler := l.Middleware(func(r *http.Request) string { return r.Header.Get("X-User") }, next)
+	handler := l.Middleware(ReadClass, func(*http.Request, error) {}, next)
 
 	rec := httptest.NewRecorder()
-	req := httptest.NewRequest(http.MethodGet, "/", nil)
-	req.Header.Set("X-User", "alice")
-	handler.ServeHTTP(rec, req)
+	handler.ServeHTTP(rec, requestAs("alice"))
 	if rec.Code != http.StatusOK {
 		t.Errorf("alice first request status = %d", rec.Code)
 	}
 
 	rec = httptest.NewRecorder()
-	req = httptest.NewRequest(http.MethodGet, "/", nil)
-	req.Header.Set("X-User", "bob")
-	handler.ServeHTTP(rec, req)
+	handler.ServeHTTP(rec, requestAs("bob"))
 	if rec.Code != http.StatusOK {
 		t.Errorf("bob request status = %d (bob has separate quota)", rec.Code)
 	}
 }
+
+func TestLimiter_Middleware_capsOperationsInFlight(t *testing.T) {
+	l := NewLimiter(RateLimit{}, RateLimits{}, 1)
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-finish
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := l.Middleware(OperationClass, func(*http.Request, error) {}, next)
+
+	done := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, requestAs("alice"))
+		done <- rec.Code
+	}()
+	<-started
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, requestAs("alice"))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("concurrent operation status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+
+	go func() {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, requestAs("bob"))
+		done <- rec.Code
+	}()
+	<-started
+
+	finish <- struct{}{}
+	finish <- struct{}{}
+	for i := 0; i < 2; i++ {
+		if code := <-done; code != http.StatusOK {
+			t.Errorf("operation status = %d, want 200", code)
+		}
+	}
+	if len(l.inFlight) != 0 {
+		t.Errorf("inFlight = %v after operations finished, want empty", l.inFlight)
+	}
+}
+
+func TestLimiter_Reserve(t *testing.T) {
+	l := NewLimiter(RateLimit{}, RateLimits{}, 4)
+	if err := l.acquire("alice"); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	reserved, release := l.Reserve("alice", 63)
+	if reserved != 3 {
+		t.Errorf("Reserve = %d, want the 3 remaining", reserved)
+	}
+	if again, _ := l.Reserve("alice", 1); again != 0 {
+		t.Errorf("Reserve at the cap = %d, want 0", again)
+	}
+	if err := l.acquire("alice"); err == nil {
+		t.Error("acquire at the cap = nil, want error")
+	}
+	release()
+	l.release("alice")
+	if len(l.inFlight) != 0 {
+		t.Errorf("inFlight = %v after release, want empty", l.inFlight)
+	}
+
+	unlimited := NewLimiter(RateLimit{}, RateLimits{}, 0)
+	if reserved, _ := unlimited.Reserve("alice", 63); reserved != 63 {
+		t.Errorf("Reserve without a cap = %d, want 63", reserved)
+	}
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+)
+
+// The first file descriptor passed by systemd socket activation, as described by sd_listen_fds(3)
+const listenFdsStart = 3
+
+// A unix socket on which to serve, named so that it may be tied to a permissions profile
+type Socket struct {
+	Name string
+	Path string
+}
+
+// ParseSocket parses a socket given as "name=path", or as a path alone for a socket with no name
+func ParseSocket(socket string) Socket {
+	if name, path, ok := strings.Cut(socket, "="); ok {
+		return Socket{Name: name, Path: path}
+	}
+	return Socket{Path: socket}
+}
+
+// A profile of permissions for connections through a named listener
+type ListenerProfile struct {
+	// Roles which replace those of the server for connections through the listener, if given
+	Roles auth.Roles `json:"roles,omitempty"`
+	// Claims which replace those of the server for connections through the listener, if given
+	Claims *auth.Claims `json:"claims,omitempty"`
+	// The only actions which may be performed through the listener, or any actions if empty
+	Actions []auth.Action `json:"actions,omitempty"`
+}
+
+// A listener which is named so that connections accepted by it can be attributed to it
+type namedListener struct {
+	net.Listener
+	name string
+}
+
+type listenerKey struct{}
+
+func listenerFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(listenerKey{}).(string)
+	return name
+}
+
+// listen opens a listener for each configured socket, and for each socket passed by systemd socket activation
+func listen(sockets []Socket) ([]*namedListener, error) {
+	var listeners []*namedListener
+	closeAll := func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}
+
+	for _, socket := range sockets {
+		addr, err := net.ResolveUnixAddr("unix", socket.Path)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		listener, err := net.ListenUnix("unix", addr)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		listeners = append(listeners, &namedListener{listener, socket.Name})
+	}
+
+	activated, err := activatedListeners()
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	listeners = append(listeners, activated...)
+
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf("No server socket")
+	}
+	return listeners, nil
+}
+
+// activatedListeners opens the sockets passed to the process by systemd socket activation, if any,
+// named by LISTEN_FDNAMES.
+func activatedListeners() ([]*namedListener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil {
+		// assume a single socket if the number is not given
+		count = 1
+	}
+
+	names := listenerNames(count, os.Getenv("LISTEN_FDNAMES"))
+	var listeners []*namedListener
+	for i, name := range names {
+		f := os.NewFile(uintptr(listenFdsStart+i), name)
+		listener, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+			return nil, fmt.Errorf("failed to listen on activated socket %d (%s): %w", listenFdsStart+i, name, err)
+		}
+		listeners = append(listeners, &namedListener{listener, name})
+	}
+	return listeners, nil
+}
+
+// listenerNames gives the names of the given number of activated sockets from the colon-separated
+// LISTEN_FDNAMES, leaving any which are not named empty.
+func listenerNames(count int, fdNames string) []string {
+	names := make([]string, count)
+	if fdNames != "" {
+		copy(names, strings.Split(fdNames, ":"))
+	}
+	return names
+}
+
+// The profile of the listener through which the caller connected
+func (p *Permissions) profile(identity *auth.Identity) ListenerProfile {
+	return p.Listeners[identity.Listener]
+}
+
+// The roles which apply to the caller, which may be replaced by the profile of their listener
+func (p *Permissions) roles(identity *auth.Identity) auth.Roles {
+	if roles := p.profile(identity).Roles; roles != nil {
+		return roles
+	}
+	return p.Roles
+}
+
+// The claims which apply to a connection through a listener, which may be replaced by its profile
+func (p *Permissions) claims(listener string) *auth.Claims {
+	if claims := p.Listeners[listener].Claims; claims != nil {
+		return claims
+	}
+	return &p.Claims
+}
+
+// assertPermission asserts that the caller has the given permissions through their roles,
+// and that the actions are permitted through the listener by which they connected.
+func (p *Permissions) assertPermission(identity *auth.Identity, permissions auth.Permissions) error {
+	err := p.roles(identity).AssertPermission(identity.Roles, permissions)
+	if err != nil {
+		return err
+	}
+	actions := p.profile(identity).Actions
+	if len(actions) == 0 || slices.Contains(actions, auth.Any) {
+		return nil
+	}
+	for subject, required := range permissions {
+		for _, action := range required {
+			if !slices.Contains(actions, action) {
+				return fmt.Errorf("%s on %s is not permitted through listener %q", action, subject, identity.Listener)
+			}
+		}
+	}
+	return nil
+}
+
+// checkPermission is as assertPermission, reporting whether the permissions are held
+func (p *Permissions) checkPermission(identity *auth.Identity, permissions auth.Permissions) bool {
+	return p.assertPermission(identity, permissions) == nil
+}
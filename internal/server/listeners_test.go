@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseSocket(t *testing.T) {
+	tests := []struct {
+		socket string
+		want   Socket
+	}{
+		{"/run/agent.socket", Socket{Path: "/run/agent.socket"}},
+		{"admin=/run/admin.socket", Socket{Name: "admin", Path: "/run/admin.socket"}},
+	}
+	for _, tt := range tests {
+		if got := ParseSocket(tt.socket); got != tt.want {
+			t.Errorf("ParseSocket(%q) = %+v, want %+v", tt.socket, got, tt.want)
+		}
+	}
+}
+
+func TestListenerNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		count   int
+		fdNames string
+		want    []string
+	}{
+		{"unnamed", 2, "", []string{"", ""}},
+		{"named", 2, "public:admin", []string{"public", "admin"}},
+		{"fewer names", 3, "public", []string{"public", "", ""}},
+		{"more names", 1, "public:admin", []string{"public"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, listenerNames(tt.count, tt.fdNames)); diff != "" {
+				t.Errorf("listenerNames mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestListen_namedSockets(t *testing.T) {
+	dir := t.TempDir()
+	listeners, err := listen([]Socket{
+		{Name: "public", Path: filepath.Join(dir, "public.socket")},
+		{Name: "admin", Path: filepath.Join(dir, "admin.socket")},
+	})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	var names []string
+	for _, listener := range listeners {
+		names = append(names, listener.name)
+		listener.Close()
+	}
+	if !slices.Equal(names, []string{"public", "admin"}) {
+		t.Errorf("listener names = %v, want [public admin]", names)
+	}
+}
+
+func TestPermissions_assertPermission_listenerProfile(t *testing.T) {
+	p := &Permissions{
+		Roles: auth.Roles{"admin": {Name: "admin", Permissions: auth.Permissions{auth.All: {auth.Any}}}},
+		Listeners: map[string]ListenerProfile{
+			"public": {Actions: []auth.Action{auth.Read, auth.List}},
+			"guest":  {Roles: auth.Roles{"guest": {Name: "guest", Permissions: auth.Permissions{auth.Secrets: {auth.List}}}}},
+		},
+	}
+	tests := []struct {
+		name        string
+		listener    string
+		roles       auth.ClaimedRoles
+		permissions auth.Permissions
+		wantErr     bool
+	}{
+		{"unnamed listener", "", auth.ClaimedRoles{"admin"}, auth.Permissions{auth.Instances: {auth.Write}}, false},
+		{"unprofiled listener", "other", auth.ClaimedRoles{"admin"}, auth.Permissions{auth.Instances: {auth.Write}}, false},
+		{"action within cap", "public", auth.ClaimedRoles{"admin"}, auth.Permissions{auth.Secrets: {auth.Read}}, false},
+		{"action outside cap", "public", auth.ClaimedRoles{"admin"}, auth.Permissions{auth.Instances: {auth.Write}}, true},
+		{"role replaced by profile", "guest", auth.ClaimedRoles{"admin"}, auth.Permissions{auth.Secrets: {auth.List}}, true},
+		{"role of profile", "guest", auth.ClaimedRoles{"guest"}, auth.Permissions{auth.Secrets: {auth.List}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity := &auth.Identity{Principal: "alice", Roles: tt.roles, Listener: tt.listener}
+			err := p.assertPermission(identity, tt.permissions)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("assertPermission = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// peerConnection connects to a unix socket from this process, giving the server end of the connection and the
+// executable of this process, by which it may claim roles
+func peerConnection(t *testing.T) (*net.UnixConn, string) {
+	t.Helper()
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: filepath.Join(t.TempDir(), "socket"), Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	client, err := net.DialUnix("unix", nil, listener.Addr().(*net.UnixAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	server, err := listener.AcceptUnix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, exe
+}
+
+func TestPermissions_Middleware_listenerClaims(t *testing.T) {
+	server, exe := peerConnection(t)
+
+	p := &Permissions{
+		Roles: auth.Roles{"tester": {Name: "tester", Permissions: auth.Permissions{auth.Secrets: {auth.Read}}}},
+		Listeners: map[string]ListenerProfile{
+			"test": {Claims: &auth.Claims{PlatformClaims: auth.PlatformClaims{Executables: map[string]auth.ClaimedRoles{exe: {"tester"}}}}},
+		},
+	}
+	var identity *auth.Identity
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity = identityFromContext(r.Context())
+	})
+	handler := p.Middleware(auth.Permissions{auth.Secrets: {auth.Read}}, func(*http.Request, error) {}, next)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	ctx := context.WithValue(req.Context(), connectionKey{}, server)
+	ctx = context.WithValue(ctx, listenerKey{}, "test")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req.WithContext(ctx))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want claims of the listener profile to grant access", rec.Code)
+	}
+	if identity.Listener != "test" {
+		t.Errorf("Listener = %q, want test", identity.Listener)
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/logging"
+)
+
+// The header by which a caller may identify a request, and by which the ID of each request is returned
+const requestIdHeader = "X-Request-ID"
+
+// Request IDs which are accepted from callers, which are otherwise replaced by a generated ID
+var requestIdPattern = regexp.MustCompile(`^[\w.:@/-]{1,128}$`)
+
+// The details of a request which are only known once it is authenticated, recorded for its access log
+type accessEntry struct {
+	principal string
+}
+
+type accessEntryKey struct{}
+
+// recordPrincipal records the authenticated principal of a request for its access log
+func recordPrincipal(ctx context.Context, principal string) {
+	if entry, ok := ctx.Value(accessEntryKey{}).(*accessEntry); ok {
+		entry.principal = principal
+	}
+}
+
+// logRequests identifies each request by the ID given by the caller or a generated one, which is attached
+// to everything logged on its behalf, and writes an access log line once it has been served.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestId := r.Header.Get(requestIdHeader)
+		if !requestIdPattern.MatchString(requestId) {
+			requestId = logging.NewRequestId()
+		}
+		w.Header().Set(requestIdHeader, requestId)
+
+		entry := &accessEntry{}
+		ctx := logging.WithRequestId(r.Context(), requestId)
+		r = r.WithContext(context.WithValue(ctx, accessEntryKey{}, entry))
+		recorder := &statusRecorder{ResponseWriter: w}
+		started := time.Now()
+		next.ServeHTTP(recorder, r)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		slog.InfoContext(ctx, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"route", r.Pattern,
+			"status", recorder.status,
+			"duration", time.Since(started),
+			"principal", entry.principal,
+			"listener", listenerFromContext(ctx),
+		)
+	})
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eliasvasylenko/secret-agent/internal/logging"
+	"github.com/google/go-cmp/cmp"
+)
+
+// captureLogs directs the default logger to a buffer for the duration of the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	var out bytes.Buffer
+	logger, err := logging.NewLogger(&out, logging.JSON, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(saved) })
+	return &out
+}
+
+func TestLogRequests(t *testing.T) {
+	tests := []struct {
+		name      string
+		requestId string
+		generated bool
+	}{
+		{name: "given", requestId: "abc-123"},
+		{name: "missing", generated: true},
+		{name: "invalid", requestId: "not a valid id", generated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := captureLogs(t)
+			var seen string
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /things/{id}", func(w http.ResponseWriter, r *http.Request) {
+				seen = logging.RequestId(r.Context())
+				recordPrincipal(r.Context(), "alice")
+				w.WriteHeader(http.StatusCreated)
+			})
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://test/things/1", nil)
+			if tt.requestId != "" {
+				req.Header.Set(requestIdHeader, tt.requestId)
+			}
+			logRequests(mux).ServeHTTP(rec, req)
+
+			requestId := rec.Header().Get(requestIdHeader)
+			if tt.generated && (requestId == "" || requestId == tt.requestId) {
+				t.Errorf("%s = %q, want a generated ID", requestIdHeader, requestId)
+			}
+			if !tt.generated && requestId != tt.requestId {
+				t.Errorf("%s = %q, want %q", requestIdHeader, requestId, tt.requestId)
+			}
+			if seen != requestId {
+				t.Errorf("handler saw request ID %q, want %q", seen, requestId)
+			}
+
+			var line map[string]any
+			if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+				t.Fatalf("%v: %s", err, out.Bytes())
+			}
+			delete(line, "time")
+			delete(line, "duration")
+			want := map[string]any{
+				"level":     "INFO",
+				"msg":       "request",
+				"method":    "GET",
+				"path":      "/things/1",
+				"route":     "GET /things/{id}",
+				"status":    201.0,
+				"principal": "alice",
+				"listener":  "",
+				"requestId": requestId,
+			}
+			if diff := cmp.Diff(want, line); diff != "" {
+				t.Errorf("access log mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
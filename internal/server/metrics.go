@@ -0,0 +1,94 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/eliasvasylenko/secret-agent/internal/metrics"
+)
+
+var (
+	requestsTotal = metrics.Default.NewCounterVec(
+		"secret_agent_http_requests_total",
+		"API requests served, by route and status code.",
+		"route", "code",
+	)
+	rateLimitRejections = metrics.Default.NewCounterVec(
+		"secret_agent_rate_limit_rejections_total",
+		"Requests rejected by the rate limiter, by endpoint class and reason.",
+		"class", "reason",
+	)
+	authDenials = metrics.Default.NewCounterVec(
+		"secret_agent_auth_denials_total",
+		"Requests denied on authentication or authorisation, by route and status code.",
+		"route", "code",
+	)
+	denialsDropped = metrics.Default.NewCounterVec(
+		"secret_agent_denials_dropped_total",
+		"Denied requests not recorded in the audit trail, as the peer was over its limit or the queue was full.",
+		"reason",
+	)
+)
+
+// A response writer which records the status code written, for metrics
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+// Unwrap gives the underlying response writer, so that it may be flushed through a response controller
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// countRequests counts the requests served by the handler by route and status code
+func countRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		requestsTotal.Inc(r.Pattern, strconv.Itoa(recorder.status))
+	})
+}
+
+// serveMetrics serves metrics for scraping on a TCP address, or on a unix socket if the address is a path
+func serveMetrics(address string) (*http.Server, error) {
+	network := "tcp"
+	if strings.HasPrefix(address, "/") {
+		network = "unix"
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Default.Handler())
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("failed to serve metrics", "error", err)
+		}
+	}()
+	return srv, nil
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/eliasvasylenko/secret-agent/internal/metrics"
+)
+
+func TestCountRequests(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /counted/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler := countRequests(mux)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/counted/1", nil))
+
+	var out strings.Builder
+	metrics.Default.WriteText(&out)
+	if want := `secret_agent_http_requests_total{route="GET /counted/{id}",code="418"} 1`; !strings.Contains(out.String(), want) {
+		t.Errorf("metrics = %s, want to contain %s", out.String(), want)
+	}
+}
+
+func TestServeMetrics(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "metrics.socket")
+	srv, err := serveMetrics(socket)
+	if err != nil {
+		t.Fatalf("serveMetrics: %v", err)
+	}
+	defer srv.Close()
+	rateLimitRejections.Inc(string(AdminClass), "rate")
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}}
+	response, err := client.Get("http://metrics/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics: %v", err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d: %s", response.StatusCode, body)
+	}
+	if want := `secret_agent_rate_limit_rejections_total{class="admin",reason="rate"}`; !strings.Contains(string(body), want) {
+		t.Errorf("metrics = %s, want to contain %s", body, want)
+	}
+}
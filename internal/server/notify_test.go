@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// listenNotify listens on a datagram socket standing in for the service manager, and points NOTIFY_SOCKET at it
+func listenNotify(t *testing.T) *net.UnixConn {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "notify.socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("ListenUnixgram: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", socket)
+	return conn
+}
+
+func readNotification(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 256)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("reading notification: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestNotify(t *testing.T) {
+	conn := listenNotify(t)
+
+	if err := notify("READY=1"); err != nil {
+		t.Fatalf("notify: %v", err)
+	}
+	if got := readNotification(t, conn); got != "READY=1" {
+		t.Errorf("notification = %q, want READY=1", got)
+	}
+}
+
+func TestNotify_noSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := notify("READY=1"); err != nil {
+		t.Errorf("notify without socket = %v, want nil", err)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	tests := []struct {
+		name string
+		usec string
+		pid  string
+		want time.Duration
+	}{
+		{"disabled", "", "", 0},
+		{"enabled", "2000000", "", 2 * time.Second},
+		{"enabled for this process", "2000000", strconv.Itoa(os.Getpid()), 2 * time.Second},
+		{"enabled for another process", "2000000", "1", 0},
+		{"invalid", "soon", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("WATCHDOG_USEC", tt.usec)
+			t.Setenv("WATCHDOG_PID", tt.pid)
+			if got := watchdogInterval(); got != tt.want {
+				t.Errorf("watchdogInterval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStartWatchdog(t *testing.T) {
+	conn := listenNotify(t)
+	t.Setenv("WATCHDOG_USEC", "20000")
+	t.Setenv("WATCHDOG_PID", "")
+
+	stop := startWatchdog()
+	defer stop()
+	if got := readNotification(t, conn); got != "WATCHDOG=1" {
+		t.Errorf("notification = %q, want WATCHDOG=1", got)
+	}
+}
@@ -0,0 +1,630 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/audit"
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/bulk"
+	"github.com/eliasvasylenko/secret-agent/internal/command"
+	"github.com/eliasvasylenko/secret-agent/internal/events"
+	"github.com/eliasvasylenko/secret-agent/internal/marshal"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+)
+
+// The version of the OpenAPI specification to which the description of the API conforms
+const openAPIVersion = "3.0.3"
+
+// A route registered by the controller, with the class and permissions given to its middleware
+type route struct {
+	pattern     string
+	class       LimitClass
+	permissions auth.Permissions
+	handler     http.HandlerFunc
+}
+
+func (r *route) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.handler(w, req)
+}
+
+// name gives the name of the controller method which handles the route
+func (r *route) name() string {
+	name := runtime.FuncForPC(reflect.ValueOf(r.handler).Pointer()).Name()
+	name = name[strings.LastIndex(name, ".")+1:]
+	return strings.TrimSuffix(name, "-fm")
+}
+
+// routes collects the routes of the controller in the order they are registered
+func (c *Controller) routes() []*route {
+	collector := *c
+	collector.middleware = func(class LimitClass, perms auth.Permissions, next http.HandlerFunc) http.Handler {
+		return &route{class: class, permissions: perms, handler: next}
+	}
+	collector.unauthenticated = func(next http.HandlerFunc) http.Handler {
+		return &route{handler: next}
+	}
+	var routes []*route
+	collector.buildHandler(func(pattern string, handler http.Handler) {
+		route := handler.(*route)
+		route.pattern = pattern
+		routes = append(routes, route)
+	})
+	return routes
+}
+
+// A parameter of a request, given in its query or headers
+type parameterDoc struct {
+	name        string
+	description string
+	// A value of the type of the parameter
+	example any
+}
+
+// A response to a request, with a body of the type of the given value
+type responseDoc struct {
+	description string
+	body        any
+	// The media type of the body, if not JSON
+	contentType string
+	// Descriptions of headers of the response, by name
+	headers map[string]string
+}
+
+// The documentation of an endpoint, which is described along with the metadata of its route
+type endpointDoc struct {
+	summary     string
+	description string
+	query       []parameterDoc
+	headers     []parameterDoc
+	// A value of the type of the body of the request, if any
+	request any
+	// Successful responses by status code. Errors are described by the default response.
+	responses map[int]responseDoc
+}
+
+var rangeParameters = []parameterDoc{
+	{"from", "The first item of the page", 0},
+	{"to", "The item after the last of the page", 0},
+}
+
+var operationHeaders = []parameterDoc{
+	{"Idempotency-Key", "A key chosen by the caller to identify the request, so that a retry returns the outcome of the original request", ""},
+	{"If-Match", "The entity tag of the state of the secret, so that the operation is only performed if nothing has happened to the secret since", ""},
+}
+
+var requestIdParameter = parameterDoc{
+	requestIdHeader, "An ID for the request, which is attached to its logs and to any operation it starts, or a generated ID if not given", "",
+}
+
+var apiVersionParameter = parameterDoc{
+	APIVersionHeader, "The version of the API which the caller requires, which is refused as not acceptable if it is not supported", APIVersion,
+}
+
+var etagHeader = map[string]string{
+	"ETag": "The entity tag of the state of the secret, identifying its latest operation",
+}
+
+var operationResponses = map[int]responseDoc{
+	http.StatusOK:       {description: "The operation was performed", body: &secrets.Instance{}},
+	http.StatusAccepted: {description: "The operation requires approval, which was requested", body: &secrets.Approval{}},
+}
+
+// Documentation of each route of the controller, keyed by its pattern
+var endpointDocs = map[string]endpointDoc{
+	"GET /secrets": {
+		summary:   "List secrets",
+		responses: map[int]responseDoc{http.StatusOK: {description: "The secrets", body: ItemsResponse[secrets.Secrets]{}}},
+	},
+	"GET /secrets/{secretId}": {
+		summary:   "Get a secret",
+		responses: map[int]responseDoc{http.StatusOK: {description: "The secret", body: &secrets.Secret{}}},
+	},
+	"GET /secrets/{secretId}/instances": {
+		summary: "List the instances of a secret",
+		query:   rangeParameters,
+		responses: map[int]responseDoc{http.StatusOK: {
+			description: "The instances of the secret",
+			body:        ItemsResponse[secrets.Instances]{},
+			headers:     etagHeader,
+		}},
+	},
+	"POST /secrets/{secretId}/instances": {
+		summary:     "Create an instance of a secret",
+		description: "The operation is subject to the operation policies of the server, and may require approval.",
+		headers:     operationHeaders,
+		request:     OperationParameters{},
+		responses:   operationResponses,
+	},
+	"GET /secrets/{secretId}/active": {
+		summary: "Get the active instance of a secret",
+		responses: map[int]responseDoc{http.StatusOK: {
+			description: "The active instance of the secret, or null if no instance is active",
+			body:        &secrets.Instance{},
+			headers:     etagHeader,
+		}},
+	},
+	"GET /secrets/{secretId}/instances/{instanceId}": {
+		summary: "Get an instance of a secret",
+		responses: map[int]responseDoc{http.StatusOK: {
+			description: "The instance",
+			body:        &secrets.Instance{},
+			headers:     etagHeader,
+		}},
+	},
+	"GET /secrets/{secretId}/instances/{instanceId}/operations": {
+		summary:   "List the operations performed on an instance of a secret",
+		query:     rangeParameters,
+		responses: map[int]responseDoc{http.StatusOK: {description: "The operations", body: []*secrets.Operation{}}},
+	},
+	"POST /secrets/{secretId}/instances/{instanceId}/operations": {
+		summary:     "Perform an operation on an instance of a secret",
+		description: "The operation is subject to the operation policies of the server, and may require approval.",
+		headers:     operationHeaders,
+		request:     CreateOperationParameters{},
+		responses:   operationResponses,
+	},
+	"POST /bulk/operations": {
+		summary:     "Perform an operation across a selection of secrets",
+		description: "Requires the permissions of the operation, as for a single operation, which beyond those of the route are write on secrets for operations other than create. Each selected secret is authorised as for a single operation, and secrets which require approval are refused.",
+		request:     bulk.Request{},
+		responses:   map[int]responseDoc{http.StatusOK: {description: "The outcome of the batch", body: &bulk.Report{}}},
+	},
+	"GET /approvals": {
+		summary:   "List approval requests",
+		query:     rangeParameters,
+		responses: map[int]responseDoc{http.StatusOK: {description: "The approval requests", body: ItemsResponse[[]*secrets.Approval]{}}},
+	},
+	"GET /approvals/{approvalId}": {
+		summary:   "Get an approval request",
+		responses: map[int]responseDoc{http.StatusOK: {description: "The approval request", body: &secrets.Approval{}}},
+	},
+	"POST /approvals/{approvalId}/approve": {
+		summary:     "Approve a request",
+		description: "The requested operation is performed once the request has enough approvals.",
+		request:     DecisionParameters{},
+		responses:   map[int]responseDoc{http.StatusOK: {description: "The approval request", body: &secrets.Approval{}}},
+	},
+	"POST /approvals/{approvalId}/reject": {
+		summary:   "Reject a request",
+		request:   DecisionParameters{},
+		responses: map[int]responseDoc{http.StatusOK: {description: "The approval request", body: &secrets.Approval{}}},
+	},
+	"GET /audit/denials": {
+		summary:   "List denied requests",
+		query:     rangeParameters,
+		responses: map[int]responseDoc{http.StatusOK: {description: "The denials", body: ItemsResponse[[]*audit.Denial]{}}},
+	},
+	"GET /elevations": {
+		summary:   "List elevations",
+		query:     rangeParameters,
+		responses: map[int]responseDoc{http.StatusOK: {description: "The elevations", body: ItemsResponse[[]*auth.Elevation]{}}},
+	},
+	"POST /elevations": {
+		summary:     "Elevate the caller to a role",
+		description: "Requires the elevate permission on elevations, and the elevation is subject to the elevation policies of the server.",
+		request:     ElevationParameters{},
+		responses:   map[int]responseDoc{http.StatusOK: {description: "The elevation", body: &auth.Elevation{}}},
+	},
+	"POST /elevations/{elevationId}/revoke": {
+		summary:     "Revoke an elevation",
+		description: "Requires the elevate permission on elevations. An elevation may be revoked by its principal, or by a caller who also has write permission on elevations.",
+		responses:   map[int]responseDoc{http.StatusOK: {description: "The revoked elevation", body: &auth.Elevation{}}},
+	},
+	"GET /events": {
+		summary:     "Stream events",
+		description: "Only events which the caller is permitted to read are sent. The stream is gone if events following the event given by Last-Event-ID have been pruned, in which case it must be started again from the latest event.",
+		query:       []parameterDoc{{"secretId", "The secret about which to send events, or any if not given", ""}},
+		headers:     []parameterDoc{{"Last-Event-ID", "The event after which to resume the stream, or the latest event if not given", 0}},
+		responses: map[int]responseDoc{http.StatusOK: {
+			description: "A stream of server-sent events, with data of the given schema",
+			body:        &events.Event{},
+			contentType: "text/event-stream",
+		}},
+	},
+	"GET /whoami": {
+		summary: "Describe the caller and their permissions",
+		query: []parameterDoc{
+			{"secretId", "The secret upon which to check an operation", ""},
+			{"operation", "An operation to check whether the caller could request", ""},
+			{"forced", "Whether to check a forced operation", false},
+			{"reason", "The reason to give for the operation", ""},
+		},
+		responses: map[int]responseDoc{http.StatusOK: {description: "The caller", body: &Whoami{}}},
+	},
+	"GET /healthz": {
+		summary:   "Check that the agent is alive",
+		responses: map[int]responseDoc{http.StatusOK: {description: "The agent is alive", body: map[string]string{}}},
+	},
+	"GET /readyz": {
+		summary:     "Check that the agent is ready to serve requests",
+		description: "The database must answer with a current schema, the configuration must be loaded, and no operation may have been running for longer than the configured threshold.",
+		responses: map[int]responseDoc{
+			http.StatusOK:                 {description: "The agent is ready", body: &Readiness{}},
+			http.StatusServiceUnavailable: {description: "The agent is not ready", body: &Readiness{}},
+		},
+	},
+	"GET /status": {
+		summary:     "Describe the status of the running agent",
+		description: "Requires read permission on status, as the configuration files loaded by the agent are described.",
+		responses:   map[int]responseDoc{http.StatusOK: {description: "The status of the agent", body: &AgentStatus{}}},
+	},
+	"GET /status/secrets": {
+		summary:     "Summarise the status of every secret",
+		description: "Each secret is given with its active instance, its latest, running and test operations, its number of live instances, and a verdict upon its health. Operations are judged stuck by the same threshold as readiness.",
+		responses:   map[int]responseDoc{http.StatusOK: {description: "The summaries, in order of secret ID", body: ItemsResponse[[]*secrets.Summary]{}}},
+	},
+	"GET /openapi.json": {
+		summary:   "Describe the API",
+		responses: map[int]responseDoc{http.StatusOK: {description: "An OpenAPI description of the API", body: map[string]any{}}},
+	},
+}
+
+var pathParameterPattern = regexp.MustCompile(`\{(\w+)\}`)
+
+func (s *Controller) getOpenAPI(w http.ResponseWriter, r *http.Request) {
+	document, err := s.openAPI()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeResult(w, document, http.StatusOK)
+}
+
+// openAPI describes the routes of the controller as an OpenAPI document
+func (c *Controller) openAPI() (map[string]any, error) {
+	schemas := newSchemaBuilder()
+	errorSchema, err := schemas.schema(reflect.TypeFor[ErrorResponse]())
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[string]map[string]any)
+	for _, route := range c.routes() {
+		doc, ok := endpointDocs[route.pattern]
+		if !ok {
+			return nil, fmt.Errorf("route %s is not documented", route.pattern)
+		}
+		operation, err := doc.describe(schemas, route)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe route %s: %w", route.pattern, err)
+		}
+		operation["responses"].(map[string]any)["default"] = map[string]any{
+			"description": "An error",
+			"content":     map[string]any{"application/json": map[string]any{"schema": errorSchema}},
+		}
+
+		method, path, _ := strings.Cut(route.pattern, " ")
+		if paths[path] == nil {
+			paths[path] = make(map[string]any)
+		}
+		paths[path][strings.ToLower(method)] = operation
+	}
+
+	return map[string]any{
+		"openapi": openAPIVersion,
+		"info": map[string]any{
+			"title":   "secret-agent",
+			"version": APIVersion,
+		},
+		"servers": []map[string]any{
+			{"url": APIPrefix, "description": "The current version of the API"},
+			{"url": "/", "description": "Unversioned aliases of the current version, for clients which predate versioning"},
+		},
+		"paths":      paths,
+		"components": map[string]any{"schemas": schemas.components},
+	}, nil
+}
+
+// describe gives the OpenAPI operation object of an endpoint
+func (d endpointDoc) describe(schemas *schemaBuilder, route *route) (map[string]any, error) {
+	operation := map[string]any{
+		"operationId":        route.name(),
+		"summary":            d.summary,
+		"description":        strings.TrimSpace(d.description + " " + describePermissions(route.permissions)),
+		"x-permissions":      route.permissions,
+		"x-rate-limit-class": route.class,
+	}
+	if route.permissions == nil {
+		operation["x-permissions"] = auth.Permissions{}
+		operation["x-unauthenticated"] = true
+		delete(operation, "x-rate-limit-class")
+	}
+
+	parameters := []any{}
+	for _, match := range pathParameterPattern.FindAllStringSubmatch(route.pattern, -1) {
+		parameters = append(parameters, map[string]any{
+			"name":     match[1],
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]any{"type": "string"},
+		})
+	}
+	for _, location := range []struct {
+		in         string
+		parameters []parameterDoc
+	}{{"query", d.query}, {"header", append(slices.Clone(d.headers), requestIdParameter, apiVersionParameter)}} {
+		for _, parameter := range location.parameters {
+			schema, err := schemas.schema(reflect.TypeOf(parameter.example))
+			if err != nil {
+				return nil, err
+			}
+			parameters = append(parameters, map[string]any{
+				"name":        parameter.name,
+				"in":          location.in,
+				"description": parameter.description,
+				"schema":      schema,
+			})
+		}
+	}
+	if len(parameters) > 0 {
+		operation["parameters"] = parameters
+	}
+
+	if d.request != nil {
+		schema, err := schemas.requestSchema(reflect.TypeOf(d.request))
+		if err != nil {
+			return nil, err
+		}
+		operation["requestBody"] = map[string]any{
+			"required": true,
+			"content":  map[string]any{"application/json": map[string]any{"schema": schema}},
+		}
+	}
+
+	responses := make(map[string]any)
+	for code, response := range d.responses {
+		schema, err := schemas.schema(reflect.TypeOf(response.body))
+		if err != nil {
+			return nil, err
+		}
+		contentType := response.contentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		described := map[string]any{
+			"description": response.description,
+			"content":     map[string]any{contentType: map[string]any{"schema": schema}},
+		}
+		if len(response.headers) > 0 {
+			headers := make(map[string]any)
+			for name, description := range response.headers {
+				headers[name] = map[string]any{"description": description, "schema": map[string]any{"type": "string"}}
+			}
+			described["headers"] = headers
+		}
+		responses[fmt.Sprint(code)] = described
+	}
+	operation["responses"] = responses
+
+	return operation, nil
+}
+
+// describePermissions states the permissions required by a route, e.g. "Requires write on instances."
+func describePermissions(permissions auth.Permissions) string {
+	if permissions == nil {
+		return "Requires no authentication."
+	}
+	var required []string
+	for subject, actions := range permissions {
+		for _, action := range actions {
+			required = append(required, fmt.Sprintf("%s on %s", action, subject))
+		}
+	}
+	if len(required) == 0 {
+		return "Requires no permissions beyond authentication."
+	}
+	slices.Sort(required)
+	return "Requires " + strings.Join(required, ", ") + "."
+}
+
+// Builds JSON schemas for Go types as they are marshalled, collecting named structs as components
+type schemaBuilder struct {
+	components map[string]any
+	// Whether the types being described are read from the body of a request rather than written to a response
+	request bool
+	// The names of the components which are read from requests
+	requestComponents map[string]bool
+}
+
+func newSchemaBuilder() *schemaBuilder {
+	return &schemaBuilder{components: make(map[string]any), requestComponents: make(map[string]bool)}
+}
+
+// requestSchema gives the schema of a type which is read from the body of a request. A field of a request is only
+// required if it is tagged `openapi:"required"`, since its zero value may be valid even if it is not omitted when
+// marshalled.
+func (b *schemaBuilder) requestSchema(t reflect.Type) (map[string]any, error) {
+	b.request = true
+	defer func() { b.request = false }()
+	return b.schema(t)
+}
+
+// override gives the schema of a type which is marshalled by custom methods, or nil if it is not
+func (b *schemaBuilder) override(t reflect.Type) (map[string]any, error) {
+	switch t {
+	case reflect.TypeFor[time.Time]():
+		return map[string]any{"type": "string", "format": "date-time"}, nil
+	case reflect.TypeFor[json.RawMessage]():
+		return map[string]any{"description": "Any JSON value"}, nil
+	case reflect.TypeFor[marshal.Duration]():
+		return map[string]any{"type": "string", "description": "A duration such as 1h30m"}, nil
+	case reflect.TypeFor[marshal.Regexp]():
+		return map[string]any{"type": "string", "format": "regex"}, nil
+	case reflect.TypeFor[secrets.Secrets]():
+		return b.schema(reflect.TypeFor[[]*secrets.Secret]())
+	case reflect.TypeFor[secrets.Instances]():
+		return b.schema(reflect.TypeFor[[]*secrets.Instance]())
+	case reflect.TypeFor[auth.Actions]():
+		return b.oneOrMany(reflect.TypeFor[auth.Action]())
+	case reflect.TypeFor[auth.ClaimedRoles]():
+		return b.oneOrMany(reflect.TypeFor[auth.RoleName]())
+	case reflect.TypeFor[command.Command]():
+		object, err := b.structSchema(t)
+		if err != nil {
+			return nil, err
+		}
+		script := map[string]any{"type": "string", "description": "The script alone, with no environment"}
+		return map[string]any{"oneOf": []any{script, object}}, nil
+	}
+	return nil, nil
+}
+
+// oneOrMany gives the schema of a list which is marshalled as its only element if it has one
+func (b *schemaBuilder) oneOrMany(element reflect.Type) (map[string]any, error) {
+	one, err := b.schema(element)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"oneOf": []any{one, map[string]any{"type": "array", "items": one}}}, nil
+}
+
+// schema gives the schema of a type, or a reference to it if it is a named struct
+func (b *schemaBuilder) schema(t reflect.Type) (map[string]any, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if schema, err := b.override(t); schema != nil || err != nil {
+		return schema, err
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}, nil
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}, nil
+	case reflect.String:
+		return map[string]any{"type": "string"}, nil
+	case reflect.Interface:
+		return map[string]any{}, nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}, nil
+		}
+		items, err := b.schema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "array", "items": items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported map key type %s", t.Key())
+		}
+		values, err := b.schema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "object", "additionalProperties": values}, nil
+	case reflect.Struct:
+		// generic types are described inline, as their names are not valid component names
+		if t.Name() == "" || strings.Contains(t.Name(), "[") {
+			return b.structSchema(t)
+		}
+		name := path.Base(t.PkgPath()) + "." + t.Name()
+		ref := map[string]any{"$ref": "#/components/schemas/" + name}
+		if _, ok := b.components[name]; ok {
+			if b.requestComponents[name] != b.request {
+				return nil, fmt.Errorf("%s is described both as a request and as a response, whose required fields differ", name)
+			}
+			return ref, nil
+		}
+		// reserve the name before describing the struct, so that recursive references to it terminate
+		b.components[name] = nil
+		b.requestComponents[name] = b.request
+		schema, err := b.structSchema(t)
+		if err != nil {
+			delete(b.components, name)
+			delete(b.requestComponents, name)
+			return nil, err
+		}
+		b.components[name] = schema
+		return ref, nil
+	default:
+		return nil, fmt.Errorf("unsupported type %s", t)
+	}
+}
+
+// structSchema describes the fields of a struct as they are marshalled, including those of embedded structs
+func (b *schemaBuilder) structSchema(t reflect.Type) (map[string]any, error) {
+	properties := make(map[string]any)
+	var required []string
+	err := b.addFields(t, properties, &required)
+	if err != nil {
+		return nil, err
+	}
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		slices.Sort(required)
+		schema["required"] = required
+	}
+	return schema, nil
+}
+
+func (b *schemaBuilder) addFields(t reflect.Type, properties map[string]any, required *[]string) error {
+	var embedded []reflect.Type
+	for i := range t.NumField() {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+			embedded = append(embedded, fieldType)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		schema, err := b.schema(field.Type)
+		if err != nil {
+			return fmt.Errorf("field %s of %s: %w", field.Name, t, err)
+		}
+		properties[name] = schema
+		if b.request {
+			if field.Tag.Get("openapi") == "required" {
+				*required = append(*required, name)
+			}
+		} else if !strings.Contains(options, "omitempty") && !strings.Contains(options, "omitzero") {
+			*required = append(*required, name)
+		}
+	}
+
+	// fields of embedded structs are promoted unless they are hidden by the fields of the outer struct
+	for _, embeddedType := range embedded {
+		embeddedProperties := make(map[string]any)
+		var embeddedRequired []string
+		if err := b.addFields(embeddedType, embeddedProperties, &embeddedRequired); err != nil {
+			return err
+		}
+		for name, schema := range embeddedProperties {
+			if _, ok := properties[name]; !ok {
+				properties[name] = schema
+				if slices.Contains(embeddedRequired, name) {
+					*required = append(*required, name)
+				}
+			}
+		}
+	}
+	return nil
+}
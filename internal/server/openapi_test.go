@@ -0,0 +1,254 @@
+package server
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/mocks"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestController_openAPI_documentsEveryRoute(t *testing.T) {
+	c := NewController(&mocks.MockSecrets{}, nil, nil, nil, nil, noopLimiter{}, noopPermissions{})
+
+	registered := make(map[string]bool)
+	for _, route := range c.routes() {
+		registered[route.pattern] = true
+		if _, ok := endpointDocs[route.pattern]; !ok {
+			t.Errorf("route %s is not documented", route.pattern)
+		}
+	}
+	for pattern := range endpointDocs {
+		if !registered[pattern] {
+			t.Errorf("documented route %s is not registered", pattern)
+		}
+	}
+}
+
+func TestController_openAPI_documentsEveryResponseType(t *testing.T) {
+	schemas := newSchemaBuilder()
+	for pattern, doc := range endpointDocs {
+		if doc.request != nil {
+			if _, err := schemas.requestSchema(reflect.TypeOf(doc.request)); err != nil {
+				t.Errorf("%s: %v", pattern, err)
+			}
+		}
+		for _, response := range doc.responses {
+			if _, err := schemas.schema(reflect.TypeOf(response.body)); err != nil {
+				t.Errorf("%s: %v", pattern, err)
+			}
+		}
+	}
+}
+
+func TestController_openAPI_requestsRequireOnlyTaggedFields(t *testing.T) {
+	c := NewController(&mocks.MockSecrets{}, nil, nil, nil, nil, noopLimiter{}, noopPermissions{})
+	document, err := c.openAPI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	schemas := document["components"].(map[string]any)["schemas"].(map[string]any)
+
+	tests := map[string][]string{
+		"server.OperationParameters":       nil,
+		"server.CreateOperationParameters": nil,
+		"server.DecisionParameters":        nil,
+		"server.ElevationParameters":       {"reason", "role"},
+		"bulk.Request":                     {"name", "select"},
+		// responses require every field which is not omitted when empty
+		"auth.Elevation": {"expiresAt", "id", "principal", "reason", "role", "startedAt"},
+	}
+	for name, want := range tests {
+		schema, ok := schemas[name].(map[string]any)
+		if !ok {
+			t.Errorf("schema %s is missing", name)
+			continue
+		}
+		got, _ := schema["required"].([]string)
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("%s required fields mismatch (-want +got):\n%s", name, diff)
+		}
+	}
+}
+
+// Status codes which handlers may write other than through errors
+var successStatuses = map[string]int{
+	"StatusOK":       http.StatusOK,
+	"StatusAccepted": http.StatusAccepted,
+	// written by readiness checks which fail
+	"StatusServiceUnavailable": http.StatusServiceUnavailable,
+}
+
+// TestController_openAPI_documentsEveryStatus checks that the statuses which each handler writes upon success,
+// directly or through other methods of the controller, are those which are documented for its route.
+func TestController_openAPI_documentsEveryStatus(t *testing.T) {
+	written := writtenStatuses(t)
+
+	c := NewController(&mocks.MockSecrets{}, nil, nil, nil, nil, noopLimiter{}, noopPermissions{})
+	for _, route := range c.routes() {
+		documented := slices.Sorted(maps.Keys(endpointDocs[route.pattern].responses))
+		statuses := slices.Sorted(maps.Keys(written[route.name()]))
+		if diff := cmp.Diff(documented, statuses); diff != "" {
+			t.Errorf("%s (%s) statuses mismatch (-documented +written):\n%s", route.pattern, route.name(), diff)
+		}
+	}
+}
+
+// writtenStatuses finds the statuses written by each method of the controller by reading its source
+func writtenStatuses(t *testing.T) map[string]map[int]bool {
+	fset := token.NewFileSet()
+	entries, err := os.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	direct := make(map[string]map[int]bool)
+	calls := make(map[string][]string)
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".go") || strings.HasSuffix(entry.Name(), "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, entry.Name(), nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, decl := range file.Decls {
+			function, ok := decl.(*ast.FuncDecl)
+			if !ok || function.Recv == nil || !isController(function.Recv.List[0].Type) {
+				continue
+			}
+			name := function.Name.Name
+			receiver := function.Recv.List[0].Names[0].Name
+			direct[name] = make(map[int]bool)
+			ast.Inspect(function.Body, func(node ast.Node) bool {
+				call, ok := node.(*ast.CallExpr)
+				if !ok {
+					return true
+				}
+				var status ast.Expr
+				switch fun := call.Fun.(type) {
+				case *ast.Ident:
+					if fun.Name == "writeResult" {
+						status = call.Args[2]
+					}
+				case *ast.SelectorExpr:
+					if x, ok := fun.X.(*ast.Ident); ok && x.Name == receiver {
+						calls[name] = append(calls[name], fun.Sel.Name)
+					} else if fun.Sel.Name == "WriteHeader" {
+						status = call.Args[0]
+					}
+				}
+				if status != nil {
+					selector, ok := status.(*ast.SelectorExpr)
+					code, known := 0, false
+					if ok {
+						code, known = successStatuses[selector.Sel.Name]
+					}
+					if !known {
+						t.Errorf("%s writes a status which is not known at %s", name, fset.Position(status.Pos()))
+					}
+					direct[name][code] = true
+				}
+				return true
+			})
+		}
+	}
+
+	written := make(map[string]map[int]bool)
+	var collect func(name string, statuses map[int]bool, visited map[string]bool)
+	collect = func(name string, statuses map[int]bool, visited map[string]bool) {
+		if visited[name] {
+			return
+		}
+		visited[name] = true
+		maps.Copy(statuses, direct[name])
+		for _, callee := range calls[name] {
+			collect(callee, statuses, visited)
+		}
+	}
+	for name := range direct {
+		written[name] = make(map[int]bool)
+		collect(name, written[name], make(map[string]bool))
+	}
+	return written
+}
+
+func isController(receiver ast.Expr) bool {
+	star, ok := receiver.(*ast.StarExpr)
+	if !ok {
+		return false
+	}
+	ident, ok := star.X.(*ast.Ident)
+	return ok && ident.Name == "Controller"
+}
+
+func TestController_getOpenAPI(t *testing.T) {
+	c := NewController(&mocks.MockSecrets{}, nil, nil, nil, nil, noopLimiter{}, noopPermissions{identity: &auth.Identity{Principal: "test"}})
+	mux := http.NewServeMux()
+	c.buildHandler(mux.Handle)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://test/openapi.json", nil)
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200\nbody: %s", rec.Code, rec.Body.Bytes())
+	}
+	var document struct {
+		OpenAPI string `json:"openapi"`
+		Paths   map[string]map[string]struct {
+			OperationId string           `json:"operationId"`
+			Permissions auth.Permissions `json:"x-permissions"`
+			Parameters  []struct {
+				Name string `json:"name"`
+				In   string `json:"in"`
+			} `json:"parameters"`
+			Responses map[string]json.RawMessage `json:"responses"`
+		} `json:"paths"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &document); err != nil {
+		t.Fatal(err)
+	}
+	if document.OpenAPI != openAPIVersion {
+		t.Errorf("openapi = %q, want %q", document.OpenAPI, openAPIVersion)
+	}
+
+	operation := document.Paths["/secrets/{secretId}/instances"]["post"]
+	if operation.OperationId != "createInstance" {
+		t.Errorf("operationId = %q, want createInstance", operation.OperationId)
+	}
+	if diff := cmp.Diff(auth.Permissions{auth.Instances: {auth.Write}}, operation.Permissions); diff != "" {
+		t.Errorf("permissions mismatch (-want +got):\n%s", diff)
+	}
+	var parameters []string
+	for _, parameter := range operation.Parameters {
+		parameters = append(parameters, parameter.In+":"+parameter.Name)
+	}
+	if diff := cmp.Diff([]string{"path:secretId", "header:Idempotency-Key", "header:If-Match", "header:X-Request-ID", "header:X-API-Version"}, parameters); diff != "" {
+		t.Errorf("parameters mismatch (-want +got):\n%s", diff)
+	}
+	responses := slices.Sorted(maps.Keys(operation.Responses))
+	if diff := cmp.Diff([]string{"200", "202", "default"}, responses); diff != "" {
+		t.Errorf("responses mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, name := range []string{"secrets.Instance", "secrets.Approval", "secrets.Secret", "server.httpError"} {
+		if _, ok := document.Components.Schemas[name]; !ok {
+			t.Errorf("schema %s is missing", name)
+		}
+	}
+}
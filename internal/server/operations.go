@@ -1,10 +1,18 @@
 package server
 
 import (
+	"fmt"
+	"net/http"
+	"strconv"
+
 	"github.com/eliasvasylenko/secret-agent/internal/command"
 	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+	"github.com/eliasvasylenko/secret-agent/internal/validation"
 )
 
+// The longest idempotency key which may be given for a request
+const maxIdempotencyKeyLen = 255
+
 type OperationParameters struct {
 	Env    command.Environment `json:"env"`
 	Forced bool                `json:"forced"`
@@ -15,3 +23,59 @@ type CreateOperationParameters struct {
 	Name                secrets.OperationName `json:"name"`
 	OperationParameters `json:""`
 }
+
+func (p *OperationParameters) validate(v *validation.Validator) {
+	p.Env.Validate(v, "/env")
+}
+
+func (p *CreateOperationParameters) validate(v *validation.Validator) {
+	if p.Name != "" && !knownOperation(p.Name) {
+		v.Field("/name", "unknown operation %q", p.Name)
+	}
+	p.OperationParameters.validate(v)
+}
+
+func knownOperation(name secrets.OperationName) bool {
+	switch name {
+	case secrets.Create, secrets.Activate, secrets.Deactivate, secrets.Destroy, secrets.Test:
+		return true
+	}
+	return false
+}
+
+// FormatETag gives the entity tag of the state of a secret, which is identified by the number of its latest operation
+func FormatETag(operationNumber int) string {
+	return strconv.Quote(strconv.Itoa(operationNumber))
+}
+
+// ParseETag parses an entity tag given by FormatETag
+func ParseETag(tag string) (int, error) {
+	unquoted, err := strconv.Unquote(tag)
+	if err != nil {
+		return 0, fmt.Errorf("invalid entity tag %s", tag)
+	}
+	operationNumber, err := strconv.Atoi(unquoted)
+	if err != nil {
+		return 0, fmt.Errorf("invalid entity tag %s", tag)
+	}
+	return operationNumber, nil
+}
+
+// readPreconditions reads the Idempotency-Key and If-Match headers of a request for an operation.
+// The latest operation expected by the caller is nil if they will accept any.
+func readPreconditions(r *http.Request) (string, *int, error) {
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if len(idempotencyKey) > maxIdempotencyKeyLen {
+		return "", nil, NewErrorResponse(http.StatusBadRequest, fmt.Errorf("idempotency key too long (%d exceeds max of %d bytes)", len(idempotencyKey), maxIdempotencyKeyLen))
+	}
+
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" || ifMatch == "*" {
+		return idempotencyKey, nil, nil
+	}
+	latest, err := ParseETag(ifMatch)
+	if err != nil {
+		return "", nil, NewErrorResponse(http.StatusBadRequest, err)
+	}
+	return idempotencyKey, &latest, nil
+}
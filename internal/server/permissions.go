@@ -3,17 +3,31 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+	"github.com/eliasvasylenko/secret-agent/internal/store"
 )
 
 type Permissions struct {
-	Roles  auth.Roles  `json:"roles"`
-	Claims auth.Claims `json:"claims"`
+	Roles      auth.Roles        `json:"roles"`
+	Claims     auth.Claims       `json:"claims"`
+	Approvals  ApprovalPolicies  `json:"approvals,omitempty"`
+	Policies   OperationPolicies `json:"policies,omitempty"`
+	Elevations ElevationPolicies `json:"elevations,omitempty"`
+	Limits     RateLimits        `json:"limits,omitempty"`
+	// Profiles for connections through named listeners
+	Listeners map[string]ListenerProfile `json:"listeners,omitempty"`
+
+	// The store of active elevations, which add roles to the identities of callers
+	elevationStore store.Elevations
 }
 
 type identityKey struct{}
@@ -43,22 +57,49 @@ func LoadPermissions(permissionsFileName string) (*Permissions, error) {
 	return &permissions, err
 }
 
-func (p *Permissions) Middleware(permissions auth.Permissions, next http.Handler) http.Handler {
+// Middleware authenticates the caller and asserts that they have the given permissions.
+// Denied requests are reported to the given function, with any partial identity of the caller in their context.
+func (p *Permissions) Middleware(permissions auth.Permissions, denied func(r *http.Request, err error), next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		connection := r.Context().Value(connectionKey{}).(net.Conn)
-		identity, err := p.Claims.ClaimIdentity(r, connection)
+		listener := listenerFromContext(r.Context())
+		identity, err := p.claims(listener).ClaimIdentity(r, connection)
+		if identity != nil {
+			identity.Listener = listener
+			recordPrincipal(r.Context(), identity.Principal)
+			r = r.WithContext(context.WithValue(r.Context(), identityKey{}, identity))
+		}
+		if err != nil {
+			err = NewErrorResponse(http.StatusUnauthorized, err)
+			authDenials.Inc(r.Pattern, strconv.Itoa(http.StatusUnauthorized))
+			denied(r, err)
+			writeError(w, err)
+			return
+		}
+
+		err = p.elevate(r, identity)
 		if err != nil {
-			writeError(w, NewErrorResponse(http.StatusUnauthorized, err))
+			err = NewErrorResponse(http.StatusInternalServerError, fmt.Errorf("failed to find elevation: %w", err))
+			authDenials.Inc(r.Pattern, strconv.Itoa(http.StatusInternalServerError))
+			denied(r, err)
+			writeError(w, err)
 			return
 		}
 
-		err = p.Roles.AssertPermission(identity.Roles, permissions)
+		err = p.assertPermission(identity, permissions)
 		if err != nil {
-			writeError(w, NewErrorResponse(http.StatusForbidden, err))
+			err = NewErrorResponse(http.StatusForbidden, err)
+			authDenials.Inc(r.Pattern, strconv.Itoa(http.StatusForbidden))
+			denied(r, err)
+			writeError(w, err)
 			return
 		}
 
-		r = r.WithContext(context.WithValue(r.Context(), identityKey{}, identity))
 		next.ServeHTTP(w, r)
 	})
 }
+
+// RequiredApprovals returns the number of approvals required for an operation, and the time after which a request for it expires.
+func (p *Permissions) RequiredApprovals(secretId string, operation secrets.OperationName, forced bool) (int, time.Duration) {
+	return p.Approvals.Required(secretId, operation, forced)
+}
@@ -95,13 +95,15 @@ func TestPermissions_Middleware_returns401WhenClaimIdentityFails(t *testing.T) {
 	defer client.Close()
 	defer server.Close()
 
-	p := &Permissions{Roles: auth.Roles{"admin": {Name: "admin", Permissions: auth.Permissions{auth.All: auth.Any}}}}
+	p := &Permissions{Roles: auth.Roles{"admin": {Name: "admin", Permissions: auth.Permissions{auth.All: {auth.Any}}}}}
 	nextCalled := false
 	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		nextCalled = true
 		w.WriteHeader(http.StatusOK)
 	})
-	handler := p.Middleware(auth.Permissions{auth.Secrets: auth.Read}, next)
+	var deniedErr error
+	denied := func(r *http.Request, err error) { deniedErr = err }
+	handler := p.Middleware(auth.Permissions{auth.Secrets: {auth.Read}}, denied, next)
 
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
 	req = req.WithContext(context.WithValue(req.Context(), connectionKey{}, server))
@@ -114,4 +116,7 @@ func TestPermissions_Middleware_returns401WhenClaimIdentityFails(t *testing.T) {
 	if rec.Code != http.StatusUnauthorized {
 		t.Errorf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
 	}
+	if deniedErr == nil {
+		t.Error("denial was not reported")
+	}
 }
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/marshal"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+)
+
+// Selects operations by name and by the ID of the secret operated upon
+type OperationSelector struct {
+	// The names of the selected operations, or all operations if empty
+	Operations []secrets.OperationName `json:"operations,omitempty"`
+
+	// Patterns matching the IDs of the selected secrets, or all secrets if empty
+	Secrets []string `json:"secrets,omitempty"`
+}
+
+// Matches checks if an operation on a secret is selected
+func (s OperationSelector) Matches(secretId string, operation secrets.OperationName) bool {
+	if len(s.Operations) > 0 && !slices.Contains(s.Operations, operation) {
+		return false
+	}
+	return len(s.Secrets) == 0 || slices.ContainsFunc(s.Secrets, func(pattern string) bool {
+		matched, _ := path.Match(pattern, secretId)
+		return matched
+	})
+}
+
+// An operation policy constrains the parameters with which matching operations may be requested
+type OperationPolicy struct {
+	// The operations which the policy applies to
+	OperationSelector `json:""`
+
+	// Require a non-empty reason
+	RequireReason bool `json:"requireReason,omitempty"`
+
+	// A regular expression which reasons must match, e.g. a ticket ID such as "^OPS-[0-9]+"
+	ReasonPattern *marshal.Regexp `json:"reasonPattern,omitempty"`
+}
+
+type OperationPolicies []OperationPolicy
+
+// Check that the parameters of an operation satisfy the policy
+func (p OperationPolicy) Check(secretId string, operation secrets.OperationName, parameters OperationParameters) error {
+	if !p.Matches(secretId, operation) {
+		return nil
+	}
+	if p.RequireReason && parameters.Reason == "" {
+		return fmt.Errorf("%s of %s requires a reason", operation, secretId)
+	}
+	if p.ReasonPattern != nil && p.ReasonPattern.Regexp != nil && !p.ReasonPattern.MatchString(parameters.Reason) {
+		return fmt.Errorf("%s of %s requires a reason matching '%s'", operation, secretId, p.ReasonPattern)
+	}
+	return nil
+}
+
+// AuthoriseOperation checks that an operation may be requested with the given parameters by the given identity.
+// Forced operations require the force permission on instances, and the parameters must satisfy every operation policy.
+func (p *Permissions) AuthoriseOperation(identity *auth.Identity, secretId string, operation secrets.OperationName, parameters OperationParameters) error {
+	if parameters.Forced && !p.checkPermission(identity, auth.Permissions{auth.Instances: {auth.Force}}) {
+		return NewErrorResponse(http.StatusForbidden, fmt.Errorf("forced %s not permitted with claimed roles %v", operation, identity.Roles))
+	}
+	for _, policy := range p.Policies {
+		if err := policy.Check(secretId, operation, parameters); err != nil {
+			return NewErrorResponse(http.StatusForbidden, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+)
+
+func TestPermissions_AuthoriseOperation(t *testing.T) {
+	var permissions Permissions
+	err := json.Unmarshal([]byte(`{
+		"roles": {
+			"operator": {"permissions": {"instances": ["read", "write"]}},
+			"breaker": {"permissions": {"instances": ["read", "write", "force"]}}
+		},
+		"claims": {},
+		"policies": [
+			{"operations": ["destroy"], "requireReason": true},
+			{"secrets": ["db-*"], "reasonPattern": "^OPS-[0-9]+"}
+		]
+	}`), &permissions)
+	if err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+
+	operator := &auth.Identity{Principal: "op", Roles: auth.ClaimedRoles{"operator"}}
+	breaker := &auth.Identity{Principal: "breaker", Roles: auth.ClaimedRoles{"breaker"}}
+	tests := []struct {
+		name       string
+		identity   *auth.Identity
+		secretId   string
+		operation  secrets.OperationName
+		parameters OperationParameters
+		wantErr    bool
+	}{
+		{"unmatched", operator, "web", secrets.Activate, OperationParameters{}, false},
+		{"forced without permission", operator, "web", secrets.Activate, OperationParameters{Forced: true}, true},
+		{"forced with permission", breaker, "web", secrets.Activate, OperationParameters{Forced: true}, false},
+		{"missing reason", operator, "web", secrets.Destroy, OperationParameters{}, true},
+		{"reason", operator, "web", secrets.Destroy, OperationParameters{Reason: "tidy"}, false},
+		{"reason not matching pattern", operator, "db-main", secrets.Activate, OperationParameters{Reason: "tidy"}, true},
+		{"reason matching pattern", operator, "db-main", secrets.Activate, OperationParameters{Reason: "OPS-1234 rotate"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := permissions.AuthoriseOperation(tt.identity, tt.secretId, tt.operation, tt.parameters)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AuthoriseOperation err = %v, wantErr %v", err, tt.wantErr)
+			}
+			var response *ErrorResponse
+			if err != nil && (!errors.As(err, &response) || response.HttpError.Code != http.StatusForbidden) {
+				t.Errorf("AuthoriseOperation err = %v, want 403 response", err)
+			}
+		})
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+
+	"github.com/eliasvasylenko/secret-agent/internal/validation"
 )
 
 type ItemsResponse[T any] struct {
@@ -19,18 +21,32 @@ type ErrorResponse struct {
 type httpError struct {
 	Code    int    `json:"status"`
 	Message string `json:"message"`
+	// The problems with each field of the request at fault, if the request is invalid
+	Fields validation.Errors `json:"fields,omitempty"`
 }
 
 func NewErrorResponse(code int, err error) *ErrorResponse {
-	return &ErrorResponse{HttpError: &httpError{Code: code, Message: err.Error()}, Headers: make(map[string]string)}
+	response := &ErrorResponse{HttpError: &httpError{Code: code}, Headers: make(map[string]string)}
+	if fields := (validation.Errors{}); errors.As(err, &fields) {
+		response.HttpError.Message = "invalid request"
+		response.HttpError.Fields = fields
+	} else if err != nil {
+		response.HttpError.Message = err.Error()
+	}
+	return response
 }
 
 func (r *ErrorResponse) Error() string {
+	var message string
 	if r.HttpError.Message == "" {
-		return fmt.Sprintf("%v %s", r.HttpError.Code, http.StatusText(r.HttpError.Code))
+		message = fmt.Sprintf("%v %s", r.HttpError.Code, http.StatusText(r.HttpError.Code))
 	} else {
-		return fmt.Sprintf("%v %s - %s", r.HttpError.Code, http.StatusText(r.HttpError.Code), r.HttpError.Message)
+		message = fmt.Sprintf("%v %s - %s", r.HttpError.Code, http.StatusText(r.HttpError.Code), r.HttpError.Message)
+	}
+	for _, field := range r.HttpError.Fields {
+		message += "\n  " + field.String()
 	}
+	return message
 }
 
 func writeResult(w http.ResponseWriter, value any, statusCode int) error {
@@ -49,7 +65,11 @@ func writeResult(w http.ResponseWriter, value any, statusCode int) error {
 func writeError(w http.ResponseWriter, err error) error {
 	var response *ErrorResponse
 	if errors.As(err, &response) {
-		w.Header().Add("", "")
+		for name, value := range response.Headers {
+			w.Header().Set(name, value)
+		}
+	} else if fields := (validation.Errors{}); errors.As(err, &fields) {
+		response = NewErrorResponse(http.StatusBadRequest, err)
 	} else {
 		response = NewErrorResponse(
 			http.StatusInternalServerError,
@@ -2,83 +2,167 @@ package server
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"io"
+	"log/slog"
 	"net"
 	"net/http"
-	"os"
-	"strconv"
+	"sync"
 	"time"
 
+	"github.com/eliasvasylenko/secret-agent/internal/marshal"
 	"github.com/eliasvasylenko/secret-agent/internal/store"
 )
 
 type Server struct {
 	config     ServerConfig
 	controller *Controller
+	health     store.Health
+	denials    *DenialLog
 }
 
 type ServerConfig struct {
-	Socket        string
-	RequestLimit  uint32
-	RequestWindow time.Duration
+	Sockets         []Socket
+	RequestLimit    uint32
+	RequestWindow   time.Duration
+	MaxInFlight     uint32
+	DenialRetention time.Duration
+	// Time for which running operations may finish after shutdown is requested, before they are interrupted
+	ShutdownGrace time.Duration
+	AuditSink     io.Writer
+	// Address on which metrics are served for scraping, either a TCP address or the path of a unix socket, if any
+	MetricsAddress string
+	// Time for which an operation may run before the server reports that it is not ready
+	StuckAfter time.Duration
+	// Digests of the configuration files loaded by the server, by path, to report in its status
+	ConfigHashes map[string]string
+	// The time at which scheduled background work last ran, to report in the status of the server
+	LastSchedulerRun func() *time.Time
 }
 
-func New(config ServerConfig, secretStore store.Secrets, permissions *Permissions) *Server {
-	limiter := NewLimiter(config.RequestLimit, config.RequestWindow)
+func New(config ServerConfig, secretStore store.Secrets, approvalStore store.Approvals, denialStore store.Denials, elevationStore store.Elevations, eventStore store.Events, healthStore store.Health, permissions *Permissions) *Server {
+	defaultLimit := RateLimit{Limit: config.RequestLimit, Window: marshal.Duration(config.RequestWindow)}
+	limiter := NewLimiter(defaultLimit, permissions.Limits, config.MaxInFlight)
+	denialLog := NewDenialLog(denialStore, config.DenialRetention, config.AuditSink)
+	permissions.elevationStore = elevationStore
+	controller := NewController(secretStore, approvalStore, denialLog, elevationStore, eventStore, limiter, permissions)
+	controller.monitor = NewMonitor(healthStore, config.StuckAfter, config.ConfigHashes, config.LastSchedulerRun)
 	return &Server{
 		config:     config,
-		controller: NewController(secretStore, limiter, permissions),
+		controller: controller,
+		health:     healthStore,
+		denials:    denialLog,
 	}
 }
 
 // Context key for passing the underlying connection into the handler
 type connectionKey struct{}
 
-func (s *Server) Serve() error {
-	var listener net.Listener
-	var err error
-	if s.config.Socket != "" {
-		// socket option given for manual execution
+// Serve requests until the context is cancelled, then shut down gracefully. Requests in progress are given the
+// shutdown grace period to finish, after which any operations still running are interrupted, and recorded as such.
+func (s *Server) Serve(ctx context.Context) error {
+	// operations left unfinished by a previous server, e.g. one which was killed, would otherwise appear to run forever
+	interrupted, err := s.health.InterruptUnfinished(ctx)
+	if err != nil {
+		return err
+	}
+	if interrupted > 0 {
+		slog.Warn("recorded operations left unfinished by a previous server as interrupted", "operations", interrupted)
+	}
 
-		// resolve socket
-		var addr *net.UnixAddr
-		addr, err = net.ResolveUnixAddr("unix", s.config.Socket)
+	listeners, err := listen(s.config.Sockets)
+	if err != nil {
+		return err
+	}
+	if s.config.MetricsAddress != "" {
+		metricsServer, err := serveMetrics(s.config.MetricsAddress)
 		if err != nil {
+			for _, listener := range listeners {
+				listener.Close()
+			}
 			return err
 		}
-
-		// listen on socket
-		listener, err = net.ListenUnix("unix", addr)
-
-	} else if os.Getenv("LISTEN_PID") == strconv.Itoa(os.Getpid()) {
-		// started as a systemd service
-
-		// open file descriptor
-		f := os.NewFile(3, "socket")
-
-		// listen on FD
-		listener, err = net.FileListener(f)
-
-	} else {
-		return fmt.Errorf("No server socket")
+		defer metricsServer.Close()
 	}
 
-	if err != nil {
-		return err
-	}
+	// denials are written until every request has finished
+	denialsCtx, stopDenials := context.WithCancel(context.WithoutCancel(ctx))
+	denialsWritten := make(chan struct{})
+	go func() {
+		s.denials.Run(denialsCtx)
+		close(denialsWritten)
+	}()
+	defer func() {
+		stopDenials()
+		<-denialsWritten
+	}()
 
 	mux := http.NewServeMux()
-	s.controller.buildHandler(mux.Handle)
+	s.controller.buildHandler(versioned(mux.Handle))
+
+	// requests are not cancelled by graceful shutdown, only once the grace period has passed
+	requestCtx, interrupt := context.WithCancel(context.WithoutCancel(ctx))
+	defer interrupt()
+	// long-lived responses, such as event streams, end as soon as shutdown starts
+	shutdown := make(chan struct{})
+	var running sync.WaitGroup
 	srv := &http.Server{
-		Handler: mux,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			running.Add(1)
+			defer running.Done()
+			logRequests(countRequests(negotiateVersion(mux))).ServeHTTP(w, r)
+		}),
+		BaseContext: func(listener net.Listener) context.Context {
+			ctx := context.WithValue(requestCtx, listenerKey{}, listener.(*namedListener).name)
+			return context.WithValue(ctx, shutdownKey{}, (<-chan struct{})(shutdown))
+		},
 		ConnContext: func(ctx context.Context, connection net.Conn) context.Context {
 			return context.WithValue(ctx, connectionKey{}, connection)
 		},
 	}
+	srv.RegisterOnShutdown(func() { close(shutdown) })
+
+	// serve http over each socket
+	served := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func() {
+			served <- srv.Serve(listener)
+		}()
+	}
+	notifyOrLog("READY=1")
+	stopWatchdog := startWatchdog()
+	defer stopWatchdog()
 
-	// serve http over socket
-	if err := srv.Serve(listener); err != nil {
+	select {
+	case err := <-served:
+		srv.Close()
+		return err
+	case <-ctx.Done():
+	}
+
+	notifyOrLog("STOPPING=1")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownGrace)
+	defer cancel()
+	err = srv.Shutdown(shutdownCtx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		slog.Warn("interrupting requests still running after shutdown grace period", "grace", s.config.ShutdownGrace)
+		interrupt()
+		running.Wait()
+		err = srv.Close()
+	}
+	if err != nil {
 		return err
 	}
+	for range listeners {
+		if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+	}
 	return nil
 }
+
+func notifyOrLog(state string) {
+	if err := notify(state); err != nil {
+		slog.Error("failed to notify service manager", "state", state, "error", err)
+	}
+}
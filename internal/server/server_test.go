@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/mocks"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+)
+
+// startServer serves the controller on a temporary socket until the returned context is cancelled
+func startServer(t *testing.T, config ServerConfig, controller *Controller) (*http.Client, context.CancelFunc, chan error) {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "server.socket")
+	config.Sockets = []Socket{{Path: socket}}
+	s := &Server{config: config, controller: controller, health: &stubHealth{}, denials: NewDenialLog(&mocks.MockDenials{}, 0, nil)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(ctx)
+	}()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}}
+	return client, cancel, served
+}
+
+func TestServer_Serve_shutdown(t *testing.T) {
+	notifications := listenNotify(t)
+	mockStore := &mocks.MockSecrets{}
+	defer mockStore.Mock.Validate(t)
+	started := make(chan struct{})
+	mocks.Expect(&mockStore.Mock, mockStore.List, func(ctx context.Context) (secrets.Secrets, error) {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		return secrets.Secrets{}, ctx.Err()
+	})
+
+	controller := NewController(mockStore, nil, nil, nil, nil, noopLimiter{}, noopPermissions{})
+	client, shutdown, served := startServer(t, ServerConfig{ShutdownGrace: time.Second}, controller)
+	if got := readNotification(t, notifications); got != "READY=1" {
+		t.Fatalf("notification = %q, want READY=1", got)
+	}
+
+	status := make(chan int)
+	go func() {
+		response, err := client.Get("http://secret-agent/secrets")
+		if err != nil {
+			t.Errorf("GET /secrets: %v", err)
+			status <- 0
+			return
+		}
+		response.Body.Close()
+		status <- response.StatusCode
+	}()
+	<-started
+	shutdown()
+
+	if got := readNotification(t, notifications); got != "STOPPING=1" {
+		t.Errorf("notification = %q, want STOPPING=1", got)
+	}
+	if code := <-status; code != http.StatusOK {
+		t.Errorf("status = %d, want request in progress to finish within grace period", code)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Serve = %v, want nil", err)
+	}
+}
+
+func TestServer_Serve_interruptsAfterGracePeriod(t *testing.T) {
+	notifications := listenNotify(t)
+	mockStore := &mocks.MockSecrets{}
+	defer mockStore.Mock.Validate(t)
+	started := make(chan struct{})
+	interrupted := make(chan bool, 1)
+	mocks.Expect(&mockStore.Mock, mockStore.List, func(ctx context.Context) (secrets.Secrets, error) {
+		close(started)
+		select {
+		case <-ctx.Done():
+			interrupted <- true
+		case <-time.After(5 * time.Second):
+			interrupted <- false
+		}
+		return nil, ctx.Err()
+	})
+
+	controller := NewController(mockStore, nil, nil, nil, nil, noopLimiter{}, noopPermissions{})
+	client, shutdown, served := startServer(t, ServerConfig{ShutdownGrace: 50 * time.Millisecond}, controller)
+	if got := readNotification(t, notifications); got != "READY=1" {
+		t.Fatalf("notification = %q, want READY=1", got)
+	}
+
+	go func() {
+		response, err := client.Get("http://secret-agent/secrets")
+		if err == nil {
+			response.Body.Close()
+		}
+	}()
+	<-started
+	shutdown()
+
+	if !<-interrupted {
+		t.Error("request was not interrupted after the grace period")
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Serve = %v, want nil", err)
+	}
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/eliasvasylenko/secret-agent/internal/validation"
+)
+
+// The largest request body which is read
+const maxBodySize = 1 << 20
+
+// The number of items listed if the end of the range is not given, and the most which may be requested at once
+const (
+	defaultPageSize = 32
+	maxPageSize     = 1000
+)
+
+// A request body which may be checked for problems once it is decoded
+type validated interface {
+	validate(v *validation.Validator)
+}
+
+// readBody strictly decodes the body of a request, refusing unknown fields, trailing data and bodies which are too
+// large, then reports any problems with it if it may be validated
+func readBody(w http.ResponseWriter, r *http.Request, v any) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err == nil {
+		if _, trailing := decoder.Token(); trailing != io.EOF {
+			err = errors.New("unexpected data after the request body")
+		}
+	}
+	if err != nil {
+		return decodeError(err)
+	}
+
+	if body, ok := v.(validated); ok {
+		var validator validation.Validator
+		body.validate(&validator)
+		if err := validator.Err(); err != nil {
+			return NewErrorResponse(http.StatusBadRequest, err)
+		}
+	}
+	return nil
+}
+
+// decodeError reports an error decoding a request body, by the field at fault if it is known
+func decodeError(err error) error {
+	var tooLarge *http.MaxBytesError
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &tooLarge):
+		return NewErrorResponse(http.StatusRequestEntityTooLarge, fmt.Errorf("request body exceeds %d bytes", tooLarge.Limit))
+	case errors.Is(err, io.EOF):
+		return NewErrorResponse(http.StatusBadRequest, errors.New("request body is required"))
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return NewErrorResponse(http.StatusBadRequest, errors.New("malformed JSON, the request body ends early"))
+	case errors.As(err, &syntaxError):
+		return NewErrorResponse(http.StatusBadRequest, fmt.Errorf("malformed JSON at offset %d, %w", syntaxError.Offset, err))
+	case errors.As(err, &typeError):
+		pointer := ""
+		if typeError.Field != "" {
+			pointer = validation.Pointer(strings.Split(typeError.Field, ".")...)
+		}
+		return fieldError(pointer, "must be %s, not %s", jsonType(typeError.Type), typeError.Value)
+	}
+	// the decoder gives no typed error for unknown fields
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		if name, err := strconv.Unquote(field); err == nil {
+			return fieldError(validation.Pointer(name), "unknown field")
+		}
+	}
+	return NewErrorResponse(http.StatusBadRequest, err)
+}
+
+// fieldError reports a problem with the field of the request body at the given JSON pointer
+func fieldError(pointer string, format string, args ...any) error {
+	var validator validation.Validator
+	validator.Field(pointer, format, args...)
+	return NewErrorResponse(http.StatusBadRequest, validator.Err())
+}
+
+// jsonType describes the JSON values which decode to a type
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.String:
+		return "a string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	case reflect.Pointer:
+		return jsonType(t.Elem())
+	}
+	return "a value of type " + t.String()
+}
+
+// parseRange gives the bounds of the range of items requested by the query of a list, reporting every problem
+// with them. The range starts at the first item and holds a page of items by default.
+func parseRange(url url.URL) (int, int, error) {
+	var validator validation.Validator
+	query := url.Query()
+	from := parseInt(&validator, query, "from", 0)
+	to := parseInt(&validator, query, "to", from+defaultPageSize)
+	if err := validator.Err(); err != nil {
+		return 0, 0, NewErrorResponse(http.StatusBadRequest, err)
+	}
+
+	if from < 0 {
+		validator.Parameter("from", "must not be negative")
+	}
+	if to < from {
+		validator.Parameter("to", "must not be less than from")
+	} else if to-from > maxPageSize {
+		validator.Parameter("to", "must not be more than %d past from", maxPageSize)
+	}
+	if err := validator.Err(); err != nil {
+		return 0, 0, NewErrorResponse(http.StatusBadRequest, err)
+	}
+	return from, to, nil
+}
+
+func parseInt(validator *validation.Validator, query url.Values, name string, defaultValue int) int {
+	numString := query.Get(name)
+	if numString == "" {
+		return defaultValue
+	}
+	num, err := strconv.ParseInt(numString, 10, 32)
+	if err != nil {
+		validator.Parameter(name, "must be an integer")
+		return defaultValue
+	}
+	return int(num)
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/eliasvasylenko/secret-agent/internal/validation"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReadBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		status  int
+		message string
+		fields  validation.Errors
+	}{
+		{name: "valid", body: `{"name":"activate","env":{"KEY":"value"},"forced":true,"reason":"r"}`, status: http.StatusOK},
+		{name: "trailing whitespace", body: "{\"name\":\"test\"}\n", status: http.StatusOK},
+		{name: "empty", body: "", status: http.StatusBadRequest, message: "request body is required"},
+		{name: "malformed", body: `{"name":`, status: http.StatusBadRequest, message: "malformed JSON, the request body ends early"},
+		{name: "trailing data", body: `{"name":"test"} {}`, status: http.StatusBadRequest, message: "unexpected data after the request body"},
+		{
+			name:    "unknown field",
+			body:    `{"name":"activate","forcd":true}`,
+			status:  http.StatusBadRequest,
+			message: "invalid request",
+			fields:  validation.Errors{{Pointer: "/forcd", Message: "unknown field"}},
+		},
+		{
+			name:    "wrong type",
+			body:    `{"name":"activate","forced":"yes"}`,
+			status:  http.StatusBadRequest,
+			message: "invalid request",
+			fields:  validation.Errors{{Pointer: "/forced", Message: "must be a boolean, not string"}},
+		},
+		{
+			name:    "invalid fields",
+			body:    `{"name":"rotate","env":{"NOT-A-NAME":"x"}}`,
+			status:  http.StatusBadRequest,
+			message: "invalid request",
+			fields: validation.Errors{
+				{Pointer: "/name", Message: `unknown operation "rotate"`},
+				{Pointer: "/env/NOT-A-NAME", Message: `invalid environment variable name "NOT-A-NAME"`},
+			},
+		},
+		{
+			name:    "too large",
+			body:    `{"reason":"` + strings.Repeat("x", maxBodySize) + `"}`,
+			status:  http.StatusRequestEntityTooLarge,
+			message: "request body exceeds 1048576 bytes",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "http://test/", strings.NewReader(tt.body))
+			var parameters CreateOperationParameters
+			if err := readBody(rec, req, &parameters); err != nil {
+				writeError(rec, err)
+			} else {
+				rec.WriteHeader(http.StatusOK)
+			}
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d\nbody: %s", rec.Code, tt.status, rec.Body.Bytes())
+			}
+			if tt.status == http.StatusOK {
+				return
+			}
+			var response ErrorResponse
+			if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if response.HttpError.Message != tt.message {
+				t.Errorf("message = %q, want %q", response.HttpError.Message, tt.message)
+			}
+			if diff := cmp.Diff(tt.fields, response.HttpError.Fields); diff != "" {
+				t.Errorf("fields mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		query  string
+		from   int
+		to     int
+		fields validation.Errors
+	}{
+		{query: "", from: 0, to: defaultPageSize},
+		{query: "from=10", from: 10, to: 10 + defaultPageSize},
+		{query: "from=5&to=15", from: 5, to: 15},
+		{query: "from=x&to=y", fields: validation.Errors{
+			{Parameter: "from", Message: "must be an integer"},
+			{Parameter: "to", Message: "must be an integer"},
+		}},
+		{query: "from=-1&to=5", fields: validation.Errors{{Parameter: "from", Message: "must not be negative"}}},
+		{query: "from=5&to=4", fields: validation.Errors{{Parameter: "to", Message: "must not be less than from"}}},
+		{query: "from=0&to=1001", fields: validation.Errors{{Parameter: "to", Message: "must not be more than 1000 past from"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			from, to, err := parseRange(url.URL{RawQuery: tt.query})
+			if tt.fields == nil {
+				if err != nil || from != tt.from || to != tt.to {
+					t.Errorf("parseRange = %d, %d, %v, want %d, %d", from, to, err, tt.from, tt.to)
+				}
+				return
+			}
+			response, ok := err.(*ErrorResponse)
+			if !ok || response.HttpError.Code != http.StatusBadRequest {
+				t.Fatalf("err = %v, want a bad request", err)
+			}
+			if diff := cmp.Diff(tt.fields, response.HttpError.Fields); diff != "" {
+				t.Errorf("fields mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
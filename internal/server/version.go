@@ -0,0 +1,68 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// The version of the API which is served, and which is requested by the client of this build
+const APIVersion = "1"
+
+// The prefix of the path of every route in the current version of the API
+const APIPrefix = "/v" + APIVersion
+
+const (
+	// Header by which a client requests a version of the API, and by which the server reports the version served
+	APIVersionHeader = "X-API-Version"
+	// Header by which the server reports every version of the API it supports
+	SupportedVersionsHeader = "X-API-Supported-Versions"
+)
+
+// The versions of the API which the server supports, oldest first
+var supportedVersions = []string{APIVersion}
+
+// versioned registers each route under the prefix of the current version of the API, and also unprefixed as an
+// alias for clients which predate versioning
+func versioned(registerHandler func(pattern string, handler http.Handler)) func(pattern string, handler http.Handler) {
+	return func(pattern string, handler http.Handler) {
+		method, path, _ := strings.Cut(pattern, " ")
+		registerHandler(method+" "+APIPrefix+path, handler)
+		registerHandler(pattern, handler)
+	}
+}
+
+// negotiateVersion reports the supported versions of the API on every response, and refuses requests for a version
+// which is not supported, whether requested by header or by the prefix of the path
+func negotiateVersion(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(SupportedVersionsHeader, strings.Join(supportedVersions, ", "))
+
+		requested := r.Header.Get(APIVersionHeader)
+		if prefixed, ok := pathVersion(r.URL.Path); ok {
+			if requested != "" && requested != prefixed {
+				writeError(w, NewErrorResponse(http.StatusBadRequest, fmt.Errorf("API version %s is requested by header, but version %s by path", requested, prefixed)))
+				return
+			}
+			requested = prefixed
+		}
+		if requested != "" && !slices.Contains(supportedVersions, requested) {
+			writeError(w, NewErrorResponse(http.StatusNotAcceptable, fmt.Errorf("API version %s is not supported by the agent, which supports versions %s", requested, strings.Join(supportedVersions, ", "))))
+			return
+		}
+
+		w.Header().Set(APIVersionHeader, APIVersion)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// pathVersion gives the version of the API named by the prefix of a path, if it has one
+func pathVersion(path string) (string, bool) {
+	segment, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	version, ok := strings.CutPrefix(segment, "v")
+	if !ok || version == "" || strings.Trim(version, "0123456789") != "" {
+		return "", false
+	}
+	return version, true
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiateVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		version string
+		status  int
+	}{
+		{name: "prefixed", path: "/v1/things/1", status: http.StatusOK},
+		{name: "alias", path: "/things/1", status: http.StatusOK},
+		{name: "alias with header", path: "/things/1", version: "1", status: http.StatusOK},
+		{name: "prefixed with header", path: "/v1/things/1", version: "1", status: http.StatusOK},
+		{name: "unsupported header", path: "/things/1", version: "2", status: http.StatusNotAcceptable},
+		{name: "unsupported prefix", path: "/v2/things/1", status: http.StatusNotAcceptable},
+		{name: "conflicting header", path: "/v1/things/1", version: "2", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			versioned(mux.Handle)("GET /things/{id}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if id := r.PathValue("id"); id != "1" {
+					t.Errorf("id = %q, want 1", id)
+				}
+			}))
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "http://test"+tt.path, nil)
+			if tt.version != "" {
+				req.Header.Set(APIVersionHeader, tt.version)
+			}
+			negotiateVersion(mux).ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d\nbody: %s", rec.Code, tt.status, rec.Body.Bytes())
+			}
+			if supported := rec.Header().Get(SupportedVersionsHeader); supported != "1" {
+				t.Errorf("%s = %q, want 1", SupportedVersionsHeader, supported)
+			}
+			served := rec.Header().Get(APIVersionHeader)
+			if tt.status == http.StatusOK && served != APIVersion {
+				t.Errorf("%s = %q, want %s", APIVersionHeader, served, APIVersion)
+			}
+			if tt.status != http.StatusOK && served != "" {
+				t.Errorf("%s = %q, want none for a refused request", APIVersionHeader, served)
+			}
+		})
+	}
+}
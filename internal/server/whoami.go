@@ -0,0 +1,121 @@
+package server
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+	"github.com/eliasvasylenko/secret-agent/internal/validation"
+)
+
+// The identity of a caller and their effective permissions
+type Whoami struct {
+	Principal   string                    `json:"principal"`
+	Roles       auth.ClaimedRoles         `json:"roles"`
+	Permissions auth.EffectivePermissions `json:"permissions"`
+	Credentials *auth.Credentials         `json:"credentials,omitempty"`
+	Process     *auth.Process             `json:"process,omitempty"`
+	Elevation   *auth.Elevation           `json:"elevation,omitempty"`
+	Listener    string                    `json:"listener,omitempty"`
+	Check       *OperationCheck           `json:"check,omitempty"`
+}
+
+// An operation which a caller could request
+type OperationQuery struct {
+	SecretId  string                `json:"secretId"`
+	Operation secrets.OperationName `json:"operation"`
+	Forced    bool                  `json:"forced,omitzero"`
+	Reason    string                `json:"reason,omitzero"`
+}
+
+// Whether a caller could request an operation, without requesting it
+type OperationCheck struct {
+	OperationQuery
+	Permitted         bool   `json:"permitted"`
+	Denial            string `json:"denial,omitempty"`
+	RequiredApprovals int    `json:"requiredApprovals,omitzero"`
+}
+
+// Parse an operation query from URL parameters, or return nil if no operation is given
+func parseOperationQuery(url url.URL) (*OperationQuery, error) {
+	query := url.Query()
+	if !query.Has("operation") {
+		return nil, nil
+	}
+	operationQuery := &OperationQuery{
+		SecretId:  query.Get("secretId"),
+		Operation: secrets.OperationName(query.Get("operation")),
+		Reason:    query.Get("reason"),
+	}
+	var validator validation.Validator
+	if operationQuery.SecretId == "" {
+		validator.Parameter("secretId", "is required to check an operation")
+	}
+	if !knownOperation(operationQuery.Operation) {
+		validator.Parameter("operation", "unknown operation %q", operationQuery.Operation)
+	}
+	if query.Has("forced") {
+		forced, err := strconv.ParseBool(query.Get("forced"))
+		if err != nil {
+			validator.Parameter("forced", "must be true or false")
+		}
+		operationQuery.Forced = forced
+	}
+	if err := validator.Err(); err != nil {
+		return nil, err
+	}
+	return operationQuery, nil
+}
+
+// Encode an operation query as URL parameters
+func (q *OperationQuery) Values() url.Values {
+	values := url.Values{}
+	values.Set("secretId", q.SecretId)
+	values.Set("operation", string(q.Operation))
+	if q.Forced {
+		values.Set("forced", "true")
+	}
+	if q.Reason != "" {
+		values.Set("reason", q.Reason)
+	}
+	return values
+}
+
+// Whoami describes the identity and effective permissions of the caller, and checks whether they
+// could request the queried operation, if any.
+func (p *Permissions) Whoami(identity *auth.Identity, query *OperationQuery) *Whoami {
+	whoami := &Whoami{
+		Principal:   identity.Principal,
+		Roles:       identity.Roles,
+		Permissions: p.roles(identity).Effective(identity.Roles),
+		Listener:    identity.Listener,
+		Credentials: identity.Credentials,
+		Process:     identity.Process,
+		Elevation:   identity.Elevation,
+	}
+	if query == nil {
+		return whoami
+	}
+
+	check := &OperationCheck{OperationQuery: *query}
+	err := p.assertPermission(identity, requiredPermissions(query.Operation))
+	if err == nil {
+		err = p.AuthoriseOperation(identity, query.SecretId, query.Operation, OperationParameters{
+			Forced: query.Forced,
+			Reason: query.Reason,
+		})
+	}
+	var response *ErrorResponse
+	if errors.As(err, &response) {
+		check.Denial = response.HttpError.Message
+	} else if err != nil {
+		check.Denial = err.Error()
+	} else {
+		check.Permitted = true
+		check.RequiredApprovals, _ = p.RequiredApprovals(query.SecretId, query.Operation, query.Forced)
+	}
+	whoami.Check = check
+	return whoami
+}
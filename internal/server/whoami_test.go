@@ -0,0 +1,67 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPermissions_Whoami(t *testing.T) {
+	var permissions Permissions
+	err := json.Unmarshal([]byte(`{
+		"roles": {
+			"reader": {"permissions": {"secrets": "read", "instances": "read"}},
+			"operator": {"permissions": {"secrets": "write", "instances": "write"}, "inherits": ["reader"], "deny": {"instances": "force"}}
+		},
+		"claims": {},
+		"approvals": [{"operations": ["destroy"], "approvals": 2}],
+		"policies": [{"operations": ["deactivate"], "requireReason": true}]
+	}`), &permissions)
+	if err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	operator := &auth.Identity{Principal: "op", Roles: auth.ClaimedRoles{"operator"}}
+	reader := &auth.Identity{Principal: "reader", Roles: auth.ClaimedRoles{"reader"}}
+
+	got := permissions.Whoami(operator, nil)
+	want := &Whoami{
+		Principal: "op",
+		Roles:     auth.ClaimedRoles{"operator"},
+		Permissions: auth.EffectivePermissions{
+			Granted: auth.Permissions{auth.Secrets: {auth.Write, auth.Read}, auth.Instances: {auth.Write, auth.Read}},
+			Denied:  auth.Permissions{auth.Instances: {auth.Force}},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Whoami mismatch (-want +got):\n%s", diff)
+	}
+
+	tests := []struct {
+		name          string
+		identity      *auth.Identity
+		query         OperationQuery
+		wantPermitted bool
+		wantApprovals int
+	}{
+		{"permitted", operator, OperationQuery{SecretId: "s", Operation: secrets.Activate}, true, 0},
+		{"requires approval", operator, OperationQuery{SecretId: "s", Operation: secrets.Destroy}, true, 2},
+		{"missing permission", reader, OperationQuery{SecretId: "s", Operation: secrets.Activate}, false, 0},
+		{"force denied", operator, OperationQuery{SecretId: "s", Operation: secrets.Activate, Forced: true}, false, 0},
+		{"missing reason", operator, OperationQuery{SecretId: "s", Operation: secrets.Deactivate}, false, 0},
+		{"reason given", operator, OperationQuery{SecretId: "s", Operation: secrets.Deactivate, Reason: "rotate"}, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := permissions.Whoami(tt.identity, &tt.query).Check
+			if check.Permitted != tt.wantPermitted || check.RequiredApprovals != tt.wantApprovals {
+				t.Errorf("Check = %+v, want permitted %v with %d approvals", check, tt.wantPermitted, tt.wantApprovals)
+			}
+			if !check.Permitted && check.Denial == "" {
+				t.Errorf("Check = %+v, want denial reason", check)
+			}
+		})
+	}
+}
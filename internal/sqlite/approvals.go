@@ -0,0 +1,271 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/events"
+	"github.com/eliasvasylenko/secret-agent/internal/marshal"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+	"github.com/google/uuid"
+)
+
+// An approval store implementation backed by sqlite
+type ApprovalRepository struct {
+	db *sql.DB
+}
+
+// Either a database or a transaction
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (s *SecretRespository) Approvals() *ApprovalRepository {
+	return &ApprovalRepository{db: s.db}
+}
+
+const approvalColumns = `
+			a.id,
+			a.secretId,
+			a.instanceId,
+			a.name,
+			a.forced,
+			a.reason,
+			a.env,
+			a.requestedBy,
+			a.requestedAt,
+			a.expiresAt,
+			a.required,
+			a.status,
+			a.operationId,
+			COALESCE(a.idempotencyKey, ''),
+			a.ifMatch,
+			COALESCE(a.elevationId, '')`
+
+func scanApproval(scan func(dest ...any) error) (*secrets.Approval, error) {
+	approval := &secrets.Approval{}
+	var envBytes []byte
+	err := scan(&approval.Id, &approval.SecretId, &approval.InstanceId, &approval.Name, &approval.Forced, &approval.Reason, &envBytes, &approval.RequestedBy, &approval.RequestedAt, &approval.ExpiresAt, &approval.Required, &approval.Status, &approval.OperationNumber, &approval.IdempotencyKey, &approval.IfMatch, &approval.ElevationId)
+	if err != nil {
+		return nil, err
+	}
+	return approval, json.Unmarshal(envBytes, &approval.Env)
+}
+
+func (a *ApprovalRepository) List(ctx context.Context, startAt int, endAt int) ([]*secrets.Approval, error) {
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT`+approvalColumns+`
+		FROM approval a
+		ORDER BY a.rowid DESC
+		LIMIT ? OFFSET ?
+	`, endAt-startAt, startAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	approvals := []*secrets.Approval{}
+	for rows.Next() {
+		approval, err := scanApproval(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		approvals = append(approvals, approval)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, approval := range approvals {
+		if err := loadDecisions(ctx, a.db, approval); err != nil {
+			return nil, err
+		}
+	}
+	return approvals, nil
+}
+
+func (a *ApprovalRepository) Get(ctx context.Context, approvalId string) (*secrets.Approval, error) {
+	return getApproval(ctx, a.db, approvalId)
+}
+
+func getApproval(ctx context.Context, q querier, approvalId string) (*secrets.Approval, error) {
+	approval, err := scanApproval(q.QueryRowContext(ctx, `
+		SELECT`+approvalColumns+`
+		FROM approval a
+		WHERE a.id = ?
+	`, approvalId).Scan)
+	if err != nil {
+		return nil, err
+	}
+	return approval, loadDecisions(ctx, q, approval)
+}
+
+func loadDecisions(ctx context.Context, q querier, approval *secrets.Approval) error {
+	if approval.Status == secrets.Pending && time.Now().After(approval.ExpiresAt) {
+		approval.Status = secrets.Expired
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT
+			principal,
+			approved,
+			comment,
+			decidedAt
+		FROM approval_decision
+		WHERE approvalId = ?
+		ORDER BY decidedAt
+	`, approval.Id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	approval.Decisions = []*secrets.Decision{}
+	for err == nil && rows.Next() {
+		decision := &secrets.Decision{}
+		approval.Decisions = append(approval.Decisions, decision)
+		err = rows.Scan(&decision.Principal, &decision.Approved, &decision.Comment, &decision.DecidedAt)
+	}
+	return err
+}
+
+func (a *ApprovalRepository) Request(ctx context.Context, approval *secrets.Approval) (*secrets.Approval, error) {
+	envBytes, err := marshal.JSON(approval.Env)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, commit, rollback, err := beginTx(a.db)
+	if err != nil {
+		return nil, err
+	}
+	defer rollback()
+
+	if approval.IdempotencyKey != "" {
+		previous, err := scanApproval(tx.QueryRowContext(ctx, `
+			SELECT`+approvalColumns+`
+			FROM approval a
+			WHERE a.requestedBy = ? AND a.idempotencyKey = ?
+		`, approval.RequestedBy, approval.IdempotencyKey).Scan)
+		if err == nil {
+			if previous.SecretId != approval.SecretId || previous.InstanceId != approval.InstanceId || previous.Name != approval.Name {
+				return nil, secrets.ErrIdempotencyKeyReused
+			}
+			return previous, loadDecisions(ctx, tx, previous)
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+	}
+
+	approval.Id = uuid.NewString()
+	approval.Status = secrets.Pending
+	approval.Decisions = []*secrets.Decision{}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO approval (id, secretId, instanceId, name, forced, reason, env, requestedBy, requestedAt, expiresAt, required, status, idempotencyKey, ifMatch, elevationId)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			RETURNING requestedAt
+	`, approval.Id, approval.SecretId, approval.InstanceId, approval.Name, approval.Forced, approval.Reason, envBytes, approval.RequestedBy, time.Now(), approval.ExpiresAt, approval.Required, approval.Status, sql.NullString{String: approval.IdempotencyKey, Valid: approval.IdempotencyKey != ""}, approval.IfMatch, sql.NullString{String: approval.ElevationId, Valid: approval.ElevationId != ""}).Scan(&approval.RequestedAt)
+	if err != nil {
+		return nil, err
+	}
+	err = recordEvent(ctx, tx, approvalEvent(events.ApprovalRequested, approval, approval.RequestedBy), approval)
+	if err != nil {
+		return nil, err
+	}
+	return approval, commit()
+}
+
+func approvalEvent(eventType events.Type, approval *secrets.Approval, principal string) *events.Event {
+	return &events.Event{
+		Type:       eventType,
+		SecretId:   approval.SecretId,
+		InstanceId: approval.InstanceId,
+		Principal:  principal,
+	}
+}
+
+func (a *ApprovalRepository) Decide(ctx context.Context, approvalId string, decision secrets.Decision) (*secrets.Approval, error) {
+	tx, commit, rollback, err := beginTx(a.db)
+	if err != nil {
+		return nil, err
+	}
+	defer rollback()
+
+	approval, err := getApproval(ctx, tx, approvalId)
+	if err != nil {
+		return nil, err
+	}
+
+	if approval.Status == secrets.Expired {
+		// record the expiry before refusing the decision
+		_, err = tx.ExecContext(ctx, `
+			UPDATE approval SET status = ?
+			WHERE id = ?
+		`, approval.Status, approval.Id)
+		if err == nil {
+			err = commit()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if approval.Status != secrets.Pending {
+		return nil, fmt.Errorf("cannot decide upon %s approval request", approval.Status)
+	}
+	if decision.Principal == approval.RequestedBy {
+		return nil, fmt.Errorf("cannot decide upon own approval request")
+	}
+	for _, previous := range approval.Decisions {
+		if previous.Principal == decision.Principal {
+			return nil, fmt.Errorf("%s has already decided upon approval request", decision.Principal)
+		}
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO approval_decision (approvalId, principal, approved, comment, decidedAt)
+			VALUES (?, ?, ?, ?, ?)
+			RETURNING decidedAt
+	`, approval.Id, decision.Principal, decision.Approved, decision.Comment, time.Now()).Scan(&decision.DecidedAt)
+	if err != nil {
+		return nil, err
+	}
+	approval.Decisions = append(approval.Decisions, &decision)
+
+	if !decision.Approved {
+		approval.Status = secrets.Rejected
+	} else if approval.Approvals() >= approval.Required {
+		approval.Status = secrets.Approved
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE approval SET status = ?
+		WHERE id = ?
+	`, approval.Status, approval.Id)
+	if err != nil {
+		return nil, err
+	}
+	err = recordEvent(ctx, tx, approvalEvent(events.ApprovalDecided, approval, decision.Principal), approval)
+	if err != nil {
+		return nil, err
+	}
+
+	return approval, commit()
+}
+
+func (a *ApprovalRepository) Complete(ctx context.Context, approvalId string, operationNumber int) error {
+	_, err := a.db.ExecContext(ctx, `
+		UPDATE approval SET operationId = ?
+		WHERE id = ?
+	`, operationNumber, approvalId)
+	return err
+}
+
+func (a *ApprovalRepository) Fail(ctx context.Context, approvalId string) error {
+	_, err := a.db.ExecContext(ctx, `
+		UPDATE approval SET status = ?
+		WHERE id = ? AND status = ? AND operationId IS NULL
+	`, secrets.Failed, approvalId, secrets.Approved)
+	return err
+}
@@ -0,0 +1,283 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/command"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+)
+
+func requestApproval(t *testing.T, approvals *ApprovalRepository, instanceId string, name secrets.OperationName, required int, expiresAt time.Time) *secrets.Approval {
+	t.Helper()
+	approval, err := approvals.Request(context.Background(), &secrets.Approval{
+		SecretId:    "s1",
+		InstanceId:  instanceId,
+		Name:        name,
+		Reason:      "because",
+		Env:         command.Environment{"KEY": "value"},
+		RequestedBy: "requester",
+		ExpiresAt:   expiresAt,
+		Required:    required,
+	})
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	return approval
+}
+
+func TestApprovalRepository_Request_Get_List(t *testing.T) {
+	repo := newTestRepo(t, nil)
+	ctx := context.Background()
+	approvals := repo.Approvals()
+
+	first := requestApproval(t, approvals, "i1", secrets.Destroy, 1, time.Now().Add(time.Hour))
+	second := requestApproval(t, approvals, "", secrets.Create, 2, time.Now().Add(time.Hour))
+	if first.Id == "" || first.Status != secrets.Pending {
+		t.Errorf("Request = %+v, want pending request with ID", first)
+	}
+
+	got, err := approvals.Get(ctx, first.Id)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.Name != secrets.Destroy || got.InstanceId != "i1" || got.Reason != "because" || got.Env["KEY"] != "value" {
+		t.Errorf("Get = %+v", got)
+	}
+
+	list, err := approvals.List(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(list) != 2 || list[0].Id != second.Id || list[1].Id != first.Id {
+		t.Errorf("List = %v, want most recent first", list)
+	}
+}
+
+func TestApprovalRepository_Request_ifMatch(t *testing.T) {
+	repo := newTestRepo(t, nil)
+	ctx := context.Background()
+	approvals := repo.Approvals()
+
+	latest := 7
+	approval, err := approvals.Request(ctx, &secrets.Approval{SecretId: "s1", InstanceId: "i1", Name: secrets.Destroy, RequestedBy: "requester", ExpiresAt: time.Now().Add(time.Hour), Required: 1, IfMatch: &latest})
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	got, err := approvals.Get(ctx, approval.Id)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if ifMatch := got.Parameters().IfMatch; ifMatch == nil || *ifMatch != latest {
+		t.Errorf("Get parameters IfMatch = %v, want %d", ifMatch, latest)
+	}
+}
+
+func TestApprovalRepository_Decide(t *testing.T) {
+	repo := newTestRepo(t, nil)
+	ctx := context.Background()
+	approvals := repo.Approvals()
+
+	approval := requestApproval(t, approvals, "i1", secrets.Destroy, 2, time.Now().Add(time.Hour))
+
+	if _, err := approvals.Decide(ctx, approval.Id, secrets.Decision{Principal: "requester", Approved: true}); err == nil {
+		t.Error("Decide by requester = nil, want error")
+	}
+
+	decided, err := approvals.Decide(ctx, approval.Id, secrets.Decision{Principal: "alice", Approved: true, Comment: "ok"})
+	if err != nil {
+		t.Fatalf("Decide: %v", err)
+	}
+	if decided.Status != secrets.Pending || decided.Approvals() != 1 {
+		t.Errorf("after one of two approvals, Decide = %+v", decided)
+	}
+
+	if _, err := approvals.Decide(ctx, approval.Id, secrets.Decision{Principal: "alice", Approved: true}); err == nil {
+		t.Error("second Decide by same principal = nil, want error")
+	}
+
+	decided, err = approvals.Decide(ctx, approval.Id, secrets.Decision{Principal: "bob", Approved: true})
+	if err != nil {
+		t.Fatalf("Decide: %v", err)
+	}
+	if decided.Status != secrets.Approved {
+		t.Errorf("after two of two approvals, Status = %s, want approved", decided.Status)
+	}
+
+	if _, err := approvals.Decide(ctx, approval.Id, secrets.Decision{Principal: "carol", Approved: true}); err == nil {
+		t.Error("Decide on approved request = nil, want error")
+	}
+
+	if err := approvals.Complete(ctx, approval.Id, 42); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	got, err := approvals.Get(ctx, approval.Id)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.OperationNumber == nil || *got.OperationNumber != 42 || len(got.Decisions) != 2 {
+		t.Errorf("Get after Complete = %+v", got)
+	}
+}
+
+func TestApprovalRepository_Fail(t *testing.T) {
+	repo := newTestRepo(t, nil)
+	ctx := context.Background()
+	approvals := repo.Approvals()
+
+	approval, err := approvals.Request(ctx, &secrets.Approval{SecretId: "s1", InstanceId: "i1", Name: secrets.Activate, RequestedBy: "requester", ExpiresAt: time.Now().Add(time.Hour), Required: 1})
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	if err := approvals.Fail(ctx, approval.Id); err != nil {
+		t.Fatalf("Fail: %v", err)
+	}
+	if got, err := approvals.Get(ctx, approval.Id); err != nil || got.Status != secrets.Pending {
+		t.Errorf("Get after Fail while pending = %+v, %v, want pending", got, err)
+	}
+
+	if _, err := approvals.Decide(ctx, approval.Id, secrets.Decision{Principal: "alice", Approved: true}); err != nil {
+		t.Fatalf("Decide: %v", err)
+	}
+	if err := approvals.Fail(ctx, approval.Id); err != nil {
+		t.Fatalf("Fail: %v", err)
+	}
+	got, err := approvals.Get(ctx, approval.Id)
+	if err != nil || got.Status != secrets.Failed {
+		t.Errorf("Get after Fail = %+v, %v, want failed", got, err)
+	}
+	if _, err := approvals.Decide(ctx, approval.Id, secrets.Decision{Principal: "bob", Approved: true}); err == nil {
+		t.Error("Decide on failed request = nil, want error")
+	}
+}
+
+func TestApprovalRepository_Decide_reject(t *testing.T) {
+	repo := newTestRepo(t, nil)
+	ctx := context.Background()
+	approvals := repo.Approvals()
+
+	approval := requestApproval(t, approvals, "i1", secrets.Destroy, 2, time.Now().Add(time.Hour))
+
+	decided, err := approvals.Decide(ctx, approval.Id, secrets.Decision{Principal: "alice", Approved: false, Comment: "no"})
+	if err != nil {
+		t.Fatalf("Decide: %v", err)
+	}
+	if decided.Status != secrets.Rejected {
+		t.Errorf("Status = %s, want rejected", decided.Status)
+	}
+	if _, err := approvals.Decide(ctx, approval.Id, secrets.Decision{Principal: "bob", Approved: true}); err == nil {
+		t.Error("Decide on rejected request = nil, want error")
+	}
+}
+
+func TestApprovalRepository_Decide_expired(t *testing.T) {
+	repo := newTestRepo(t, nil)
+	ctx := context.Background()
+	approvals := repo.Approvals()
+
+	approval := requestApproval(t, approvals, "i1", secrets.Destroy, 1, time.Now().Add(-time.Minute))
+
+	if _, err := approvals.Decide(ctx, approval.Id, secrets.Decision{Principal: "alice", Approved: true}); err == nil {
+		t.Error("Decide on expired request = nil, want error")
+	}
+	got, err := approvals.Get(ctx, approval.Id)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.Status != secrets.Expired {
+		t.Errorf("Status = %s, want expired", got.Status)
+	}
+}
+
+func TestInstanceRepository_approvedOperation(t *testing.T) {
+	repo := newTestRepo(t, nil)
+	ctx := context.Background()
+	instances := repo.Instances("s1")
+
+	approval := requestApproval(t, repo.Approvals(), "", secrets.Create, 1, time.Now().Add(time.Hour))
+	created, err := instances.Create(ctx, approval.Parameters())
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	ops, err := instances.History(ctx, created.Id, 0, 10)
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	if len(ops) != 1 || ops[0].ApprovalId == nil || *ops[0].ApprovalId != approval.Id || ops[0].StartedBy != "requester" {
+		t.Errorf("History = %+v, want operation started by requester with approval %s", ops, approval.Id)
+	}
+}
+
+func TestInstanceRepository_approvedOperation_elevated(t *testing.T) {
+	repo := newTestRepo(t, nil)
+	ctx := context.Background()
+	instances := repo.Instances("s1")
+
+	elevation := grantElevation(t, repo.Elevations(), "requester", time.Now().Add(time.Hour))
+	requested, err := repo.Approvals().Request(ctx, &secrets.Approval{
+		SecretId:    "s1",
+		Name:        secrets.Create,
+		RequestedBy: "requester",
+		ExpiresAt:   time.Now().Add(time.Hour),
+		Required:    1,
+		ElevationId: elevation.Id,
+	})
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	approval, err := repo.Approvals().Get(ctx, requested.Id)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if approval.ElevationId != elevation.Id {
+		t.Fatalf("ElevationId = %q, want %s", approval.ElevationId, elevation.Id)
+	}
+	created, err := instances.Create(ctx, approval.Parameters())
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	ops, err := instances.History(ctx, created.Id, 0, 10)
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	if len(ops) != 1 || ops[0].ElevationId == nil || *ops[0].ElevationId != elevation.Id {
+		t.Errorf("History = %+v, want operation with elevation %s", ops, elevation.Id)
+	}
+}
+
+func TestApprovalRepository_Request_idempotencyKey(t *testing.T) {
+	repo := newTestRepo(t, nil)
+	ctx := context.Background()
+	approvals := repo.Approvals()
+
+	request := func(name secrets.OperationName) (*secrets.Approval, error) {
+		return approvals.Request(ctx, &secrets.Approval{
+			SecretId:       "s1",
+			InstanceId:     "i1",
+			Name:           name,
+			RequestedBy:    "requester",
+			ExpiresAt:      time.Now().Add(time.Hour),
+			Required:       1,
+			IdempotencyKey: "k1",
+		})
+	}
+	first, err := request(secrets.Destroy)
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	retried, err := request(secrets.Destroy)
+	if err != nil {
+		t.Fatalf("Request retry: %v", err)
+	}
+	if retried.Id != first.Id || retried.IdempotencyKey != "k1" {
+		t.Errorf("Request retry = %+v, want original %+v", retried, first)
+	}
+	_, err = request(secrets.Test)
+	if !errors.Is(err, secrets.ErrIdempotencyKeyReused) {
+		t.Errorf("Request with key of another request = %v, want %v", err, secrets.ErrIdempotencyKeyReused)
+	}
+}
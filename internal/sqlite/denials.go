@@ -0,0 +1,129 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/audit"
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/events"
+	"github.com/eliasvasylenko/secret-agent/internal/marshal"
+)
+
+// A store of denied attempts backed by sqlite
+type DenialRepository struct {
+	db *sql.DB
+}
+
+func (s *SecretRespository) Denials() *DenialRepository {
+	return &DenialRepository{db: s.db}
+}
+
+func (d *DenialRepository) Record(ctx context.Context, denial *audit.Denial) error {
+	permissionBytes, err := marshal.JSON(denial.Permission)
+	if err != nil {
+		return err
+	}
+	rolesBytes, err := marshal.JSON([]auth.RoleName(denial.Roles))
+	if err != nil {
+		return err
+	}
+	var uid, gid, pid *int64
+	if denial.Credentials != nil {
+		uid, gid, pid = ptr(int64(denial.Credentials.Uid)), ptr(int64(denial.Credentials.Gid)), ptr(int64(denial.Credentials.Pid))
+	}
+
+	tx, commit, rollback, err := beginTx(d.db)
+	if err != nil {
+		return err
+	}
+	defer rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO denial (principal, uid, gid, pid, listener, route, secretId, instanceId, operation, permission, roles, reason, deniedAt)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			RETURNING id, deniedAt
+	`, denial.Principal, uid, gid, pid, denial.Listener, denial.Route, denial.SecretId, denial.InstanceId, denial.Operation, permissionBytes, rolesBytes, denial.Reason, time.Now()).Scan(&denial.Id, &denial.DeniedAt)
+	if err != nil {
+		return err
+	}
+	event := &events.Event{
+		Type:       events.AccessDenied,
+		SecretId:   denial.SecretId,
+		InstanceId: denial.InstanceId,
+		Principal:  denial.Principal,
+	}
+	err = recordEvent(ctx, tx, event, denial)
+	if err != nil {
+		return err
+	}
+	return commit()
+}
+
+func (d *DenialRepository) List(ctx context.Context, startAt int, endAt int) ([]*audit.Denial, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT
+			id,
+			principal,
+			uid,
+			gid,
+			pid,
+			listener,
+			route,
+			secretId,
+			instanceId,
+			operation,
+			permission,
+			roles,
+			reason,
+			deniedAt
+		FROM denial
+		ORDER BY id DESC
+		LIMIT ? OFFSET ?
+	`, endAt-startAt, startAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	denials := []*audit.Denial{}
+	for rows.Next() {
+		denial := &audit.Denial{}
+		var uid, gid, pid *int64
+		var permissionBytes, rolesBytes []byte
+		err = rows.Scan(&denial.Id, &denial.Principal, &uid, &gid, &pid, &denial.Listener, &denial.Route, &denial.SecretId, &denial.InstanceId, &denial.Operation, &permissionBytes, &rolesBytes, &denial.Reason, &denial.DeniedAt)
+		if err != nil {
+			return nil, err
+		}
+		if uid != nil && gid != nil && pid != nil {
+			denial.Credentials = &auth.Credentials{Uid: uint32(*uid), Gid: uint32(*gid), Pid: int32(*pid)}
+		}
+		var roles []auth.RoleName
+		err = errors.Join(json.Unmarshal(permissionBytes, &denial.Permission), json.Unmarshal(rolesBytes, &roles))
+		if err != nil {
+			return nil, err
+		}
+		denial.Roles = roles
+		denials = append(denials, denial)
+	}
+	return denials, rows.Err()
+}
+
+// Delete denied attempts recorded before the given time, returning the number deleted
+func (d *DenialRepository) Prune(ctx context.Context, before time.Time) (int, error) {
+	result, err := d.db.ExecContext(ctx, `
+		DELETE FROM denial
+		WHERE deniedAt < ?
+	`, before)
+	if err != nil {
+		return 0, err
+	}
+	pruned, err := result.RowsAffected()
+	return int(pruned), err
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
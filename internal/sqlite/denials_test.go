@@ -0,0 +1,98 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/audit"
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDenialRepository_Record_List(t *testing.T) {
+	repo := newTestRepo(t, nil)
+	ctx := context.Background()
+	denials := repo.Denials()
+
+	for _, reason := range []string{"first", "second"} {
+		denial := &audit.Denial{
+			Principal:   "user",
+			Credentials: &auth.Credentials{Uid: 1000, Gid: 100, Pid: 42},
+			Listener:    "admin",
+			Route:       "POST /secrets/{secretId}/instances",
+			SecretId:    "s1",
+			Operation:   secrets.Create,
+			Permission:  auth.Permissions{auth.Instances: {auth.Write}},
+			Roles:       auth.ClaimedRoles{"reader"},
+			Reason:      reason,
+		}
+		if err := denials.Record(ctx, denial); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+		if denial.Id == 0 || denial.DeniedAt.IsZero() {
+			t.Errorf("Record = %+v, want ID and time", denial)
+		}
+	}
+
+	list, err := denials.List(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(list) != 2 || list[0].Reason != "second" || list[1].Reason != "first" {
+		t.Fatalf("List = %+v, want most recent first", list)
+	}
+	want := &audit.Denial{
+		Id:          1,
+		Principal:   "user",
+		Credentials: &auth.Credentials{Uid: 1000, Gid: 100, Pid: 42},
+		Listener:    "admin",
+		Route:       "POST /secrets/{secretId}/instances",
+		SecretId:    "s1",
+		Operation:   secrets.Create,
+		Permission:  auth.Permissions{auth.Instances: {auth.Write}},
+		Roles:       auth.ClaimedRoles{"reader"},
+		Reason:      "first",
+		DeniedAt:    list[1].DeniedAt,
+	}
+	if diff := cmp.Diff(want, list[1]); diff != "" {
+		t.Errorf("List mismatch (-want +got):\n%s", diff)
+	}
+	list, err = denials.List(ctx, 1, 2)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(list) != 1 || list[0].Reason != "first" {
+		t.Errorf("List(1, 2) = %+v", list)
+	}
+}
+
+func TestDenialRepository_Prune(t *testing.T) {
+	repo := newTestRepo(t, nil)
+	ctx := context.Background()
+	denials := repo.Denials()
+
+	if err := denials.Record(ctx, &audit.Denial{Route: "GET /secrets", Reason: "old"}); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	cutoff := time.Now()
+	if err := denials.Record(ctx, &audit.Denial{Route: "GET /secrets", Reason: "new"}); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+
+	pruned, err := denials.Prune(ctx, cutoff)
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if pruned != 1 {
+		t.Errorf("Prune = %d, want 1", pruned)
+	}
+	list, err := denials.List(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(list) != 1 || list[0].Reason != "new" {
+		t.Errorf("List = %+v, want only new denial", list)
+	}
+}
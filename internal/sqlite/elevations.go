@@ -0,0 +1,145 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/events"
+	"github.com/google/uuid"
+)
+
+// A store of break-glass elevations backed by sqlite
+type ElevationRepository struct {
+	db *sql.DB
+}
+
+func (s *SecretRespository) Elevations() *ElevationRepository {
+	return &ElevationRepository{db: s.db}
+}
+
+const elevationColumns = `
+			id,
+			principal,
+			role,
+			reason,
+			startedAt,
+			expiresAt,
+			revokedAt,
+			revokedBy`
+
+func scanElevation(scan func(dest ...any) error) (*auth.Elevation, error) {
+	elevation := &auth.Elevation{}
+	err := scan(&elevation.Id, &elevation.Principal, &elevation.Role, &elevation.Reason, &elevation.StartedAt, &elevation.ExpiresAt, &elevation.RevokedAt, &elevation.RevokedBy)
+	if err != nil {
+		return nil, err
+	}
+	return elevation, nil
+}
+
+func (e *ElevationRepository) Grant(ctx context.Context, elevation *auth.Elevation) (*auth.Elevation, error) {
+	tx, commit, rollback, err := beginTx(e.db)
+	if err != nil {
+		return nil, err
+	}
+	defer rollback()
+
+	active, err := activeElevation(ctx, tx, elevation.Principal)
+	if err != nil {
+		return nil, err
+	}
+	if active != nil {
+		return nil, fmt.Errorf("%s already has active elevation %s", elevation.Principal, active.Id)
+	}
+
+	elevation.Id = uuid.NewString()
+	elevation.StartedAt = time.Now()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO elevation (id, principal, role, reason, startedAt, expiresAt)
+			VALUES (?, ?, ?, ?, ?, ?)
+	`, elevation.Id, elevation.Principal, elevation.Role, elevation.Reason, elevation.StartedAt, elevation.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	err = recordEvent(ctx, tx, &events.Event{Type: events.ElevationGranted, Principal: elevation.Principal}, elevation)
+	if err != nil {
+		return nil, err
+	}
+	return elevation, commit()
+}
+
+func (e *ElevationRepository) Active(ctx context.Context, principal string) (*auth.Elevation, error) {
+	return activeElevation(ctx, e.db, principal)
+}
+
+func activeElevation(ctx context.Context, q querier, principal string) (*auth.Elevation, error) {
+	elevation, err := scanElevation(q.QueryRowContext(ctx, `
+		SELECT`+elevationColumns+`
+		FROM elevation
+		WHERE principal = ? AND revokedAt IS NULL AND expiresAt > ?
+		ORDER BY expiresAt DESC
+		LIMIT 1
+	`, principal, time.Now()).Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return elevation, err
+}
+
+func (e *ElevationRepository) Get(ctx context.Context, elevationId string) (*auth.Elevation, error) {
+	return scanElevation(e.db.QueryRowContext(ctx, `
+		SELECT`+elevationColumns+`
+		FROM elevation
+		WHERE id = ?
+	`, elevationId).Scan)
+}
+
+func (e *ElevationRepository) List(ctx context.Context, startAt int, endAt int) ([]*auth.Elevation, error) {
+	rows, err := e.db.QueryContext(ctx, `
+		SELECT`+elevationColumns+`
+		FROM elevation
+		ORDER BY startedAt DESC
+		LIMIT ? OFFSET ?
+	`, endAt-startAt, startAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	elevations := []*auth.Elevation{}
+	for rows.Next() {
+		elevation, err := scanElevation(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		elevations = append(elevations, elevation)
+	}
+	return elevations, rows.Err()
+}
+
+func (e *ElevationRepository) Revoke(ctx context.Context, elevationId string, revokedBy string) (*auth.Elevation, error) {
+	tx, commit, rollback, err := beginTx(e.db)
+	if err != nil {
+		return nil, err
+	}
+	defer rollback()
+
+	elevation, err := scanElevation(tx.QueryRowContext(ctx, `
+		UPDATE elevation SET revokedAt = ?, revokedBy = ?
+		WHERE id = ? AND revokedAt IS NULL AND expiresAt > ?
+		RETURNING`+elevationColumns+`
+	`, time.Now(), revokedBy, elevationId, time.Now()).Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("no active elevation %s", elevationId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = recordEvent(ctx, tx, &events.Event{Type: events.ElevationRevoked, Principal: revokedBy}, elevation)
+	if err != nil {
+		return nil, err
+	}
+	return elevation, commit()
+}
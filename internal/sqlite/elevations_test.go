@@ -0,0 +1,114 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/command"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+)
+
+func grantElevation(t *testing.T, elevations *ElevationRepository, principal string, expiresAt time.Time) *auth.Elevation {
+	t.Helper()
+	elevation, err := elevations.Grant(context.Background(), &auth.Elevation{
+		Principal: principal,
+		Role:      "operator",
+		Reason:    "incident",
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		t.Fatalf("Grant: %v", err)
+	}
+	return elevation
+}
+
+func TestElevationRepository_Grant_Active_Revoke(t *testing.T) {
+	repo := newTestRepo(t, nil)
+	ctx := context.Background()
+	elevations := repo.Elevations()
+
+	active, err := elevations.Active(ctx, "alice")
+	if err != nil || active != nil {
+		t.Fatalf("Active before grant = %+v, %v, want nil", active, err)
+	}
+
+	granted := grantElevation(t, elevations, "alice", time.Now().Add(time.Hour))
+	if granted.Id == "" || granted.StartedAt.IsZero() {
+		t.Errorf("Grant = %+v, want ID and start time", granted)
+	}
+	_, err = elevations.Grant(ctx, &auth.Elevation{Principal: "alice", Role: "admin", Reason: "again", ExpiresAt: time.Now().Add(time.Hour)})
+	if err == nil {
+		t.Error("Grant while active: want error")
+	}
+
+	active, err = elevations.Active(ctx, "alice")
+	if err != nil || active == nil || active.Id != granted.Id {
+		t.Fatalf("Active = %+v, %v, want %s", active, err, granted.Id)
+	}
+
+	revoked, err := elevations.Revoke(ctx, granted.Id, "bob")
+	if err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if revoked.RevokedAt == nil || revoked.RevokedBy != "bob" {
+		t.Errorf("Revoke = %+v, want revoked by bob", revoked)
+	}
+	if _, err := elevations.Revoke(ctx, granted.Id, "bob"); err == nil {
+		t.Error("Revoke twice: want error")
+	}
+	active, err = elevations.Active(ctx, "alice")
+	if err != nil || active != nil {
+		t.Errorf("Active after revoke = %+v, %v, want nil", active, err)
+	}
+
+	list, err := elevations.List(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(list) != 1 || list[0].Id != granted.Id || list[0].RevokedBy != "bob" {
+		t.Errorf("List = %+v", list)
+	}
+}
+
+func TestElevationRepository_expired(t *testing.T) {
+	repo := newTestRepo(t, nil)
+	ctx := context.Background()
+	elevations := repo.Elevations()
+
+	expired := grantElevation(t, elevations, "alice", time.Now().Add(-time.Minute))
+	active, err := elevations.Active(ctx, "alice")
+	if err != nil || active != nil {
+		t.Errorf("Active = %+v, %v, want nil once expired", active, err)
+	}
+	if _, err := elevations.Revoke(ctx, expired.Id, "bob"); err == nil {
+		t.Error("Revoke expired: want error")
+	}
+	grantElevation(t, elevations, "alice", time.Now().Add(time.Hour))
+}
+
+func TestInstanceRepository_elevatedOperation(t *testing.T) {
+	repo := newTestRepo(t, nil)
+	ctx := context.Background()
+	instances := repo.Instances("s1")
+
+	elevation := grantElevation(t, repo.Elevations(), "alice", time.Now().Add(time.Hour))
+	created, err := instances.Create(ctx, secrets.OperationParameters{
+		Env:         command.Environment{},
+		Reason:      "incident",
+		StartedBy:   "alice",
+		ElevationId: elevation.Id,
+	})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	ops, err := instances.History(ctx, created.Id, 0, 10)
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	if len(ops) != 1 || ops[0].ElevationId == nil || *ops[0].ElevationId != elevation.Id {
+		t.Errorf("History = %+v, want operation with elevation %s", ops, elevation.Id)
+	}
+}
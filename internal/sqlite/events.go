@@ -0,0 +1,152 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/events"
+	"github.com/eliasvasylenko/secret-agent/internal/marshal"
+)
+
+// An outbox of events backed by sqlite
+type EventRepository struct {
+	db *sql.DB
+}
+
+func (s *SecretRespository) Events() *EventRepository {
+	return &EventRepository{db: s.db}
+}
+
+// recordEvent records an event with the given subject as its data, in the transaction which
+// made the change the event describes, so that an event is recorded if and only if the change is.
+func recordEvent(ctx context.Context, q querier, event *events.Event, subject any) error {
+	var err error
+	if subject != nil {
+		event.Data, err = marshal.JSON(subject)
+		if err != nil {
+			return err
+		}
+	}
+	return q.QueryRowContext(ctx, `
+		INSERT INTO event (type, secretId, instanceId, principal, data, occurredAt)
+			VALUES (?, ?, ?, ?, ?, ?)
+			RETURNING id, occurredAt
+	`, event.Type, event.SecretId, event.InstanceId, event.Principal, []byte(event.Data), time.Now()).Scan(&event.Id, &event.OccurredAt)
+}
+
+const eventColumns = `
+			id,
+			type,
+			secretId,
+			instanceId,
+			principal,
+			data,
+			occurredAt`
+
+func scanEvent(scan func(dest ...any) error) (*events.Event, error) {
+	event := &events.Event{}
+	var data []byte
+	err := scan(&event.Id, &event.Type, &event.SecretId, &event.InstanceId, &event.Principal, &data, &event.OccurredAt)
+	if err != nil {
+		return nil, err
+	}
+	event.Data = data
+	return event, nil
+}
+
+// List at most limit events following the event with the given ID, in the order they were recorded
+func (e *EventRepository) After(ctx context.Context, eventId int64, limit int) ([]*events.Event, error) {
+	rows, err := e.db.QueryContext(ctx, `
+		SELECT`+eventColumns+`
+		FROM event
+		WHERE id > ?
+		ORDER BY id
+		LIMIT ?
+	`, eventId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := []*events.Event{}
+	for rows.Next() {
+		event, err := scanEvent(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, event)
+	}
+	return list, rows.Err()
+}
+
+// The ID of the most recently recorded event, or zero if there are none
+func (e *EventRepository) Latest(ctx context.Context) (int64, error) {
+	var eventId int64
+	err := e.db.QueryRowContext(ctx, `
+		SELECT COALESCE(MAX(id), 0)
+		FROM event
+	`).Scan(&eventId)
+	return eventId, err
+}
+
+// The ID of the most recently recorded event which has been pruned, or zero if none have been. Events are
+// numbered consecutively and pruned oldest first, so those before the oldest which remains have been pruned, or
+// if none remain, every event which was numbered.
+func (e *EventRepository) Pruned(ctx context.Context) (int64, error) {
+	var eventId int64
+	err := e.db.QueryRowContext(ctx, `
+		SELECT COALESCE(
+			(SELECT MIN(id) - 1 FROM event),
+			(SELECT seq FROM sqlite_sequence WHERE name = 'event'),
+			0
+		)
+	`).Scan(&eventId)
+	return eventId, err
+}
+
+// Delete events recorded before the given time, up to and including the event with the given ID, returning the
+// number deleted
+func (e *EventRepository) Prune(ctx context.Context, before time.Time, throughEventId int64) (int, error) {
+	result, err := e.db.ExecContext(ctx, `
+		DELETE FROM event
+		WHERE occurredAt < ? AND id <= ?
+	`, before, throughEventId)
+	if err != nil {
+		return 0, err
+	}
+	pruned, err := result.RowsAffected()
+	return int(pruned), err
+}
+
+// Get the progress of delivery to a subscriber. A new subscriber starts from the latest event,
+// rather than receiving every event which was recorded before it was subscribed.
+func (e *EventRepository) Subscription(ctx context.Context, subscriber string) (*events.Subscription, error) {
+	_, err := e.db.ExecContext(ctx, `
+		INSERT OR IGNORE INTO subscription (subscriber, deliveredEventId)
+			SELECT ?, COALESCE(MAX(id), 0)
+			FROM event
+	`, subscriber)
+	if err != nil {
+		return nil, err
+	}
+
+	subscription := &events.Subscription{}
+	err = e.db.QueryRowContext(ctx, `
+		SELECT subscriber, deliveredEventId, attempts, nextAttemptAt, lastError
+		FROM subscription
+		WHERE subscriber = ?
+	`, subscriber).Scan(&subscription.Subscriber, &subscription.DeliveredEventId, &subscription.Attempts, &subscription.NextAttemptAt, &subscription.LastError)
+	if err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+// Record the progress of delivery to a subscriber
+func (e *EventRepository) UpdateSubscription(ctx context.Context, subscription *events.Subscription) error {
+	_, err := e.db.ExecContext(ctx, `
+		UPDATE subscription SET deliveredEventId = ?, attempts = ?, nextAttemptAt = ?, lastError = ?
+		WHERE subscriber = ?
+	`, subscription.DeliveredEventId, subscription.Attempts, subscription.NextAttemptAt, subscription.LastError, subscription.Subscriber)
+	return err
+}
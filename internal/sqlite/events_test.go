@@ -0,0 +1,214 @@
+package sqlite
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/command"
+	"github.com/eliasvasylenko/secret-agent/internal/events"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+	"github.com/google/go-cmp/cmp"
+)
+
+func eventTypes(list []*events.Event) []events.Type {
+	types := []events.Type{}
+	for _, event := range list {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func TestEventRepository_operations(t *testing.T) {
+	repo := newTestRepo(t, nil)
+	ctx := context.Background()
+	instances := repo.Instances("s1")
+
+	created, err := instances.Create(ctx, secrets.OperationParameters{Reason: "create", StartedBy: "alice"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := instances.Activate(ctx, created.Id, secrets.OperationParameters{Reason: "activate", StartedBy: "alice"}); err != nil {
+		t.Fatalf("Activate: %v", err)
+	}
+	if _, err := instances.Deactivate(ctx, created.Id, secrets.OperationParameters{Reason: "deactivate", StartedBy: "alice"}); err != nil {
+		t.Fatalf("Deactivate: %v", err)
+	}
+
+	list, err := repo.Events().After(ctx, 0, 100)
+	if err != nil {
+		t.Fatalf("After: %v", err)
+	}
+	want := []events.Type{
+		events.OperationStarted, events.OperationCompleted,
+		events.OperationStarted, events.ActiveInstanceChanged, events.OperationCompleted,
+		events.OperationStarted, events.ActiveInstanceChanged, events.OperationCompleted,
+	}
+	if diff := cmp.Diff(want, eventTypes(list)); diff != "" {
+		t.Fatalf("event types mismatch (-want +got):\n%s", diff)
+	}
+
+	for i, event := range list {
+		if event.SecretId != "s1" || event.InstanceId != created.Id || event.Principal != "alice" {
+			t.Errorf("event %d = %+v, want about s1 instance %s by alice", i, event, created.Id)
+		}
+		if i > 0 && event.Id <= list[i-1].Id {
+			t.Errorf("event %d has ID %d, want greater than %d", i, event.Id, list[i-1].Id)
+		}
+	}
+
+	var completed secrets.Operation
+	if err := json.Unmarshal(list[1].Data, &completed); err != nil {
+		t.Fatalf("unmarshal completed operation: %v", err)
+	}
+	if completed.Name != secrets.Create || completed.CompletedAt == nil {
+		t.Errorf("completed operation = %+v, want completed create", completed)
+	}
+
+	var activated, deactivated events.ActiveInstance
+	if err := json.Unmarshal(list[3].Data, &activated); err != nil || activated.ActiveInstanceId == nil || *activated.ActiveInstanceId != created.Id {
+		t.Errorf("activated = %s, %v, want active instance %s", list[3].Data, err, created.Id)
+	}
+	if err := json.Unmarshal(list[6].Data, &deactivated); err != nil || deactivated.ActiveInstanceId != nil {
+		t.Errorf("deactivated = %s, %v, want no active instance", list[6].Data, err)
+	}
+
+	latest, err := repo.Events().Latest(ctx)
+	if err != nil || latest != list[len(list)-1].Id {
+		t.Errorf("Latest = %d, %v, want %d", latest, err, list[len(list)-1].Id)
+	}
+	tail, err := repo.Events().After(ctx, list[5].Id, 1)
+	if err != nil || len(tail) != 1 || tail[0].Id != list[6].Id {
+		t.Errorf("After(%d, 1) = %v, %v, want event %d", list[5].Id, tail, err, list[6].Id)
+	}
+}
+
+func TestEventRepository_failedOperation(t *testing.T) {
+	failing := &secrets.Secret{Name: "failing", Create: &command.Command{Script: "exit 1"}}
+	repo := newTestRepo(t, secrets.Secrets{"failing": failing})
+	ctx := context.Background()
+
+	if _, err := repo.Instances("failing").Create(ctx, secrets.OperationParameters{Reason: "r", StartedBy: "u"}); err == nil {
+		t.Fatal("Create = nil, want error from failing command")
+	}
+
+	list, err := repo.Events().After(ctx, 0, 100)
+	if err != nil {
+		t.Fatalf("After: %v", err)
+	}
+	if diff := cmp.Diff([]events.Type{events.OperationStarted, events.OperationFailed}, eventTypes(list)); diff != "" {
+		t.Errorf("event types mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestEventRepository_policyEvents(t *testing.T) {
+	repo := newTestRepo(t, nil)
+	ctx := context.Background()
+
+	approval := requestApproval(t, repo.Approvals(), "instance", secrets.Destroy, 1, time.Now().Add(time.Hour))
+	if _, err := repo.Approvals().Decide(ctx, approval.Id, secrets.Decision{Principal: "bob", Approved: true}); err != nil {
+		t.Fatalf("Decide: %v", err)
+	}
+	elevation := grantElevation(t, repo.Elevations(), "alice", time.Now().Add(time.Hour))
+	if _, err := repo.Elevations().Revoke(ctx, elevation.Id, "carol"); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+
+	list, err := repo.Events().After(ctx, 0, 100)
+	if err != nil {
+		t.Fatalf("After: %v", err)
+	}
+	want := []events.Type{events.ApprovalRequested, events.ApprovalDecided, events.ElevationGranted, events.ElevationRevoked}
+	if diff := cmp.Diff(want, eventTypes(list)); diff != "" {
+		t.Fatalf("event types mismatch (-want +got):\n%s", diff)
+	}
+	if list[1].Principal != "bob" || list[3].Principal != "carol" {
+		t.Errorf("principals = %s, %s, want the deciding and revoking principals", list[1].Principal, list[3].Principal)
+	}
+
+	var revoked auth.Elevation
+	if err := json.Unmarshal(list[3].Data, &revoked); err != nil || revoked.Id != elevation.Id || revoked.RevokedAt == nil {
+		t.Errorf("revoked elevation = %s, %v", list[3].Data, err)
+	}
+}
+
+func TestEventRepository_Subscription(t *testing.T) {
+	repo := newTestRepo(t, nil)
+	ctx := context.Background()
+	grantElevation(t, repo.Elevations(), "alice", time.Now().Add(time.Hour))
+	latest, _ := repo.Events().Latest(ctx)
+
+	subscription, err := repo.Events().Subscription(ctx, "hook")
+	if err != nil {
+		t.Fatalf("Subscription: %v", err)
+	}
+	if subscription.DeliveredEventId != latest {
+		t.Errorf("new subscription delivered = %d, want latest event %d", subscription.DeliveredEventId, latest)
+	}
+
+	nextAttemptAt := time.Now().Add(time.Minute).UTC().Truncate(time.Second)
+	subscription.DeliveredEventId = 0
+	subscription.Attempts = 2
+	subscription.NextAttemptAt = &nextAttemptAt
+	subscription.LastError = "refused"
+	if err := repo.Events().UpdateSubscription(ctx, subscription); err != nil {
+		t.Fatalf("UpdateSubscription: %v", err)
+	}
+
+	got, err := repo.Events().Subscription(ctx, "hook")
+	if err != nil {
+		t.Fatalf("Subscription: %v", err)
+	}
+	if diff := cmp.Diff(subscription, got); diff != "" {
+		t.Errorf("subscription mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestEventRepository_Prune(t *testing.T) {
+	repo := newTestRepo(t, nil)
+	ctx := context.Background()
+	grantElevation(t, repo.Elevations(), "alice", time.Now().Add(time.Hour))
+
+	pruned, err := repo.Events().Prune(ctx, time.Now().Add(-time.Hour), 1)
+	if err != nil || pruned != 0 {
+		t.Errorf("Prune before event = %d, %v, want 0", pruned, err)
+	}
+	pruned, err = repo.Events().Prune(ctx, time.Now().Add(time.Second), 0)
+	if err != nil || pruned != 0 {
+		t.Errorf("Prune after undelivered event = %d, %v, want 0", pruned, err)
+	}
+	pruned, err = repo.Events().Prune(ctx, time.Now().Add(time.Second), 1)
+	if err != nil || pruned != 1 {
+		t.Errorf("Prune after event = %d, %v, want 1", pruned, err)
+	}
+}
+
+func TestEventRepository_Pruned(t *testing.T) {
+	repo := newTestRepo(t, nil)
+	ctx := context.Background()
+	outbox := repo.Events()
+
+	if pruned, err := outbox.Pruned(ctx); err != nil || pruned != 0 {
+		t.Errorf("Pruned with no events = %d, %v, want 0", pruned, err)
+	}
+	for _, principal := range []string{"alice", "bob", "carol"} {
+		grantElevation(t, repo.Elevations(), principal, time.Now().Add(time.Hour))
+	}
+	if pruned, err := outbox.Pruned(ctx); err != nil || pruned != 0 {
+		t.Errorf("Pruned before pruning = %d, %v, want 0", pruned, err)
+	}
+	if _, err := outbox.Prune(ctx, time.Now().Add(time.Second), 2); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if pruned, err := outbox.Pruned(ctx); err != nil || pruned != 2 {
+		t.Errorf("Pruned = %d, %v, want 2", pruned, err)
+	}
+	if _, err := outbox.Prune(ctx, time.Now().Add(time.Second), 3); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if pruned, err := outbox.Pruned(ctx); err != nil || pruned != 3 {
+		t.Errorf("Pruned once every event is pruned = %d, %v, want 3", pruned, err)
+	}
+}
@@ -0,0 +1,237 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/events"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+)
+
+// Checks upon the health of the sqlite store
+type HealthRepository struct {
+	db      *sql.DB
+	secrets secrets.Secrets
+}
+
+func (s *SecretRespository) Health() *HealthRepository {
+	return &HealthRepository{db: s.db, secrets: s.secrets}
+}
+
+func (h *HealthRepository) Check(ctx context.Context) error {
+	var version int
+	err := h.db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version)
+	if err != nil {
+		return err
+	}
+	if version != len(migrations) {
+		return fmt.Errorf("database schema version %d is not the current version %d", version, len(migrations))
+	}
+	return nil
+}
+
+func (h *HealthRepository) RunningSince(ctx context.Context, startedBefore time.Time) (int, error) {
+	var count int
+	err := h.db.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM operation
+		WHERE completedAt IS NULL AND failedAt IS NULL AND startedAt < ?
+	`, startedBefore).Scan(&count)
+	return count, err
+}
+
+// Unfinished operations are left by a server which was killed, or which failed to record their outcome
+func (h *HealthRepository) InterruptUnfinished(ctx context.Context) (int, error) {
+	tx, commit, rollback, err := beginTx(h.db)
+	if err != nil {
+		return 0, err
+	}
+	defer rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT
+			o.secretId,
+			o.instanceId,`+statusColumns+`
+		FROM operation o
+		WHERE o.completedAt IS NULL AND o.failedAt IS NULL
+	`)
+	if err != nil {
+		return 0, err
+	}
+	operations := []secrets.Operation{}
+	for rows.Next() {
+		var operation secrets.Operation
+		err = rows.Scan(statusFields(&operation.Status, &operation.SecretId, &operation.InstanceId)...)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		operations = append(operations, operation)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, `
+		UPDATE operation SET failedAt = ?, interruptedAt = ?
+		WHERE completedAt IS NULL AND failedAt IS NULL
+	`, now, now)
+	if err != nil {
+		return 0, err
+	}
+	for _, operation := range operations {
+		operation.Status.FailedAt = &now
+		operation.Status.InterruptedAt = &now
+		err = recordEvent(ctx, tx, operationEvent(events.OperationFailed, operation), operation)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(operations), commit()
+}
+
+func (h *HealthRepository) ActiveSecrets(ctx context.Context) (int, error) {
+	var count int
+	err := h.db.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM secret
+		WHERE activeInstanceId IS NOT NULL
+	`).Scan(&count)
+	return count, err
+}
+
+// The columns of an operation which is summarised, as selected from the operation table by the given alias
+func summaryColumns(alias string) string {
+	return fmt.Sprintf(`
+			%[1]s.id,
+			%[1]s.instanceId,
+			%[1]s.name,
+			%[1]s.forced,
+			%[1]s.reason,
+			%[1]s.startedBy,
+			%[1]s.startedAt,
+			%[1]s.completedAt,
+			%[1]s.failedAt,
+			%[1]s.interruptedAt`, alias)
+}
+
+// The scan destinations for the columns of an operation which is summarised, each of which is null if there is no
+// such operation
+type summaryFields struct {
+	operationNumber *int
+	instanceId      *string
+	name            *secrets.OperationName
+	forced          *bool
+	reason          *string
+	startedBy       *string
+	startedAt       *time.Time
+	completedAt     *time.Time
+	failedAt        *time.Time
+	interruptedAt   *time.Time
+}
+
+func (f *summaryFields) fields() []any {
+	return []any{&f.operationNumber, &f.instanceId, &f.name, &f.forced, &f.reason, &f.startedBy, &f.startedAt, &f.completedAt, &f.failedAt, &f.interruptedAt}
+}
+
+// operation gives the scanned operation upon the given secret, or nil if there is none
+func (f *summaryFields) operation(secretId string) *secrets.Operation {
+	if f.operationNumber == nil {
+		return nil
+	}
+	return &secrets.Operation{
+		SecretId:   secretId,
+		InstanceId: *f.instanceId,
+		Status: secrets.Status{
+			OperationNumber: *f.operationNumber,
+			Name:            *f.name,
+			Forced:          *f.forced,
+			Reason:          *f.reason,
+			StartedBy:       *f.startedBy,
+			StartedAt:       *f.startedAt,
+			CompletedAt:     f.completedAt,
+			FailedAt:        f.failedAt,
+			InterruptedAt:   f.interruptedAt,
+		},
+	}
+}
+
+// Summaries are read in a single query, which covers configured secrets upon which no operation has been performed
+func (h *HealthRepository) Summaries(ctx context.Context, stuckBefore time.Time) ([]*secrets.Summary, error) {
+	secretIds := make([]string, 0, len(h.secrets))
+	for secretId := range h.secrets {
+		secretIds = append(secretIds, secretId)
+	}
+	slices.Sort(secretIds)
+	configured, err := json.Marshal(secretIds)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := h.db.QueryContext(ctx, `
+		WITH
+			configured AS (
+				SELECT value AS secretId
+				FROM json_each(?)
+			),
+			live AS (
+				SELECT o.secretId, COUNT(*) AS count
+				FROM (SELECT MAX(id) AS id FROM operation GROUP BY instanceId) latest
+				JOIN operation o ON o.id = latest.id
+				WHERE o.name != 'destroy' OR o.completedAt IS NULL
+				GROUP BY o.secretId
+			)
+		SELECT
+			c.secretId,
+			s.activeInstanceId,
+			created.startedAt,
+			COALESCE(live.count, 0),`+summaryColumns("latest")+`,`+summaryColumns("running")+`,`+summaryColumns("tested")+`
+		FROM configured c
+		LEFT JOIN secret s ON s.id = c.secretId
+		LEFT JOIN live ON live.secretId = c.secretId
+		LEFT JOIN operation created ON created.id = (
+			SELECT MIN(id) FROM operation WHERE instanceId = s.activeInstanceId
+		)
+		LEFT JOIN operation latest ON latest.id = (
+			SELECT MAX(id) FROM operation WHERE secretId = c.secretId
+		)
+		LEFT JOIN operation running ON running.id = (
+			SELECT MIN(id) FROM operation WHERE secretId = c.secretId AND completedAt IS NULL AND failedAt IS NULL
+		)
+		LEFT JOIN operation tested ON tested.id = (
+			SELECT MAX(id) FROM operation WHERE instanceId = s.activeInstanceId AND name = 'test'
+		)
+		ORDER BY c.secretId
+	`, string(configured))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	summaries := []*secrets.Summary{}
+	for rows.Next() {
+		summary := &secrets.Summary{}
+		var latest, running, tested summaryFields
+		fields := []any{&summary.SecretId, &summary.ActiveInstanceId, &summary.ActiveCreatedAt, &summary.LiveInstances}
+		fields = append(fields, latest.fields()...)
+		fields = append(fields, running.fields()...)
+		fields = append(fields, tested.fields()...)
+		err = rows.Scan(fields...)
+		if err != nil {
+			return nil, err
+		}
+		summary.LatestOperation = latest.operation(summary.SecretId)
+		summary.RunningOperation = running.operation(summary.SecretId)
+		summary.LastTest = tested.operation(summary.SecretId)
+		summary.Judge(now, stuckBefore, time.Duration(h.secrets[summary.SecretId].RotateAfter))
+		summaries = append(summaries, summary)
+	}
+	return summaries, rows.Err()
+}
@@ -0,0 +1,280 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/command"
+	"github.com/eliasvasylenko/secret-agent/internal/events"
+	"github.com/eliasvasylenko/secret-agent/internal/marshal"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHealthRepository_Check(t *testing.T) {
+	repo := newTestRepo(t, nil)
+	ctx := context.Background()
+	health := repo.Health()
+
+	if err := health.Check(ctx); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if _, err := repo.db.ExecContext(ctx, `PRAGMA user_version = 1`); err != nil {
+		t.Fatal(err)
+	}
+	if err := health.Check(ctx); err == nil {
+		t.Error("Check = nil, want error for outdated schema")
+	}
+}
+
+func TestHealthRepository_ActiveSecrets(t *testing.T) {
+	repo := newTestRepo(t, secrets.Secrets{"s1": noOpSecret, "s2": &secrets.Secret{Name: "s2"}})
+	ctx := context.Background()
+	health := repo.Health()
+
+	instances := repo.Instances("s1")
+	created, err := instances.Create(ctx, secrets.OperationParameters{Reason: "r", StartedBy: "u"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if count, err := health.ActiveSecrets(ctx); err != nil || count != 0 {
+		t.Errorf("ActiveSecrets = %d, %v, want 0", count, err)
+	}
+	if _, err := instances.Activate(ctx, created.Id, secrets.OperationParameters{Reason: "r", StartedBy: "u"}); err != nil {
+		t.Fatalf("Activate: %v", err)
+	}
+	if count, err := health.ActiveSecrets(ctx); err != nil || count != 1 {
+		t.Errorf("ActiveSecrets = %d, %v, want 1", count, err)
+	}
+}
+
+func TestHealthRepository_InterruptUnfinished(t *testing.T) {
+	repo := newTestRepo(t, secrets.Secrets{"s1": noOpSecret})
+	ctx := context.Background()
+	health := repo.Health()
+
+	created, err := repo.Instances("s1").Create(ctx, secrets.OperationParameters{Reason: "r", StartedBy: "u"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	// as if the server were killed before the operation finished
+	if _, err := repo.db.ExecContext(ctx, `UPDATE operation SET completedAt = NULL`); err != nil {
+		t.Fatal(err)
+	}
+	latest, err := repo.Events().Latest(ctx)
+	if err != nil {
+		t.Fatalf("Latest: %v", err)
+	}
+
+	if count, err := health.InterruptUnfinished(ctx); err != nil || count != 1 {
+		t.Fatalf("InterruptUnfinished = %d, %v, want 1", count, err)
+	}
+	if count, err := health.RunningSince(ctx, time.Now().Add(time.Hour)); err != nil || count != 0 {
+		t.Errorf("RunningSince after InterruptUnfinished = %d, %v, want 0", count, err)
+	}
+	history, err := repo.Instances("s1").History(ctx, created.Id, 0, 10)
+	if err != nil || len(history) != 1 || history[0].Status.FailedAt == nil || history[0].Status.InterruptedAt == nil {
+		t.Errorf("History = %+v, %v, want the operation interrupted", history, err)
+	}
+	recorded, err := repo.Events().After(ctx, latest, 10)
+	if err != nil || len(recorded) != 1 || recorded[0].Type != events.OperationFailed || recorded[0].InstanceId != created.Id {
+		t.Errorf("events = %+v, %v, want the operation failed", recorded, err)
+	}
+
+	if count, err := health.InterruptUnfinished(ctx); err != nil || count != 0 {
+		t.Errorf("InterruptUnfinished again = %d, %v, want 0", count, err)
+	}
+}
+
+func TestHealthRepository_RunningSince(t *testing.T) {
+	slow := &secrets.Secret{Name: "slow", Create: &command.Command{Script: "sleep 0.5"}}
+	repo := newTestRepo(t, secrets.Secrets{"slow": slow})
+	ctx := context.Background()
+	health := repo.Health()
+
+	done := make(chan error)
+	go func() {
+		_, err := repo.Instances("slow").Create(ctx, secrets.OperationParameters{Reason: "r", StartedBy: "u"})
+		done <- err
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		count, err := health.RunningSince(ctx, time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatalf("RunningSince: %v", err)
+		}
+		if count == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("operation was never running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if count, err := health.RunningSince(ctx, time.Now().Add(-time.Hour)); err != nil || count != 0 {
+		t.Errorf("RunningSince an hour ago = %d, %v, want 0", count, err)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if count, err := health.RunningSince(ctx, time.Now().Add(time.Hour)); err != nil || count != 0 {
+		t.Errorf("RunningSince after completion = %d, %v, want 0", count, err)
+	}
+}
+
+func TestHealthRepository_Summaries(t *testing.T) {
+	repo := newTestRepo(t, secrets.Secrets{
+		"failing":  {Name: "failing", Test: &command.Command{Script: "false"}},
+		"healthy":  {Name: "healthy", RotateAfter: marshal.Duration(time.Hour)},
+		"inactive": {Name: "inactive"},
+		"overdue":  {Name: "overdue", RotateAfter: marshal.Duration(time.Nanosecond)},
+	})
+	ctx := context.Background()
+	parameters := secrets.OperationParameters{Reason: "r", StartedBy: "u"}
+	activate := func(secretId string) string {
+		t.Helper()
+		instances := repo.Instances(secretId)
+		created, err := instances.Create(ctx, parameters)
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if _, err := instances.Activate(ctx, created.Id, parameters); err != nil {
+			t.Fatalf("Activate: %v", err)
+		}
+		return created.Id
+	}
+
+	healthy := activate("healthy")
+	if _, err := repo.Instances("healthy").Test(ctx, healthy, parameters); err != nil {
+		t.Fatalf("Test: %v", err)
+	}
+	failing := activate("failing")
+	if _, err := repo.Instances("failing").Test(ctx, failing, parameters); err == nil {
+		t.Fatal("Test = nil, want error")
+	}
+	destroyed, err := repo.Instances("overdue").Create(ctx, parameters)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := repo.Instances("overdue").Destroy(ctx, destroyed.Id, parameters); err != nil {
+		t.Fatalf("Destroy: %v", err)
+	}
+	overdue := activate("overdue")
+
+	summaries, err := repo.Health().Summaries(ctx, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("Summaries: %v", err)
+	}
+	type summary struct {
+		secretId         string
+		activeInstanceId string
+		latest           secrets.OperationName
+		lastTestFailed   *bool
+		liveInstances    int
+		verdict          secrets.Verdict
+	}
+	failed, passed := true, false
+	want := []summary{
+		{"failing", failing, secrets.Test, &failed, 1, secrets.Failing},
+		{"healthy", healthy, secrets.Test, &passed, 1, secrets.Healthy},
+		{"inactive", "", "", nil, 0, secrets.Inactive},
+		{"overdue", overdue, secrets.Activate, nil, 1, secrets.Overdue},
+	}
+	var got []summary
+	for _, s := range summaries {
+		g := summary{secretId: s.SecretId, liveInstances: s.LiveInstances, verdict: s.Verdict}
+		if s.ActiveInstanceId != nil {
+			g.activeInstanceId = *s.ActiveInstanceId
+			if s.ActiveCreatedAt == nil {
+				t.Errorf("%s has no creation time for its active instance", s.SecretId)
+			}
+		}
+		if s.LatestOperation != nil {
+			g.latest = s.LatestOperation.Name
+		}
+		if s.LastTest != nil {
+			testFailed := s.LastTest.FailedAt != nil
+			g.lastTestFailed = &testFailed
+		}
+		if s.RunningOperation != nil {
+			t.Errorf("%s has a running operation %+v", s.SecretId, s.RunningOperation)
+		}
+		got = append(got, g)
+	}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(summary{})); diff != "" {
+		t.Errorf("Summaries (-want +got):\n%s", diff)
+	}
+	if due := summaries[1].RotationDueAt; due == nil || !due.Equal(summaries[1].ActiveCreatedAt.Add(time.Hour)) {
+		t.Errorf("healthy is due for rotation at %v, want an hour after %v", due, summaries[1].ActiveCreatedAt)
+	}
+}
+
+func TestHealthRepository_Summaries_interrupted(t *testing.T) {
+	repo := newTestRepo(t, secrets.Secrets{"s1": noOpSecret})
+	ctx := context.Background()
+	health := repo.Health()
+
+	if _, err := repo.Instances("s1").Create(ctx, secrets.OperationParameters{Reason: "r", StartedBy: "u"}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	// as if the server were killed before the operation finished
+	if _, err := repo.db.ExecContext(ctx, `UPDATE operation SET completedAt = NULL`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := health.InterruptUnfinished(ctx); err != nil {
+		t.Fatalf("InterruptUnfinished: %v", err)
+	}
+
+	summaries, err := health.Summaries(ctx, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Summaries: %v", err)
+	}
+	if summaries[0].RunningOperation != nil || summaries[0].Verdict == secrets.Stuck {
+		t.Errorf("Summaries = %+v, want the interrupted operation no longer running", summaries[0])
+	}
+	if latest := summaries[0].LatestOperation; latest == nil || latest.Status.InterruptedAt == nil {
+		t.Errorf("latest operation = %+v, want it interrupted", latest)
+	}
+}
+
+func TestHealthRepository_Summaries_stuck(t *testing.T) {
+	slow := &secrets.Secret{Name: "slow", Create: &command.Command{Script: "sleep 0.5"}}
+	repo := newTestRepo(t, secrets.Secrets{"slow": slow})
+	ctx := context.Background()
+	health := repo.Health()
+
+	done := make(chan error)
+	go func() {
+		_, err := repo.Instances("slow").Create(ctx, secrets.OperationParameters{Reason: "r", StartedBy: "u"})
+		done <- err
+	}()
+	defer func() {
+		if err := <-done; err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		summaries, err := health.Summaries(ctx, time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatalf("Summaries: %v", err)
+		}
+		if running := summaries[0].RunningOperation; running != nil {
+			if running.Name != secrets.Create || !summaries[0].Stuck || summaries[0].Verdict != secrets.Stuck {
+				t.Errorf("Summaries = %+v, want a stuck create", summaries[0])
+			}
+			if summaries[0].LiveInstances != 1 {
+				t.Errorf("LiveInstances = %d, want 1", summaries[0].LiveInstances)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("operation was never running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
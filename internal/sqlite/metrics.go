@@ -0,0 +1,77 @@
+package sqlite
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/metrics"
+)
+
+var (
+	operationsTotal = metrics.Default.NewCounterVec(
+		"secret_agent_operations_total",
+		"Operations performed, by secret, operation name and outcome.",
+		"secret", "operation", "outcome",
+	)
+	operationDuration = metrics.Default.NewHistogramVec(
+		"secret_agent_operation_duration_seconds",
+		"Duration of operations, by secret and operation name.",
+		metrics.DurationBuckets,
+		"secret", "operation",
+	)
+	operationsInFlight = metrics.Default.NewGaugeVec(
+		"secret_agent_operations_in_flight",
+		"Operations currently running, by secret.",
+		"secret",
+	)
+)
+
+// RegisterMetrics adds metrics collected from the database to the registry
+func (s *SecretRespository) RegisterMetrics(registry *metrics.Registry) {
+	registry.NewGaugeFunc(
+		"secret_agent_active_instance_age_seconds",
+		"Time since the active instance of each secret was created.",
+		[]string{"secret"},
+		func() ([]metrics.Sample, error) {
+			ages, err := s.activeInstanceAges(context.Background())
+			if err != nil {
+				return nil, err
+			}
+			samples := make([]metrics.Sample, 0, len(ages))
+			for _, secretId := range slices.Sorted(maps.Keys(ages)) {
+				samples = append(samples, metrics.Sample{LabelValues: []string{secretId}, Value: ages[secretId].Seconds()})
+			}
+			return samples, nil
+		},
+	)
+}
+
+// The time since the active instance of each secret with one was created
+func (s *SecretRespository) activeInstanceAges(ctx context.Context) (map[string]time.Duration, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT
+			s.id,
+			o.startedAt
+		FROM secret s
+		INNER JOIN operation o
+			ON o.instanceId = s.activeInstanceId AND o.name = 'create'
+		ORDER BY s.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ages := map[string]time.Duration{}
+	now := time.Now()
+	for rows.Next() {
+		var secretId string
+		var createdAt time.Time
+		if err := rows.Scan(&secretId, &createdAt); err != nil {
+			return nil, err
+		}
+		ages[secretId] = now.Sub(createdAt)
+	}
+	return ages, rows.Err()
+}
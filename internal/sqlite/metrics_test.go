@@ -0,0 +1,45 @@
+package sqlite
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/eliasvasylenko/secret-agent/internal/metrics"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+)
+
+func TestSecretRepository_RegisterMetrics(t *testing.T) {
+	repo := newTestRepo(t, secrets.Secrets{"s1": noOpSecret, "s2": {Name: "s2"}})
+	ctx := context.Background()
+	instances := repo.Instances("s1")
+	created, err := instances.Create(ctx, secrets.OperationParameters{Reason: "create", StartedBy: "user"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := instances.Activate(ctx, created.Id, secrets.OperationParameters{Reason: "activate", StartedBy: "user"}); err != nil {
+		t.Fatalf("Activate: %v", err)
+	}
+	if _, err := repo.Instances("s2").Create(ctx, secrets.OperationParameters{Reason: "create", StartedBy: "user"}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	registry := metrics.NewRegistry()
+	repo.RegisterMetrics(registry)
+	var out strings.Builder
+	if err := registry.WriteText(&out); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	if !strings.Contains(out.String(), `secret_agent_active_instance_age_seconds{secret="s1"}`) {
+		t.Errorf("metrics = %s, want age of active instance of s1", out.String())
+	}
+	if strings.Contains(out.String(), `secret="s2"`) {
+		t.Errorf("metrics = %s, want no age for s2 without an active instance", out.String())
+	}
+
+	out.Reset()
+	metrics.Default.WriteText(&out)
+	if !strings.Contains(out.String(), `secret_agent_operations_total{secret="s1",operation="activate",outcome="completed"}`) {
+		t.Errorf("metrics = %s, want completed activation of s1 counted", out.String())
+	}
+}
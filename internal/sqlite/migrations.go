@@ -0,0 +1,202 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Schema migrations, applied in order. The number of migrations which have
+// been applied to a database is tracked by its user_version.
+var migrations = []string{
+	// The initial schema, which may already exist in databases predating migrations
+	`
+		CREATE TABLE IF NOT EXISTS instance (
+			id TEXT NOT NULL PRIMARY KEY,
+			secretId TEXT NOT NULL,
+			secret JSONB NOT NULL,
+			FOREIGN KEY(secretId) REFERENCES secret(id)
+		);
+		CREATE TABLE IF NOT EXISTS secret (
+			id TEXT NOT NULL PRIMARY KEY,
+			activeInstanceId TEXT,
+			FOREIGN KEY(activeInstanceId) REFERENCES instance(id)
+		);
+		CREATE TABLE IF NOT EXISTS operation (
+			id INTEGER NOT NULL PRIMARY KEY,
+			secretId TEXT NOT NULL,
+			instanceId TEXT NOT NULL,
+			name VARCHAR(32) NOT NULL,
+			forced INTEGER NOT NULL,
+			reason TEXT NOT NULL,
+			startedBy TEXT NOT NULL,
+			startedAt DATETIME NOT NULL,
+			completedAt DATETIME,
+			failedAt DATETIME,
+			FOREIGN KEY(secretId) REFERENCES secret(id)
+			FOREIGN KEY(instanceId) REFERENCES instance(id)
+		);
+		CREATE INDEX IF NOT EXISTS instance_operation ON operation (instanceId, id DESC);
+		CREATE INDEX IF NOT EXISTS secret_operation ON operation (secretId, id DESC);
+	`,
+	// Approval requests for operations
+	`
+		CREATE TABLE approval (
+			id TEXT NOT NULL PRIMARY KEY,
+			secretId TEXT NOT NULL,
+			instanceId TEXT NOT NULL,
+			name VARCHAR(32) NOT NULL,
+			forced INTEGER NOT NULL,
+			reason TEXT NOT NULL,
+			env JSONB NOT NULL,
+			requestedBy TEXT NOT NULL,
+			requestedAt DATETIME NOT NULL,
+			expiresAt DATETIME NOT NULL,
+			required INTEGER NOT NULL,
+			status VARCHAR(16) NOT NULL,
+			operationId INTEGER,
+			FOREIGN KEY(operationId) REFERENCES operation(id)
+		);
+		CREATE TABLE approval_decision (
+			approvalId TEXT NOT NULL,
+			principal TEXT NOT NULL,
+			approved INTEGER NOT NULL,
+			comment TEXT NOT NULL,
+			decidedAt DATETIME NOT NULL,
+			PRIMARY KEY(approvalId, principal),
+			FOREIGN KEY(approvalId) REFERENCES approval(id)
+		);
+		ALTER TABLE operation ADD COLUMN approvalId TEXT REFERENCES approval(id);
+	`,
+	// Denied attempts to call the API or perform operations
+	`
+		CREATE TABLE denial (
+			id INTEGER NOT NULL PRIMARY KEY,
+			principal TEXT NOT NULL,
+			route TEXT NOT NULL,
+			secretId TEXT NOT NULL,
+			instanceId TEXT NOT NULL,
+			operation VARCHAR(32) NOT NULL,
+			reason TEXT NOT NULL,
+			deniedAt DATETIME NOT NULL
+		);
+	`,
+	// Details of the caller and the permission required for denied attempts
+	`
+		ALTER TABLE denial ADD COLUMN uid INTEGER;
+		ALTER TABLE denial ADD COLUMN gid INTEGER;
+		ALTER TABLE denial ADD COLUMN pid INTEGER;
+		ALTER TABLE denial ADD COLUMN permission JSONB NOT NULL DEFAULT '{}';
+		ALTER TABLE denial ADD COLUMN roles JSONB NOT NULL DEFAULT '[]';
+		CREATE INDEX denial_time ON denial (deniedAt);
+	`,
+	// Break-glass elevations of principals to roles
+	`
+		CREATE TABLE elevation (
+			id TEXT NOT NULL PRIMARY KEY,
+			principal TEXT NOT NULL,
+			role TEXT NOT NULL,
+			reason TEXT NOT NULL,
+			startedAt DATETIME NOT NULL,
+			expiresAt DATETIME NOT NULL,
+			revokedAt DATETIME,
+			revokedBy TEXT NOT NULL DEFAULT ''
+		);
+		CREATE INDEX principal_elevation ON elevation (principal, expiresAt);
+		ALTER TABLE operation ADD COLUMN elevationId TEXT REFERENCES elevation(id);
+	`,
+	// Operations interrupted by the shutdown of the server
+	`
+		ALTER TABLE operation ADD COLUMN interruptedAt DATETIME;
+	`,
+	// Listeners through which denied attempts were made
+	`
+		ALTER TABLE denial ADD COLUMN listener TEXT NOT NULL DEFAULT '';
+	`,
+	// An outbox of events, and the progress of their delivery to subscribers
+	`
+		CREATE TABLE event (
+			id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			type VARCHAR(64) NOT NULL,
+			secretId TEXT NOT NULL DEFAULT '',
+			instanceId TEXT NOT NULL DEFAULT '',
+			principal TEXT NOT NULL DEFAULT '',
+			data JSONB,
+			occurredAt DATETIME NOT NULL
+		);
+		CREATE INDEX event_time ON event (occurredAt);
+		CREATE TABLE subscription (
+			subscriber TEXT NOT NULL PRIMARY KEY,
+			deliveredEventId INTEGER NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			nextAttemptAt DATETIME,
+			lastError TEXT NOT NULL DEFAULT ''
+		);
+	`,
+	// Keys identifying the requests which started operations or requested approval, so that they may be retried
+	`
+		ALTER TABLE operation ADD COLUMN idempotencyKey TEXT;
+		CREATE UNIQUE INDEX operation_idempotency ON operation (startedBy, idempotencyKey) WHERE idempotencyKey IS NOT NULL;
+		ALTER TABLE approval ADD COLUMN idempotencyKey TEXT;
+		CREATE UNIQUE INDEX approval_idempotency ON approval (requestedBy, idempotencyKey) WHERE idempotencyKey IS NOT NULL;
+	`,
+	// Batches of operations performed across many secrets at once
+	`
+		ALTER TABLE operation ADD COLUMN batchId TEXT;
+		CREATE INDEX operation_batch ON operation (batchId) WHERE batchId IS NOT NULL;
+	`,
+	// The API requests which started operations, to correlate them with logs
+	`
+		ALTER TABLE operation ADD COLUMN requestId TEXT;
+	`,
+	// The errors with which operations failed, so that retried requests fail alike
+	`
+		ALTER TABLE operation ADD COLUMN error TEXT;
+	`,
+	// The latest operations expected by requests for approval, which are checked again once approved
+	`
+		ALTER TABLE approval ADD COLUMN ifMatch INTEGER;
+	`,
+	// The elevations of the requesters of approval, under which approved operations are performed
+	`
+		ALTER TABLE approval ADD COLUMN elevationId TEXT;
+	`,
+}
+
+// Apply any migrations which have not yet been applied to the database
+func migrate(ctx context.Context, db *sql.DB) error {
+	var version int
+	err := db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version)
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than supported version %d", version, len(migrations))
+	}
+
+	for ; version < len(migrations); version++ {
+		err = applyMigration(ctx, db, version)
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %d: %w", version+1, err)
+		}
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, db *sql.DB, version int) error {
+	tx, commit, rollback, err := beginTx(db)
+	if err != nil {
+		return err
+	}
+	defer rollback()
+
+	_, err = tx.ExecContext(ctx, migrations[version])
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d`, version+1))
+	if err != nil {
+		return err
+	}
+	return commit()
+}
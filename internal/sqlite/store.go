@@ -3,12 +3,14 @@ package sqlite
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
 	"database/sql"
 
+	"github.com/eliasvasylenko/secret-agent/internal/events"
 	"github.com/eliasvasylenko/secret-agent/internal/marshal"
 	"github.com/eliasvasylenko/secret-agent/internal/secrets"
 	"github.com/google/uuid"
@@ -31,35 +33,10 @@ type InstanceRepository struct {
 
 func NewSecretRepository(ctx context.Context, dbFile string, secrets secrets.Secrets, debug bool, maxReasonLen int) (*SecretRespository, error) {
 	db, err := sql.Open("sqlite3", dbFile)
-	_, err = db.ExecContext(ctx, `
-		CREATE TABLE IF NOT EXISTS instance (
-			id TEXT NOT NULL PRIMARY KEY,
-			secretId TEXT NOT NULL,
-			secret JSONB NOT NULL,
-			FOREIGN KEY(secretId) REFERENCES secret(id)
-		);
-		CREATE TABLE IF NOT EXISTS secret (
-			id TEXT NOT NULL PRIMARY KEY,
-			activeInstanceId TEXT,
-			FOREIGN KEY(activeInstanceId) REFERENCES instance(id)
-		);
-		CREATE TABLE IF NOT EXISTS operation (
-			id INTEGER NOT NULL PRIMARY KEY,
-			secretId TEXT NOT NULL,
-			instanceId TEXT NOT NULL,
-			name VARCHAR(32) NOT NULL,
-			forced INTEGER NOT NULL,
-			reason TEXT NOT NULL,
-			startedBy TEXT NOT NULL,
-			startedAt DATETIME NOT NULL,
-			completedAt DATETIME,
-			failedAt DATETIME,
-			FOREIGN KEY(secretId) REFERENCES secret(id)
-			FOREIGN KEY(instanceId) REFERENCES instance(id)
-		);
-		CREATE INDEX IF NOT EXISTS instance_operation ON operation (instanceId, id DESC);
-		CREATE INDEX IF NOT EXISTS secret_operation ON operation (secretId, id DESC);
-	`)
+	if err != nil {
+		return nil, err
+	}
+	err = migrate(ctx, db)
 	return &SecretRespository{db: db, secrets: secrets, maxReasonLen: maxReasonLen}, err
 }
 
@@ -67,6 +44,33 @@ func (s *SecretRespository) Close() {
 	s.db.Close()
 }
 
+// The columns of an operation which make up the status of an instance
+const statusColumns = `
+			o.id,
+			o.name,
+			o.forced,
+			o.reason,
+			o.startedBy,
+			o.startedAt,
+			o.completedAt,
+			o.failedAt,
+			o.interruptedAt,
+			o.approvalId,
+			o.elevationId,
+			o.idempotencyKey,
+			o.batchId,
+			o.requestId`
+
+// Joins the create operation of the instance i as c, from which the time at which it was created is read
+const createdJoin = `
+		INNER JOIN operation c
+			ON c.id = (SELECT MIN(id) FROM operation WHERE instanceId = i.id)`
+
+// The scan destinations for the given fields followed by the status columns
+func statusFields(status *secrets.Status, fields ...any) []any {
+	return append(fields, &status.OperationNumber, &status.Name, &status.Forced, &status.Reason, &status.StartedBy, &status.StartedAt, &status.CompletedAt, &status.FailedAt, &status.InterruptedAt, &status.ApprovalId, &status.ElevationId, &status.IdempotencyKey, &status.BatchId, &status.RequestId)
+}
+
 func beginTx(db *sql.DB) (*sql.Tx, func() error, func(), error) {
 	tx, err := db.Begin()
 	committed := false
@@ -81,7 +85,7 @@ func beginTx(db *sql.DB) (*sql.Tx, func() error, func(), error) {
 		committed = true
 		err := tx.Rollback()
 		if err != nil {
-			log.Default().Printf("rollback error %s", err.Error())
+			slog.Error("failed to roll back transaction", "error", err)
 		}
 	}
 	return tx, commit, rollback, err
@@ -102,18 +106,11 @@ func (s *SecretRespository) Get(ctx context.Context, secretId string) (*secrets.
 func (s *SecretRespository) History(ctx context.Context, secretId string, startAt int, endAt int) ([]*secrets.Operation, error) {
 	rows, err := s.db.QueryContext(ctx, `
 		SELECT
-			id,
-			secretId,
-			instanceId,
-			name,
-			forced,
-			reason,
-			startedBy,
-			startedAt,
-			completedAt,
-			failedAt
-		FROM operation
-		WHERE secretId = ?
+			o.secretId,
+			o.instanceId,`+statusColumns+`
+		FROM operation o
+		WHERE o.secretId = ?
+		ORDER BY o.id
 		LIMIT ? OFFSET ?
 	`, secretId, endAt-startAt, startAt)
 	if err != nil {
@@ -124,11 +121,85 @@ func (s *SecretRespository) History(ctx context.Context, secretId string, startA
 	for err == nil && rows.Next() {
 		operation := &secrets.Operation{}
 		operations = append(operations, operation)
-		err = rows.Scan(&operation.OperationNumber, &operation.SecretId, &operation.InstanceId, &operation.Name, &operation.Forced, &operation.Reason, &operation.StartedBy, &operation.StartedAt, &operation.CompletedAt, &operation.FailedAt)
+		err = rows.Scan(statusFields(&operation.Status, &operation.SecretId, &operation.InstanceId)...)
 	}
 	return operations, err
 }
 
+func (s *SecretRespository) LatestOperation(ctx context.Context, secretId string) (int, error) {
+	return latestOperation(ctx, s.db, secretId)
+}
+
+func latestOperation(ctx context.Context, q querier, secretId string) (int, error) {
+	var latest int
+	err := q.QueryRowContext(ctx, `
+		SELECT COALESCE(MAX(id), 0)
+		FROM operation
+		WHERE secretId = ?
+	`, secretId).Scan(&latest)
+	return latest, err
+}
+
+// checkLatestOperation asserts that the latest operation on the secret is the one expected by the caller, if any
+func checkLatestOperation(ctx context.Context, q querier, secretId string, expected *int) error {
+	if expected == nil {
+		return nil
+	}
+	latest, err := latestOperation(ctx, q, secretId)
+	if err != nil {
+		return err
+	}
+	if latest != *expected {
+		return &secrets.PreconditionFailedError{Expected: *expected, Latest: latest}
+	}
+	return nil
+}
+
+// replayOperation finds the operation started by an earlier request with the same idempotency key, if any,
+// giving the instance with the status left by that operation, and the error with which it failed
+func replayOperation(ctx context.Context, q querier, secretId string, instanceId string, operationName secrets.OperationName, parameters secrets.OperationParameters) (*secrets.Instance, error) {
+	if parameters.IdempotencyKey == "" {
+		return nil, nil
+	}
+	instance := &secrets.Instance{}
+	var previousSecretId string
+	var secretBytes []byte
+	var failure *string
+	err := q.QueryRowContext(ctx, `
+		SELECT
+			o.secretId,
+			o.instanceId,
+			i.secret,
+			c.startedAt,
+			o.error,`+statusColumns+`
+		FROM operation o
+		INNER JOIN instance i
+			ON i.id = o.instanceId`+createdJoin+`
+		WHERE o.startedBy = ? AND o.idempotencyKey = ?
+	`, parameters.StartedBy, parameters.IdempotencyKey).Scan(statusFields(&instance.Status, &previousSecretId, &instance.Id, &secretBytes, &instance.CreatedAt, &failure)...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// a new instance is created for each request to create one, so only an operation upon an existing instance names it
+	if previousSecretId != secretId || instance.Status.Name != operationName || (instanceId != "" && instanceId != instance.Id) {
+		return nil, secrets.ErrIdempotencyKeyReused
+	}
+	err = json.Unmarshal(secretBytes, &instance.Secret)
+	if err != nil {
+		return nil, err
+	}
+	if instance.Status.FailedAt != nil {
+		if failure == nil {
+			return instance, fmt.Errorf("operation %d failed", instance.Status.OperationNumber)
+		}
+		return instance, errors.New(*failure)
+	}
+	return instance, nil
+}
+
 func (s *SecretRespository) Instances(secretId string) *InstanceRepository {
 	secret := s.secrets[secretId]
 	return &InstanceRepository{
@@ -144,23 +215,18 @@ func (i *InstanceRepository) List(ctx context.Context, from int, to int) (secret
 		SELECT
 			i.id,
 			i.secret,
-			o.id,
-			o.name,
-			o.forced,
-			o.reason,
-			o.startedBy,
-			o.startedAt,
-			o.completedAt,
-			o.failedAt
+			c.startedAt,`+statusColumns+`
 		FROM instance i
 		INNER JOIN (
 			SELECT MAX(id), *
 			FROM operation
 			GROUP BY instanceId
 		) o
-		 	ON o.instanceId = i.id
+		 	ON o.instanceId = i.id`+createdJoin+`
 		WHERE i.secretId = ?
-	`, i.secretId)
+		ORDER BY i.rowid
+		LIMIT ? OFFSET ?
+	`, i.secretId, to-from, from)
 	if err != nil {
 		return nil, err
 	}
@@ -169,7 +235,7 @@ func (i *InstanceRepository) List(ctx context.Context, from int, to int) (secret
 	for err == nil && rows.Next() {
 		instance := &secrets.Instance{}
 		var secretBytes []byte
-		err = rows.Scan(&instance.Id, &secretBytes, &instance.Status.OperationNumber, &instance.Status.Name, &instance.Status.Forced, &instance.Status.Reason, &instance.Status.StartedBy, &instance.Status.StartedAt, &instance.Status.CompletedAt, &instance.Status.FailedAt)
+		err = rows.Scan(statusFields(&instance.Status, &instance.Id, &secretBytes, &instance.CreatedAt)...)
 		if err != nil {
 			break
 		}
@@ -187,23 +253,16 @@ func (i *InstanceRepository) Get(ctx context.Context, instanceId string) (*secre
 	err := i.db.QueryRowContext(ctx, `
 		SELECT
 			i.secret,
-			o.id,
-			o.name,
-			o.forced,
-			o.reason,
-			o.startedBy,
-			o.startedAt,
-			o.completedAt,
-			o.failedAt
+			c.startedAt,`+statusColumns+`
 		FROM instance i
 		INNER JOIN (
 			SELECT MAX(id), *
 			FROM operation
 			GROUP BY instanceId
 		) o
-		 	ON o.instanceId = i.id
+		 	ON o.instanceId = i.id`+createdJoin+`
 		WHERE i.id = ?
-	`, instanceId).Scan(&secretBytes, &instance.Status.OperationNumber, &instance.Status.Name, &instance.Status.Forced, &instance.Status.Reason, &instance.Status.StartedBy, &instance.Status.StartedAt, &instance.Status.CompletedAt, &instance.Status.FailedAt)
+	`, instanceId).Scan(statusFields(&instance.Status, &secretBytes, &instance.CreatedAt)...)
 	if err != nil {
 		return nil, err
 	}
@@ -216,14 +275,7 @@ func (i *InstanceRepository) GetActive(ctx context.Context) (*secrets.Instance,
 		SELECT
 			i.id,
 			i.secret,
-			o.id,
-			o.name,
-			o.forced,
-			o.reason,
-			o.startedBy,
-			o.startedAt,
-			o.completedAt,
-			o.failedAt
+			c.startedAt,`+statusColumns+`
 		FROM secret s
 		INNER JOIN instance i
 			ON i.id = s.activeInstanceId
@@ -232,7 +284,7 @@ func (i *InstanceRepository) GetActive(ctx context.Context) (*secrets.Instance,
 			FROM operation
 			GROUP BY instanceId
 		) o
-		 	ON o.instanceId = i.id
+		 	ON o.instanceId = i.id`+createdJoin+`
 		WHERE s.id = ?
 	`, i.secretId)
 	if err != nil {
@@ -245,7 +297,7 @@ func (i *InstanceRepository) GetActive(ctx context.Context) (*secrets.Instance,
 
 	var secretBytes []byte
 	var instance = &secrets.Instance{}
-	err = rows.Scan(&instance.Id, &secretBytes, &instance.Status.OperationNumber, &instance.Status.Name, &instance.Status.Forced, &instance.Status.Reason, &instance.Status.StartedBy, &instance.Status.StartedAt, &instance.Status.CompletedAt, &instance.Status.FailedAt)
+	err = rows.Scan(statusFields(&instance.Status, &instance.Id, &secretBytes, &instance.CreatedAt)...)
 	if err != nil {
 		return nil, err
 	}
@@ -267,6 +319,15 @@ func (i *InstanceRepository) Create(ctx context.Context, paramaters secrets.Oper
 	}
 	defer rollback()
 
+	replayed, err := replayOperation(ctx, tx, i.secretId, "", secrets.Create, paramaters)
+	if replayed != nil || err != nil {
+		return replayed, err
+	}
+	err = checkLatestOperation(ctx, tx, i.secretId, paramaters.IfMatch)
+	if err != nil {
+		return nil, err
+	}
+
 	_, err = tx.ExecContext(ctx, `
 			INSERT OR IGNORE INTO secret(id)
 				VALUES (?)
@@ -299,9 +360,10 @@ func (i *InstanceRepository) Create(ctx context.Context, paramaters secrets.Oper
 	}
 
 	instance := &secrets.Instance{
-		Id:     instanceId,
-		Status: operation.Status,
-		Secret: *i.secret,
+		Id:        instanceId,
+		Status:    operation.Status,
+		Secret:    *i.secret,
+		CreatedAt: operation.StartedAt,
 	}
 
 	err = completeOperation(ctx, i.db, i.secretId, instance, operation, paramaters)
@@ -335,12 +397,23 @@ func updateOperation(ctx context.Context, db *sql.DB, secretId string, instanceI
 	}
 	defer rollback()
 
+	replayed, err := replayOperation(ctx, tx, secretId, instanceId, operationName, paramaters)
+	if replayed != nil || err != nil {
+		return replayed, err
+	}
+	err = checkLatestOperation(ctx, tx, secretId, paramaters.IfMatch)
+	if err != nil {
+		return nil, err
+	}
+
 	var secretBytes []byte
+	var createdAt time.Time
 	var activeInstanceId *string
 	var previousOperation secrets.Operation
 	err = tx.QueryRowContext(ctx, `
 		SELECT
 			i.secret,
+			c.startedAt,
 			s.activeInstanceId,
 			o.id,
 			o.name,
@@ -355,9 +428,9 @@ func updateOperation(ctx context.Context, db *sql.DB, secretId string, instanceI
 			FROM operation
 			GROUP BY instanceId
 		) o
-		 	ON o.instanceId = i.id
+		 	ON o.instanceId = i.id`+createdJoin+`
 		WHERE i.id = ?
-	`, instanceId).Scan(&secretBytes, &activeInstanceId, &previousOperation.OperationNumber, &previousOperation.Name, &previousOperation.StartedAt, &previousOperation.CompletedAt, &previousOperation.FailedAt)
+	`, instanceId).Scan(&secretBytes, &createdAt, &activeInstanceId, &previousOperation.OperationNumber, &previousOperation.Name, &previousOperation.StartedAt, &previousOperation.CompletedAt, &previousOperation.FailedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -367,19 +440,10 @@ func updateOperation(ctx context.Context, db *sql.DB, secretId string, instanceI
 		return nil, err
 	}
 
-	var msg string
-
-	if previousOperation.CompletedAt == nil && operationName != previousOperation.Name {
-		msg = fmt.Sprintf("%s when previous %s has not succeeded", operationName, previousOperation.Name)
-	} else if operationName == secrets.Activate && activeInstanceId != nil {
-		msg = fmt.Sprintf("%s when instance %s is active", operationName, *activeInstanceId)
-	} else if (operationName == secrets.Test || operationName == secrets.Deactivate) && (activeInstanceId == nil || *activeInstanceId != instanceId) {
-		msg = fmt.Sprintf("%s when instance is not active", operationName)
-	}
-
+	msg := secrets.CheckOperation(operationName, instanceId, previousOperation.Status, activeInstanceId)
 	if msg != "" {
 		if paramaters.Forced {
-			log.Default().Printf("forcing %s", msg)
+			slog.WarnContext(ctx, "forcing operation", "secretId", secretId, "instanceId", instanceId, "reason", "cannot "+msg)
 		} else {
 			return nil, fmt.Errorf("cannot %s", msg)
 		}
@@ -395,9 +459,10 @@ func updateOperation(ctx context.Context, db *sql.DB, secretId string, instanceI
 	}
 
 	instance := &secrets.Instance{
-		Id:     instanceId,
-		Status: operation.Status,
-		Secret: secretPlan,
+		Id:        instanceId,
+		Status:    operation.Status,
+		Secret:    secretPlan,
+		CreatedAt: createdAt,
 	}
 
 	err = completeOperation(ctx, db, secretId, instance, operation, paramaters)
@@ -415,16 +480,65 @@ func startOperation(ctx context.Context, tx *sql.Tx, secretId string, instanceId
 			StartedBy: paramaters.StartedBy,
 		},
 	}
+	if paramaters.ApprovalId != "" {
+		operation.ApprovalId = &paramaters.ApprovalId
+	}
+	if paramaters.ElevationId != "" {
+		operation.ElevationId = &paramaters.ElevationId
+	}
+	if paramaters.IdempotencyKey != "" {
+		operation.IdempotencyKey = &paramaters.IdempotencyKey
+	}
+	if paramaters.BatchId != "" {
+		operation.BatchId = &paramaters.BatchId
+	}
+	if paramaters.RequestId != "" {
+		operation.RequestId = &paramaters.RequestId
+	}
 	err := tx.QueryRowContext(ctx, `
-		INSERT INTO operation (secretId, instanceId, name, forced, reason, startedBy, startedAt)
-			VALUES (?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO operation (secretId, instanceId, name, forced, reason, startedBy, startedAt, approvalId, elevationId, idempotencyKey, batchId, requestId)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 			RETURNING id, startedAt
-	`, secretId, instanceId, operation.Name, operation.Forced, operation.Reason, operation.StartedBy, time.Now()).Scan(&operation.OperationNumber, &operation.StartedAt)
+	`, secretId, instanceId, operation.Name, operation.Forced, operation.Reason, operation.StartedBy, time.Now(), operation.ApprovalId, operation.ElevationId, operation.IdempotencyKey, operation.BatchId, operation.RequestId).Scan(&operation.OperationNumber, &operation.StartedAt)
+	if err != nil {
+		return operation, err
+	}
+	err = recordEvent(ctx, tx, operationEvent(events.OperationStarted, operation), operation)
 	return operation, err
 }
 
+func operationEvent(eventType events.Type, operation secrets.Operation) *events.Event {
+	return &events.Event{
+		Type:       eventType,
+		SecretId:   operation.SecretId,
+		InstanceId: operation.InstanceId,
+		Principal:  operation.StartedBy,
+	}
+}
+
+func activeInstanceEvent(ctx context.Context, tx *sql.Tx, operation secrets.Operation, activeInstanceId *string) error {
+	event := operationEvent(events.ActiveInstanceChanged, operation)
+	return recordEvent(ctx, tx, event, events.ActiveInstance{ActiveInstanceId: activeInstanceId})
+}
+
 func completeOperation(ctx context.Context, db *sql.DB, secretId string, instance *secrets.Instance, operation secrets.Operation, parameters secrets.OperationParameters) error {
+	operationsInFlight.Add(1, secretId)
 	processErr := instance.Secret.Process(ctx, operation.Name, "", parameters, operation.InstanceId)
+	operationsInFlight.Add(-1, secretId)
+
+	// the outcome is recorded even if the operation was interrupted by cancellation of the context
+	interrupted := ctx.Err() != nil
+	ctx = context.WithoutCancel(ctx)
+
+	outcome := "completed"
+	if interrupted {
+		outcome = "interrupted"
+	} else if processErr != nil {
+		outcome = "failed"
+	}
+	operationsTotal.Inc(secretId, string(operation.Name), outcome)
+	slog.InfoContext(ctx, "operation finished", "secretId", secretId, "instanceId", operation.InstanceId, "operation", operation.Name, "operationNumber", operation.OperationNumber, "outcome", outcome)
+	operationDuration.Observe(time.Since(operation.StartedAt).Seconds(), secretId, string(operation.Name))
 
 	tx, commit, rollback, err := beginTx(db)
 	if err != nil {
@@ -438,33 +552,56 @@ func completeOperation(ctx context.Context, db *sql.DB, secretId string, instanc
 			UPDATE secret SET activeInstanceId = ?
 			WHERE id = ?
 		`, instance.Id, secretId)
+		err = activeInstanceEvent(ctx, tx, operation, &instance.Id)
+		if err != nil {
+			return errors.Join(processErr, err)
+		}
 	}
 
-	err = processErr
-	if err != nil {
-		commitErr := tx.QueryRowContext(ctx, `
-			UPDATE operation SET failedAt = ?
+	if processErr != nil {
+		now := time.Now()
+		var interruptedAt *time.Time
+		if interrupted {
+			interruptedAt = &now
+		}
+		err = tx.QueryRowContext(ctx, `
+			UPDATE operation SET failedAt = ?, interruptedAt = ?, error = ?
 			WHERE id = ?
-			RETURNING failedAt
-		`, time.Now(), operation.OperationNumber).Scan(&instance.Status.FailedAt)
-		if commitErr != nil {
-			err = commitErr
+			RETURNING failedAt, interruptedAt
+		`, now, interruptedAt, processErr.Error(), operation.OperationNumber).Scan(&instance.Status.FailedAt, &instance.Status.InterruptedAt)
+		if err != nil {
+			return err
 		}
-	} else {
-		// only unset active instance on successful deactivate
-		if operation.Name == secrets.Deactivate {
-			tx.ExecContext(ctx, `
-				UPDATE secret SET activeInstanceId = NULL
-				WHERE id = ?
-			`, secretId)
+		operation.Status = instance.Status
+		err = recordEvent(ctx, tx, operationEvent(events.OperationFailed, operation), operation)
+		if err != nil {
+			return errors.Join(processErr, err)
 		}
+		return errors.Join(processErr, commit())
+	}
 
-		err = tx.QueryRowContext(ctx, `
-			UPDATE operation SET completedAt = ?
+	// only unset active instance on successful deactivate
+	if operation.Name == secrets.Deactivate {
+		tx.ExecContext(ctx, `
+			UPDATE secret SET activeInstanceId = NULL
 			WHERE id = ?
-			RETURNING completedAt
-		`, time.Now(), operation.OperationNumber).Scan(&instance.Status.CompletedAt)
+		`, secretId)
+		err = activeInstanceEvent(ctx, tx, operation, nil)
+		if err != nil {
+			return err
+		}
 	}
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE operation SET completedAt = ?
+		WHERE id = ?
+		RETURNING completedAt
+	`, time.Now(), operation.OperationNumber).Scan(&instance.Status.CompletedAt)
+	if err != nil {
+		return err
+	}
+	operation.Status = instance.Status
+	err = recordEvent(ctx, tx, operationEvent(events.OperationCompleted, operation), operation)
 	if err != nil {
 		return err
 	}
@@ -475,18 +612,11 @@ func completeOperation(ctx context.Context, db *sql.DB, secretId string, instanc
 func (i *InstanceRepository) History(ctx context.Context, instanceId string, startAt int, endAt int) ([]*secrets.Operation, error) {
 	rows, err := i.db.QueryContext(ctx, `
 		SELECT
-			id,
-			secretId,
-			instanceId,
-			name,
-			forced,
-			reason,
-			startedBy,
-			startedAt,
-			completedAt,
-			failedAt
-		FROM operation
-		WHERE instanceId = ?
+			o.secretId,
+			o.instanceId,`+statusColumns+`
+		FROM operation o
+		WHERE o.instanceId = ?
+		ORDER BY o.id
 		LIMIT ? OFFSET ?
 	`, instanceId, endAt-startAt, startAt)
 	if err != nil {
@@ -497,7 +627,7 @@ func (i *InstanceRepository) History(ctx context.Context, instanceId string, sta
 	for err == nil && rows.Next() {
 		operation := &secrets.Operation{}
 		operations = append(operations, operation)
-		err = rows.Scan(&operation.OperationNumber, &operation.SecretId, &operation.InstanceId, &operation.Name, &operation.Forced, &operation.Reason, &operation.StartedBy, &operation.StartedAt, &operation.CompletedAt, &operation.FailedAt)
+		err = rows.Scan(statusFields(&operation.Status, &operation.SecretId, &operation.InstanceId)...)
 	}
 	return operations, err
 }
@@ -2,9 +2,13 @@ package sqlite
 
 import (
 	"context"
+	"errors"
 	"path/filepath"
+	"slices"
 	"testing"
+	"time"
 
+	"github.com/eliasvasylenko/secret-agent/internal/command"
 	"github.com/eliasvasylenko/secret-agent/internal/secrets"
 	"github.com/google/go-cmp/cmp"
 )
@@ -134,6 +138,87 @@ func TestInstanceRepository_Create_List_Get(t *testing.T) {
 	}
 }
 
+func TestInstanceRepository_List_range(t *testing.T) {
+	repo := newTestRepo(t, nil)
+	ctx := context.Background()
+	instances := repo.Instances("s1")
+
+	var ids []string
+	for range 3 {
+		created, err := instances.Create(ctx, secrets.OperationParameters{StartedBy: "user"})
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		ids = append(ids, created.Id)
+	}
+
+	tests := []struct {
+		name    string
+		from    int
+		to      int
+		wantIds []string
+	}{
+		{"whole", 0, 10, ids},
+		{"first page", 0, 2, ids[:2]},
+		{"last page", 2, 4, ids[2:]},
+		{"past the end", 3, 5, nil},
+		{"far past the end", 100, 200, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := instances.List(ctx, tt.from, tt.to)
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			var gotIds []string
+			for id := range got {
+				gotIds = append(gotIds, id)
+			}
+			slices.Sort(gotIds)
+			wantIds := slices.Sorted(slices.Values(tt.wantIds))
+			if diff := cmp.Diff(wantIds, gotIds); diff != "" {
+				t.Errorf("List(%d, %d) mismatch (-want +got):\n%s", tt.from, tt.to, diff)
+			}
+		})
+	}
+}
+
+func TestInstanceRepository_createdAt(t *testing.T) {
+	repo := newTestRepo(t, nil)
+	ctx := context.Background()
+	instances := repo.Instances("s1")
+
+	created, err := instances.Create(ctx, secrets.OperationParameters{StartedBy: "user"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if created.CreatedAt.IsZero() || !created.CreatedAt.Equal(created.Status.StartedAt) {
+		t.Fatalf("Create CreatedAt = %v, want the start of the create operation %v", created.CreatedAt, created.Status.StartedAt)
+	}
+	activated, err := instances.Activate(ctx, created.Id, secrets.OperationParameters{StartedBy: "user"})
+	if err != nil {
+		t.Fatalf("Activate: %v", err)
+	}
+
+	list, err := instances.List(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	got, err := instances.Get(ctx, created.Id)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	active, err := instances.GetActive(ctx)
+	if err != nil {
+		t.Fatalf("GetActive: %v", err)
+	}
+	for name, instance := range map[string]*secrets.Instance{"Activate": activated, "List": list[created.Id], "Get": got, "GetActive": active} {
+		if !instance.CreatedAt.Equal(created.CreatedAt) {
+			t.Errorf("%s CreatedAt = %v, want %v", name, instance.CreatedAt, created.CreatedAt)
+		}
+	}
+}
+
 func TestInstanceRepository_Get_notFound(t *testing.T) {
 	repo := newTestRepo(t, nil)
 	ctx := context.Background()
@@ -182,7 +267,7 @@ func TestInstanceRepository_History(t *testing.T) {
 	ctx := context.Background()
 	instances := repo.Instances("s1")
 
-	created, err := instances.Create(ctx, secrets.OperationParameters{Reason: "create", StartedBy: "user"})
+	created, err := instances.Create(ctx, secrets.OperationParameters{Reason: "create", StartedBy: "user", RequestId: "req-1"})
 	if err != nil {
 		t.Fatalf("Create: %v", err)
 	}
@@ -197,6 +282,56 @@ func TestInstanceRepository_History(t *testing.T) {
 	if ops[0].Name != secrets.Create {
 		t.Errorf("History[0].Name = %s", ops[0].Name)
 	}
+	if ops[0].RequestId == nil || *ops[0].RequestId != "req-1" {
+		t.Errorf("History[0].RequestId = %v, want req-1", ops[0].RequestId)
+	}
+}
+
+func TestInstanceRepository_History_oldestFirst(t *testing.T) {
+	repo := newTestRepo(t, nil)
+	ctx := context.Background()
+	instances := repo.Instances("s1")
+
+	created, err := instances.Create(ctx, secrets.OperationParameters{StartedBy: "user"})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := instances.Activate(ctx, created.Id, secrets.OperationParameters{StartedBy: "user"}); err != nil {
+		t.Fatalf("Activate: %v", err)
+	}
+	if _, err := instances.Deactivate(ctx, created.Id, secrets.OperationParameters{StartedBy: "user"}); err != nil {
+		t.Fatalf("Deactivate: %v", err)
+	}
+
+	want := []secrets.OperationName{secrets.Create, secrets.Activate, secrets.Deactivate}
+	names := func(ops []*secrets.Operation) []secrets.OperationName {
+		var names []secrets.OperationName
+		for _, op := range ops {
+			names = append(names, op.Name)
+		}
+		return names
+	}
+	ops, err := instances.History(ctx, created.Id, 0, 10)
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	if diff := cmp.Diff(want, names(ops)); diff != "" {
+		t.Errorf("instance History mismatch (-want +got):\n%s", diff)
+	}
+	ops, err = repo.History(ctx, "s1", 0, 10)
+	if err != nil {
+		t.Fatalf("secret History: %v", err)
+	}
+	if diff := cmp.Diff(want, names(ops)); diff != "" {
+		t.Errorf("secret History mismatch (-want +got):\n%s", diff)
+	}
+	ops, err = instances.History(ctx, created.Id, 1, 2)
+	if err != nil {
+		t.Fatalf("History page: %v", err)
+	}
+	if diff := cmp.Diff(want[1:2], names(ops)); diff != "" {
+		t.Errorf("History page mismatch (-want +got):\n%s", diff)
+	}
 }
 
 func TestInstanceRepository_Activate_Deactivate(t *testing.T) {
@@ -254,3 +389,143 @@ func TestInstanceRepository_Create_validateReason(t *testing.T) {
 		t.Fatal("Create with too-long reason = nil, want error")
 	}
 }
+
+func TestNewSecretRepository_migrations(t *testing.T) {
+	ctx := context.Background()
+	dbFile := filepath.Join(t.TempDir(), "store.db")
+	for range 2 {
+		repo, err := NewSecretRepository(ctx, dbFile, secrets.Secrets{"s1": noOpSecret}, false, 256)
+		if err != nil {
+			t.Fatalf("NewSecretRepository: %v", err)
+		}
+		var version int
+		if err := repo.db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version); err != nil {
+			t.Fatalf("user_version: %v", err)
+		}
+		if version != len(migrations) {
+			t.Errorf("user_version = %d, want %d", version, len(migrations))
+		}
+		repo.Close()
+	}
+}
+
+func TestInstanceRepository_Create_interrupted(t *testing.T) {
+	slow := &secrets.Secret{Name: "slow", Create: &command.Command{Script: "sleep 10"}}
+	repo := newTestRepo(t, secrets.Secrets{"slow": slow})
+	ctx, cancel := context.WithCancel(context.Background())
+	instances := repo.Instances("slow")
+
+	time.AfterFunc(100*time.Millisecond, cancel)
+	created, err := instances.Create(ctx, secrets.OperationParameters{Reason: "r", StartedBy: "u"})
+	if err == nil {
+		t.Fatal("Create = nil, want error from interrupted command")
+	}
+	if created.Status.FailedAt == nil || created.Status.InterruptedAt == nil {
+		t.Errorf("Create status = %+v, want failed and interrupted", created.Status)
+	}
+
+	got, err := instances.Get(context.Background(), created.Id)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.Status.InterruptedAt == nil {
+		t.Errorf("Get status = %+v, want interrupted", got.Status)
+	}
+}
+
+func TestInstanceRepository_idempotencyKey(t *testing.T) {
+	repo := newTestRepo(t, nil)
+	ctx := context.Background()
+	instances := repo.Instances("s1")
+
+	parameters := secrets.OperationParameters{Reason: "create", StartedBy: "user", IdempotencyKey: "k1"}
+	created, err := instances.Create(ctx, parameters)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	retried, err := instances.Create(ctx, parameters)
+	if err != nil {
+		t.Fatalf("Create retry: %v", err)
+	}
+	if retried.Id != created.Id || retried.Status.OperationNumber != created.Status.OperationNumber {
+		t.Errorf("Create retry = %+v, want original %+v", retried, created)
+	}
+	if retried.Status.IdempotencyKey == nil || *retried.Status.IdempotencyKey != "k1" {
+		t.Errorf("Create retry status = %+v, want idempotency key", retried.Status)
+	}
+	list, err := instances.List(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(list) != 1 {
+		t.Errorf("List returned %d instances, want 1", len(list))
+	}
+
+	// another principal may use the same key
+	other, err := instances.Create(ctx, secrets.OperationParameters{Reason: "create", StartedBy: "other", IdempotencyKey: "k1"})
+	if err != nil {
+		t.Fatalf("Create by other: %v", err)
+	}
+	if other.Id == created.Id {
+		t.Error("Create by other returned the instance of another principal")
+	}
+
+	_, err = instances.Activate(ctx, created.Id, parameters)
+	if !errors.Is(err, secrets.ErrIdempotencyKeyReused) {
+		t.Errorf("Activate with key of create = %v, want %v", err, secrets.ErrIdempotencyKeyReused)
+	}
+}
+
+func TestInstanceRepository_idempotencyKey_failed(t *testing.T) {
+	failing := &secrets.Secret{Name: "s1", Create: &command.Command{Script: "echo broken >&2; false"}}
+	repo := newTestRepo(t, secrets.Secrets{"s1": failing})
+	ctx := context.Background()
+	instances := repo.Instances("s1")
+
+	parameters := secrets.OperationParameters{Reason: "create", StartedBy: "user", IdempotencyKey: "k1"}
+	created, err := instances.Create(ctx, parameters)
+	if err == nil {
+		t.Fatal("Create = nil, want error")
+	}
+	retried, retryErr := instances.Create(ctx, parameters)
+	if retryErr == nil || retryErr.Error() != err.Error() {
+		t.Errorf("Create retry err = %v, want %v", retryErr, err)
+	}
+	if retried == nil || retried.Id != created.Id || retried.Status.FailedAt == nil {
+		t.Errorf("Create retry = %+v, want original %+v", retried, created)
+	}
+}
+
+func TestInstanceRepository_ifMatch(t *testing.T) {
+	repo := newTestRepo(t, nil)
+	ctx := context.Background()
+	instances := repo.Instances("s1")
+
+	latest, err := repo.LatestOperation(ctx, "s1")
+	if err != nil || latest != 0 {
+		t.Fatalf("LatestOperation = %d, %v, want 0 before any operation", latest, err)
+	}
+	created, err := instances.Create(ctx, secrets.OperationParameters{Reason: "create", StartedBy: "user", IfMatch: &latest})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	latest, err = repo.LatestOperation(ctx, "s1")
+	if err != nil || latest != created.Status.OperationNumber {
+		t.Fatalf("LatestOperation = %d, %v, want %d", latest, err, created.Status.OperationNumber)
+	}
+
+	stale := latest - 1
+	_, err = instances.Activate(ctx, created.Id, secrets.OperationParameters{Reason: "activate", StartedBy: "user", IfMatch: &stale})
+	var precondition *secrets.PreconditionFailedError
+	if !errors.As(err, &precondition) || precondition.Latest != latest {
+		t.Fatalf("Activate with stale precondition = %v, want precondition failure", err)
+	}
+	if active, _ := instances.GetActive(ctx); active != nil {
+		t.Errorf("GetActive = %v after failed precondition, want nil", active)
+	}
+
+	_, err = instances.Activate(ctx, created.Id, secrets.OperationParameters{Reason: "activate", StartedBy: "user", IfMatch: &latest})
+	if err != nil {
+		t.Fatalf("Activate: %v", err)
+	}
+}
@@ -2,7 +2,11 @@ package store
 
 import (
 	"context"
+	"time"
 
+	"github.com/eliasvasylenko/secret-agent/internal/audit"
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/events"
 	"github.com/eliasvasylenko/secret-agent/internal/secrets"
 )
 
@@ -13,9 +17,12 @@ type Secrets interface {
 	// Get the secret with the given id
 	Get(ctx context.Context, secretId string) (*secrets.Secret, error)
 
-	// Read the operation history of a secret, from the given inclusive index, to the given exclusive index
+	// Read the operation history of a secret, oldest first, from the given inclusive index, to the given exclusive index
 	History(ctx context.Context, secretId string, from int, to int) ([]*secrets.Operation, error)
 
+	// The number of the latest operation on a secret, or zero if there have been none
+	LatestOperation(ctx context.Context, secretId string) (int, error)
+
 	// The interfaces of the secret with the given id
 	Instances(secretId string) Instances
 }
@@ -45,6 +52,94 @@ type Instances interface {
 	// Deactivate the instance with the given ID and secret name
 	Test(ctx context.Context, instanceId string, parameters secrets.OperationParameters) (*secrets.Instance, error)
 
-	// Read the operation history of a secret instance, from the given inclusive index, to the given exclusive index
+	// Read the operation history of a secret instance, oldest first, from the given inclusive index, to the given exclusive index
 	History(ctx context.Context, instanceId string, from int, to int) ([]*secrets.Operation, error)
 }
+
+type Approvals interface {
+	// List approval requests, most recent first, from the given inclusive index, to the given exclusive index
+	List(ctx context.Context, from int, to int) ([]*secrets.Approval, error)
+
+	// Get the approval request with the given ID
+	Get(ctx context.Context, approvalId string) (*secrets.Approval, error)
+
+	// Request approval for an operation, returning the pending approval request
+	Request(ctx context.Context, approval *secrets.Approval) (*secrets.Approval, error)
+
+	// Record a decision upon a pending approval request, returning the updated request
+	Decide(ctx context.Context, approvalId string, decision secrets.Decision) (*secrets.Approval, error)
+
+	// Record the operation which was started once the request was approved
+	Complete(ctx context.Context, approvalId string, operationNumber int) error
+
+	// Record that the operation could not be started once the request was approved
+	Fail(ctx context.Context, approvalId string) error
+}
+
+type Denials interface {
+	// Record a denied attempt
+	Record(ctx context.Context, denial *audit.Denial) error
+
+	// List denied attempts, most recent first, from the given inclusive index, to the given exclusive index
+	List(ctx context.Context, from int, to int) ([]*audit.Denial, error)
+
+	// Delete denied attempts recorded before the given time, returning the number deleted
+	Prune(ctx context.Context, before time.Time) (int, error)
+}
+
+type Elevations interface {
+	// Grant an elevation, unless the principal already has an active elevation
+	Grant(ctx context.Context, elevation *auth.Elevation) (*auth.Elevation, error)
+
+	// Get the active elevation of a principal, or nil if there is none
+	Active(ctx context.Context, principal string) (*auth.Elevation, error)
+
+	// Get an elevation
+	Get(ctx context.Context, elevationId string) (*auth.Elevation, error)
+
+	// List elevations, most recent first, from the given inclusive index, to the given exclusive index
+	List(ctx context.Context, from int, to int) ([]*auth.Elevation, error)
+
+	// Revoke an active elevation
+	Revoke(ctx context.Context, elevationId string, revokedBy string) (*auth.Elevation, error)
+}
+
+type Events interface {
+	// List at most limit events following the event with the given ID, in the order they were recorded
+	After(ctx context.Context, eventId int64, limit int) ([]*events.Event, error)
+
+	// The ID of the most recently recorded event, or zero if there are none
+	Latest(ctx context.Context) (int64, error)
+
+	// The ID of the most recently recorded event which has been pruned, or zero if none have been
+	Pruned(ctx context.Context) (int64, error)
+
+	// Delete events recorded before the given time, up to and including the event with the given ID, returning the
+	// number deleted
+	Prune(ctx context.Context, before time.Time, throughEventId int64) (int, error)
+
+	// Get the progress of delivery to a subscriber, starting new subscribers from the latest event
+	Subscription(ctx context.Context, subscriber string) (*events.Subscription, error)
+
+	// Record the progress of delivery to a subscriber
+	UpdateSubscription(ctx context.Context, subscription *events.Subscription) error
+}
+
+type Health interface {
+	// Check that the store answers and that its schema is current
+	Check(ctx context.Context) error
+
+	// The number of operations started before the given time which have neither completed nor failed
+	RunningSince(ctx context.Context, startedBefore time.Time) (int, error)
+
+	// Record every operation which has neither completed nor failed as interrupted, as no operation can still be
+	// running when the server starts, giving the number of operations interrupted
+	InterruptUnfinished(ctx context.Context) (int, error)
+
+	// The number of secrets with an active instance
+	ActiveSecrets(ctx context.Context) (int, error)
+
+	// Summarise every configured secret in order of ID, given that operations started before stuckBefore should
+	// have finished. Operations left unfinished by a previous server count as running until InterruptUnfinished.
+	Summaries(ctx context.Context, stuckBefore time.Time) ([]*secrets.Summary, error)
+}
@@ -0,0 +1,69 @@
+package validation
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A problem with one field of the body of a request, or with one parameter of its query
+type FieldError struct {
+	// A JSON pointer to the field of the body, if the problem is with the body
+	Pointer string `json:"pointer,omitempty"`
+	// The name of the query parameter, if the problem is with the query
+	Parameter string `json:"parameter,omitempty"`
+	Message   string `json:"message"`
+}
+
+func (e *FieldError) String() string {
+	if e.Parameter != "" {
+		return fmt.Sprintf("parameter %s: %s", e.Parameter, e.Message)
+	}
+	if e.Pointer == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Pointer, e.Message)
+}
+
+// The problems found with a request
+type Errors []*FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.String()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// A Validator collects the problems found with a request, so that they may all be reported together
+type Validator struct {
+	errors Errors
+}
+
+// Field records a problem with the field of the body at the given JSON pointer
+func (v *Validator) Field(pointer string, format string, args ...any) {
+	v.errors = append(v.errors, &FieldError{Pointer: pointer, Message: fmt.Sprintf(format, args...)})
+}
+
+// Parameter records a problem with the named parameter of the query
+func (v *Validator) Parameter(name string, format string, args ...any) {
+	v.errors = append(v.errors, &FieldError{Parameter: name, Message: fmt.Sprintf(format, args...)})
+}
+
+// Err gives the problems recorded, or nil if there are none
+func (v *Validator) Err() error {
+	if len(v.errors) == 0 {
+		return nil
+	}
+	return v.errors
+}
+
+// Pointer gives the JSON pointer to the field reached through the given reference tokens, escaping each
+func Pointer(tokens ...string) string {
+	var pointer strings.Builder
+	for _, token := range tokens {
+		pointer.WriteByte('/')
+		pointer.WriteString(strings.NewReplacer("~", "~0", "/", "~1").Replace(token))
+	}
+	return pointer.String()
+}
@@ -0,0 +1,48 @@
+package validation
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPointer(t *testing.T) {
+	tests := []struct {
+		tokens []string
+		want   string
+	}{
+		{nil, ""},
+		{[]string{"env"}, "/env"},
+		{[]string{"select", "names", "0"}, "/select/names/0"},
+		{[]string{"env", "a/b~c"}, "/env/a~1b~0c"},
+	}
+	for _, tt := range tests {
+		if got := Pointer(tt.tokens...); got != tt.want {
+			t.Errorf("Pointer(%q) = %q, want %q", tt.tokens, got, tt.want)
+		}
+	}
+}
+
+func TestValidator(t *testing.T) {
+	var v Validator
+	if err := v.Err(); err != nil {
+		t.Errorf("Err = %v, want nil before any problem is recorded", err)
+	}
+
+	v.Field("/name", "unknown operation %q", "rotate")
+	v.Parameter("from", "must not be negative")
+	v.Field("", "request is empty")
+
+	want := Errors{
+		{Pointer: "/name", Message: `unknown operation "rotate"`},
+		{Parameter: "from", Message: "must not be negative"},
+		{Message: "request is empty"},
+	}
+	if diff := cmp.Diff(want, v.Err()); diff != "" {
+		t.Errorf("errors mismatch (-want +got):\n%s", diff)
+	}
+	wantMessage := `/name: unknown operation "rotate"; parameter from: must not be negative; request is empty`
+	if got := v.Err().Error(); got != wantMessage {
+		t.Errorf("Error = %q, want %q", got, wantMessage)
+	}
+}
@@ -62,6 +62,11 @@ let
       default = { };
       type = with lib.types; attrsOf str;
     };
+    labels = lib.mkOption {
+      description = "Labels by which the secret may be selected for bulk operations";
+      default = { };
+      type = with lib.types; attrsOf str;
+    };
     create = mkCommandOptions "create the secret";
     destroy = mkCommandOptions "destroy the secret";
     activate = mkCommandOptions "activate the secret";
@@ -98,6 +103,117 @@ let
     package = lib.mkPackageOption packages.${pkgs.system} "secret-agent" {
       default = "default";
     };
+    denialRetention = lib.mkOption {
+      description = "Time for which denied attempts are retained in the audit trail, or forever if \"0\"";
+      type = lib.types.str;
+      default = "2160h";
+    };
+    auditSink = lib.mkOption {
+      description = "Path of a file to which denied attempts are also appended as JSON lines";
+      type = with lib.types; nullOr str;
+      default = null;
+    };
+    shutdownGraceSec = lib.mkOption {
+      description = "Seconds for which running operations may finish on shutdown before they are interrupted";
+      type = lib.types.ints.unsigned;
+      default = 30;
+    };
+    watchdogSec = lib.mkOption {
+      description = "Watchdog timeout after which the service is restarted if it stops responding, or null to disable";
+      type = with lib.types; nullOr str;
+      default = null;
+    };
+    metricsAddress = lib.mkOption {
+      description = "TCP address, e.g. \"127.0.0.1:9464\", or unix socket path on which to serve Prometheus metrics, or null to disable";
+      type = with lib.types; nullOr str;
+      default = null;
+    };
+    logFormat = lib.mkOption {
+      description = "Format of the log lines of the service, including its request access log";
+      type = lib.types.enum [
+        "text"
+        "json"
+      ];
+      default = "text";
+    };
+    stuckAfter = lib.mkOption {
+      description = "Duration for which an operation may run before the service reports that it is not ready";
+      type = lib.types.str;
+      default = "1h";
+    };
+    maxInFlight = lib.mkOption {
+      description = "Maximum number of concurrent operations per principal, or unlimited if 0";
+      type = lib.types.ints.unsigned;
+      default = 4;
+    };
+    limits = lib.mkOption {
+      description = "Rate limits per endpoint class (read, operation or admin), in place of the default of 100 requests per minute";
+      type =
+        with lib.types;
+        let
+          rateLimit = submodule {
+            options = {
+              limit = lib.mkOption {
+                description = "Maximum number of requests per window";
+                type = ints.unsigned;
+              };
+              window = lib.mkOption {
+                description = "Window of time over which the limit is enforced, e.g. \"1m\"";
+                type = str;
+              };
+            };
+          };
+        in
+        submodule {
+          options = {
+            classes = lib.mkOption {
+              description = "Limits for each endpoint class";
+              type = attrsOf rateLimit;
+              default = { };
+            };
+            roles = lib.mkOption {
+              description = "Limits for each endpoint class which apply to principals claiming a role, in place of those of the class";
+              type = attrsOf (attrsOf rateLimit);
+              default = { };
+            };
+          };
+        };
+      default = { };
+    };
+    listeners = lib.mkOption {
+      description = "Additional named sockets, each with a permissions profile, e.g. a world-connectable read-only socket";
+      type =
+        with lib.types;
+        attrsOf (submodule {
+          options = {
+            path = lib.mkOption {
+              description = "Path of the socket";
+              type = str;
+            };
+            socketMode = lib.mkOption {
+              description = "File mode of the socket";
+              type = str;
+              default = "0666";
+            };
+            actions = lib.mkOption {
+              description = "The only actions which may be performed through the socket, or any actions if empty";
+              type = listOf str;
+              default = [ ];
+            };
+            roles = lib.mkOption {
+              description = "Roles which replace those of the service for connections through the socket";
+              type = nullOr (attrsOf anything);
+              default = null;
+            };
+            claims = lib.mkOption {
+              description = "Claims which replace those of the service for connections through the socket";
+              type = nullOr (attrsOf anything);
+              default = null;
+            };
+          };
+        });
+      default = { };
+    };
     roles = lib.mkOption {
       description = "Roles and their permissions";
       type =
@@ -107,6 +223,17 @@ let
             permissions = lib.mkOption {
               description = "The permissions assigned to a role";
               type = stringOrStrings;
+              default = { };
+            };
+            inherits = lib.mkOption {
+              description = "Roles whose permissions and denials are inherited";
+              type = listOf str;
+              default = [ ];
+            };
+            deny = lib.mkOption {
+              description = "Permissions which are denied, even if granted by this or any other claimed role";
+              type = stringOrStrings;
+              default = { };
             };
           };
         });
@@ -125,6 +252,171 @@ let
         type = stringOrStrings;
         default.secret-agent = "admin";
       };
+      executables = lib.mkOption {
+        description = "Patterns matching the paths of executables, and the roles their processes can assume";
+        type = stringOrStrings;
+        default = { };
+      };
+      units = lib.mkOption {
+        description = "Patterns matching systemd units, e.g. \"nginx.service\", and the roles their processes can assume";
+        type = stringOrStrings;
+        default = { };
+      };
+      cgroups = lib.mkOption {
+        description = "Patterns matching cgroup paths, and the roles their processes can assume";
+        type = stringOrStrings;
+        default = { };
+      };
+    };
+    approvals = lib.mkOption {
+      description = "Policies requiring operations to be approved by other principals before they are performed";
+      type =
+        with lib.types;
+        listOf (submodule {
+          options = {
+            operations = lib.mkOption {
+              description = "The operations which require approval, or all operations if empty";
+              type = listOf str;
+              default = [ ];
+            };
+            secrets = lib.mkOption {
+              description = "Patterns matching the IDs of the secrets which require approval, or all secrets if empty";
+              type = listOf str;
+              default = [ ];
+            };
+            forced = lib.mkOption {
+              description = "Only require approval for forced operations";
+              type = bool;
+              default = false;
+            };
+            approvals = lib.mkOption {
+              description = "The number of approvals required from principals with the approve permission";
+              type = ints.positive;
+              default = 1;
+            };
+            expiry = lib.mkOption {
+              description = "The time after which a pending request expires, e.g. \"1h\"";
+              type = nullOr str;
+              default = null;
+            };
+          };
+        });
+      default = [ ];
+    };
+    policies = lib.mkOption {
+      description = "Policies constraining the reasons given for operations";
+      type =
+        with lib.types;
+        listOf (submodule {
+          options = {
+            operations = lib.mkOption {
+              description = "The operations which the policy applies to, or all operations if empty";
+              type = listOf str;
+              default = [ ];
+            };
+            secrets = lib.mkOption {
+              description = "Patterns matching the IDs of the secrets which the policy applies to, or all secrets if empty";
+              type = listOf str;
+              default = [ ];
+            };
+            requireReason = lib.mkOption {
+              description = "Require a non-empty reason";
+              type = bool;
+              default = false;
+            };
+            reasonPattern = lib.mkOption {
+              description = "A regular expression which reasons must match, e.g. \"^OPS-[0-9]+\"";
+              type = nullOr str;
+              default = null;
+            };
+          };
+        });
+      default = [ ];
+    };
+    elevations = lib.mkOption {
+      description = "Policies allowing principals to elevate to a role for a bounded time, for break-glass access";
+      type =
+        with lib.types;
+        listOf (submodule {
+          options = {
+            role = lib.mkOption {
+              description = "The role which may be elevated to";
+              type = str;
+            };
+            from = lib.mkOption {
+              description = "The claimed roles which are allowed to elevate to the role";
+              type = listOf str;
+            };
+            maxDuration = lib.mkOption {
+              description = "The maximum duration of an elevation, e.g. \"30m\"";
+              type = nullOr str;
+              default = null;
+            };
+          };
+        });
+      default = [ ];
+    };
+    eventRetention = lib.mkOption {
+      description = "Time for which events are retained for delivery to notification subscribers, or forever if \"0\"";
+      type = lib.types.str;
+      default = "720h";
+    };
+    notifications = lib.mkOption {
+      description = "Subscribers to which events are delivered, by executing a command or posting to a local webhook";
+      type =
+        with lib.types;
+        attrsOf (submodule {
+          options = {
+            events = lib.mkOption {
+              description = "Patterns of the types of events to deliver, e.g. \"operation.*\", or every type if empty";
+              type = listOf str;
+              default = [ ];
+            };
+            secrets = lib.mkOption {
+              description = "Patterns of the IDs of secrets about which to deliver events, or every secret if empty";
+              type = listOf str;
+              default = [ ];
+            };
+            exec = lib.mkOption {
+              description = "Command to which events are given as JSON on stdin";
+              type = nullOr commandType;
+              default = null;
+            };
+            webhook = lib.mkOption {
+              description = "Local webhook to which events are posted as JSON";
+              type = nullOr (submodule {
+                options = {
+                  url = lib.mkOption {
+                    description = "URL to which events are posted, on a loopback address unless a socket is given";
+                    type = str;
+                  };
+                  socket = lib.mkOption {
+                    description = "Path of a unix socket through which to connect";
+                    type = nullOr str;
+                    default = null;
+                  };
+                };
+              });
+              default = null;
+            };
+            hmacKeyFile = lib.mkOption {
+              description = "Path of a file containing the key with which events are signed by HMAC-SHA256";
+              type = nullOr str;
+              default = null;
+            };
+            maxAttempts = lib.mkOption {
+              description = "Number of attempts to deliver an event before it is skipped";
+              type = nullOr ints.positive;
+              default = null;
+            };
+            timeout = lib.mkOption {
+              description = "Time allowed for each attempt to deliver an event, e.g. \"10s\"";
+              type = nullOr str;
+              default = null;
+            };
+          };
+        });
+      default = { };
     };
     secrets = lib.mkOption {
       description = "Secrets";
@@ -171,6 +463,7 @@ let
         lib.attrsets.filterAttrs (n: v: v != null) {
           inherit name;
           environment = secret.environment;
+          labels = secret.labels;
           create = makeCommandConfig secret.create;
           destroy = makeCommandConfig secret.destroy;
           activate = makeCommandConfig secret.activate;
@@ -185,16 +478,52 @@ let
   permissionsFile = pkgs.writeText "permissions.config" (
     builtins.toJSON {
       claims = {
-        inherit (cfg.claims) users groups;
+        inherit (cfg.claims)
+          users
+          groups
+          executables
+          units
+          cgroups
+          ;
       };
       inherit (cfg) roles;
+      approvals = map (lib.filterAttrs (n: v: v != null)) cfg.approvals;
+      policies = map (lib.filterAttrs (n: v: v != null)) cfg.policies;
+      elevations = map (lib.filterAttrs (n: v: v != null)) cfg.elevations;
+      inherit (cfg) limits;
+      listeners = lib.mapAttrs (
+        name: listener:
+        lib.filterAttrs (n: v: v != null && v != [ ]) {
+          inherit (listener) actions roles claims;
+        }
+      ) cfg.listeners;
     }
   );
 
+  # The socket units which activate the service
+  socketUnits = [
+    "secret-agent.socket"
+  ]
+  ++ map (name: "secret-agent-${name}.socket") (lib.attrNames cfg.listeners);
+
   # Write the secrets config file for the service backend
   secretsFile = pkgs.writeText "secret-agent.config" (
     builtins.toJSON {
       secrets = makeSecretsConfig cfg.secrets;
+      notifications = lib.mapAttrs (
+        name: subscriber:
+        lib.filterAttrs (n: v: v != null && v != [ ]) (
+          subscriber
+          // {
+            exec = makeCommandConfig subscriber.exec;
+            webhook =
+              if subscriber.webhook == null then
+                null
+              else
+                lib.filterAttrs (n: v: v != null) subscriber.webhook;
+          }
+        )
+      ) cfg.notifications;
     }
   );
 in
@@ -213,13 +542,31 @@ in
         coreutils
       ];
       serviceConfig = {
-        Type = "simple";
+        Type = "notify";
+        NotifyAccess = "main";
         Restart = "no";
-        ExecStart = "${cfg.package}/bin/secret-agent serve -S ${secretsFile} -P ${permissionsFile} -D ./dbfile";
+        # allow time for the server to interrupt and record any operations which outlast the grace period
+        TimeoutStopSec = cfg.shutdownGraceSec + 15;
+        ExecStart = lib.concatStringsSep " " (
+          [
+            "${cfg.package}/bin/secret-agent serve -S ${secretsFile} -P ${permissionsFile} -D ./dbfile"
+            "--denial-retention ${cfg.denialRetention}"
+            "--event-retention ${cfg.eventRetention}"
+            "--max-in-flight ${toString cfg.maxInFlight}"
+            "--shutdown-grace ${toString cfg.shutdownGraceSec}s"
+            "--log-format ${cfg.logFormat}"
+            "--stuck-after ${cfg.stuckAfter}"
+          ]
+          ++ lib.optional (cfg.auditSink != null) "--audit-sink ${cfg.auditSink}"
+          ++ lib.optional (cfg.metricsAddress != null) "--metrics-address ${cfg.metricsAddress}"
+        );
         NonBlocking = true;
+      }
+      // lib.optionalAttrs (cfg.watchdogSec != null) {
+        WatchdogSec = cfg.watchdogSec;
       };
-      requires = [ "secret-agent.socket" ];
-      after = [ "secret-agent.socket" ];
+      requires = socketUnits;
+      after = socketUnits;
     };
 
     environment.systemPackages = [
@@ -232,15 +579,33 @@ in
       }))
     ];
 
-    systemd.sockets.secret-agent = {
-      enable = true;
-      wantedBy = [ "sockets.target" ];
-      description = "Socket to communicate with secret agent";
-      listenStreams = [ "/tmp/secret-agent.socket" ];
-      socketConfig = {
-        NoDelay = true;
+    systemd.sockets = {
+      secret-agent = {
+        enable = true;
+        wantedBy = [ "sockets.target" ];
+        description = "Socket to communicate with secret agent";
+        listenStreams = [ "/tmp/secret-agent.socket" ];
+        socketConfig = {
+          NoDelay = true;
+        };
       };
-    };
+    }
+    // lib.mapAttrs' (
+      name: listener:
+      lib.nameValuePair "secret-agent-${name}" {
+        enable = true;
+        wantedBy = [ "sockets.target" ];
+        description = "Socket to communicate with secret agent through the ${name} listener";
+        listenStreams = [ listener.path ];
+        socketConfig = {
+          NoDelay = true;
+          SocketMode = listener.socketMode;
+          # the name ties connections through the socket to the listener profile
+          FileDescriptorName = name;
+          Service = "secret-agent.service";
+        };
+      }
+    ) cfg.listeners;
 
     users.groups.secret-agent = { };
     users.users.secret-agent = {
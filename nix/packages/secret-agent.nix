@@ -13,4 +13,11 @@ pkgs.buildGoModule {
     "-s"
     "-w"
   ];
+  nativeBuildInputs = [ pkgs.installShellFiles ];
+  postInstall = ''
+    installShellCompletion --cmd secret-agent \
+      --bash <($out/bin/secret-agent completion bash) \
+      --zsh <($out/bin/secret-agent completion zsh) \
+      --fish <($out/bin/secret-agent completion fish)
+  '';
 }
@@ -0,0 +1,166 @@
+package secretagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/config"
+	"github.com/eliasvasylenko/secret-agent/internal/server"
+	"github.com/eliasvasylenko/secret-agent/internal/sqlite"
+	"github.com/eliasvasylenko/secret-agent/internal/store"
+)
+
+// Time allowed for an embedded agent to start listening, and for operations to finish when it is closed
+const (
+	agentStartTimeout  = 5 * time.Second
+	agentShutdownGrace = 5 * time.Second
+)
+
+// Config for an embedded agent
+type AgentConfig struct {
+	// Path of the secrets configuration file, in the format read by the agent
+	SecretsFile string
+	// Path of the permissions configuration file, in the format read by the agent, or if empty, every permission is
+	// granted to the user running the agent
+	PermissionsFile string
+	// Directory in which the database and socket of the agent are kept, or if empty, a temporary directory which is
+	// removed when the agent is closed
+	Dir string
+}
+
+// An Agent serves the API in-process upon its own sqlite database, so that code which talks to the agent may be
+// tested without running it as a service. Requests are not rate limited, and requests and operations are logged
+// through the default logger of [log/slog]. An agent is started by [StartAgent], and must be closed once it is no
+// longer needed.
+type Agent struct {
+	socket     string
+	repository *sqlite.SecretRespository
+	stop       context.CancelFunc
+	served     chan error
+	// The temporary directory of the agent, if it has one
+	tempDir string
+}
+
+// StartAgent starts an embedded agent, and waits until it is listening
+func StartAgent(ctx context.Context, agentConfig AgentConfig) (*Agent, error) {
+	agent := &Agent{}
+	dir := agentConfig.Dir
+	if dir == "" {
+		// socket paths are short, so the directory is not kept under a deep temporary directory such as testing.T.TempDir
+		tempDir, err := os.MkdirTemp("", "secret-agent-")
+		if err != nil {
+			return nil, err
+		}
+		agent.tempDir, dir = tempDir, tempDir
+	}
+	agent.socket = filepath.Join(dir, "secret-agent.sock")
+
+	err := agent.start(ctx, agentConfig, filepath.Join(dir, "secret-agent.db"))
+	if err != nil {
+		agent.Close()
+		return nil, err
+	}
+	return agent, nil
+}
+
+func (a *Agent) start(ctx context.Context, agentConfig AgentConfig, dbFile string) error {
+	secretsConfig, err := config.LoadSecretsConfig(agentConfig.SecretsFile)
+	if err != nil {
+		return err
+	}
+	permissions, err := agentPermissions(agentConfig.PermissionsFile)
+	if err != nil {
+		return err
+	}
+	a.repository, err = sqlite.NewSecretRepository(ctx, dbFile, secretsConfig.Secrets, false, 4096)
+	if err != nil {
+		return err
+	}
+
+	secretStore := agentSecrets{a.repository}
+	agentServer := server.New(server.ServerConfig{
+		Sockets:       []server.Socket{{Path: a.socket}},
+		ShutdownGrace: agentShutdownGrace,
+	}, secretStore, a.repository.Approvals(), a.repository.Denials(), a.repository.Elevations(), a.repository.Events(), a.repository.Health(), permissions)
+
+	serveCtx, stop := context.WithCancel(context.WithoutCancel(ctx))
+	a.stop = stop
+	a.served = make(chan error, 1)
+	go func() {
+		a.served <- agentServer.Serve(serveCtx)
+	}()
+
+	timeout := time.After(agentStartTimeout)
+	for {
+		connection, err := net.Dial("unix", a.socket)
+		if err == nil {
+			return connection.Close()
+		}
+		select {
+		case err := <-a.served:
+			a.served <- err
+			return fmt.Errorf("agent stopped while starting: %w", err)
+		case <-timeout:
+			return errors.New("agent did not start listening in time")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// agentSecrets adapts the sqlite repository to the store which is served
+type agentSecrets struct {
+	*sqlite.SecretRespository
+}
+
+func (s agentSecrets) Instances(secretId string) store.Instances {
+	return s.SecretRespository.Instances(secretId)
+}
+
+// agentPermissions loads the permissions of the agent, or grants every permission to the user running it
+func agentPermissions(permissionsFile string) (*server.Permissions, error) {
+	if permissionsFile != "" {
+		return server.LoadPermissions(permissionsFile)
+	}
+	var permissions server.Permissions
+	err := json.Unmarshal(fmt.Appendf(nil, `{
+		"roles": {"owner": {"permissions": {"all": "any"}}},
+		"claims": {"users": {%q: "owner"}}
+	}`, strconv.Itoa(os.Getuid())), &permissions)
+	return &permissions, err
+}
+
+// Socket gives the path of the socket on which the agent listens
+func (a *Agent) Socket() string {
+	return a.socket
+}
+
+// Client gives a client for the agent
+func (a *Agent) Client(ctx context.Context) (*Client, error) {
+	return Connect(ctx, ClientConfig{Socket: a.socket})
+}
+
+// Close stops the agent, waiting for requests in progress to finish, then closes its database and removes its
+// temporary directory, if it has one
+func (a *Agent) Close() error {
+	var err error
+	if a.stop != nil {
+		a.stop()
+		if served := <-a.served; served != nil {
+			err = served
+		}
+	}
+	if a.repository != nil {
+		a.repository.Close()
+	}
+	if a.tempDir != "" {
+		err = errors.Join(err, os.RemoveAll(a.tempDir))
+	}
+	return err
+}
@@ -0,0 +1,258 @@
+package secretagent
+
+import (
+	"context"
+	"iter"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/client"
+	"github.com/eliasvasylenko/secret-agent/internal/marshal"
+	"github.com/eliasvasylenko/secret-agent/internal/server"
+)
+
+// The number of items requested in each page of a list
+const pageSize = 100
+
+// Config for connecting to a running agent
+type ClientConfig struct {
+	// Path of the unix socket on which the agent listens, or if empty, the first reachable of
+	// $XDG_RUNTIME_DIR/secret-agent.sock and /run/secret-agent/socket
+	Socket string
+	// Time allowed to connect to the agent, or unlimited if zero
+	ConnectTimeout time.Duration
+	// Time allowed for the agent to respond to a read, or unlimited if zero. Operations are not timed out, since the
+	// agent interrupts an operation if the client which requested it hangs up.
+	ReadTimeout time.Duration
+	// Times a read is retried after failing transiently, such as while the agent restarts
+	Retries int
+}
+
+// A Client talks to a running agent. Its methods are safe for concurrent use.
+//
+// Each request is made on behalf of the user running the client, who is identified by the agent through the
+// credentials of the socket connection and is only permitted what the roles they claim allow.
+type Client struct {
+	secrets *client.SecretClient
+}
+
+// Connect gives a client for the running agent at the configured socket, or else the agent found at one of the
+// default sockets, failing with [ErrNoServer] if none is found
+func Connect(ctx context.Context, config ClientConfig) (*Client, error) {
+	socket, err := client.FindSocket(ctx, config.Socket, config.ConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{client.NewSecretStore(client.Config{
+		Socket:         socket,
+		ConnectTimeout: config.ConnectTimeout,
+		ReadTimeout:    config.ReadTimeout,
+		Retries:        config.Retries,
+	})}, nil
+}
+
+// Secrets gives every configured secret, by ID
+func (c *Client) Secrets(ctx context.Context) (Secrets, error) {
+	all, err := c.secrets.List(ctx)
+	return toSecrets(all), apiError(err)
+}
+
+// Secret gives the configured secret with the given ID
+func (c *Client) Secret(ctx context.Context, secretId string) (*Secret, error) {
+	secret, err := c.secrets.Get(ctx, secretId)
+	return toSecret(secret), apiError(err)
+}
+
+// Summaries gives a summary of every secret in order of ID, from a single query by the agent
+func (c *Client) Summaries(ctx context.Context) ([]*Summary, error) {
+	summaries, err := c.secrets.Summaries(ctx)
+	return convertAll(summaries, toSummary), apiError(err)
+}
+
+// Instances gives every instance of a secret, by ID
+func (c *Client) Instances(ctx context.Context, secretId string) (Instances, error) {
+	all := Instances{}
+	for instance, err := range paginate(ctx, func(ctx context.Context, from int, to int) ([]*Instance, error) {
+		page, err := c.secrets.Instances(secretId).List(ctx, from, to)
+		return convertAll(page.Sorted(), toInstance), err
+	}) {
+		if err != nil {
+			return nil, err
+		}
+		all[instance.Id] = instance
+	}
+	return all, nil
+}
+
+// Instance gives an instance of a secret
+func (c *Client) Instance(ctx context.Context, secretId string, instanceId string) (*Instance, error) {
+	instance, err := c.secrets.Instances(secretId).Get(ctx, instanceId)
+	return toInstance(instance), apiError(err)
+}
+
+// ActiveInstance gives the active instance of a secret, or nil if no instance is active
+func (c *Client) ActiveInstance(ctx context.Context, secretId string) (*Instance, error) {
+	instance, err := c.secrets.Instances(secretId).GetActive(ctx)
+	return toInstance(instance), apiError(err)
+}
+
+// LatestOperation gives the number of the latest operation upon a secret, which may be given as
+// [OperationOptions.IfMatch] so that an operation is only performed if nothing has happened to the secret since
+func (c *Client) LatestOperation(ctx context.Context, secretId string) (int, error) {
+	latest, err := c.secrets.LatestOperation(ctx, secretId)
+	return latest, apiError(err)
+}
+
+// Create creates an instance of a secret. If the operation requires approval, it is not performed, and an
+// [*ApprovalRequiredError] is returned which gives the request for approval made in its place.
+func (c *Client) Create(ctx context.Context, secretId string, options OperationOptions) (*Instance, error) {
+	instance, err := c.secrets.Instances(secretId).Create(ctx, options.parameters())
+	return toInstance(instance), apiError(err)
+}
+
+// Activate activates an instance of a secret, which fails unless forced while another is active, or requests approval
+// to do so as for [Client.Create]
+func (c *Client) Activate(ctx context.Context, secretId string, instanceId string, options OperationOptions) (*Instance, error) {
+	instance, err := c.secrets.Instances(secretId).Activate(ctx, instanceId, options.parameters())
+	return toInstance(instance), apiError(err)
+}
+
+// Deactivate deactivates an instance of a secret, or requests approval to do so as for [Client.Create]
+func (c *Client) Deactivate(ctx context.Context, secretId string, instanceId string, options OperationOptions) (*Instance, error) {
+	instance, err := c.secrets.Instances(secretId).Deactivate(ctx, instanceId, options.parameters())
+	return toInstance(instance), apiError(err)
+}
+
+// Destroy destroys an instance of a secret, or requests approval to do so as for [Client.Create]
+func (c *Client) Destroy(ctx context.Context, secretId string, instanceId string, options OperationOptions) (*Instance, error) {
+	instance, err := c.secrets.Instances(secretId).Destroy(ctx, instanceId, options.parameters())
+	return toInstance(instance), apiError(err)
+}
+
+// Test tests an instance of a secret, or requests approval to do so as for [Client.Create]
+func (c *Client) Test(ctx context.Context, secretId string, instanceId string, options OperationOptions) (*Instance, error) {
+	instance, err := c.secrets.Instances(secretId).Test(ctx, instanceId, options.parameters())
+	return toInstance(instance), apiError(err)
+}
+
+// Operations iterates over the history of operations upon an instance of a secret, oldest first. An error ends
+// the sequence.
+func (c *Client) Operations(ctx context.Context, secretId string, instanceId string) iter.Seq2[*Operation, error] {
+	return paginate(ctx, func(ctx context.Context, from int, to int) ([]*Operation, error) {
+		operations, err := c.secrets.Instances(secretId).History(ctx, instanceId, from, to)
+		return convertAll(operations, toOperation), err
+	})
+}
+
+// Approvals iterates over requests for approval, most recent first. An error ends the sequence.
+func (c *Client) Approvals(ctx context.Context) iter.Seq2[*Approval, error] {
+	return paginate(ctx, func(ctx context.Context, from int, to int) ([]*Approval, error) {
+		approvals, err := c.secrets.Approvals().List(ctx, from, to)
+		return convertAll(approvals, toApproval), err
+	})
+}
+
+// Approval gives a request for approval
+func (c *Client) Approval(ctx context.Context, approvalId string) (*Approval, error) {
+	approval, err := c.secrets.Approvals().Get(ctx, approvalId)
+	return toApproval(approval), apiError(err)
+}
+
+// Approve approves a request for approval, which performs the operation if no further approval is required
+func (c *Client) Approve(ctx context.Context, approvalId string, comment string) (*Approval, error) {
+	approval, err := c.secrets.Approvals().Approve(ctx, approvalId, comment)
+	return toApproval(approval), apiError(err)
+}
+
+// Reject rejects a request for approval
+func (c *Client) Reject(ctx context.Context, approvalId string, comment string) (*Approval, error) {
+	approval, err := c.secrets.Approvals().Reject(ctx, approvalId, comment)
+	return toApproval(approval), apiError(err)
+}
+
+// Denials iterates over the audit trail of denied attempts, most recent first. An error ends the sequence.
+func (c *Client) Denials(ctx context.Context) iter.Seq2[*Denial, error] {
+	return paginate(ctx, func(ctx context.Context, from int, to int) ([]*Denial, error) {
+		denials, err := c.secrets.Denials().List(ctx, from, to)
+		return convertAll(denials, toDenial), err
+	})
+}
+
+// Elevations iterates over elevations, most recent first. An error ends the sequence.
+func (c *Client) Elevations(ctx context.Context) iter.Seq2[*Elevation, error] {
+	return paginate(ctx, func(ctx context.Context, from int, to int) ([]*Elevation, error) {
+		elevations, err := c.secrets.Elevations().List(ctx, from, to)
+		return convertAll(elevations, toElevation), err
+	})
+}
+
+// Elevate elevates the caller to a role for the given duration, or for the longest allowed if zero
+func (c *Client) Elevate(ctx context.Context, role RoleName, reason string, duration time.Duration) (*Elevation, error) {
+	elevation, err := c.secrets.Elevations().Elevate(ctx, server.ElevationParameters{
+		Role:     auth.RoleName(role),
+		Reason:   reason,
+		Duration: marshal.Duration(duration),
+	})
+	return toElevation(elevation), apiError(err)
+}
+
+// Revoke revokes an elevation before it expires
+func (c *Client) Revoke(ctx context.Context, elevationId string) (*Elevation, error) {
+	elevation, err := c.secrets.Elevations().Revoke(ctx, elevationId)
+	return toElevation(elevation), apiError(err)
+}
+
+// Whoami gives the identity of the caller as the agent sees it, and their effective permissions
+func (c *Client) Whoami(ctx context.Context) (*Whoami, error) {
+	whoami, err := c.secrets.Whoami(ctx, nil)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	return toWhoami(whoami), nil
+}
+
+// Bulk performs an operation across a selection of secrets. Operations which would require approval are refused.
+func (c *Client) Bulk(ctx context.Context, request BulkRequest) (*BulkReport, error) {
+	report, err := c.secrets.Bulk().Run(ctx, request.request())
+	if err != nil {
+		return nil, apiError(err)
+	}
+	return toBulkReport(report), nil
+}
+
+// Events streams the events which the caller may observe as they are recorded, until the context is done. An error
+// ends the sequence, after which the stream may be resumed after the last event received. If events following it
+// have been pruned in the meantime, the stream fails with [ErrEventsPruned] and must be started again from the
+// latest event, having read afresh any state which the events update.
+func (c *Client) Events(ctx context.Context, query EventQuery) iter.Seq2[*Event, error] {
+	return func(yield func(*Event, error) bool) {
+		stream := c.secrets.Events().Stream(ctx, client.EventQuery{SecretId: query.SecretId, After: query.After, Opened: query.Opened})
+		for event, err := range stream {
+			if !yield(toEvent(event), apiError(err)) {
+				return
+			}
+		}
+	}
+}
+
+// paginate iterates over a list which is requested a page at a time, until a page is not full
+func paginate[T any](ctx context.Context, list func(ctx context.Context, from int, to int) ([]T, error)) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for from := 0; ; from += pageSize {
+			page, err := list(ctx, from, from+pageSize)
+			if err != nil {
+				var zero T
+				yield(zero, apiError(err))
+				return
+			}
+			for _, item := range page {
+				if !yield(item, nil) {
+					return
+				}
+			}
+			if len(page) < pageSize {
+				return
+			}
+		}
+	}
+}
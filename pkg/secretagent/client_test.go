@@ -0,0 +1,113 @@
+package secretagent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+	"github.com/eliasvasylenko/secret-agent/internal/server"
+	"github.com/eliasvasylenko/secret-agent/internal/validation"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPaginate(t *testing.T) {
+	items := make([]int, pageSize+1)
+	for i := range items {
+		items[i] = i
+	}
+	var requested [][2]int
+	list := func(ctx context.Context, from int, to int) ([]int, error) {
+		requested = append(requested, [2]int{from, to})
+		return items[min(from, len(items)):min(to, len(items))], nil
+	}
+
+	var got []int
+	for item, err := range paginate(context.Background(), list) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, item)
+	}
+	if diff := cmp.Diff(items, got); diff != "" {
+		t.Errorf("unexpected items (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([][2]int{{0, pageSize}, {pageSize, 2 * pageSize}}, requested); diff != "" {
+		t.Errorf("unexpected pages requested (-want +got):\n%s", diff)
+	}
+}
+
+func TestPaginate_error(t *testing.T) {
+	list := func(ctx context.Context, from int, to int) ([]int, error) {
+		return nil, server.NewErrorResponse(http.StatusForbidden, errors.New("no"))
+	}
+	for _, err := range paginate(context.Background(), list) {
+		if !errors.Is(err, ErrForbidden) {
+			t.Errorf("expected forbidden, got %v", err)
+		}
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	err := apiError(server.NewErrorResponse(http.StatusBadRequest, validation.Errors{
+		{Pointer: "/env/1", Message: "invalid name"},
+		{Parameter: "from", Message: "must not be negative"},
+	}))
+
+	if !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("expected invalid request, got %v", err)
+	}
+	if errors.Is(err, ErrNotFound) {
+		t.Errorf("unexpected not found, got %v", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected an API error, got %T", err)
+	}
+	want := &APIError{
+		Status:  http.StatusBadRequest,
+		Message: "invalid request",
+		Fields: []FieldError{
+			{Pointer: "/env/1", Message: "invalid name"},
+			{Parameter: "from", Message: "must not be negative"},
+		},
+	}
+	if diff := cmp.Diff(want, apiErr); diff != "" {
+		t.Errorf("unexpected error (-want +got):\n%s", diff)
+	}
+	if got, want := apiErr.Error(), "400 Bad Request - invalid request (/env/1: invalid name; parameter from: must not be negative)"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestAPIError_eventsPruned(t *testing.T) {
+	err := apiError(server.NewErrorResponse(http.StatusGone, errors.New("events following event 1 have been pruned")))
+	if !errors.Is(err, ErrEventsPruned) {
+		t.Errorf("expected events pruned, got %v", err)
+	}
+}
+
+func TestAPIError_otherErrors(t *testing.T) {
+	err := errors.New("connection refused")
+	if got := apiError(err); got != err {
+		t.Errorf("expected the error unchanged, got %v", got)
+	}
+	if got := apiError(nil); got != nil {
+		t.Errorf("expected nil, got %v", got)
+	}
+}
+
+func TestAPIError_approvalRequired(t *testing.T) {
+	err := apiError(fmt.Errorf("activate: %w", &secrets.ApprovalRequiredError{Approval: &secrets.Approval{Id: "a1", Name: secrets.Activate, Required: 2, Status: secrets.Pending}}))
+
+	var approvalRequired *ApprovalRequiredError
+	if !errors.As(err, &approvalRequired) {
+		t.Fatalf("expected approval required, got %T", err)
+	}
+	want := &Approval{Id: "a1", Name: Activate, Required: 2, Status: Pending}
+	if diff := cmp.Diff(want, approvalRequired.Approval); diff != "" {
+		t.Errorf("unexpected approval (-want +got):\n%s", diff)
+	}
+}
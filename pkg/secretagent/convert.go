@@ -0,0 +1,279 @@
+package secretagent
+
+import (
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/audit"
+	"github.com/eliasvasylenko/secret-agent/internal/auth"
+	"github.com/eliasvasylenko/secret-agent/internal/bulk"
+	"github.com/eliasvasylenko/secret-agent/internal/command"
+	"github.com/eliasvasylenko/secret-agent/internal/events"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+	"github.com/eliasvasylenko/secret-agent/internal/server"
+)
+
+// The types of the agent are converted to those of this package as they are returned, so that the types which the
+// package exposes do not change along with those of the agent
+
+// convertAll converts each of the given values, keeping nil as nil
+func convertAll[T any, U any](values []T, convert func(T) U) []U {
+	if values == nil {
+		return nil
+	}
+	converted := make([]U, 0, len(values))
+	for _, value := range values {
+		converted = append(converted, convert(value))
+	}
+	return converted
+}
+
+func toSecrets(all secrets.Secrets) Secrets {
+	if all == nil {
+		return nil
+	}
+	converted := make(Secrets, len(all))
+	for id, secret := range all {
+		converted[id] = toSecret(secret)
+	}
+	return converted
+}
+
+func toSecret(secret *secrets.Secret) *Secret {
+	if secret == nil {
+		return nil
+	}
+	return &Secret{
+		Name:        secret.Name,
+		Environment: secret.Environment,
+		Labels:      secret.Labels,
+		Create:      toCommand(secret.Create),
+		Destroy:     toCommand(secret.Destroy),
+		Activate:    toCommand(secret.Activate),
+		Deactivate:  toCommand(secret.Deactivate),
+		Test:        toCommand(secret.Test),
+		Derive:      toSecrets(secret.Derive),
+		RotateAfter: time.Duration(secret.RotateAfter),
+	}
+}
+
+func toCommand(c *command.Command) *Command {
+	if c == nil {
+		return nil
+	}
+	return &Command{Script: c.Script, Environment: c.Environment, Shell: c.Shell}
+}
+
+func toInstances(all secrets.Instances) Instances {
+	if all == nil {
+		return nil
+	}
+	converted := make(Instances, len(all))
+	for id, instance := range all {
+		converted[id] = toInstance(instance)
+	}
+	return converted
+}
+
+func toInstance(instance *secrets.Instance) *Instance {
+	if instance == nil {
+		return nil
+	}
+	return &Instance{
+		Id:        instance.Id,
+		Secret:    *toSecret(&instance.Secret),
+		Status:    toStatus(instance.Status),
+		CreatedAt: instance.CreatedAt,
+	}
+}
+
+func toStatus(status secrets.Status) Status {
+	return Status{
+		OperationNumber: status.OperationNumber,
+		Name:            OperationName(status.Name),
+		Forced:          status.Forced,
+		Reason:          status.Reason,
+		StartedBy:       status.StartedBy,
+		StartedAt:       status.StartedAt,
+		CompletedAt:     status.CompletedAt,
+		FailedAt:        status.FailedAt,
+		InterruptedAt:   status.InterruptedAt,
+		ApprovalId:      status.ApprovalId,
+		ElevationId:     status.ElevationId,
+		IdempotencyKey:  status.IdempotencyKey,
+		BatchId:         status.BatchId,
+		RequestId:       status.RequestId,
+	}
+}
+
+func toOperation(operation *secrets.Operation) *Operation {
+	if operation == nil {
+		return nil
+	}
+	return &Operation{
+		SecretId:   operation.SecretId,
+		InstanceId: operation.InstanceId,
+		Status:     toStatus(operation.Status),
+	}
+}
+
+func toSummary(summary *secrets.Summary) *Summary {
+	return &Summary{
+		SecretId:         summary.SecretId,
+		ActiveInstanceId: summary.ActiveInstanceId,
+		ActiveCreatedAt:  summary.ActiveCreatedAt,
+		LatestOperation:  toOperation(summary.LatestOperation),
+		RunningOperation: toOperation(summary.RunningOperation),
+		Stuck:            summary.Stuck,
+		LiveInstances:    summary.LiveInstances,
+		LastTest:         toOperation(summary.LastTest),
+		RotationDueAt:    summary.RotationDueAt,
+		Verdict:          Verdict(summary.Verdict),
+	}
+}
+
+func toApproval(approval *secrets.Approval) *Approval {
+	if approval == nil {
+		return nil
+	}
+	return &Approval{
+		Id:              approval.Id,
+		SecretId:        approval.SecretId,
+		InstanceId:      approval.InstanceId,
+		Name:            OperationName(approval.Name),
+		Forced:          approval.Forced,
+		Reason:          approval.Reason,
+		RequestedBy:     approval.RequestedBy,
+		RequestedAt:     approval.RequestedAt,
+		ExpiresAt:       approval.ExpiresAt,
+		Required:        approval.Required,
+		Status:          ApprovalStatus(approval.Status),
+		Decisions:       convertAll(approval.Decisions, toDecision),
+		OperationNumber: approval.OperationNumber,
+		IdempotencyKey:  approval.IdempotencyKey,
+		IfMatch:         approval.IfMatch,
+		ElevationId:     approval.ElevationId,
+	}
+}
+
+func toDecision(decision *secrets.Decision) *Decision {
+	return &Decision{
+		Principal: decision.Principal,
+		Approved:  decision.Approved,
+		Comment:   decision.Comment,
+		DecidedAt: decision.DecidedAt,
+	}
+}
+
+func toDenial(denial *audit.Denial) *Denial {
+	return &Denial{
+		Id:          denial.Id,
+		Principal:   denial.Principal,
+		Credentials: toCredentials(denial.Credentials),
+		Listener:    denial.Listener,
+		Route:       denial.Route,
+		SecretId:    denial.SecretId,
+		InstanceId:  denial.InstanceId,
+		Operation:   OperationName(denial.Operation),
+		Permission:  toPermissions(denial.Permission),
+		Roles:       convertAll(denial.Roles, toRoleName),
+		Reason:      denial.Reason,
+		DeniedAt:    denial.DeniedAt,
+	}
+}
+
+func toCredentials(credentials *auth.Credentials) *Credentials {
+	if credentials == nil {
+		return nil
+	}
+	return &Credentials{Uid: credentials.Uid, Gid: credentials.Gid, Pid: credentials.Pid}
+}
+
+func toPermissions(permissions auth.Permissions) Permissions {
+	if permissions == nil {
+		return nil
+	}
+	converted := make(Permissions, len(permissions))
+	for subject, actions := range permissions {
+		converted[string(subject)] = convertAll(actions, func(action auth.Action) string { return string(action) })
+	}
+	return converted
+}
+
+func toRoleName(role auth.RoleName) RoleName {
+	return RoleName(role)
+}
+
+func toElevation(elevation *auth.Elevation) *Elevation {
+	if elevation == nil {
+		return nil
+	}
+	return &Elevation{
+		Id:        elevation.Id,
+		Principal: elevation.Principal,
+		Role:      RoleName(elevation.Role),
+		Reason:    elevation.Reason,
+		StartedAt: elevation.StartedAt,
+		ExpiresAt: elevation.ExpiresAt,
+		RevokedAt: elevation.RevokedAt,
+		RevokedBy: elevation.RevokedBy,
+	}
+}
+
+func toWhoami(whoami *server.Whoami) *Whoami {
+	return &Whoami{
+		Principal:   whoami.Principal,
+		Roles:       convertAll(whoami.Roles, toRoleName),
+		Granted:     toPermissions(whoami.Permissions.Granted),
+		Denied:      toPermissions(whoami.Permissions.Denied),
+		Credentials: toCredentials(whoami.Credentials),
+		Elevation:   toElevation(whoami.Elevation),
+		Listener:    whoami.Listener,
+	}
+}
+
+func toEvent(event *events.Event) *Event {
+	if event == nil {
+		return nil
+	}
+	return &Event{
+		Id:         event.Id,
+		Type:       EventType(event.Type),
+		SecretId:   event.SecretId,
+		InstanceId: event.InstanceId,
+		Principal:  event.Principal,
+		OccurredAt: event.OccurredAt,
+		Data:       event.Data,
+	}
+}
+
+func toBulkReport(report *bulk.Report) *BulkReport {
+	return &BulkReport{
+		BatchId:   report.BatchId,
+		Name:      OperationName(report.Name),
+		Results:   convertAll(report.Results, toBulkResult),
+		Succeeded: report.Succeeded,
+		Failed:    report.Failed,
+		Skipped:   report.Skipped,
+	}
+}
+
+func toBulkResult(result *bulk.Result) *BulkResult {
+	return &BulkResult{
+		SecretId: result.SecretId,
+		Instance: toInstance(result.Instance),
+		Skipped:  result.Skipped,
+		Error:    result.Error,
+	}
+}
+
+func (r BulkRequest) request() bulk.Request {
+	return bulk.Request{
+		Name:        secrets.OperationName(r.Name),
+		Select:      bulk.Selector{Names: r.Select.Names, Labels: r.Select.Labels},
+		Concurrency: r.Concurrency,
+		KeepGoing:   r.KeepGoing,
+		Env:         r.Env,
+		Forced:      r.Forced,
+		Reason:      r.Reason,
+	}
+}
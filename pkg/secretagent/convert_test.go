@@ -0,0 +1,47 @@
+package secretagent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/command"
+	"github.com/eliasvasylenko/secret-agent/internal/marshal"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestToInstance(t *testing.T) {
+	startedAt := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	batchId := "b1"
+	instance := &secrets.Instance{
+		Id: "i1",
+		Secret: secrets.Secret{
+			Name:        "s1",
+			Environment: command.Environment{"KEY": "value"},
+			Labels:      map[string]string{"team": "db"},
+			Create:      &command.Command{Script: "echo created", Shell: "bash"},
+			Derive:      secrets.Secrets{"s2": {Name: "s2"}},
+			RotateAfter: marshal.Duration(time.Hour),
+		},
+		Status: secrets.Status{OperationNumber: 3, Name: secrets.Create, StartedBy: "user", StartedAt: startedAt, BatchId: &batchId},
+	}
+
+	want := &Instance{
+		Id: "i1",
+		Secret: Secret{
+			Name:        "s1",
+			Environment: map[string]string{"KEY": "value"},
+			Labels:      map[string]string{"team": "db"},
+			Create:      &Command{Script: "echo created", Shell: "bash"},
+			Derive:      Secrets{"s2": {Name: "s2"}},
+			RotateAfter: time.Hour,
+		},
+		Status: Status{OperationNumber: 3, Name: Create, StartedBy: "user", StartedAt: startedAt, BatchId: &batchId},
+	}
+	if diff := cmp.Diff(want, toInstance(instance)); diff != "" {
+		t.Errorf("unexpected instance (-want +got):\n%s", diff)
+	}
+	if got := toInstance(nil); got != nil {
+		t.Errorf("expected nil, got %+v", got)
+	}
+}
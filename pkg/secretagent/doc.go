@@ -0,0 +1,17 @@
+// Package secretagent is the Go SDK for secret-agent. It provides a typed client for a running agent, and an
+// agent which may be embedded in-process upon its own sqlite database, so that code which talks to the agent may be
+// tested without running it as a service.
+//
+// # Compatibility
+//
+// This package is the only stable Go API of the module. Everything under internal/ may change at any time.
+//
+// Within a major version of the module, exported identifiers of this package are not removed or renamed, and the
+// signatures of its functions and methods do not change incompatibly. Fields and methods may be added to its
+// types, and constants to its enumerations, so values should be constructed with field names, and switches upon
+// enumerations should allow for values they do not name.
+//
+// The types of resources, such as [Secret], [Instance] and [Approval], belong to this package and are converted from
+// those which the agent sends as JSON, whose shape is given by version 1 of the HTTP API. The client requests that
+// version from the agent, and reports [ErrIncompatible] if the agent does not serve it.
+package secretagent
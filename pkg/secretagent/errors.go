@@ -0,0 +1,119 @@
+package secretagent
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/eliasvasylenko/secret-agent/internal/client"
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+	"github.com/eliasvasylenko/secret-agent/internal/server"
+)
+
+// Errors which an [*APIError] matches by its status, for use with [errors.Is]
+var (
+	ErrInvalidRequest     = errors.New("invalid request")
+	ErrUnauthenticated    = errors.New("unauthenticated")
+	ErrForbidden          = errors.New("forbidden")
+	ErrNotFound           = errors.New("not found")
+	ErrConflict           = errors.New("conflict")
+	ErrPreconditionFailed = errors.New("precondition failed")
+	ErrRateLimited        = errors.New("rate limited")
+	ErrUnavailable        = errors.New("unavailable")
+	// Events following the one after which a stream was to be resumed have been pruned
+	ErrEventsPruned = errors.New("events pruned")
+)
+
+// ErrIncompatible is returned when the agent does not serve the version of the API which the client requires
+var ErrIncompatible = client.ErrIncompatible
+
+// ErrNoServer is returned by [Connect] when no socket is given and no running agent is found
+var ErrNoServer = client.ErrNoServer
+
+// ApprovalRequiredError is returned when an operation is not performed until it has been approved. The request
+// for approval which was made in its place is given.
+type ApprovalRequiredError struct {
+	Approval *Approval
+}
+
+func (e *ApprovalRequiredError) Error() string {
+	return fmt.Sprintf("%s requires %d approval(s), pending approval request %s", e.Approval.Name, e.Approval.Required, e.Approval.Id)
+}
+
+var statusErrors = map[int]error{
+	http.StatusBadRequest:            ErrInvalidRequest,
+	http.StatusUnauthorized:          ErrUnauthenticated,
+	http.StatusForbidden:             ErrForbidden,
+	http.StatusNotFound:              ErrNotFound,
+	http.StatusConflict:              ErrConflict,
+	http.StatusPreconditionFailed:    ErrPreconditionFailed,
+	http.StatusTooManyRequests:       ErrRateLimited,
+	http.StatusServiceUnavailable:    ErrUnavailable,
+	http.StatusGone:                  ErrEventsPruned,
+	http.StatusNotAcceptable:         ErrIncompatible,
+	http.StatusRequestEntityTooLarge: ErrInvalidRequest,
+}
+
+// An error reported by the agent in response to a request
+type APIError struct {
+	// The HTTP status of the response
+	Status  int
+	Message string
+	// The problems with each field of the request, if it was invalid
+	Fields []FieldError
+}
+
+// A problem with one field of the body of a request, or with one parameter of its query
+type FieldError struct {
+	// A JSON pointer to the field of the body, if the problem is with the body
+	Pointer string
+	// The name of the query parameter, if the problem is with the query
+	Parameter string
+	Message   string
+}
+
+func (e *APIError) Error() string {
+	message := fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status))
+	if e.Message != "" {
+		message += " - " + e.Message
+	}
+	var fields []string
+	for _, field := range e.Fields {
+		switch {
+		case field.Parameter != "":
+			fields = append(fields, fmt.Sprintf("parameter %s: %s", field.Parameter, field.Message))
+		case field.Pointer != "":
+			fields = append(fields, fmt.Sprintf("%s: %s", field.Pointer, field.Message))
+		default:
+			fields = append(fields, field.Message)
+		}
+	}
+	if len(fields) > 0 {
+		message += " (" + strings.Join(fields, "; ") + ")"
+	}
+	return message
+}
+
+// Is reports whether the error has the status of the given error, such as [ErrNotFound]
+func (e *APIError) Is(target error) bool {
+	return statusErrors[e.Status] == target
+}
+
+// apiError translates an error reported by the agent to an [*APIError], or a request for approval made in place of an
+// operation to an [*ApprovalRequiredError], leaving other errors as they are
+func apiError(err error) error {
+	var approvalRequired *secrets.ApprovalRequiredError
+	if errors.As(err, &approvalRequired) {
+		return &ApprovalRequiredError{Approval: toApproval(approvalRequired.Approval)}
+	}
+	var response *server.ErrorResponse
+	if !errors.As(err, &response) || response.HttpError == nil {
+		return err
+	}
+	translated := &APIError{Status: response.HttpError.Code, Message: response.HttpError.Message}
+	for _, field := range response.HttpError.Fields {
+		translated.Fields = append(translated.Fields, FieldError{Pointer: field.Pointer, Parameter: field.Parameter, Message: field.Message})
+	}
+	return translated
+}
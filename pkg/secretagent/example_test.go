@@ -0,0 +1,164 @@
+package secretagent_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/eliasvasylenko/secret-agent/pkg/secretagent"
+)
+
+// startAgent starts an embedded agent serving a single secret, whose commands only echo
+func startAgent(ctx context.Context) (*secretagent.Agent, func()) {
+	dir, err := os.MkdirTemp("", "secret-agent-example-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	secretsFile := filepath.Join(dir, "secrets.json")
+	err = os.WriteFile(secretsFile, []byte(`{
+		"secrets": [{
+			"name": "db-password",
+			"create": "echo created",
+			"activate": "echo activated",
+			"deactivate": "echo deactivated",
+			"destroy": "echo destroyed"
+		}]
+	}`), 0600)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	agent, err := secretagent.StartAgent(ctx, secretagent.AgentConfig{SecretsFile: secretsFile})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return agent, func() {
+		agent.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func ExampleStartAgent() {
+	ctx := context.Background()
+	agent, stop := startAgent(ctx)
+	defer stop()
+
+	agentClient, err := agent.Client(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	instance, err := agentClient.Create(ctx, "db-password", secretagent.OperationOptions{Reason: "example"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = agentClient.Activate(ctx, "db-password", instance.Id, secretagent.OperationOptions{Reason: "example"})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	active, err := agentClient.ActiveInstance(ctx, "db-password")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(active.Id == instance.Id, active.Status.Name)
+	// Output: true activate
+}
+
+func ExampleClient_Operations() {
+	ctx := context.Background()
+	agent, stop := startAgent(ctx)
+	defer stop()
+
+	agentClient, err := agent.Client(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	instance, err := agentClient.Create(ctx, "db-password", secretagent.OperationOptions{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = agentClient.Destroy(ctx, "db-password", instance.Id, secretagent.OperationOptions{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for operation, err := range agentClient.Operations(ctx, "db-password", instance.Id) {
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(operation.Status.Name)
+	}
+	// Output:
+	// create
+	// destroy
+}
+
+func ExampleClient_Summaries() {
+	ctx := context.Background()
+	agent, stop := startAgent(ctx)
+	defer stop()
+
+	agentClient, err := agent.Client(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	instance, err := agentClient.Create(ctx, "db-password", secretagent.OperationOptions{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = agentClient.Activate(ctx, "db-password", instance.Id, secretagent.OperationOptions{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	summaries, err := agentClient.Summaries(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, summary := range summaries {
+		fmt.Println(summary.SecretId, summary.Verdict, summary.LiveInstances, summary.LatestOperation.Name)
+	}
+	// Output: db-password healthy 1 activate
+}
+
+func ExampleAPIError() {
+	ctx := context.Background()
+	agent, stop := startAgent(ctx)
+	defer stop()
+
+	agentClient, err := agent.Client(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = agentClient.Approval(ctx, "no-such-approval")
+	fmt.Println(errors.Is(err, secretagent.ErrNotFound))
+
+	var apiError *secretagent.APIError
+	if errors.As(err, &apiError) {
+		fmt.Println(apiError.Status)
+	}
+	// Output:
+	// true
+	// 404
+}
+
+func ExampleConnect() {
+	ctx := context.Background()
+
+	// connect to the agent running as a service, at one of the default sockets
+	agentClient, err := secretagent.Connect(ctx, secretagent.ClientConfig{Retries: 3})
+	if errors.Is(err, secretagent.ErrNoServer) {
+		log.Fatal("the agent is not running")
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	whoami, err := agentClient.Whoami(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(whoami)
+}
@@ -0,0 +1,327 @@
+package secretagent
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/eliasvasylenko/secret-agent/internal/secrets"
+)
+
+// A secret, with the commands which perform each operation upon its instances
+type Secret struct {
+	Name string
+	// The environment of every command of the secret
+	Environment map[string]string
+	// Labels by which the secret may be selected for bulk operations
+	Labels map[string]string
+	// The commands which perform each operation, if the secret defines them
+	Create     *Command
+	Destroy    *Command
+	Activate   *Command
+	Deactivate *Command
+	Test       *Command
+	// Secrets derived from this one
+	Derive Secrets
+	// The age at which the active instance is due to be replaced by a new one, or never if zero
+	RotateAfter time.Duration
+}
+
+// Secrets by ID
+type Secrets map[string]*Secret
+
+// A command which performs an operation upon an instance of a secret
+type Command struct {
+	Script      string
+	Environment map[string]string
+	// The shell which runs the script, or the default shell of the agent if empty
+	Shell string
+}
+
+// An instance of a secret, with the status of its latest operation
+type Instance struct {
+	Id     string
+	Secret Secret
+	Status Status
+	// When the instance was created, i.e. when its create operation started
+	CreatedAt time.Time
+}
+
+// Instances by ID
+type Instances map[string]*Instance
+
+// The status of the latest operation upon an instance
+type Status struct {
+	OperationNumber int
+	Name            OperationName
+	Forced          bool
+	Reason          string
+	StartedBy       string
+	StartedAt       time.Time
+	CompletedAt     *time.Time
+	FailedAt        *time.Time
+	// Set along with FailedAt if the operation was interrupted, e.g. by shutdown of the agent
+	InterruptedAt *time.Time
+	// The request for approval which authorised the operation, if any
+	ApprovalId *string
+	// The elevation which was active for the caller who started the operation, if any
+	ElevationId *string
+	// The key which identified the request which started the operation, if any
+	IdempotencyKey *string
+	// The bulk operation to which the operation belongs, if any
+	BatchId *string
+	// The ID of the API request which started the operation, if any
+	RequestId *string
+}
+
+// An operation upon an instance of a secret
+type Operation struct {
+	SecretId   string
+	InstanceId string
+	Status
+}
+
+// The name of an operation
+type OperationName string
+
+const (
+	Create     OperationName = "create"
+	Destroy    OperationName = "destroy"
+	Activate   OperationName = "activate"
+	Deactivate OperationName = "deactivate"
+	Test       OperationName = "test"
+)
+
+// A summary of the state of a secret, with a verdict upon its health
+type Summary struct {
+	SecretId string
+	// The active instance, if any, and when it was created
+	ActiveInstanceId *string
+	ActiveCreatedAt  *time.Time
+	// The latest operation upon any instance of the secret, if any
+	LatestOperation *Operation
+	// The longest running operation upon the secret which has neither completed nor failed, if any
+	RunningOperation *Operation
+	// Whether the running operation has run for longer than it should
+	Stuck bool
+	// The number of instances of the secret which have not been destroyed
+	LiveInstances int
+	// The latest test of the active instance, if it has been tested
+	LastTest *Operation
+	// The time from which the active instance is due to be replaced, if the secret is rotated
+	RotationDueAt *time.Time
+	Verdict       Verdict
+}
+
+// A verdict upon the health of a secret
+type Verdict string
+
+const (
+	Healthy  Verdict = "healthy"
+	Inactive Verdict = "inactive"
+	Failing  Verdict = "failing"
+	Overdue  Verdict = "overdue"
+	Stuck    Verdict = "stuck"
+)
+
+// A request for an operation which must be approved before it is performed
+type Approval struct {
+	Id          string
+	SecretId    string
+	InstanceId  string
+	Name        OperationName
+	Forced      bool
+	Reason      string
+	RequestedBy string
+	RequestedAt time.Time
+	ExpiresAt   time.Time
+	Required    int
+	Status      ApprovalStatus
+	Decisions   []*Decision
+	// The operation which was started once the request was approved
+	OperationNumber *int
+	// The key which identified the request for approval, if any
+	IdempotencyKey string
+	// The latest operation upon the secret which the request expected, if any
+	IfMatch *int
+	// The elevation which was active for the requester, if any
+	ElevationId string
+}
+
+// A decision to approve or reject a request for approval
+type Decision struct {
+	Principal string
+	Approved  bool
+	Comment   string
+	DecidedAt time.Time
+}
+
+// The status of a request for approval
+type ApprovalStatus string
+
+const (
+	Pending  ApprovalStatus = "pending"
+	Approved ApprovalStatus = "approved"
+	Rejected ApprovalStatus = "rejected"
+	Expired  ApprovalStatus = "expired"
+	Failed   ApprovalStatus = "failed"
+)
+
+// An attempt which was denied, from the audit trail
+type Denial struct {
+	Id          int
+	Principal   string
+	Credentials *Credentials
+	Listener    string
+	Route       string
+	SecretId    string
+	InstanceId  string
+	Operation   OperationName
+	// The permission which was missing
+	Permission Permissions
+	Roles      []RoleName
+	Reason     string
+	DeniedAt   time.Time
+}
+
+// The credentials of the process at the other end of a socket connection
+type Credentials struct {
+	Uid uint32
+	Gid uint32
+	Pid int32
+}
+
+// Actions by the subject upon which they are permitted, e.g. "write" upon "instances"
+type Permissions map[string][]string
+
+// A time-bounded elevation of a principal to a role
+type Elevation struct {
+	Id        string
+	Principal string
+	Role      RoleName
+	Reason    string
+	StartedAt time.Time
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+	RevokedBy string
+}
+
+// The name of a role
+type RoleName string
+
+// The identity of the caller and their effective permissions
+type Whoami struct {
+	Principal string
+	Roles     []RoleName
+	// The permissions granted by the claimed roles, and those denied even so
+	Granted Permissions
+	Denied  Permissions
+	// The credentials of the caller, if they connected over a unix socket
+	Credentials *Credentials
+	// The elevation of the caller, if they are elevated
+	Elevation *Elevation
+	Listener  string
+}
+
+// An event about a secret, such as the completion of an operation
+type Event struct {
+	// The position of the event in the order in which events were recorded
+	Id         int64
+	Type       EventType
+	SecretId   string
+	InstanceId string
+	Principal  string
+	OccurredAt time.Time
+	// The subject of the event as JSON, e.g. the operation, approval request or elevation
+	Data json.RawMessage
+}
+
+// The type of an event
+type EventType string
+
+const (
+	OperationStarted      EventType = "operation.started"
+	OperationCompleted    EventType = "operation.completed"
+	OperationFailed       EventType = "operation.failed"
+	ActiveInstanceChanged EventType = "secret.activeInstanceChanged"
+	ApprovalRequested     EventType = "approval.requested"
+	ApprovalDecided       EventType = "approval.decided"
+	AccessDenied          EventType = "access.denied"
+	ElevationGranted      EventType = "elevation.granted"
+	ElevationRevoked      EventType = "elevation.revoked"
+)
+
+// A query for a stream of events
+type EventQuery struct {
+	// Only stream events about the secret with this ID, if given
+	SecretId string
+	// Resume the stream after the event with this ID, or start from the latest event if nil
+	After *int64
+	// Called once the stream is open, if given, so that state which the events update may be read without missing
+	// any change to it. An error ends the stream.
+	Opened func() error
+}
+
+// A request to perform an operation across a selection of secrets. Operations other than create are performed
+// upon the active instance of each secret.
+type BulkRequest struct {
+	Name   OperationName
+	Select Selector
+	// The most operations which may be performed at once, or one at a time if zero
+	Concurrency int
+	// Whether to carry on with the rest of the selection after an operation fails
+	KeepGoing bool
+	Env       map[string]string
+	Forced    bool
+	Reason    string
+}
+
+// A selection of secrets by their IDs or labels
+type Selector struct {
+	// Patterns, as understood by path.Match, at least one of which the ID of a selected secret matches
+	Names []string
+	// Labels which a selected secret carries with the given values
+	Labels map[string]string
+}
+
+// The outcome of an operation across a selection of secrets, with a result for each selected secret in order of
+// their IDs
+type BulkReport struct {
+	BatchId   string
+	Name      OperationName
+	Results   []*BulkResult
+	Succeeded int
+	Failed    int
+	Skipped   int
+}
+
+// The outcome of an operation upon one secret of a selection
+type BulkResult struct {
+	SecretId string
+	Instance *Instance
+	Skipped  bool
+	Error    string
+}
+
+// Options for an operation upon a secret
+type OperationOptions struct {
+	// Variables added to the environment of the command which performs the operation
+	Env map[string]string
+	// Whether to override the safety checks of the operation
+	Forced bool
+	// The reason for the operation, which is recorded in its history
+	Reason string
+	// A key chosen by the caller to identify the request, so that a retry returns the outcome of the original
+	IdempotencyKey string
+	// The number of the operation which must be the latest upon the secret for this one to be performed, if any
+	IfMatch *int
+}
+
+func (o OperationOptions) parameters() secrets.OperationParameters {
+	return secrets.OperationParameters{
+		Env:            o.Env,
+		Forced:         o.Forced,
+		Reason:         o.Reason,
+		IdempotencyKey: o.IdempotencyKey,
+		IfMatch:        o.IfMatch,
+	}
+}